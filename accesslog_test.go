@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get(requestIDHeader)
+		w.Write([]byte("[]"))
+	}))
+	defer upstream.Close()
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	h := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := fetchDepartures(r.Context(), upstream.URL, "100", "300", 0); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/next?trip=0", nil))
+	id := w.Header().Get(requestIDHeader)
+	if len(id) != 16 {
+		t.Fatalf("expected a generated request ID, got %q", id)
+	}
+	if upstreamID != id {
+		t.Errorf("expected the upstream request to carry %q, got %q", id, upstreamID)
+	}
+	if line := logs.String(); !strings.Contains(line, id+" GET /api/next?trip=0 418 ") {
+		t.Errorf("unexpected access log: %q", line)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestIDHeader, "proxy-abc.1")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got != "proxy-abc.1" {
+		t.Errorf("expected the incoming request ID to be kept, got %q", got)
+	}
+
+	req.Header.Set(requestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got == "bad id\n" || len(got) != 16 {
+		t.Errorf("expected an unsafe request ID to be replaced, got %q", got)
+	}
+}
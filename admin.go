@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsMinutes is how many one-minute buckets of backend stats are kept and
+// graphed on the admin page.
+const statsMinutes = 60
+
+type AdminConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Persist writes /admin/trips edits back to the config file, keeping
+	// the previous version beside it as a .bak.
+	Persist bool `yaml:"persist,omitempty"`
+}
+
+// requireAdmin wraps h in HTTP basic auth against the admin credentials
+// (username defaults to "admin"). Browsers resend basic auth to any page
+// that posts to the board, so changes must also come from the board's own
+// pages.
+func requireAdmin(cfg AdminConfig, h http.HandlerFunc) http.HandlerFunc {
+	username := cfg.Username
+	if username == "" {
+		username = "admin"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !equalSecret(user, username) || !equalSecret(pass, cfg.Password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="departure-board admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			http.Error(w, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// sameOrigin reports whether a browser says r came from one of the board's
+// own pages. Requests without Sec-Fetch-Site or Origin, from curl and
+// scripts, aren't cross-site and are allowed.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+type statsBucket struct {
+	minute     int64
+	requests   int
+	errors     int
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+type backendSeries struct {
+	buckets   [statsMinutes]statsBucket
+	lastOK    time.Time
+	lastError string
+	lastErrAt time.Time
+}
+
+// backendStats records the latency and outcome of every upstream request per
+// backend host, in one-minute buckets.
+type backendStats struct {
+	mu       sync.Mutex
+	backends map[string]*backendSeries
+}
+
+func newBackendStats() *backendStats {
+	return &backendStats{backends: make(map[string]*backendSeries)}
+}
+
+func (s *backendStats) record(host string, at time.Time, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series, ok := s.backends[host]
+	if !ok {
+		series = &backendSeries{}
+		s.backends[host] = series
+	}
+	minute := at.Unix() / 60
+	b := &series.buckets[minute%statsMinutes]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	b.requests++
+	b.latency += latency
+	b.maxLatency = max(b.maxLatency, latency)
+	if err != nil {
+		b.errors++
+		series.lastError, series.lastErrAt = err.Error(), at
+	} else {
+		series.lastOK = at
+	}
+}
+
+// statsTransport feeds every request through next into stats. Responses with a
+// 5xx status count as errors.
+type statsTransport struct {
+	next  http.RoundTripper
+	stats *backendStats
+}
+
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	recordErr := err
+	if err == nil && resp.StatusCode >= 500 {
+		recordErr = fmt.Errorf("status %d from %s", resp.StatusCode, req.URL.Path)
+	}
+	t.stats.record(req.URL.Host, start, time.Since(start), recordErr)
+	return resp, err
+}
+
+type adminBar struct {
+	X, Y, H    int
+	ErrY, ErrH int
+	Title      string
+}
+
+type adminBackend struct {
+	Host         string
+	Requests     int
+	ErrorRate    string
+	AvgLatency   string
+	MaxLatency   string
+	LastOK       string
+	LastError    string
+	LastErrorAge string
+	RequestBars  []adminBar
+	LatencyBars  []adminBar
+	GraphWidth   int
+	GraphHeight  int
+}
+
+type adminRoute struct {
+	Trip      string
+	Route     string
+	Stop      string
+	LastFetch string
+	Stale     bool
+	NextPoll  string
+	LastError string
+}
+
+type adminPage struct {
+	Generated string
+	Backends  []adminBackend
+	CacheHits int
+	Lookups   int
+	HitRatio  string
+	Routes    []adminRoute
+}
+
+const (
+	adminBarWidth    = 6
+	adminGraphHeight = 40
+)
+
+// summary summarises the last hour of every backend, oldest minute first.
+func (s *backendStats) summary(now time.Time) []adminBackend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []adminBackend
+	for host, series := range s.backends {
+		b := adminBackend{
+			Host:        host,
+			GraphWidth:  statsMinutes * adminBarWidth,
+			GraphHeight: adminGraphHeight,
+			LastError:   series.lastError,
+		}
+		if !series.lastOK.IsZero() {
+			b.LastOK = formatSpan(now.Sub(series.lastOK)) + " ago"
+		}
+		if !series.lastErrAt.IsZero() {
+			b.LastErrorAge = formatSpan(now.Sub(series.lastErrAt)) + " ago"
+		}
+
+		current := now.Unix() / 60
+		var minutes [statsMinutes]statsBucket
+		maxReq, maxLat := 1, time.Millisecond
+		errs := 0
+		var total, worst time.Duration
+		for i := range minutes {
+			m := current - statsMinutes + 1 + int64(i)
+			if bk := series.buckets[m%statsMinutes]; bk.minute == m {
+				minutes[i] = bk
+			}
+			bk := minutes[i]
+			b.Requests += bk.requests
+			errs += bk.errors
+			total += bk.latency
+			worst = max(worst, bk.maxLatency)
+			maxReq = max(maxReq, bk.requests)
+			if bk.requests > 0 {
+				maxLat = max(maxLat, bk.latency/time.Duration(bk.requests))
+			}
+		}
+		for i, bk := range minutes {
+			ago := statsMinutes - 1 - i
+			x := i * adminBarWidth
+			h, errH := bk.requests*adminGraphHeight/maxReq, bk.errors*adminGraphHeight/maxReq
+			b.RequestBars = append(b.RequestBars, adminBar{
+				X: x, Y: adminGraphHeight - h, H: h,
+				ErrY: adminGraphHeight - errH, ErrH: errH,
+				Title: fmt.Sprintf("%d min ago: %d requests, %d errors", ago, bk.requests, bk.errors),
+			})
+			var avg time.Duration
+			if bk.requests > 0 {
+				avg = bk.latency / time.Duration(bk.requests)
+			}
+			h = int(avg * adminGraphHeight / maxLat)
+			b.LatencyBars = append(b.LatencyBars, adminBar{
+				X: x, Y: adminGraphHeight - h, H: h,
+				Title: fmt.Sprintf("%d min ago: %s avg, %s max", ago, formatLatency(avg), formatLatency(bk.maxLatency)),
+			})
+		}
+		if b.Requests > 0 {
+			b.ErrorRate = fmt.Sprintf("%.1f%%", float64(errs)*100/float64(b.Requests))
+			b.AvgLatency = formatLatency(total / time.Duration(b.Requests))
+			b.MaxLatency = formatLatency(worst)
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%d ms", d.Milliseconds())
+}
+
+// formatSpan renders a duration coarsely, e.g. "42s", "5m" or "3h".
+func formatSpan(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
+
+// buildAdminStatusHandler renders backend health, cache effectiveness and the
+// freshness of every route's stop queries.
+func buildAdminStatusHandler(apiURL string, cfg Config, cache *departureCache, p *poller, stats *backendStats) http.HandlerFunc {
+	tmpl := template.Must(template.New("admin").Parse(adminTemplate))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		page := adminPage{
+			Generated: now.In(boardTZ).Format("15:04:05"),
+			Backends:  stats.summary(now),
+		}
+		page.CacheHits, page.Lookups = cache.hitRatio()
+		if page.Lookups > 0 {
+			page.HitRatio = fmt.Sprintf("%.0f%%", float64(page.CacheHits)*100/float64(page.Lookups))
+		}
+
+		for _, trip := range cfg.Trips {
+			for _, route := range trip.Routes {
+				for _, q := range routeQueries(apiURL, route) {
+					ar := adminRoute{Trip: trip.Name, Route: routeLabel(route), Stop: q.stopID, LastFetch: "never", Stale: true}
+					fetchedAt, failure := cache.status(q)
+					if !fetchedAt.IsZero() {
+						ar.LastFetch = formatSpan(now.Sub(fetchedAt)) + " ago"
+						ar.Stale = now.Sub(fetchedAt) > 5*time.Minute
+					}
+					if failure.err != nil && failure.at.After(fetchedAt) {
+						ar.LastError = fmt.Sprintf("%s (%s ago)", failure.err, formatSpan(now.Sub(failure.at)))
+					}
+					if at, ok := p.nextPoll(q); ok {
+						ar.NextPoll = "in " + formatSpan(max(at.Sub(now), 0))
+					}
+					page.Routes = append(page.Routes, ar)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl.Execute(w, page)
+	}
+}
+
+func routeLabel(route RouteConfig) string {
+	label := route.DepartureName
+	if label == "" {
+		label = route.DepartureStopID
+	}
+	if via := route.transferNames(); via != "" {
+		label += " via " + via
+	}
+	return label
+}
+
+var adminTemplate = strings.TrimSpace(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="15">
+<title>Board status</title>
+<style>
+*{margin:0;padding:0;box-sizing:border-box}
+body{font-family:"IBM Plex Sans",system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;color:#1a1a1a;background:#fafafa;padding:16px;max-width:1000px;margin:0 auto}
+h1{font-size:18px;font-weight:600;margin-bottom:16px}
+h2{font-size:15px;font-weight:600;margin:24px 0 8px}
+table{width:100%;border-collapse:collapse;font-size:14px}
+th{text-align:left;font-size:12px;font-weight:500;color:#555;border-bottom:2px solid #e4e4e4;padding:6px 8px}
+td{padding:6px 8px;border-bottom:1px solid #e4e4e4;vertical-align:top}
+.backend{background:#fff;border:1px solid #e4e4e4;border-radius:6px;padding:12px;margin-bottom:12px}
+.backend h3{font-size:14px;font-weight:600;margin-bottom:6px}
+.facts{font-size:13px;color:#555;margin-bottom:8px}
+.graph{font-size:12px;color:#555;display:flex;align-items:flex-end;gap:8px;margin-top:4px}
+.graph span{width:70px}
+svg rect.ok{fill:#4a7bd1}
+svg rect.err{fill:#ff6b6b}
+svg rect.lat{fill:#2f855a}
+.stale{color:#b45309;font-weight:600}
+.err{color:#c53030}
+.none{color:#555}
+</style>
+</head>
+<body>
+<h1>Board status <span class="none">at {{.Generated}}</span></h1>
+
+<h2>Backends (last hour)</h2>
+{{range .Backends}}
+<div class="backend">
+<h3>{{.Host}}</h3>
+<div class="facts">{{.Requests}} requests{{with .ErrorRate}} · {{.}} errors{{end}}{{with .AvgLatency}} · {{.}} avg{{end}}{{with .MaxLatency}} · {{.}} max{{end}}{{with .LastOK}} · last success {{.}}{{end}}</div>
+{{if .LastError}}<div class="facts err">Last error {{.LastErrorAge}}: {{.LastError}}</div>{{end}}
+<div class="graph"><span>Requests</span><svg width="{{.GraphWidth}}" height="{{.GraphHeight}}">{{range .RequestBars}}<rect class="ok" x="{{.X}}" y="{{.Y}}" width="5" height="{{.H}}"><title>{{.Title}}</title></rect>{{if .ErrH}}<rect class="err" x="{{.X}}" y="{{.ErrY}}" width="5" height="{{.ErrH}}"/>{{end}}{{end}}</svg></div>
+<div class="graph"><span>Latency</span><svg width="{{.GraphWidth}}" height="{{.GraphHeight}}">{{range .LatencyBars}}<rect class="lat" x="{{.X}}" y="{{.Y}}" width="5" height="{{.H}}"><title>{{.Title}}</title></rect>{{end}}</svg></div>
+</div>
+{{else}}
+<p class="none">No upstream requests yet.</p>
+{{end}}
+
+<h2>Cache</h2>
+<p class="facts">{{if .HitRatio}}{{.HitRatio}} hit ratio ({{.CacheHits}} of {{.Lookups}} lookups){{else}}No lookups yet.{{end}}</p>
+
+<h2>Routes</h2>
+<table>
+<thead><tr><th>Trip</th><th>Route</th><th>Stop</th><th>Last fetch</th><th>Next poll</th><th>Last error</th></tr></thead>
+<tbody>
+{{range .Routes}}
+<tr>
+<td>{{.Trip}}</td>
+<td>{{.Route}}</td>
+<td>{{.Stop}}</td>
+<td{{if .Stale}} class="stale"{{end}}>{{.LastFetch}}</td>
+<td>{{with .NextPoll}}{{.}}{{else}}<span class="none">on demand</span>{{end}}</td>
+<td class="err">{{.LastError}}</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+</body>
+</html>
+`)
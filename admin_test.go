@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	h := requireAdmin(AdminConfig{Password: "secret"}, func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/admin/status", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected a basic auth challenge, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/status", nil)
+	req.SetBasicAuth("admin", "wrong")
+	w = httptest.NewRecorder()
+	h(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong password, got %d", w.Code)
+	}
+
+	req.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	h(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the right credentials, got %d", w.Code)
+	}
+
+	// A page elsewhere can't post with the admin's remembered login
+	for _, tc := range []struct {
+		header, value string
+		status        int
+	}{
+		{"Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-site", http.StatusForbidden},
+		{"Origin", "http://evil.example", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-origin", http.StatusOK},
+		{"Origin", "http://example.com", http.StatusOK},
+		{"", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/preview/promote", nil)
+		req.SetBasicAuth("admin", "secret")
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != tc.status {
+			t.Errorf("POST with %s %q: expected %d, got %d", tc.header, tc.value, tc.status, w.Code)
+		}
+	}
+
+	if _, err := parseConfig([]byte("config_preview: true\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "admin.password") {
+		t.Errorf("expected config_preview to need admin.password, got %v", err)
+	}
+}
+
+func TestAdminStatusHandler(t *testing.T) {
+	failing := true
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer mock.Close()
+
+	stats := newBackendStats()
+	defer func(orig http.RoundTripper) { gtfsTransport = orig }(gtfsTransport)
+	gtfsTransport = &statsTransport{next: http.DefaultTransport, stats: stats}
+
+	cfg := Config{Trips: []TripConfig{{Name: "Work", Routes: []RouteConfig{
+		{DepartureStopID: "100", DepartureName: "Home", FinalArrivalStop: "300"},
+		{DepartureStopID: "101", DepartureName: "Corner", FinalArrivalStop: "300"},
+	}}}}
+	cache := newDepartureCache(time.Minute)
+	ctx := context.Background()
+
+	if _, err := cache.fetch(ctx, mock.URL, "100", "300", 0); err == nil {
+		t.Fatal("expected the first fetch to fail")
+	}
+	failing = false
+	cache.fetch(ctx, mock.URL, "100", "300", 0)
+	cache.fetch(ctx, mock.URL, "100", "300", 0)
+
+	if hits, lookups := cache.hitRatio(); hits != 1 || lookups != 3 {
+		t.Errorf("expected 1 hit of 3 lookups, got %d of %d", hits, lookups)
+	}
+
+	w := httptest.NewRecorder()
+	buildAdminStatusHandler(mock.URL, cfg, cache, &poller{}, stats)(w, httptest.NewRequest("GET", "/admin/status", nil))
+	body := w.Body.String()
+
+	u, _ := url.Parse(mock.URL)
+	for _, want := range []string{u.Host, "2 requests", "50.0% errors", "status 502", "33% hit ratio", "Corner", "never"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected status page to contain %q", want)
+		}
+	}
+}
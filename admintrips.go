@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxTripBody caps an /admin/trips request body.
+const maxTripBody = 1 << 20
+
+// tripAdmin serves /admin/trips, which edits the running config's trips.
+// Edits are made to the config's YAML source, which is parsed and checked
+// as a reload would be and, if valid, applied straight away. Everything
+// outside trips: (comments included) is kept as it was.
+type tripAdmin struct {
+	live  *liveConfig
+	apply func(Config)
+	// path, when set, is the config file edits are written back to.
+	path string
+
+	// mu serialises edits, so none is lost to a concurrent one.
+	mu sync.Mutex
+}
+
+var errTripNotFound = errors.New("trip not found")
+
+// errTripSave is a valid edit that couldn't be written to the config file.
+var errTripSave = errors.New("saving config")
+
+// errTripConflict is a change that clashes with the existing trips, such
+// as adding a second trip with the same name.
+var errTripConflict = errors.New("conflict")
+
+// handle serves the collection at /admin/trips and each trip, by index,
+// name or slug, at /admin/trips/{trip}:
+//
+//	GET    /admin/trips         list the trips
+//	POST   /admin/trips         add a trip
+//	PUT    /admin/trips         reorder: {"order": [trip, ...]}
+//	GET    /admin/trips/{trip}  one trip
+//	PUT    /admin/trips/{trip}  replace a trip
+//	PATCH  /admin/trips/{trip}  change some of a trip's fields, e.g. {"disabled": true}
+//	DELETE /admin/trips/{trip}  remove a trip
+//
+// Trips are read and written as JSON (or YAML) with the config file's keys.
+func (a *tripAdmin) handle(w http.ResponseWriter, r *http.Request) {
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/trips"), "/")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTripBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var result any
+	status := http.StatusOK
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		result, err = a.list()
+	case key == "" && r.Method == http.MethodPost:
+		result, err = a.edit(func(trips *yaml.Node) (any, error) {
+			node, trip, err := decodeTrip(body, nil)
+			if err != nil {
+				return nil, err
+			}
+			if tripNamed(trips, trip.Name) >= 0 {
+				return nil, fmt.Errorf("%w: trip %q already exists", errTripConflict, trip.Name)
+			}
+			trips.Content = append(trips.Content, node)
+			return node, nil
+		})
+		status = http.StatusCreated
+	case key == "" && r.Method == http.MethodPut:
+		result, err = a.edit(func(trips *yaml.Node) (any, error) {
+			return trips, reorderTrips(trips, body)
+		})
+	case key == "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case r.Method == http.MethodGet:
+		result, err = a.get(key)
+	case r.Method == http.MethodPut, r.Method == http.MethodPatch:
+		result, err = a.edit(func(trips *yaml.Node) (any, error) {
+			_, i := findTripNode(trips, key)
+			if i < 0 {
+				return nil, errTripNotFound
+			}
+			var onto *yaml.Node
+			if r.Method == http.MethodPatch {
+				onto = trips.Content[i]
+			}
+			node, trip, err := decodeTrip(body, onto)
+			if err != nil {
+				return nil, err
+			}
+			if j := tripNamed(trips, trip.Name); j >= 0 && j != i {
+				return nil, fmt.Errorf("%w: trip %q already exists", errTripConflict, trip.Name)
+			}
+			trips.Content[i] = node
+			return node, nil
+		})
+	case r.Method == http.MethodDelete:
+		_, err = a.edit(func(trips *yaml.Node) (any, error) {
+			_, i := findTripNode(trips, key)
+			if i < 0 {
+				return nil, errTripNotFound
+			}
+			trips.Content = append(trips.Content[:i], trips.Content[i+1:]...)
+			return nil, nil
+		})
+		status = http.StatusNoContent
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errTripNotFound):
+		http.Error(w, fmt.Sprintf("unknown trip %q", key), http.StatusNotFound)
+		return
+	case errors.Is(err, errTripConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errTripSave):
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	data, err := nodeJSON(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (a *tripAdmin) list() (any, error) {
+	doc, err := parseSource(a.live.Load().source)
+	if err != nil {
+		return nil, err
+	}
+	return configTrips(doc), nil
+}
+
+func (a *tripAdmin) get(key string) (any, error) {
+	doc, err := parseSource(a.live.Load().source)
+	if err != nil {
+		return nil, err
+	}
+	trips := configTrips(doc)
+	if _, i := findTripNode(trips, key); i >= 0 {
+		return trips.Content[i], nil
+	}
+	return nil, errTripNotFound
+}
+
+// edit applies change to the trips of the running config's source and, if
+// the result is a valid config, saves it when persisting and makes it the
+// running config. It returns what change returns.
+func (a *tripAdmin) edit(change func(trips *yaml.Node) (any, error)) (any, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	source := a.live.Load().source
+	doc, err := parseSource(source)
+	if err != nil {
+		return nil, err
+	}
+	result, err := change(configTrips(doc))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if a.path != "" {
+		if err := saveConfig(a.path, source, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	a.apply(cfg)
+	return result, nil
+}
+
+// saveConfig replaces the config file at path, which should still hold
+// source, with data, and keeps source as path.bak. A file changed since it
+// was loaded is left alone: the edit would lose the change.
+func saveConfig(path string, source, data []byte) error {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTripSave, err)
+	}
+	if !bytes.Equal(current, source) {
+		return fmt.Errorf("%w: %s has changed since it was loaded", errTripConflict, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTripSave, err)
+	}
+	backup := path + ".bak"
+	if err := writeFileAtomic(backup, source); err != nil {
+		return fmt.Errorf("%w: backing up: %w", errTripSave, err)
+	}
+	// The backup may hold passwords, so it gets the config's permissions
+	if err := os.Chmod(backup, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("%w: backing up: %w", errTripSave, err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("%w: %w", errTripSave, err)
+	}
+	return nil
+}
+
+func parseSource(source []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(source, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("config isn't a mapping")
+	}
+	return &doc, nil
+}
+
+// configTrips returns the sequence under the document's trips: key, adding
+// an empty one if there is none.
+func configTrips(doc *yaml.Node) *yaml.Node {
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "trips" {
+			trips := root.Content[i+1]
+			if trips.Kind != yaml.SequenceNode {
+				*trips = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			}
+			return trips
+		}
+	}
+	trips := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "trips"}, trips)
+	return trips
+}
+
+// findTripNode finds a trip in the sequence by index, name or slug, as
+// selectTrip does, returning -1 if there's no such trip.
+func findTripNode(trips *yaml.Node, key string) (TripConfig, int) {
+	configs := make([]TripConfig, len(trips.Content))
+	for i, n := range trips.Content {
+		n.Decode(&configs[i])
+	}
+	if i, err := strconv.Atoi(key); err == nil {
+		if i >= 0 && i < len(configs) {
+			return configs[i], i
+		}
+		return TripConfig{}, -1
+	}
+	for i, trip := range configs {
+		if strings.EqualFold(trip.Name, key) || tripSlug(trip.Name) == key {
+			return trip, i
+		}
+	}
+	return TripConfig{}, -1
+}
+
+// tripNamed returns the index of the trip called name, or -1.
+func tripNamed(trips *yaml.Node, name string) int {
+	for i, n := range trips.Content {
+		var trip TripConfig
+		if n.Decode(&trip) == nil && strings.EqualFold(trip.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeTrip reads a request body's trip, laid over onto's fields when
+// onto isn't nil. Unknown keys are rejected so a misspelt one isn't silently
+// dropped. JSON is YAML, so either works; the trip is kept in block style
+// either way, to sit with the rest of the config.
+func decodeTrip(body []byte, onto *yaml.Node) (*yaml.Node, TripConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, TripConfig{}, fmt.Errorf("decoding trip: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, TripConfig{}, errors.New("decoding trip: expected an object")
+	}
+	node := doc.Content[0]
+	blockStyle(node)
+	if onto != nil {
+		merged := *onto
+		merged.Content = slices.Clone(onto.Content)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			merged.Content = setMapping(merged.Content, node.Content[i], node.Content[i+1])
+		}
+		node = &merged
+	}
+
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, TripConfig{}, err
+	}
+	var trip TripConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&trip); err != nil {
+		return nil, TripConfig{}, fmt.Errorf("decoding trip: %w", err)
+	}
+	if trip.Name == "" {
+		return nil, TripConfig{}, errors.New("trip needs a name")
+	}
+	return node, trip, nil
+}
+
+// setMapping sets key to value in a mapping's key/value pairs.
+func setMapping(pairs []*yaml.Node, key, value *yaml.Node) []*yaml.Node {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i].Value == key.Value {
+			pairs[i+1] = value
+			return pairs
+		}
+	}
+	return append(pairs, key, value)
+}
+
+// blockStyle clears the flow and quoting styles JSON comes with. Strings
+// that need quoting, such as stop IDs that look like numbers, are still
+// quoted.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// reorderTrips puts the trips in the order body lists them, which must be
+// every trip once.
+func reorderTrips(trips *yaml.Node, body []byte) error {
+	var req struct {
+		Order []string `yaml:"order"`
+	}
+	if err := yaml.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("decoding order: %w", err)
+	}
+	if len(req.Order) != len(trips.Content) {
+		return fmt.Errorf("order must list all %d trips", len(trips.Content))
+	}
+	ordered := make([]*yaml.Node, 0, len(req.Order))
+	seen := make(map[int]bool)
+	for _, key := range req.Order {
+		_, i := findTripNode(trips, key)
+		if i < 0 {
+			return fmt.Errorf("unknown trip %q", key)
+		}
+		if seen[i] {
+			return fmt.Errorf("trip %q is listed twice", key)
+		}
+		seen[i] = true
+		ordered = append(ordered, trips.Content[i])
+	}
+	trips.Content = ordered
+	return nil
+}
+
+// nodeJSON turns a YAML node (or nil) into JSON with the same keys.
+func nodeJSON(v any) ([]byte, error) {
+	n, ok := v.(*yaml.Node)
+	if !ok || n == nil {
+		return json.Marshal(v)
+	}
+	var out any
+	if err := n.Decode(&out); err != nil {
+		return nil, err
+	}
+	if out == nil && n.Kind == yaml.SequenceNode {
+		out = []any{}
+	}
+	return json.Marshal(out)
+}
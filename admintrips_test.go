@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTripsConfig = `# Board for the hallway
+window_minutes: 90
+trips:
+  - name: Home
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+  - name: To Work
+    routes:
+      - departure_stop_id: "200"
+        final_arrival_stop: "400"
+`
+
+func newTestTripAdmin(t *testing.T) *tripAdmin {
+	t.Helper()
+	cfg, err := parseConfig([]byte(testTripsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := newLiveConfig(cfg)
+	return &tripAdmin{live: live, apply: live.store}
+}
+
+func tripNames(cfg Config) string {
+	var names []string
+	for _, trip := range cfg.Trips {
+		names = append(names, trip.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestTripAdmin(t *testing.T) {
+	a := newTestTripAdmin(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.handle(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do("POST", "/admin/trips", `{"name": "School", "routes": [{"departure_stop_id": "500", "final_arrival_stop": "600"}]}`)
+	var trip map[string]any
+	if rec.Code != 201 || json.Unmarshal(rec.Body.Bytes(), &trip) != nil || trip["name"] != "School" {
+		t.Fatalf("expected the new trip back, got %d %s", rec.Code, rec.Body)
+	}
+	if got := tripNames(a.live.Load()); got != "Home,To Work,School" {
+		t.Errorf("expected School to be running, got %s", got)
+	}
+	if rec := do("POST", "/admin/trips", `{"name": "school", "routes": []}`); rec.Code != 409 {
+		t.Errorf("expected a duplicate name to conflict, got %d", rec.Code)
+	}
+
+	if rec := do("PATCH", "/admin/trips/to-work", `{"disabled": true}`); rec.Code != 200 {
+		t.Fatalf("unexpected %d %s", rec.Code, rec.Body)
+	}
+	if got := tripNames(a.live.Load()); got != "Home,School" {
+		t.Errorf("expected To Work to be off the board, got %s", got)
+	}
+	rec = do("GET", "/admin/trips/1", "")
+	if !strings.Contains(rec.Body.String(), `"disabled":true`) || !strings.Contains(rec.Body.String(), `"departure_stop_id":"200"`) {
+		t.Errorf("expected the disabled trip with its routes, got %s", rec.Body)
+	}
+
+	if rec := do("PUT", "/admin/trips", `{"order": ["School", "Home", "to-work"]}`); rec.Code != 200 {
+		t.Fatalf("unexpected %d %s", rec.Code, rec.Body)
+	}
+	if got := tripNames(a.live.Load()); got != "School,Home" {
+		t.Errorf("expected School first, got %s", got)
+	}
+	if rec := do("PUT", "/admin/trips", `{"order": ["School", "Home"]}`); rec.Code != 400 {
+		t.Errorf("expected an incomplete order to be rejected, got %d", rec.Code)
+	}
+
+	if rec := do("DELETE", "/admin/trips/home", ""); rec.Code != 204 {
+		t.Fatalf("unexpected %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("DELETE", "/admin/trips/home", ""); rec.Code != 404 {
+		t.Errorf("expected 404 for a removed trip, got %d", rec.Code)
+	}
+	if got := tripNames(a.live.Load()); got != "School" {
+		t.Errorf("expected only School, got %s", got)
+	}
+
+	// Everything but the trips is as it was
+	src := string(a.live.Load().source)
+	if !strings.HasPrefix(src, "# Board for the hallway\nwindow_minutes: 90\n") {
+		t.Errorf("expected the rest of the config kept, got\n%s", src)
+	}
+}
+
+func TestTripAdmin_Invalid(t *testing.T) {
+	a := newTestTripAdmin(t)
+	for _, req := range []struct{ method, path, body string }{
+		{"POST", "/admin/trips", `{"name": "School", "routez": []}`},
+		{"POST", "/admin/trips", `{"routes": []}`},
+		{"PUT", "/admin/trips/home", `{"name": "Home", "window_minutes": -5}`},
+		{"PATCH", "/admin/trips/home", `{"name": "To Work"}`},
+		{"DELETE", "/admin/trips", ""},
+	} {
+		rec := httptest.NewRecorder()
+		a.handle(rec, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+		if rec.Code < 400 {
+			t.Errorf("%s %s %s: expected an error, got %d", req.method, req.path, req.body, rec.Code)
+		}
+	}
+	if got := tripNames(a.live.Load()); got != "Home,To Work" {
+		t.Errorf("expected the running trips unchanged, got %s", got)
+	}
+}
+
+func TestTripAdmin_Persist(t *testing.T) {
+	a := newTestTripAdmin(t)
+	a.path = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(a.path, []byte(testTripsConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	a.handle(rec, httptest.NewRequest("DELETE", "/admin/trips/home", nil))
+	if rec.Code != 204 {
+		t.Fatalf("unexpected %d %s", rec.Code, rec.Body)
+	}
+	saved, _ := os.ReadFile(a.path)
+	if string(saved) != string(a.live.Load().source) || strings.Contains(string(saved), "Home") {
+		t.Errorf("expected the edit saved, got\n%s", saved)
+	}
+	backup, _ := os.ReadFile(a.path + ".bak")
+	if string(backup) != testTripsConfig {
+		t.Errorf("expected the previous config backed up, got\n%s", backup)
+	}
+	if info, err := os.Stat(a.path + ".bak"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the backup to keep the config's permissions, got %v %v", info, err)
+	}
+
+	// A change on disk that hasn't been loaded yet isn't overwritten
+	os.WriteFile(a.path, []byte(testTripsConfig+"# edited by hand\n"), 0600)
+	rec = httptest.NewRecorder()
+	a.handle(rec, httptest.NewRequest("POST", "/admin/trips", strings.NewReader(`{"name": "School", "routes": []}`)))
+	if rec.Code != 409 {
+		t.Errorf("expected a conflict, got %d %s", rec.Code, rec.Body)
+	}
+	if got := tripNames(a.live.Load()); got != "To Work" {
+		t.Errorf("expected the running trips unchanged, got %s", got)
+	}
+}
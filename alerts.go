@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertsTTL is how long service alerts are reused between page loads.
+const alertsTTL = time.Minute
+
+// AlertsConfig shows the service alerts affecting each trip as a banner on
+// its tab. Alerts come from the upstream's /alerts endpoint, or from a
+// GTFS-realtime alerts feed when gtfs_rt_url is set.
+type AlertsConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	GTFSRTURL string `yaml:"gtfs_rt_url,omitempty"`
+	// APIKey is sent as "Authorization: apikey <key>" with GTFS-realtime
+	// feed requests, as TfNSW expects.
+	APIKey string `yaml:"api_key,omitempty"`
+}
+
+type Alert struct {
+	ID          string     `json:"id"`
+	Header      string     `json:"header"`
+	Description string     `json:"description,omitempty"`
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveTo    *time.Time `json:"active_to,omitempty"`
+	Routes      []string   `json:"routes,omitempty"`
+	StopIDs     []string   `json:"stop_ids,omitempty"`
+	// Severity is "info", "warning" or "severe"; unknown severities are
+	// shown as warnings.
+	Severity string `json:"severity,omitempty"`
+}
+
+// AlertView is an alert as shown in a trip's banner.
+type AlertView struct {
+	Header      string `json:"header"`
+	Description string `json:"description,omitempty"`
+	Severity    string `json:"severity"`
+}
+
+// affects reports whether the alert names one of the stops or routes. An
+// alert naming neither applies to the whole network.
+func (a Alert) affects(stops, routes map[string]bool) bool {
+	if len(a.StopIDs) == 0 && len(a.Routes) == 0 {
+		return true
+	}
+	for _, id := range a.StopIDs {
+		if stops[id] {
+			return true
+		}
+	}
+	for _, r := range a.Routes {
+		if routes[r] {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Alert) severity() string {
+	switch a.Severity {
+	case "info", "severe":
+		return a.Severity
+	}
+	return "warning"
+}
+
+// activeDuring reports whether the alert's active period overlaps [from, to).
+// Open-ended periods extend indefinitely in that direction.
+func (a Alert) activeDuring(from, to time.Time) bool {
+	if a.ActiveFrom != nil && !a.ActiveFrom.Before(to) {
+		return false
+	}
+	if a.ActiveTo != nil && !a.ActiveTo.After(from) {
+		return false
+	}
+	return true
+}
+
+// fetchAlerts returns the current and planned service alerts affecting any of
+// the given stops. An upstream without an alerts endpoint (404) has none, as
+// do the other departure sources (alerts.gtfs_rt_url reads a GTFS-realtime
+// feed's).
+func fetchAlerts(ctx context.Context, apiURL string, stopIDs []string) ([]Alert, error) {
+	if sourceFor(apiURL) != apiSource(apiURL) {
+		return nil, nil
+	}
+	u := fmt.Sprintf("%s/alerts?stop_ids=%s", apiURL, url.QueryEscape(strings.Join(stopIDs, ",")))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestID(req)
+
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var alerts []Alert
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return alerts, nil
+}
+
+// tripStopIDs returns the distinct stop IDs a trip's routes use, in config
+// order.
+func tripStopIDs(trip TripConfig) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, route := range trip.Routes {
+		stopIDs := []string{route.DepartureStopID}
+		for _, t := range route.transfers() {
+			stopIDs = append(stopIDs, t.TransferArrivalStopID, t.TransferDepartureStopID)
+		}
+		for _, id := range append(stopIDs, route.FinalArrivalStop) {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// tripStopsByAPI splits a trip's stop IDs by the GTFS departure service its
+// routes use, apiURL for those that don't set their own.
+func tripStopsByAPI(apiURL string, trip TripConfig) map[string][]string {
+	routes := make(map[string][]RouteConfig)
+	for _, route := range trip.Routes {
+		api := route.upstreamURL(apiURL)
+		routes[api] = append(routes[api], route)
+	}
+	byAPI := make(map[string][]string, len(routes))
+	for api, rs := range routes {
+		byAPI[api] = tripStopIDs(TripConfig{Routes: rs})
+	}
+	return byAPI
+}
+
+// serviceAlerts reads service alerts for the board's trips, caching them for
+// alertsTTL. A GTFS-realtime feed is fetched whole and shared by every trip.
+type serviceAlerts struct {
+	feedURL string
+	apiKey  string
+
+	mu      sync.Mutex
+	entries map[string]alertsEntry
+}
+
+type alertsEntry struct {
+	alerts    []Alert
+	fetchedAt time.Time
+}
+
+func newServiceAlerts(cfg AlertsConfig) *serviceAlerts {
+	return &serviceAlerts{feedURL: cfg.GTFSRTURL, apiKey: cfg.APIKey, entries: make(map[string]alertsEntry)}
+}
+
+func (s *serviceAlerts) fetch(ctx context.Context, apiURL string, stopIDs []string) ([]Alert, error) {
+	key := s.feedURL
+	if key == "" {
+		key = apiURL + "?" + strings.Join(stopIDs, ",")
+	}
+	s.mu.Lock()
+	e, ok := s.entries[key]
+	s.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < alertsTTL {
+		return e.alerts, nil
+	}
+
+	var alerts []Alert
+	var err error
+	if s.feedURL != "" {
+		alerts, err = s.fetchFeed(ctx)
+	} else {
+		alerts, err = fetchAlerts(ctx, apiURL, stopIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.entries[key] = alertsEntry{alerts: alerts, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return alerts, nil
+}
+
+func (s *serviceAlerts) fetchFeed(ctx context.Context) ([]Alert, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/x-protobuf")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "apikey "+s.apiKey)
+	}
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GTFS-realtime feed returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := parseGTFSRTAlerts(body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding GTFS-realtime feed: %w", err)
+	}
+	return alerts, nil
+}
+
+// tripAlerts returns the alerts affecting the trip's stops, or the services
+// its routes filter on or its departures run, that are active at some point
+// in the next windowMinutes. Alerts that fail to load are logged and left
+// out.
+func (s *serviceAlerts) tripAlerts(ctx context.Context, apiURL string, trip TripConfig, deps []DepartureView, now time.Time, windowMinutes int) []AlertView {
+	var alerts []Alert
+	for api, stopIDs := range tripStopsByAPI(apiURL, trip) {
+		a, err := s.fetch(ctx, api, stopIDs)
+		if err != nil {
+			log.Printf("alerts for trip %q: %v", trip.Name, err)
+			continue
+		}
+		alerts = append(alerts, a...)
+		if s.feedURL != "" {
+			// Every trip shares the one feed.
+			break
+		}
+	}
+
+	stopIDs := tripStopIDs(trip)
+
+	stops := make(map[string]bool)
+	for _, id := range stopIDs {
+		stops[id] = true
+	}
+	routes := make(map[string]bool)
+	for _, route := range trip.Routes {
+		for _, name := range route.Leg1Services {
+			routes[name] = true
+		}
+		for _, t := range route.transfers() {
+			for _, name := range t.Services {
+				routes[name] = true
+			}
+		}
+	}
+	for _, dv := range deps {
+		routes[dv.RouteShortName] = true
+		for _, c := range dv.Connections {
+			routes[c.RouteShortName] = true
+		}
+	}
+
+	var views []AlertView
+	seen := make(map[string]bool)
+	end := now.Add(time.Duration(windowMinutes) * time.Minute)
+	for _, a := range alerts {
+		if !a.activeDuring(now, end) || !a.affects(stops, routes) {
+			continue
+		}
+		// Alerts with several active periods appear once per period.
+		if a.ID != "" {
+			if seen[a.ID] {
+				continue
+			}
+			seen[a.ID] = true
+		}
+		views = append(views, AlertView{Header: a.Header, Description: a.Description, Severity: a.severity()})
+	}
+	return views
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlertActiveDuring(t *testing.T) {
+	day := time.Date(2024, 6, 8, 0, 0, 0, 0, boardTZ)
+	next := day.AddDate(0, 0, 1)
+	at := func(d time.Duration) *time.Time { t := day.Add(d); return &t }
+
+	tests := []struct {
+		name     string
+		alert    Alert
+		expected bool
+	}{
+		{"open-ended", Alert{}, true},
+		{"weekend trackwork", Alert{ActiveFrom: at(-24 * time.Hour), ActiveTo: at(48 * time.Hour)}, true},
+		{"ends before the day", Alert{ActiveTo: at(0)}, false},
+		{"starts after the day", Alert{ActiveFrom: at(24 * time.Hour)}, false},
+		{"evening only", Alert{ActiveFrom: at(20 * time.Hour), ActiveTo: at(23 * time.Hour)}, true},
+	}
+	for _, tc := range tests {
+		if got := tc.alert.activeDuring(day, next); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestFetchAlerts(t *testing.T) {
+	var gotStops string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotStops = r.URL.Query().Get("stop_ids")
+		json.NewEncoder(w).Encode([]Alert{{ID: "a1", Header: "Buses replace trains"}})
+	}))
+	defer mock.Close()
+
+	alerts, err := fetchAlerts(context.Background(), mock.URL, []string{"100", "200"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotStops != "100,200" {
+		t.Errorf("expected comma-separated stop IDs, got %q", gotStops)
+	}
+	if len(alerts) != 1 || alerts[0].Header != "Buses replace trains" {
+		t.Errorf("unexpected alerts: %+v", alerts)
+	}
+
+	unsupported := httptest.NewServer(http.NotFoundHandler())
+	defer unsupported.Close()
+	alerts, err = fetchAlerts(context.Background(), unsupported.URL, []string{"100"})
+	if err != nil || alerts != nil {
+		t.Errorf("expected no alerts from an upstream without the endpoint, got %v, %v", alerts, err)
+	}
+}
+
+func TestTripStopIDs(t *testing.T) {
+	trip := TripConfig{Routes: []RouteConfig{
+		{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300"},
+		{DepartureStopID: "100", FinalArrivalStop: "300"},
+	}}
+	got := tripStopIDs(trip)
+	if len(got) != 4 || got[0] != "100" || got[3] != "300" {
+		t.Errorf("expected distinct stops in config order, got %v", got)
+	}
+}
+
+func TestTripAlerts(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	later, tomorrow := now.Add(30*time.Minute), now.Add(24*time.Hour)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/alerts" {
+			w.Write([]byte("[]"))
+			return
+		}
+		json.NewEncoder(w).Encode([]Alert{
+			{ID: "lift", Header: "Lift out of service", StopIDs: []string{"100"}, Severity: "info"},
+			{ID: "signals", Header: "Signal repairs", ActiveFrom: &later},
+			{ID: "closure", Header: "Line closed", ActiveFrom: &tomorrow, Severity: "severe"},
+			{ID: "elsewhere", Header: "Stop moved", StopIDs: []string{"999"}},
+		})
+	}))
+	defer mock.Close()
+
+	cfg, err := parseConfig([]byte(`
+alerts: {enabled: true}
+trips:
+  - name: To Work
+    routes: [{departure_stop_id: "100", final_arrival_stop: "300"}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.startClients()
+	tv, err := buildTripView(context.Background(), nil, mock.URL, cfg, cfg.Trips[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AlertView{{Header: "Lift out of service", Severity: "info"}, {Header: "Signal repairs", Severity: "warning"}}
+	if len(tv.Alerts) != len(want) || tv.Alerts[0] != want[0] || tv.Alerts[1] != want[1] {
+		t.Errorf("expected the alerts active in the window for stop 100, got %+v", tv.Alerts)
+	}
+}
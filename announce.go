@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type AnnouncementsConfig struct {
+	// TTSURL is fetched with {text} replaced by the URL-escaped announcement
+	// and must return audio, e.g. "http://localhost:5500/api/tts?text={text}".
+	TTSURL string `yaml:"tts_url"`
+}
+
+var numberWords = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+	"seventeen", "eighteen", "nineteen",
+}
+
+var tensWords = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+
+// spokenNumber spells out n for text-to-speech engines that read digits
+// awkwardly. Numbers of 100 and above are left as digits.
+func spokenNumber(n int) string {
+	switch {
+	case n < 0 || n >= 100:
+		return fmt.Sprint(n)
+	case n < 20:
+		return numberWords[n]
+	case n%10 == 0:
+		return tensWords[n/10]
+	default:
+		return tensWords[n/10] + "-" + numberWords[n%10]
+	}
+}
+
+// announcementText describes the trip's next departure as a sentence.
+func announcementText(tv TripView, now time.Time) string {
+	if len(tv.Departures) == 0 {
+		return fmt.Sprintf("There are no departures for %s in the next %s minutes.", tv.Name, spokenNumber(tv.WindowMinutes))
+	}
+
+	dv := tv.Departures[0]
+	var b strings.Builder
+	b.WriteString("The next ")
+	b.WriteString(dv.RouteShortName)
+	if dv.Headsign != "" {
+		b.WriteString(" to " + dv.Headsign)
+	}
+
+	mins := int(dv.departureAt.Sub(now).Minutes())
+	switch {
+	case mins <= 0:
+		b.WriteString(" departs now")
+	case mins == 1:
+		b.WriteString(" departs in one minute")
+	default:
+		b.WriteString(" departs in " + spokenNumber(mins) + " minutes")
+	}
+
+	if dv.FinalArrivalTime != "" && dv.ArrivalName != "" {
+		b.WriteString(", arriving at " + dv.ArrivalName + " at " + dv.FinalArrivalTime)
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+// buildAnnounceHandler serves the next-departure announcement for ?trip= as
+// plain text, or with ?format=audio streams it through the configured TTS
+// endpoint.
+func buildAnnounceHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		trip, ok := selectTrip(cfg.Trips, r.URL.Query().Get("trip"))
+		if !ok {
+			http.Error(w, "unknown trip", http.StatusNotFound)
+			return
+		}
+
+		now := time.Now().In(boardTZ)
+		tv, err := buildTripView(r.Context(), cache, apiURL, cfg, trip, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		text := announcementText(tv, now)
+
+		if r.URL.Query().Get("format") != "audio" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintln(w, text)
+			return
+		}
+
+		if cfg.Announcements.TTSURL == "" {
+			http.Error(w, "no tts_url configured", http.StatusNotFound)
+			return
+		}
+		ttsURL := strings.ReplaceAll(cfg.Announcements.TTSURL, "{text}", url.QueryEscape(text))
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, ttsURL, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			http.Error(w, "TTS request failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			http.Error(w, fmt.Sprintf("TTS returned status %d", resp.StatusCode), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.Header().Set("Cache-Control", "no-store")
+		io.Copy(w, resp.Body)
+	}
+}
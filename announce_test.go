@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpokenNumber(t *testing.T) {
+	tests := map[int]string{0: "zero", 5: "five", 13: "thirteen", 20: "twenty", 42: "forty-two", 120: "120"}
+	for n, want := range tests {
+		if got := spokenNumber(n); got != want {
+			t.Errorf("spokenNumber(%d): expected %q, got %q", n, want, got)
+		}
+	}
+}
+
+func TestAnnouncementText(t *testing.T) {
+	now := time.Date(2024, 6, 3, 8, 0, 0, 0, boardTZ)
+
+	tv := TripView{
+		Name: "To Work",
+		Departures: []DepartureView{{
+			RouteShortName:   "T1",
+			Headsign:         "the City",
+			departureAt:      now.Add(5*time.Minute + 20*time.Second),
+			FinalArrivalTime: "08:42",
+			ArrivalName:      "Wynyard",
+		}},
+	}
+	want := "The next T1 to the City departs in five minutes, arriving at Wynyard at 08:42."
+	if got := announcementText(tv, now); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	tv.Departures[0].departureAt = now.Add(30 * time.Second)
+	if got := announcementText(tv, now); !strings.Contains(got, "departs now") {
+		t.Errorf("expected 'departs now', got %q", got)
+	}
+
+	if got := announcementText(TripView{Name: "To Work"}, now); !strings.Contains(got, "no departures") {
+		t.Errorf("expected no departures message, got %q", got)
+	}
+}
+
+func TestAnnounceHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				Headsign:           "City",
+				ScheduledDeparture: now.Add(10 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	var spoken string
+	tts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spoken = r.URL.Query().Get("text")
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write([]byte("RIFF"))
+	}))
+	defer tts.Close()
+
+	cfg := Config{
+		Announcements: AnnouncementsConfig{TTSURL: tts.URL + "/api/tts?text={text}"},
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300", ArrivalName: "Work"}}},
+		},
+	}
+	handler := buildAnnounceHandler(mock.URL, cfg, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/announce?trip=To+Work", nil))
+	if !strings.HasPrefix(w.Body.String(), "The next T1 to City departs in") {
+		t.Errorf("unexpected announcement %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/announce?trip=To+Work&format=audio", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "audio/wav" || w.Body.String() != "RIFF" {
+		t.Errorf("expected TTS audio to be streamed, got %d %q", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(spoken, "The next T1 to City") {
+		t.Errorf("expected announcement sent to TTS, got %q", spoken)
+	}
+
+	cfg.Announcements.TTSURL = ""
+	handler = buildAnnounceHandler(mock.URL, cfg, nil)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/announce?format=audio", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 without tts_url, got %d", w.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// nextDepartureMaxAge is the Cache-Control max-age of /api/next. The payload
+// only changes at minute granularity. It is private: behind auth, a shared
+// cache would hand one client's answer to anyone.
+const nextDepartureMaxAge = 60
+
+type NextDeparture struct {
+	Route   string `json:"route,omitempty"`
+	Mins    *int   `json:"mins,omitempty"`
+	Arrives string `json:"arrives,omitempty"`
+}
+
+// buildNextHandler serves the next viable departure of one trip as a tiny JSON
+// object for watch complications and widgets. ?trip= selects the trip by index
+// or name; an empty object means nothing departs within the window.
+func buildNextHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trip, ok := selectTrip(cfg.Trips, r.URL.Query().Get("trip"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "unknown trip")
+			return
+		}
+
+		now := time.Now().In(boardTZ)
+		tv, err := buildTripView(r.Context(), cache, apiURL, cfg, trip, now)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		var next NextDeparture
+		if dv, ok := nextDeparture(tv.Departures); ok {
+			mins := max(int(dv.departureAt.Sub(now).Minutes()), 0)
+			next = NextDeparture{Route: dv.RouteShortName, Mins: &mins, Arrives: dv.FinalArrivalTime}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(nextDepartureMaxAge))
+		json.NewEncoder(w).Encode(next)
+	}
+}
+
+type Board struct {
+	Now           time.Time `json:"now"`
+	TimeZone      string    `json:"time_zone"`
+	Hour12        bool      `json:"hour12,omitempty"`
+	AM            string    `json:"am,omitempty"`
+	PM            string    `json:"pm,omitempty"`
+	WindowMinutes int       `json:"window_minutes"`
+	HourGroups    bool      `json:"hour_groups,omitempty"`
+	// RefreshSeconds is how often the board should be refetched.
+	RefreshSeconds int         `json:"refresh_seconds"`
+	Trips          []BoardTrip `json:"trips"`
+}
+
+type BoardTrip struct {
+	Name           string            `json:"name"`
+	Departures     []BoardDeparture  `json:"departures"`
+	Bikes          []BikeStationView `json:"bikes,omitempty"`
+	CycleArrival   string            `json:"cycle_arrival,omitempty"`
+	CarParks       []CarParkView     `json:"car_parks,omitempty"`
+	Alerts         []AlertView       `json:"alerts,omitempty"`
+	Fallback       *FallbackView     `json:"fallback,omitempty"`
+	AsOf           string            `json:"as_of,omitempty"`
+	UpdatedAgo     string            `json:"updated_ago,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	WindowMinutes  int               `json:"window_minutes"`
+	HourGroups     bool              `json:"hour_groups,omitempty"`
+	RefreshSeconds int               `json:"refresh_seconds"`
+	ArriveBy       string            `json:"arrive_by,omitempty"`
+}
+
+type BoardDeparture struct {
+	DepartureView
+	DepartsAt time.Time  `json:"departs_at"`
+	LeavesAt  *time.Time `json:"leaves_at,omitempty"`
+	Hour      string     `json:"hour"`
+}
+
+// newBoard converts rendered page data to the JSON the client-side renderer
+// consumes. departs_at lets the browser count down without refetching
+// (leaves_at instead, for routes with a walk to the stop), and hour is the
+// departure's hour group header.
+func newBoard(data PageData) Board {
+	b := Board{
+		Now:            data.Now,
+		TimeZone:       data.Now.Location().String(),
+		Hour12:         displayLocale.Hour12,
+		AM:             displayLocale.AM,
+		PM:             displayLocale.PM,
+		WindowMinutes:  data.WindowMinutes,
+		HourGroups:     data.WindowMinutes > hourGroupMinutes,
+		RefreshSeconds: data.RefreshSeconds,
+		Trips:          []BoardTrip{},
+	}
+	for _, tv := range data.Trips {
+		bt := BoardTrip{Name: tv.Name, Departures: []BoardDeparture{}, Bikes: tv.Bikes, CycleArrival: tv.CycleArrival, CarParks: tv.CarParks, Alerts: tv.Alerts, Fallback: tv.Fallback, AsOf: tv.AsOf, UpdatedAgo: tv.UpdatedAgo, Error: tv.Error, WindowMinutes: tv.WindowMinutes, HourGroups: tv.WindowMinutes > hourGroupMinutes, RefreshSeconds: tv.RefreshSeconds, ArriveBy: tv.ArriveBy}
+		for _, dv := range tv.Departures {
+			bd := BoardDeparture{DepartureView: dv, DepartsAt: dv.departureAt, Hour: displayLocale.Hour(dv.departureAt)}
+			if !dv.leaveAt.IsZero() {
+				bd.LeavesAt = &dv.leaveAt
+			}
+			bt.Departures = append(bt.Departures, bd)
+		}
+		b.Trips = append(b.Trips, bt)
+	}
+	return b
+}
+
+// buildBoardHandler serves the departures of every trip the board shows as
+// JSON, used by the client-side renderer to refresh the board without
+// reloading the page. ?trip= matches the page's, keeping a hidden trip it
+// selected.
+func buildBoardHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trips, _, ok := cfg.pageTrips(time.Now(), r.URL.Query().Get("trip"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data := buildPageData(r.Context(), cache, apiURL, cfg, trips)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(newBoard(data))
+	}
+}
+
+type Departures struct {
+	GeneratedAt   time.Time `json:"generated_at"`
+	TimeZone      string    `json:"time_zone"`
+	WindowMinutes int       `json:"window_minutes"`
+	Trips         []APITrip `json:"trips"`
+}
+
+type APITrip struct {
+	Name          string         `json:"name"`
+	WindowMinutes int            `json:"window_minutes"`
+	ArriveBy      string         `json:"arrive_by,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	AsOf          string         `json:"as_of,omitempty"`
+	UpdatedAgo    string         `json:"updated_ago,omitempty"`
+	Fallback      *FallbackView  `json:"fallback,omitempty"`
+	Departures    []APIDeparture `json:"departures"`
+}
+
+// APIDeparture is a DepartureView with the absolute times behind its display
+// strings. arrives_at is omitted when the connection is unknown.
+type APIDeparture struct {
+	DepartureView
+	DepartsAt          time.Time  `json:"departs_at"`
+	ScheduledDeparture time.Time  `json:"scheduled_departure"`
+	ArrivesAt          *time.Time `json:"arrives_at,omitempty"`
+}
+
+// buildDeparturesHandler serves the computed departures of every trip (or just
+// ?trip=) as JSON, for displays and widgets that want the board's journey
+// logic without its layout. A trip that fails to load carries its error
+// rather than failing the response.
+func buildDeparturesHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trips := cfg.Trips
+		if key := r.URL.Query().Get("trip"); key != "" {
+			trip, ok := selectTrip(cfg.Trips, key)
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "unknown trip")
+				return
+			}
+			trips = []TripConfig{trip}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(buildDepartures(r.Context(), cache, apiURL, cfg, trips))
+	}
+}
+
+// buildDepartures computes trips' departures as of now. A trip that fails to
+// load gets its error instead of departures.
+func buildDepartures(ctx context.Context, cache *departureCache, apiURL string, cfg Config, trips []TripConfig) Departures {
+	now := time.Now().In(boardTZ)
+	resp := Departures{GeneratedAt: now, TimeZone: now.Location().String(), WindowMinutes: cfg.windowMinutes(TripConfig{}), Trips: []APITrip{}}
+	for _, trip := range trips {
+		at := APITrip{Name: trip.Name, WindowMinutes: cfg.windowMinutes(trip), Departures: []APIDeparture{}}
+		tv, err := buildTripView(ctx, cache, apiURL, cfg, trip, now)
+		if err != nil {
+			at.Error = err.Error()
+			resp.Trips = append(resp.Trips, at)
+			continue
+		}
+		at.AsOf, at.UpdatedAgo, at.Fallback, at.ArriveBy = tv.AsOf, tv.UpdatedAgo, tv.Fallback, tv.ArriveBy
+		for _, dv := range tv.Departures {
+			at.Departures = append(at.Departures, apiDeparture(dv))
+		}
+		resp.Trips = append(resp.Trips, at)
+	}
+	return resp
+}
+
+func apiDeparture(dv DepartureView) APIDeparture {
+	ad := APIDeparture{DepartureView: dv, DepartsAt: dv.departureAt, ScheduledDeparture: dv.scheduledAt}
+	if dv.HasConnection {
+		arrives := dv.finalArrivalSort
+		ad.ArrivesAt = &arrives
+	}
+	return ad
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				ScheduledDeparture: now.Add(5*time.Minute + 30*time.Second),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300", FinalWalkTime: 60}}},
+			{Name: "To Home", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "100"}}},
+		},
+	}
+
+	handler := buildNextHandler(mock.URL, cfg, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/next?trip=To+Work", nil))
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private") || !strings.Contains(cc, "max-age=60") {
+		t.Errorf("expected max-age cache header, got %q", cc)
+	}
+
+	var next NextDeparture
+	if err := json.NewDecoder(w.Body).Decode(&next); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if next.Route != "T1" {
+		t.Errorf("expected route T1, got %q", next.Route)
+	}
+	if next.Mins == nil || *next.Mins != 5 {
+		t.Errorf("expected 5 mins, got %v", next.Mins)
+	}
+	if want := now.Add(31 * time.Minute).Format("15:04"); next.Arrives != want {
+		t.Errorf("expected arrival %s, got %s", want, next.Arrives)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/next?trip=To+Home", nil))
+	if body := strings.TrimSpace(w.Body.String()); body != "{}" {
+		t.Errorf("expected empty object when nothing departs, got %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/next?trip=Gym", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 for unknown trip, got %d", w.Code)
+	}
+}
+
+func TestNextDeparture(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	// Sorted by arrival: the express leaves later but gets in first, and the
+	// first row's connection is unknown
+	deps := []DepartureView{
+		{RouteShortName: "?", departureAt: now.Add(2 * time.Minute), ConnectionUnknown: true},
+		{RouteShortName: "X1", departureAt: now.Add(12 * time.Minute), HasConnection: true},
+		{RouteShortName: "T1", departureAt: now.Add(8 * time.Minute), HasConnection: true},
+		{RouteShortName: "W1", departureAt: now.Add(10 * time.Minute), leaveAt: now.Add(4 * time.Minute), HasConnection: true},
+	}
+	if dv, ok := nextDeparture(deps); !ok || dv.RouteShortName != "W1" {
+		t.Errorf("expected the first to leave with a connection, got %q", dv.RouteShortName)
+	}
+	if _, ok := nextDeparture(deps[:1]); ok {
+		t.Error("expected no next departure without a connection")
+	}
+}
+
+func TestBoardHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	departs := now.Add(10 * time.Minute).Truncate(time.Second)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				Headsign:           "City",
+				ScheduledDeparture: departs,
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+			{Name: "To Home", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "100"}}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	buildBoardHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/api/board", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var board struct {
+		TimeZone string `json:"time_zone"`
+		Trips    []struct {
+			Name       string           `json:"name"`
+			Departures []map[string]any `json:"departures"`
+		} `json:"trips"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&board); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if board.TimeZone != "Australia/Sydney" {
+		t.Errorf("expected board time zone, got %q", board.TimeZone)
+	}
+	if len(board.Trips) != 2 || board.Trips[1].Name != "To Home" {
+		t.Fatalf("expected both trips, got %+v", board.Trips)
+	}
+	if len(board.Trips[1].Departures) != 0 {
+		t.Errorf("expected empty departures list for the second trip")
+	}
+
+	dep := board.Trips[0].Departures[0]
+	if dep["route_short_name"] != "T1" || dep["headsign"] != "City" {
+		t.Errorf("expected departure fields in snake_case, got %v", dep)
+	}
+	if at, _ := time.Parse(time.RFC3339, dep["departs_at"].(string)); !at.Equal(departs) {
+		t.Errorf("expected departs_at %v, got %v", departs, dep["departs_at"])
+	}
+}
+
+func TestDeparturesHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	departs := now.Add(10 * time.Minute).Truncate(time.Second)
+	delay := 180
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				ScheduledDeparture: departs.Add(-3 * time.Minute),
+				RealtimeDeparture:  &departs,
+				DelaySeconds:       &delay,
+				Arrivals: []ArrivalDetail{
+					{StopID: "200", ScheduledArrival: departs.Add(10 * time.Minute)},
+				},
+			},
+		},
+		"201": {
+			{
+				RouteShortName:     "M1",
+				ScheduledDeparture: departs.Add(15 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: departs.Add(25 * time.Minute)},
+				},
+			},
+		},
+	}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stop_id") == "900" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(responses[r.URL.Query().Get("stop_id")])
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300", FinalWalkTime: 120}}},
+			{Name: "Broken", Routes: []RouteConfig{{DepartureStopID: "900", FinalArrivalStop: "901"}}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	buildDeparturesHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/api/departures", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp Departures
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(resp.Trips) != 2 {
+		t.Fatalf("expected 2 trips, got %+v", resp.Trips)
+	}
+	if resp.Trips[1].Error == "" || len(resp.Trips[1].Departures) != 0 {
+		t.Errorf("expected the failing trip to carry its error, got %+v", resp.Trips[1])
+	}
+
+	d := resp.Trips[0].Departures
+	if len(d) != 1 {
+		t.Fatalf("expected 1 departure, got %+v", d)
+	}
+	if d[0].DelayMinutes != 3 || !d[0].DepartsAt.Equal(departs) || !d[0].ScheduledDeparture.Equal(departs.Add(-3*time.Minute)) {
+		t.Errorf("expected a 3 min delay departing %v, got %+v", departs, d[0])
+	}
+	if len(d[0].Connections) != 1 || d[0].Connections[0].RouteShortName != "M1" {
+		t.Errorf("expected an M1 connection, got %+v", d[0].Connections)
+	}
+	if d[0].ArrivesAt == nil || !d[0].ArrivesAt.Equal(departs.Add(27*time.Minute)) {
+		t.Errorf("expected arrival at %v, got %v", departs.Add(27*time.Minute), d[0].ArrivesAt)
+	}
+
+	w = httptest.NewRecorder()
+	buildDeparturesHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/api/departures?trip=Nowhere", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 for an unknown trip, got %d", w.Code)
+	}
+}
+
+func TestHandler_RefreshSeconds(t *testing.T) {
+	mock := newMockAPI(t, map[string][]Departure{})
+	defer mock.Close()
+	cfg := Config{
+		RefreshSeconds: 120,
+		Trips: []TripConfig{
+			{Name: "Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+			{Name: "Gym", RefreshSeconds: 60, Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "500"}}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `http-equiv="refresh" content="60"`) {
+		t.Error("expected the page to reload as often as its most frequent trip")
+	}
+
+	w = httptest.NewRecorder()
+	buildEmbedHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/embed?trip=0", nil))
+	if body := w.Body.String(); !strings.Contains(body, `http-equiv="refresh" content="120"`) {
+		t.Error("expected an embedded trip to reload at its own interval")
+	}
+
+	cfg.ClientRender = true
+	w = httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"refresh_seconds":60,`) {
+		t.Error("expected the client renderer to refetch at the shortest interval")
+	}
+}
+
+func TestTripSlug(t *testing.T) {
+	for name, want := range map[string]string{
+		"To Work":          "to-work",
+		"Home → Work":      "home-work",
+		"  Gym (Tuesdays)": "gym-tuesdays",
+		"Café 2":           "café-2",
+	} {
+		if got := tripSlug(name); got != want {
+			t.Errorf("%q: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestHandler_TripSelection(t *testing.T) {
+	mock := newMockAPI(t, map[string][]Departure{})
+	defer mock.Close()
+	cfg := Config{Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "Home → Gym", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "500"}}},
+	}}
+
+	for _, url := range []string{"/?trip=Home+%E2%86%92+Gym", "/trip/home-gym", "/?trip=1"} {
+		w := httptest.NewRecorder()
+		buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", url, nil))
+		body := w.Body.String()
+		if !strings.Contains(body, `<div class="tab active" onclick="switchTab( 1 )">Home → Gym</div>`) || !strings.Contains(body, `<div class="trip active" id="trip-1"`) {
+			t.Errorf("%s: expected the selected trip's tab open", url)
+		}
+		if strings.Contains(body, "localStorage.getItem('activeTab')") {
+			t.Errorf("%s: expected the remembered tab not to be restored", url)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/trip/nowhere", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown trip, got %d", w.Code)
+	}
+
+	cfg.ClientRender = true
+	w = httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/trip/home-gym", nil))
+	if body := w.Body.String(); !strings.Contains(body, `fetch('/api/board'+'?trip='+encodeURIComponent("home-gym"))`) {
+		t.Error("expected the client renderer to refetch the selected trip's board")
+	}
+}
+
+func TestHandler_ClientRender(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	responses := map[string][]Departure{
+		"100": {{
+			RouteShortName:     "T1",
+			ScheduledDeparture: now.Add(10 * time.Minute),
+			Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+		}},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}},
+	}
+
+	w := httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `http-equiv="refresh"`) || strings.Contains(body, "/api/board") {
+		t.Error("expected server-rendered page to reload itself without the client renderer")
+	}
+
+	cfg.ClientRender = true
+	w = httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if strings.Contains(body, `http-equiv="refresh"`) {
+		t.Error("expected no meta refresh in client render mode")
+	}
+	if !strings.Contains(body, "fetch('/api/board')") {
+		t.Error("expected client renderer to poll /api/board")
+	}
+	if !strings.Contains(body, `"route_short_name":"T1"`) {
+		t.Error("expected initial board JSON embedded in the page")
+	}
+}
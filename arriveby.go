@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// arriveByShown is how many departures an arrive-by trip lists: the latest
+// ones that still arrive in time.
+const arriveByShown = 5
+
+// arriveByStep rounds the upstream lookahead of an arrive-by trip up to a
+// multiple of this, so its cache key doesn't change every minute.
+const arriveByStep = 30
+
+// arriveByTarget returns the next time the trip's arrive_by falls at or after
+// now, in now's location.
+func (t TripConfig) arriveByTarget(now time.Time) (time.Time, bool) {
+	if t.ArriveBy == "" {
+		return time.Time{}, false
+	}
+	mins, err := parseClock(t.ArriveBy)
+	if err != nil {
+		return time.Time{}, false
+	}
+	target := time.Date(now.Year(), now.Month(), now.Day(), mins/60, mins%60, 0, 0, now.Location())
+	if target.Before(now) {
+		target = time.Date(now.Year(), now.Month(), now.Day()+1, mins/60, mins%60, 0, 0, now.Location())
+	}
+	return target, true
+}
+
+// lookingAhead returns the trip with each route's window stretched to reach
+// target. Windows that already reach it are left alone so the routes keep
+// sharing their prefetched and polled cache entries.
+func (t TripConfig) lookingAhead(now, target time.Time) TripConfig {
+	mins := int(target.Sub(now).Minutes()) + 1
+	mins = min((mins+arriveByStep-1)/arriveByStep*arriveByStep, maxWindowMinutes)
+	routes := make([]RouteConfig, len(t.Routes))
+	for i, route := range t.Routes {
+		if route.windowMinutes() < mins {
+			route.window = mins
+		}
+		routes[i] = route
+	}
+	t.Routes = routes
+	return t
+}
+
+// arriveBy keeps the latest arriveByShown departures that reach their final
+// stop by target, in departure order, and marks the last of them.
+func arriveBy(deps []DepartureView, target time.Time) []DepartureView {
+	var feasible []DepartureView
+	for _, dv := range deps {
+		if dv.HasConnection && !dv.finalArrivalSort.After(target) {
+			feasible = append(feasible, dv)
+		}
+	}
+	sort.SliceStable(feasible, func(i, j int) bool {
+		return feasible[i].departureAt.Before(feasible[j].departureAt)
+	})
+	if len(feasible) > arriveByShown {
+		feasible = feasible[len(feasible)-arriveByShown:]
+	}
+	if len(feasible) > 0 {
+		feasible[len(feasible)-1].LastFeasible = true
+	}
+	return feasible
+}
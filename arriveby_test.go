@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArriveByTarget(t *testing.T) {
+	trip := TripConfig{ArriveBy: "09:00"}
+	morning := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	if target, ok := trip.arriveByTarget(morning); !ok || !target.Equal(time.Date(2026, 3, 2, 9, 0, 0, 0, boardTZ)) {
+		t.Errorf("expected 09:00 today, got %v", target)
+	}
+	evening := time.Date(2026, 3, 2, 18, 0, 0, 0, boardTZ)
+	if target, _ := trip.arriveByTarget(evening); !target.Equal(time.Date(2026, 3, 3, 9, 0, 0, 0, boardTZ)) {
+		t.Errorf("expected 09:00 tomorrow, got %v", target)
+	}
+	if _, ok := (TripConfig{}).arriveByTarget(morning); ok {
+		t.Error("expected no target without arrive_by")
+	}
+
+	trip.Routes = []RouteConfig{{}}
+	ahead := trip.lookingAhead(morning, time.Date(2026, 3, 2, 9, 0, 0, 0, boardTZ))
+	if w := ahead.Routes[0].windowMinutes(); w != 90 {
+		t.Errorf("expected the window rounded up to 90, got %d", w)
+	}
+	if trip.Routes[0].window != 0 {
+		t.Error("expected the config's route to be left alone")
+	}
+	ahead = trip.lookingAhead(morning, time.Date(2026, 3, 2, 8, 40, 0, 0, boardTZ))
+	if w := ahead.Routes[0].windowMinutes(); w != departureWindowMinutes {
+		t.Errorf("expected a target inside the window to keep it, got %d", w)
+	}
+}
+
+func TestBuildTripView_ArriveBy(t *testing.T) {
+	srv := newTestMockAPI("normal")
+	defer srv.Close()
+
+	cfg, err := parseConfig([]byte(`
+trips:
+  - name: To Work
+    arrive_by: "09:00"
+    routes: [{route_name: T, departure_stop_id: "100", final_arrival_stop: "300", final_walk_time: 180}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	tv, err := buildTripView(context.Background(), nil, srv.URL, cfg, cfg.Trips[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := time.Date(2026, 3, 2, 9, 0, 0, 0, boardTZ)
+	if len(tv.Departures) != arriveByShown || tv.ArriveBy != "09:00" {
+		t.Fatalf("expected %d departures arriving by 09:00, got %d (%q)", arriveByShown, len(tv.Departures), tv.ArriveBy)
+	}
+	for i, dv := range tv.Departures {
+		if dv.finalArrivalSort.After(target) {
+			t.Errorf("departure %s arrives at %s, after the target", dv.DepartureTime, dv.FinalArrivalTime)
+		}
+		if dv.LastFeasible != (i == len(tv.Departures)-1) {
+			t.Errorf("expected only the last departure to be marked, got %v at %d", dv.LastFeasible, i)
+		}
+	}
+	// Services run every 5 minutes, so the next one must arrive too late.
+	last := tv.Departures[len(tv.Departures)-1]
+	if !last.finalArrivalSort.Add(mockHeadway).After(target) {
+		t.Errorf("expected %s to be the last service arriving in time", last.DepartureTime)
+	}
+
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, PageData{Now: now, Trips: []TripView{tv}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "Last to arrive by 09:00") || !strings.Contains(b.String(), `class="dep last"`) {
+		t.Error("expected the last feasible departure to be highlighted")
+	}
+
+	if _, err := parseConfig([]byte("trips: [{name: A, arrive_by: \"9am\"}]\n")); err == nil || !strings.Contains(err.Error(), "arrive_by") {
+		t.Errorf("expected an arrive_by error, got %v", err)
+	}
+}
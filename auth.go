@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig keeps the board private. With password set, browsers log in
+// with HTTP basic auth (username defaults to "board"); with token set,
+// clients such as scrapers and kiosks can send "Authorization: Bearer
+// <token>" instead. Either is accepted when both are set.
+type AuthConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+func (c AuthConfig) enabled() bool {
+	return c.Password != "" || c.Token != ""
+}
+
+func (c AuthConfig) validate() error {
+	if c.Username != "" && c.Password == "" {
+		return fmt.Errorf("username needs a password")
+	}
+	return nil
+}
+
+func (c AuthConfig) username() string {
+	if c.Username != "" {
+		return c.Username
+	}
+	return "board"
+}
+
+// allows reports whether r carries the configured credentials.
+func (c AuthConfig) allows(r *http.Request) bool {
+	if c.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equalSecret(token, c.Token) {
+			return true
+		}
+	}
+	if c.Password != "" {
+		if user, pass, ok := r.BasicAuth(); ok && equalSecret(user, c.username()) && equalSecret(pass, c.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalSecret compares credentials in constant time.
+func equalSecret(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// adminPath reports whether path is behind the admin login.
+func adminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || path == "/preview" || strings.HasPrefix(path, "/preview/")
+}
+
+// requireAuth answers every request without the running config's auth
+// credentials with a 401. /admin/ and /preview pages are left to their own
+// admin login, which would otherwise compete for the Authorization header.
+func requireAuth(config func() Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := config().Auth
+		if !auth.enabled() || adminPath(r.URL.Path) || auth.allows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if auth.Password != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="departure-board"`)
+		}
+		if auth.Token != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="departure-board"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{Password: "hunter2", Token: "s3cret"}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("board")) })
+	h := requireAuth(func() Config { return cfg }, ok)
+
+	tests := []struct {
+		name   string
+		path   string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"no credentials", "/", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"api without credentials", "/api/board", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"basic auth", "/", func(r *http.Request) { r.SetBasicAuth("board", "hunter2") }, http.StatusOK},
+		{"wrong password", "/", func(r *http.Request) { r.SetBasicAuth("board", "hunter3") }, http.StatusUnauthorized},
+		{"wrong username", "/", func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusUnauthorized},
+		{"bearer token", "/metrics", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"wrong token", "/metrics", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"admin has its own login", "/admin/status", func(r *http.Request) {}, http.StatusOK},
+		{"so does the preview", "/preview/promote", func(r *http.Request) {}, http.StatusOK},
+		{"not its lookalikes", "/previews", func(r *http.Request) {}, http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		tc.setup(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && len(w.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s: expected Basic and Bearer challenges, got %v", tc.name, w.Header().Values("WWW-Authenticate"))
+		}
+	}
+
+	cfg = Config{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected an open board without auth, got %d", w.Code)
+	}
+
+	if _, err := parseConfig([]byte("auth: {username: me}\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("expected an auth error for a username without a password, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Archive entry names. Entries are stored under these fixed names rather than
+// their paths on disk; restore puts them wherever the restored config says.
+const (
+	backupConfigEntry    = "config.yaml"
+	backupHistoryEntry   = "history.jsonl"
+	backupPushStateEntry = "push-state.json"
+	backupHabitsEntry    = "habits.jsonl"
+)
+
+type backupFile struct {
+	entry string
+	path  string
+	mode  os.FileMode
+}
+
+// stateFiles lists the runtime state a config uses, config file first. Every
+// one is private: the config holds passwords and API keys, and the history
+// and habits say when someone leaves home.
+func stateFiles(configPath string, cfg Config) []backupFile {
+	files := []backupFile{{backupConfigEntry, configPath, 0600}}
+	if cfg.History.Enabled {
+		files = append(files, backupFile{backupHistoryEntry, cfg.History.file(), 0600})
+	}
+	if cfg.WebPush.Enabled {
+		files = append(files, backupFile{backupPushStateEntry, cfg.WebPush.stateFile(), 0600})
+	}
+	if cfg.Habits.Enabled {
+		files = append(files, backupFile{backupHabitsEntry, cfg.Habits.file(), 0600})
+	}
+	return files
+}
+
+// writeBackup writes the config and the state files it uses to w as a gzipped
+// tar. State files that don't exist yet are skipped.
+func writeBackup(w io.Writer, configPath string) ([]string, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var written []string
+	for _, f := range stateFiles(configPath, cfg) {
+		data, err := os.ReadFile(f.path)
+		if errors.Is(err, os.ErrNotExist) && f.entry != backupConfigEntry {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hdr := &tar.Header{Name: f.entry, Mode: int64(f.mode), Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+		written = append(written, f.path)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return written, gz.Close()
+}
+
+// restoreBackup unpacks an archive made by writeBackup, writing the config to
+// configPath and each state file to the path the restored config gives it.
+// Existing files are only replaced with force.
+func restoreBackup(r io.Reader, configPath string, force bool) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		entries[hdr.Name] = data
+	}
+
+	data, ok := entries[backupConfigEntry]
+	if !ok {
+		return nil, fmt.Errorf("archive has no %s", backupConfigEntry)
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("archived config: %w", err)
+	}
+
+	files := stateFiles(configPath, cfg)
+	if !force {
+		for _, f := range files {
+			if _, ok := entries[f.entry]; !ok {
+				continue
+			}
+			if _, err := os.Stat(f.path); err == nil {
+				return nil, fmt.Errorf("%s already exists (use -force to overwrite)", f.path)
+			}
+		}
+	}
+
+	var restored []string
+	for _, f := range files {
+		data, ok := entries[f.entry]
+		if !ok {
+			continue
+		}
+		// A file being overwritten keeps its mode; a new one gets f.mode
+		_, statErr := os.Stat(f.path)
+		if err := writeFileAtomic(f.path, data); err != nil {
+			return restored, err
+		}
+		if statErr != nil {
+			if err := os.Chmod(f.path, f.mode); err != nil {
+				return restored, err
+			}
+		}
+		restored = append(restored, f.path)
+	}
+	return restored, nil
+}
+
+// runBackup implements `departure-board backup`.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file to back up")
+	out := fs.String("o", "", "archive to write (default departure-board-YYYYMMDD.tar.gz)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *out == "" {
+		*out = "departure-board-" + time.Now().Format("20060102") + ".tar.gz"
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	written, err := writeBackup(f, *configPath)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	log.Printf("Backed up %v to %s", written, *out)
+	return nil
+}
+
+// runRestore implements `departure-board restore ARCHIVE`.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "where to write the restored config")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: departure-board restore [-config config.yaml] [-force] ARCHIVE")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	restored, err := restoreBackup(f, *configPath, *force)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %v", restored)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	config := `history:
+  enabled: true
+  file: "delays.jsonl"
+web_push:
+  enabled: true
+trips:
+  - name: Work
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`
+	t.Chdir(t.TempDir())
+	os.WriteFile("config.yaml", []byte(config), 0644)
+	os.WriteFile("delays.jsonl", []byte(`{"stop_id":"100"}`+"\n"), 0644)
+
+	var archive bytes.Buffer
+	written, err := writeBackup(&archive, "config.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(written) != 2 {
+		t.Errorf("expected config and history backed up (push state not created yet), got %v", written)
+	}
+
+	t.Chdir(t.TempDir())
+	restored, err := restoreBackup(bytes.NewReader(archive.Bytes()), "config.yaml", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(restored, ",") != "config.yaml,delays.jsonl" {
+		t.Errorf("unexpected restored files %v", restored)
+	}
+	if data, _ := os.ReadFile("delays.jsonl"); !strings.Contains(string(data), `"stop_id":"100"`) {
+		t.Errorf("expected history restored, got %q", data)
+	}
+	for _, name := range restored {
+		if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("expected %s restored private, got %v %v", name, info.Mode(), err)
+		}
+	}
+
+	if _, err := restoreBackup(bytes.NewReader(archive.Bytes()), "config.yaml", false); err == nil {
+		t.Error("expected restore to refuse to overwrite existing files")
+	}
+	os.Chmod("config.yaml", 0640)
+	if _, err := restoreBackup(bytes.NewReader(archive.Bytes()), "config.yaml", true); err != nil {
+		t.Errorf("expected -force restore to succeed, got %v", err)
+	}
+	if info, _ := os.Stat("config.yaml"); info.Mode().Perm() != 0640 {
+		t.Errorf("expected an overwritten config to keep its mode, got %v", info.Mode())
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:generate go run fontgen.go
+
+// fontFiles holds the glyph atlases /board.png draws with, rendered from
+// DejaVu Sans Mono Bold by fontgen.go: 16 by 6 cells holding ' ' to '~',
+// coverage as brightness.
+//
+//go:embed fonts/*.png
+var fontFiles embed.FS
+
+var (
+	font16 = mustAtlas("fonts/mono-16.png")
+	font24 = mustAtlas("fonts/mono-24.png")
+	font40 = mustAtlas("fonts/mono-40.png")
+)
+
+// glyphAtlas is a monospaced bitmap font.
+type glyphAtlas struct {
+	mask            *image.Alpha
+	advance, height int
+}
+
+func mustAtlas(name string) *glyphAtlas {
+	f, err := fontFiles.Open(name)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	b := img.Bounds()
+	mask := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			mask.SetAlpha(x, y, color.Alpha{color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y})
+		}
+	}
+	return &glyphAtlas{mask: mask, advance: b.Dx() / 16, height: b.Dy() / 6}
+}
+
+// pngText replaces the characters the atlases lack: arrows and dots the
+// board uses get ASCII stand-ins, anything else outside ' ' to '~' a '?'.
+var pngText = strings.NewReplacer("→", "->", "·", "-", "–", "-", "—", "-", "’", "'")
+
+func (f *glyphAtlas) width(s string) int {
+	return len([]rune(pngText.Replace(s))) * f.advance
+}
+
+// draw writes s with its top left corner at x, y, cut short with a '.' if it
+// would run past maxX, and returns where it ended.
+func (f *glyphAtlas) draw(dst draw.Image, x, y, maxX int, s string, c color.Color) int {
+	src := image.NewUniform(c)
+	runes := []rune(pngText.Replace(s))
+	for i, r := range runes {
+		if x+f.advance > maxX {
+			break
+		}
+		if i < len(runes)-1 && x+2*f.advance > maxX {
+			r = '.'
+		}
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		cell := int(r - ' ')
+		at := image.Pt(cell%16*f.advance, cell/16*f.height)
+		draw.DrawMask(dst, image.Rect(x, y, x+f.advance, y+f.height), src, image.Point{}, f.mask, at, draw.Over)
+		x += f.advance
+	}
+	return x
+}
+
+const (
+	defaultPNGWidth  = 800
+	defaultPNGHeight = 480
+)
+
+// renderTripPNG draws a trip's departures in black on white: a header with
+// the trip's name and the time, then a row per departure with the minutes
+// to go, the route, where it goes and its departure and arrival times, for
+// as many as fit.
+func renderTripPNG(tv TripView, now time.Time, width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	text, big := font24, font40
+	if height < 320 || width < 480 {
+		text, big = font16, font24
+	}
+	pad := text.height / 2
+	rule := func(y, thickness int) {
+		draw.Draw(img, image.Rect(0, y, width, y+thickness), image.Black, image.Point{}, draw.Src)
+	}
+
+	clock := displayLocale.Clock(now)
+	text.draw(img, width-pad-text.width(clock), pad, width, clock, color.Black)
+	text.draw(img, pad, pad, width-2*pad-text.width(clock), tv.Name, color.Black)
+	y := 2*pad + text.height
+	rule(y, 2)
+	y += 2
+
+	notice := func(s string) {
+		text.draw(img, pad, y+pad, width-pad, s, color.Black)
+		y += 2*pad + text.height
+		rule(y, 1)
+		y++
+	}
+	switch {
+	case tv.Error != "":
+		notice(tv.Error)
+		return img
+	case tv.AsOf != "":
+		notice("Live data unavailable, as of " + tv.AsOf + " (updated " + tv.UpdatedAgo + ")")
+	}
+	if len(tv.Departures) == 0 {
+		if tv.ArriveBy != "" {
+			notice("No departures arrive by " + tv.ArriveBy)
+		} else {
+			notice(fmt.Sprintf("No departures in next %d min", tv.WindowMinutes))
+		}
+		return img
+	}
+
+	rowH := max(big.height, 2*text.height) + pad
+	minsW := 3*big.advance + 4*text.advance + pad
+	timesW := 9*text.advance + pad
+	for _, dv := range tv.Departures {
+		if y+rowH > height {
+			break
+		}
+		line1, line2 := y+pad/2, y+pad/2+text.height
+
+		x := big.draw(img, pad, y+(rowH-big.height)/2, pad+3*big.advance, dv.MinutesAway, color.Black)
+		text.draw(img, x+text.advance/2, y+(rowH-big.height)/2+big.height-text.height, pad+minsW, dv.MinutesAwayLabel, color.Black)
+
+		x = pad + minsW
+		right := width - pad - timesW
+		badge := image.Rect(x, line1, min(x+text.width(dv.RouteShortName)+text.advance, right), line1+text.height)
+		draw.Draw(img, badge, image.Black, image.Point{}, draw.Src)
+		text.draw(img, badge.Min.X+text.advance/2, line1, badge.Max.X, dv.RouteShortName, color.White)
+		x = badge.Max.X + text.advance/2
+		for _, leg := range dv.Connections {
+			x = text.draw(img, x, line1, right, ">", color.Black) + text.advance/2
+			badge := image.Rect(x, line1, min(x+text.width(leg.RouteShortName)+text.advance, right), line1+text.height)
+			draw.Draw(img, badge, image.Black, image.Point{}, draw.Src)
+			text.draw(img, badge.Min.X+text.advance/2, line1, badge.Max.X, leg.RouteShortName, color.White)
+			x = badge.Max.X + text.advance/2
+		}
+		text.draw(img, x, line1, right, dv.Headsign, color.Black)
+		route := dv.DepartureName + " → " + dv.ArrivalName
+		if dv.TransferName != "" {
+			route = dv.DepartureName + " → " + dv.TransferName + " → " + dv.ArrivalName
+		}
+		text.draw(img, pad+minsW, line2, right, route, color.Black)
+
+		departs := "dep " + dv.DepartureTime
+		if dv.IsOnDemand {
+			departs = dv.PickupWindow
+		}
+		arrives := "arr " + dv.FinalArrivalTime
+		if dv.ConnectionUnknown {
+			arrives = "arr ?"
+		}
+		text.draw(img, width-pad-text.width(departs), line1, width, departs, color.Black)
+		text.draw(img, width-pad-text.width(arrives), line2, width, arrives, color.Black)
+
+		y += rowH
+		rule(y, 1)
+		y++
+	}
+	return img
+}
+
+// buildPNGHandler serves /board.png: one trip (?trip= by index or name)
+// drawn as a width by height PNG, default 800x480, for microcontroller and
+// e-paper clients that can show an image but not a web page.
+func buildPNGHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		trip, ok := selectTrip(cfg.Trips, q.Get("trip"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		width, height := defaultPNGWidth, defaultPNGHeight
+		for _, p := range []struct {
+			name string
+			v    *int
+		}{{"width", &width}, {"height", &height}} {
+			s := q.Get(p.name)
+			if s == "" {
+				continue
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 100 || n > 4000 {
+				http.Error(w, p.name+" must be 100 to 4000 pixels", http.StatusBadRequest)
+				return
+			}
+			*p.v = n
+		}
+
+		data := buildPageData(r.Context(), cache, apiURL, cfg, []TripConfig{trip})
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, renderTripPNG(data.Trips[0], data.Now, width, height)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// inked counts the non-white pixels of img within r.
+func inked(img *image.Gray, r image.Rectangle) int {
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.GrayAt(x, y).Y < 128 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestGlyphAtlas(t *testing.T) {
+	for _, f := range []*glyphAtlas{font16, font24, font40} {
+		if f.advance == 0 || f.height <= f.advance {
+			t.Errorf("unexpected glyph cell %dx%d", f.advance, f.height)
+		}
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 200, 40))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	if end := font24.draw(img, 0, 0, 200, "T1 → X", image.Black); end != 7*font24.advance {
+		t.Errorf("expected the arrow drawn as ->, ending at %d, got %d", 7*font24.advance, end)
+	}
+	if n := inked(img, image.Rect(2*font24.advance, 0, 3*font24.advance, font24.height)); n != 0 {
+		t.Errorf("expected a blank space, got %d inked pixels", n)
+	}
+	if end := font24.draw(img, 0, 0, 3*font24.advance, "Hornsby", image.Black); end != 3*font24.advance {
+		t.Errorf("expected text cut off at maxX, got %d", end)
+	}
+}
+
+func TestRenderTripPNG(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 4, 0, 0, boardTZ)
+	dep := DepartureView{MinutesAway: "3", MinutesAwayLabel: "min", RouteShortName: "T1", Headsign: "Hornsby", DepartureName: "Home", ArrivalName: "Central", DepartureTime: "08:07", FinalArrivalTime: "08:31"}
+	tv := TripView{Name: "To Work", Departures: []DepartureView{dep, dep, dep, dep, dep, dep, dep, dep}}
+
+	img := renderTripPNG(tv, now, 800, 480)
+	if img.Bounds() != image.Rect(0, 0, 800, 480) {
+		t.Fatalf("expected an 800x480 image, got %v", img.Bounds())
+	}
+	header := 2*(font24.height/2) + font24.height
+	if inked(img, image.Rect(0, 0, 800, header)) == 0 {
+		t.Error("expected the header to be drawn")
+	}
+	rowH := max(font40.height, 2*font24.height) + font24.height/2
+	if rows := (480 - header - 2) / (rowH + 1); inked(img, image.Rect(0, header+2+rows*(rowH+1)+1, 800, 480)) != 0 {
+		t.Errorf("expected only the %d rows that fit", rows)
+	}
+
+	empty := renderTripPNG(TripView{Name: "To Work", WindowMinutes: 60}, now, 296, 128)
+	if inked(empty, image.Rect(0, 40, 296, 128)) == 0 {
+		t.Error("expected a notice when nothing departs")
+	}
+}
+
+func TestPNGHandler(t *testing.T) {
+	mock := newMockAPI(t, map[string][]Departure{})
+	defer mock.Close()
+	cfg := Config{Trips: []TripConfig{{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}}}
+	handler := buildPNGHandler(mock.URL, cfg, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/board.png?trip=0&width=400&height=300", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected a PNG, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 400 || img.Bounds().Dy() != 300 {
+		t.Errorf("expected 400x300, got %v", img.Bounds())
+	}
+
+	for url, code := range map[string]int{
+		"/board.png?trip=Gym":             404,
+		"/board.png?width=wide":           400,
+		"/board.png?height=5000":          400,
+		"/board.png?trip=To+Work":         200,
+		"/board.png?width=100&height=100": 200,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", url, code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// departureCacheTTL is how long an upstream response is reused. It is shorter
+// than the page refresh so a single board still sees fresh data every reload.
+const departureCacheTTL = 20 * time.Second
+
+// defaultMaxStale is the oldest data stale_while_revalidate serves when it
+// doesn't set max_age.
+const defaultMaxStale = 15 * time.Minute
+
+// maxStaleOnError is the oldest cached response served in place of an
+// upstream error. Older than this its departures have all gone, and the error
+// says more than an empty board.
+const maxStaleOnError = 3 * time.Hour
+
+// revalidateTimeout bounds a background refresh, which has no page request to
+// take a deadline from.
+const revalidateTimeout = 30 * time.Second
+
+type StaleConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxAge  int  `yaml:"max_age,omitempty"`
+}
+
+func (c StaleConfig) maxAge() time.Duration {
+	if c.MaxAge > 0 {
+		return time.Duration(c.MaxAge) * time.Second
+	}
+	return defaultMaxStale
+}
+
+type stopQuery struct {
+	apiURL       string
+	stopID       string
+	arrivalStops string
+	// minutes asks the upstream for departures this far ahead rather than
+	// its default hour; 0 for the default.
+	minutes int
+}
+
+type cacheEntry struct {
+	departures []Departure
+	fetchedAt  time.Time
+}
+
+type fetchFailure struct {
+	at  time.Time
+	err error
+}
+
+// departureCache keeps recent upstream responses per stop query so that
+// several page loads (or a warm-up prefetch) share one fetch. A nil cache
+// fetches directly.
+type departureCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[stopQuery]cacheEntry
+	ttls    map[stopQuery]time.Duration
+
+	// maxStale, when set, lets fetch answer with an expired entry up to this
+	// old while it is refreshed in the background, so a slow or failing
+	// upstream doesn't hold up (or blank) the board.
+	maxStale     time.Duration
+	revalidating map[stopQuery]bool
+
+	// inflight holds the upstream fetch under way for each query, which
+	// concurrent refreshes of the same query wait for instead of repeating.
+	inflight map[stopQuery]*inflightFetch
+
+	// Counters for the admin status page.
+	hits, misses int
+	failures     map[stopQuery]fetchFailure
+
+	// history, when set, records the delays seen in every upstream response.
+	history *historyStore
+}
+
+func newDepartureCache(ttl time.Duration) *departureCache {
+	return &departureCache{
+		ttl:      ttl,
+		entries:  make(map[stopQuery]cacheEntry),
+		ttls:     make(map[stopQuery]time.Duration),
+		failures: make(map[stopQuery]fetchFailure),
+
+		revalidating: make(map[stopQuery]bool),
+		inflight:     make(map[stopQuery]*inflightFetch),
+	}
+}
+
+// setTTL overrides the TTL of one query, used for queries the poller refreshes
+// less often than the default TTL.
+func (c *departureCache) setTTL(q stopQuery, ttl time.Duration) {
+	c.mu.Lock()
+	c.ttls[q] = ttl
+	c.mu.Unlock()
+}
+
+func (c *departureCache) fetch(ctx context.Context, apiURL, stopID, arrivalStops string, minutes int) ([]Departure, error) {
+	if c == nil {
+		return fetchDepartures(ctx, apiURL, stopID, arrivalStops, minutes)
+	}
+
+	q := stopQuery{apiURL, stopID, arrivalStops, minutes}
+	c.mu.Lock()
+	e, ok := c.entries[q]
+	ttl, custom := c.ttls[q]
+	if !custom {
+		ttl = c.ttl
+	}
+	hit := ok && time.Since(e.fetchedAt) < ttl
+	stale := ok && !hit && time.Since(e.fetchedAt) < c.maxStale
+	if hit || stale {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	if stale && !c.revalidating[q] {
+		c.revalidating[q] = true
+		go c.revalidate(q)
+	}
+	c.mu.Unlock()
+	if hit || stale {
+		return copyDepartures(e.departures), nil
+	}
+
+	deps, err := c.refresh(ctx, q)
+	if err != nil {
+		// Serve the last good response rather than fail the trip;
+		// staleSince reports it so the board can say how old it is
+		if ok && time.Since(e.fetchedAt) < maxStaleOnError {
+			log.Printf("stop %s: %v; serving departures from %s", stopID, err, e.fetchedAt.Format(time.TimeOnly))
+			return copyDepartures(e.departures), nil
+		}
+		return nil, err
+	}
+	return copyDepartures(deps), nil
+}
+
+type inflightFetch struct {
+	done chan struct{}
+	deps []Departure
+	err  error
+}
+
+// refresh fetches q from upstream regardless of the cached entry's age and
+// stores the result. If q is already being fetched it waits for that fetch
+// instead, so kiosks loading the board together make one upstream call.
+func (c *departureCache) refresh(ctx context.Context, q stopQuery) ([]Departure, error) {
+	c.mu.Lock()
+	f, ok := c.inflight[q]
+	if !ok {
+		f = &inflightFetch{done: make(chan struct{})}
+		c.inflight[q] = f
+		go c.fetchShared(ctx, q, f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.deps, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchShared makes the upstream call for every caller waiting on f. It
+// outlives the request that started it, so one closed tab doesn't fail the
+// others; a cancellation isn't the upstream's fault and isn't recorded.
+func (c *departureCache) fetchShared(ctx context.Context, q stopQuery, f *inflightFetch) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), revalidateTimeout)
+	defer cancel()
+	f.deps, f.err = fetchDepartures(ctx, q.apiURL, q.stopID, q.arrivalStops, q.minutes)
+	switch {
+	case f.err == nil:
+		c.store(q, f.deps)
+	case !errors.Is(f.err, context.Canceled):
+		c.fail(q, f.err)
+	}
+	c.mu.Lock()
+	delete(c.inflight, q)
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// revalidate refreshes a stale entry in the background. If the upstream is
+// down the entry is left as it is, to be served until it is maxStale old.
+func (c *departureCache) revalidate(q stopQuery) {
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	defer cancel()
+	if _, err := c.refresh(ctx, q); err != nil {
+		log.Printf("revalidate stop %s: %v", q.stopID, err)
+	}
+	c.mu.Lock()
+	delete(c.revalidating, q)
+	c.mu.Unlock()
+}
+
+func (c *departureCache) fail(q stopQuery, err error) {
+	c.mu.Lock()
+	c.failures[q] = fetchFailure{at: time.Now(), err: err}
+	c.mu.Unlock()
+}
+
+// status reports when q was last fetched successfully and its last failure.
+func (c *departureCache) status(q stopQuery) (fetchedAt time.Time, failure fetchFailure) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[q].fetchedAt, c.failures[q]
+}
+
+// staleSince returns the oldest fetch time among queries that have cached data
+// but whose latest refresh failed, i.e. that are being answered from stale
+// data because the upstream is down.
+func (c *departureCache) staleSince(queries []stopQuery) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var oldest time.Time
+	for _, q := range queries {
+		e, ok := c.entries[q]
+		if !ok || !c.failures[q].at.After(e.fetchedAt) {
+			continue
+		}
+		if oldest.IsZero() || e.fetchedAt.Before(oldest) {
+			oldest = e.fetchedAt
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// hitRatio returns the cache hits and lookups since startup.
+func (c *departureCache) hitRatio() (hits, lookups int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.hits + c.misses
+}
+
+func (c *departureCache) store(q stopQuery, deps []Departure) {
+	now := time.Now()
+	c.mu.Lock()
+	c.entries[q] = cacheEntry{departures: deps, fetchedAt: now}
+	c.mu.Unlock()
+	if c.history != nil {
+		c.history.record(q.stopID, deps, now)
+	}
+}
+
+// copyDepartures returns a copy callers can filter in place without touching
+// the cached slice.
+func copyDepartures(deps []Departure) []Departure {
+	return append([]Departure(nil), deps...)
+}
+
+// refreshAll refreshes queries concurrently, or with one upstream request per
+// API when batch is set (falling back to individual requests if a batch
+// fails). Failures are logged with the given verb; the returned map holds the
+// departures of every query that refreshed.
+func (c *departureCache) refreshAll(ctx context.Context, queries []stopQuery, batch bool, verb string) map[stopQuery][]Departure {
+	results := make(map[stopQuery][]Departure)
+	if batch {
+		var rest []stopQuery
+		for apiURL, group := range groupByAPI(queries) {
+			if len(group) == 1 {
+				rest = append(rest, group...)
+				continue
+			}
+			deps, err := fetchDeparturesBatch(ctx, apiURL, group)
+			if err != nil {
+				for _, q := range group {
+					c.fail(q, err)
+				}
+				log.Printf("%s batch of %d stops: %v", verb, len(group), err)
+				rest = append(rest, group...)
+				continue
+			}
+			for i, q := range group {
+				c.store(q, deps[i])
+				results[q] = deps[i]
+			}
+		}
+		queries = rest
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, q := range queries {
+		wg.Add(1)
+		go func(q stopQuery) {
+			defer wg.Done()
+			deps, err := c.refresh(ctx, q)
+			if err != nil {
+				log.Printf("%s stop %s: %v", verb, q.stopID, err)
+				return
+			}
+			mu.Lock()
+			results[q] = deps
+			mu.Unlock()
+		}(q)
+	}
+	wg.Wait()
+	return results
+}
+
+// warm refreshes, in one batched request, every query that the cache can't
+// already answer, so rendering a board costs a single upstream round trip.
+func (c *departureCache) warm(ctx context.Context, queries []stopQuery) {
+	if c == nil {
+		return
+	}
+	var stale []stopQuery
+	c.mu.Lock()
+	for _, q := range queries {
+		ttl, custom := c.ttls[q]
+		if !custom {
+			ttl = c.ttl
+		}
+		if e, ok := c.entries[q]; !ok || time.Since(e.fetchedAt) >= ttl {
+			stale = append(stale, q)
+		}
+	}
+	c.mu.Unlock()
+	if len(stale) > 1 {
+		c.refreshAll(ctx, stale, true, "warm")
+	}
+}
+
+func groupByAPI(queries []stopQuery) map[string][]stopQuery {
+	groups := make(map[string][]stopQuery)
+	for _, q := range queries {
+		groups[q.apiURL] = append(groups[q.apiURL], q)
+	}
+	return groups
+}
+
+// prefetch refreshes every stop query the config needs, so the first page view
+// after startup (or during a prewarm window) is served from the cache.
+func (c *departureCache) prefetch(ctx context.Context, apiURL string, cfg Config) {
+	c.refreshAll(ctx, configQueries(apiURL, cfg), cfg.BatchQueries, "prefetch")
+}
+
+// configQueries returns the distinct stop queries made when rendering every
+// trip in the config.
+func configQueries(apiURL string, cfg Config) []stopQuery {
+	return tripQueries(apiURL, cfg.Trips)
+}
+
+// tripQueries returns the distinct stop queries made when rendering trips.
+func tripQueries(apiURL string, trips []TripConfig) []stopQuery {
+	var queries []stopQuery
+	seen := make(map[stopQuery]bool)
+	for _, trip := range trips {
+		for _, route := range trip.Routes {
+			for _, q := range routeQueries(apiURL, route) {
+				if !seen[q] {
+					seen[q] = true
+					queries = append(queries, q)
+				}
+			}
+		}
+	}
+	return queries
+}
+
+// routeQueries returns the stop queries buildRouteDepartures makes for a route:
+// the first leg, and each later leg whose transfer involves another service.
+func routeQueries(apiURL string, route RouteConfig) []stopQuery {
+	apiURL = route.upstreamURL(apiURL)
+	transfers := route.transfers()
+	minutes := route.upstreamMinutes()
+	queries := []stopQuery{{apiURL, route.DepartureStopID, legEnd(route, transfers, 0), minutes}}
+	for i, t := range transfers {
+		if to := legEnd(route, transfers, i+1); t.TransferDepartureStopID != to {
+			queries = append(queries, stopQuery{apiURL, t.TransferDepartureStopID, to, minutes})
+		}
+	}
+	return queries
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDepartureCache_Fetch(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]Departure{{RouteShortName: "T1"}, {RouteShortName: "T2"}})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(time.Minute)
+	ctx := context.Background()
+
+	deps, err := cache.fetch(ctx, mock.URL, "100", "300", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Callers filter in place; that must not leak into the cached copy.
+	deps[0].RouteShortName = "changed"
+
+	deps, err = cache.fetch(ctx, mock.URL, "100", "300", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call within TTL, got %d", calls.Load())
+	}
+	if deps[0].RouteShortName != "T1" {
+		t.Errorf("expected cached entry to be unaffected by caller changes, got %q", deps[0].RouteShortName)
+	}
+
+	cache.fetch(ctx, mock.URL, "100", "400", 0)
+	if calls.Load() != 2 {
+		t.Errorf("expected a different stop pair to miss the cache, got %d calls", calls.Load())
+	}
+}
+
+func TestDepartureCache_Expiry(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]Departure{})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(0)
+	cache.fetch(context.Background(), mock.URL, "100", "300", 0)
+	cache.fetch(context.Background(), mock.URL, "100", "300", 0)
+	if calls.Load() != 2 {
+		t.Errorf("expected expired entries to be refetched, got %d calls", calls.Load())
+	}
+}
+
+func TestDepartureCache_Coalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		json.NewEncoder(w).Encode([]Departure{{RouteShortName: "T1"}})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(time.Minute)
+	var wg sync.WaitGroup
+	results := make([][]Departure, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.fetch(context.Background(), mock.URL, "100", "300", 0)
+		}(i)
+	}
+	// Let every page load reach the cache before the upstream answers
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected concurrent loads to share one upstream call, got %d", calls.Load())
+	}
+	for i, deps := range results {
+		if len(deps) != 1 {
+			t.Errorf("load %d: expected the shared departures, got %v", i, deps)
+		}
+	}
+}
+
+func TestDepartureCache_CoalesceLeaderCancelled(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		json.NewEncoder(w).Encode([]Departure{{RouteShortName: "T1"}})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := cache.fetch(ctx, mock.URL, "100", "300", 0)
+		leader <- err
+	}()
+	for deadline := time.Now().Add(2 * time.Second); calls.Load() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream never called")
+		}
+	}
+	follower := make(chan []Departure, 1)
+	go func() {
+		deps, _ := cache.fetch(context.Background(), mock.URL, "100", "300", 0)
+		follower <- deps
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The first page load goes away while the second waits
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled load to give up, got %v", err)
+	}
+	close(release)
+	if deps := <-follower; len(deps) != 1 {
+		t.Errorf("expected the waiting load to get the departures, got %v", deps)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected one upstream call, got %d", calls.Load())
+	}
+	q := stopQuery{mock.URL, "100", "300", 0}
+	if _, failure := cache.status(q); failure.err != nil {
+		t.Errorf("expected no failure recorded, got %v", failure.err)
+	}
+}
+
+func TestDepartureCache_StaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	var down atomic.Bool
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]Departure{{RouteShortName: "T1"}})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(0)
+	cache.maxStale = time.Minute
+	ctx := context.Background()
+	q := stopQuery{mock.URL, "100", "300", 0}
+	waitRevalidated := func() {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			cache.mu.Lock()
+			busy := cache.revalidating[q]
+			cache.mu.Unlock()
+			if !busy {
+				return
+			}
+		}
+		t.Fatal("background refresh didn't finish")
+	}
+
+	if _, err := cache.fetch(ctx, mock.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired but within max stale: answered from the cache, refreshed behind
+	down.Store(true)
+	deps, err := cache.fetch(ctx, mock.URL, "100", "300", 0)
+	if err != nil || len(deps) != 1 {
+		t.Fatalf("expected the stale entry, got %v, %v", deps, err)
+	}
+	waitRevalidated()
+	if calls.Load() != 2 {
+		t.Errorf("expected a background refresh, got %d calls", calls.Load())
+	}
+	if _, stale := cache.staleSince([]stopQuery{q}); !stale {
+		t.Error("expected the query to be reported stale after the refresh failed")
+	}
+
+	// The upstream recovers
+	down.Store(false)
+	cache.fetch(ctx, mock.URL, "100", "300", 0)
+	waitRevalidated()
+	if _, stale := cache.staleSince([]stopQuery{q}); stale {
+		t.Error("expected fresh data after a successful refresh")
+	}
+
+	// Older than max stale: fetched synchronously, and the last good
+	// response is served when that fails
+	down.Store(true)
+	age := func(d time.Duration) {
+		cache.mu.Lock()
+		e := cache.entries[q]
+		e.fetchedAt = time.Now().Add(-d)
+		cache.entries[q] = e
+		cache.mu.Unlock()
+	}
+	age(2 * time.Minute)
+	before := calls.Load()
+	if deps, err := cache.fetch(ctx, mock.URL, "100", "300", 0); err != nil || len(deps) != 1 || calls.Load() != before+1 {
+		t.Errorf("expected a failed refetch to serve the old entry, got %v, %v", deps, err)
+	}
+
+	// Too old to serve at all: the failure is returned
+	age(maxStaleOnError + time.Minute)
+	if _, err := cache.fetch(ctx, mock.URL, "100", "300", 0); err == nil {
+		t.Error("expected an error once the entry is older than maxStaleOnError")
+	}
+}
+
+func TestBuildTripView_StaleOnError(t *testing.T) {
+	var down atomic.Bool
+	now := time.Now().In(boardTZ)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]Departure{{
+			TripID: "t1", RouteShortName: "T1", ScheduledDeparture: now.Add(20 * time.Minute),
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(40 * time.Minute)}},
+		}})
+	}))
+	defer mock.Close()
+
+	cache := newDepartureCache(time.Minute)
+	trip := TripConfig{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}
+	tv, err := buildTripView(context.Background(), cache, mock.URL, Config{}, trip, now)
+	if err != nil || tv.AsOf != "" {
+		t.Fatalf("expected fresh departures, got %+v, %v", tv, err)
+	}
+
+	// The upstream goes down once the entry has expired
+	down.Store(true)
+	cache.mu.Lock()
+	for q, e := range cache.entries {
+		e.fetchedAt = e.fetchedAt.Add(-5*time.Minute - 30*time.Second)
+		cache.entries[q] = e
+	}
+	cache.mu.Unlock()
+	tv, err = buildTripView(context.Background(), cache, mock.URL, Config{}, trip, now)
+	if err != nil {
+		t.Fatalf("expected the cached departures rather than an error, got %v", err)
+	}
+	if len(tv.Departures) != 1 || tv.AsOf == "" || tv.UpdatedAgo != "5 min ago" {
+		t.Errorf("expected the old departure with its age, got %d departures, as of %q, updated %q", len(tv.Departures), tv.AsOf, tv.UpdatedAgo)
+	}
+}
+
+func TestDepartureCache_Prefetch(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]Departure{})
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "A", Routes: []RouteConfig{
+				{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300"},
+				{DepartureStopID: "100", FinalArrivalStop: "300"},
+			}},
+			{Name: "B", Routes: []RouteConfig{
+				{DepartureStopID: "100", FinalArrivalStop: "300"},
+			}},
+		},
+	}
+
+	cache := newDepartureCache(time.Minute)
+	cache.prefetch(context.Background(), mock.URL, cfg)
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 distinct queries prefetched, got %d", calls.Load())
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, cache)
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if calls.Load() != 3 {
+		t.Errorf("expected page view to be served from the prefetched cache, got %d calls", calls.Load())
+	}
+}
+
+func TestDepartureCache_Batch(t *testing.T) {
+	var batches, singles atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/departures/arrivals/batch" {
+			singles.Add(1)
+			json.NewEncoder(w).Encode([]Departure{})
+			return
+		}
+		batches.Add(1)
+		var queries []struct {
+			StopID       string `json:"stop_id"`
+			ArrivalStops string `json:"arrival_stops"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&queries) != nil {
+			http.Error(w, "bad batch", http.StatusBadRequest)
+			return
+		}
+		results := make([][]Departure, len(queries))
+		for i, q := range queries {
+			results[i] = []Departure{{RouteShortName: q.StopID + ">" + q.ArrivalStops}}
+		}
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		BatchQueries: true,
+		Trips: []TripConfig{
+			{Name: "A", Routes: []RouteConfig{
+				{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300"},
+			}},
+			{Name: "B", Routes: []RouteConfig{{DepartureStopID: "500", FinalArrivalStop: "700"}}},
+		},
+	}
+
+	cache := newDepartureCache(time.Minute)
+	cache.prefetch(context.Background(), mock.URL, cfg)
+	if batches.Load() != 1 || singles.Load() != 0 {
+		t.Fatalf("expected one batched request, got %d batches and %d singles", batches.Load(), singles.Load())
+	}
+
+	deps, _ := cache.fetch(context.Background(), mock.URL, "201", "300", 0)
+	if len(deps) != 1 || deps[0].RouteShortName != "201>300" {
+		t.Errorf("expected batch results matched to their query, got %+v", deps)
+	}
+
+	cold := newDepartureCache(time.Minute)
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, cold)
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if batches.Load() != 2 || singles.Load() != 0 {
+		t.Errorf("expected a page view to warm the cache in one batch, got %d batches and %d singles", batches.Load(), singles.Load())
+	}
+}
+
+func TestDepartureCache_BatchFallback(t *testing.T) {
+	var singles atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/departures/arrivals/batch" {
+			http.NotFound(w, r)
+			return
+		}
+		singles.Add(1)
+		json.NewEncoder(w).Encode([]Departure{})
+	}))
+	defer mock.Close()
+
+	queries := []stopQuery{{mock.URL, "100", "300", 0}, {mock.URL, "500", "700", 0}}
+	cache := newDepartureCache(time.Minute)
+	got := cache.refreshAll(context.Background(), queries, true, "test")
+	if singles.Load() != 2 || len(got) != 2 {
+		t.Errorf("expected unsupported batch to fall back to single requests, got %d calls and %d results", singles.Load(), len(got))
+	}
+}
+
+func TestRouteQueries(t *testing.T) {
+	direct := routeQueries("u", RouteConfig{DepartureStopID: "100", FinalArrivalStop: "300"})
+	if len(direct) != 1 || direct[0] != (stopQuery{"u", "100", "300", 0}) {
+		t.Errorf("unexpected direct queries %+v", direct)
+	}
+
+	walkOnly := routeQueries("u", RouteConfig{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "300", FinalArrivalStop: "300"})
+	if len(walkOnly) != 1 || walkOnly[0] != (stopQuery{"u", "100", "200", 0}) {
+		t.Errorf("unexpected walk-only transfer queries %+v", walkOnly)
+	}
+
+	transfer := routeQueries("u", RouteConfig{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300"})
+	if len(transfer) != 2 || transfer[1] != (stopQuery{"u", "201", "300", 0}) {
+		t.Errorf("unexpected transfer queries %+v", transfer)
+	}
+}
+
+func TestBuildTripView_RouteUpstreams(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	trains := newMockAPI(t, map[string][]Departure{
+		"100": {{TripID: "t1", RouteShortName: "T1", ScheduledDeparture: now.Add(5 * time.Minute),
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(20 * time.Minute)}}}},
+	})
+	defer trains.Close()
+	buses := newMockAPI(t, map[string][]Departure{
+		"100": {{TripID: "b1", RouteShortName: "B1", ScheduledDeparture: now.Add(8 * time.Minute),
+			Arrivals: []ArrivalDetail{{StopID: "400", ScheduledArrival: now.Add(25 * time.Minute)}}}},
+	})
+	defer buses.Close()
+
+	trip := TripConfig{Name: "Work", Routes: []RouteConfig{
+		{DepartureStopID: "100", FinalArrivalStop: "300"},
+		{DepartureStopID: "100", FinalArrivalStop: "400", GtfsAPIURL: buses.URL},
+	}}
+	for _, batch := range []bool{false, true} {
+		cfg := Config{BatchQueries: batch, Trips: []TripConfig{trip}}
+		data := buildPageData(context.Background(), newDepartureCache(time.Minute), trains.URL, cfg, cfg.Trips)
+		deps := data.Trips[0].Departures
+		if len(deps) != 2 || deps[0].RouteShortName != "T1" || deps[1].RouteShortName != "B1" {
+			t.Errorf("batch %v: expected the T1 from one upstream and the B1 from the other, got %+v", batch, deps)
+		}
+	}
+
+	queries := tripQueries(trains.URL, []TripConfig{trip})
+	if len(queries) != 2 || queries[0].apiURL != trains.URL || queries[1].apiURL != buses.URL {
+		t.Errorf("expected a query per upstream, got %+v", queries)
+	}
+}
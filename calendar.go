@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultCalendarHours is how far ahead /calendar.ics lists journeys.
+	defaultCalendarHours = 3
+	maxCalendarHours     = maxWindowMinutes / 60
+)
+
+// icsText escapes a TEXT property value (RFC 5545 3.3.11).
+var icsText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icsWriter writes content lines, CRLF-terminated and folded at 75 octets
+// without splitting a UTF-8 sequence.
+type icsWriter struct {
+	b strings.Builder
+}
+
+func (w *icsWriter) line(name, value string) {
+	s := name + ":" + value
+	// Continuation lines start with a space, which counts.
+	for limit := 75; len(s) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+	}
+	w.b.WriteString(s + "\r\n")
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// buildCalendarHandler serves /calendar.ics: every trip's (or one trip's,
+// with ?trip=) journeys departing in the next ?hours= (default 3) as events
+// from departure to final arrival, for overlaying on a calendar app.
+// Departures without a confirmed connection are left out, having no end.
+func buildCalendarHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		trips := cfg.Trips
+		if key := q.Get("trip"); key != "" {
+			trip, ok := selectTrip(cfg.Trips, key)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			trips = []TripConfig{trip}
+		}
+		hours := defaultCalendarHours
+		if s := q.Get("hours"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxCalendarHours {
+				http.Error(w, fmt.Sprintf("hours must be 1 to %d", maxCalendarHours), http.StatusBadRequest)
+				return
+			}
+			hours = n
+		}
+
+		now := time.Now().In(boardTZ)
+		until := now.Add(time.Duration(hours) * time.Hour)
+		ahead := make([]TripConfig, len(trips))
+		for i, trip := range trips {
+			ahead[i] = trip.lookingAhead(now, until)
+		}
+		data := buildPageData(r.Context(), cache, apiURL, cfg, ahead)
+
+		var ics icsWriter
+		ics.line("BEGIN", "VCALENDAR")
+		ics.line("VERSION", "2.0")
+		ics.line("PRODID", "-//departure-board//EN")
+		ics.line("CALSCALE", "GREGORIAN")
+		ics.line("METHOD", "PUBLISH")
+		ics.line("X-WR-CALNAME", "Departures")
+		ics.line("REFRESH-INTERVAL;VALUE=DURATION", "PT5M")
+		ics.line("X-PUBLISHED-TTL", "PT5M")
+		for i, tv := range data.Trips {
+			for _, dv := range tv.Departures {
+				if !dv.HasConnection || dv.departureAt.After(until) {
+					continue
+				}
+				writeJourneyEvent(&ics, trips[i].Name, dv, now)
+			}
+		}
+		ics.line("END", "VCALENDAR")
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(ics.b.String()))
+	}
+}
+
+// writeJourneyEvent writes a journey as a VEVENT. Its UID comes from the
+// scheduled departure, so a delayed service updates its event rather than
+// adding another.
+func writeJourneyEvent(ics *icsWriter, trip string, dv DepartureView, now time.Time) {
+	scheduled := dv.scheduledAt
+	if scheduled.IsZero() {
+		scheduled = dv.departureAt
+	}
+	routes := []string{dv.RouteShortName}
+	for _, leg := range dv.Connections {
+		routes = append(routes, leg.RouteShortName)
+	}
+
+	desc := []string{"Departs " + dv.DepartureTime + " from " + dv.DepartureName}
+	if dv.Platform != "" {
+		desc[0] += ", platform " + dv.Platform
+	}
+	if dv.Headsign != "" {
+		desc = append(desc, dv.RouteShortName+" towards "+dv.Headsign)
+	}
+	if dv.TransferName != "" {
+		desc = append(desc, "Change at "+dv.TransferName)
+	}
+	desc = append(desc, "Arrives "+dv.FinalArrivalTime)
+	if dv.IsDelayed {
+		desc = append(desc, fmt.Sprintf("Running %d min late", dv.DelayMinutes))
+	}
+
+	uid := fmt.Sprintf("%s-%s-%s-%d@departure-board", trip, dv.departureStopID, dv.RouteShortName, scheduled.Unix())
+	ics.line("BEGIN", "VEVENT")
+	ics.line("UID", icsText.Replace(strings.ReplaceAll(uid, " ", "-")))
+	ics.line("DTSTAMP", icsTime(now))
+	ics.line("DTSTART", icsTime(dv.departureAt))
+	ics.line("DTEND", icsTime(dv.finalArrivalSort))
+	ics.line("SUMMARY", icsText.Replace(strings.Join(routes, "/")+": "+dv.DepartureName+" → "+dv.ArrivalName+" ("+trip+")"))
+	ics.line("LOCATION", icsText.Replace(dv.DepartureName))
+	ics.line("DESCRIPTION", icsText.Replace(strings.Join(desc, "\n")))
+	ics.line("TRANSP", "TRANSPARENT")
+	ics.line("END", "VEVENT")
+}
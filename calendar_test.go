@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	dep := func(route string, in time.Duration) Departure {
+		return Departure{
+			RouteShortName:     route,
+			Headsign:           "City, Central",
+			ScheduledDeparture: now.Add(in),
+			Arrivals:           []ArrivalDetail{{StopID: "300", StopName: "Work", ScheduledArrival: now.Add(in + 25*time.Minute)}},
+		}
+	}
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {dep("T1", 10*time.Minute), dep("T2", 5*time.Hour)},
+		"200": {{RouteShortName: "B1", ScheduledDeparture: now.Add(15 * time.Minute)}},
+	})
+	defer mock.Close()
+	cfg := Config{Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", DepartureName: "Home", FinalArrivalStop: "300", ArrivalName: "Work"}}},
+		{Name: "To Gym", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "400"}}},
+	}}
+	handler := buildCalendarHandler(mock.URL, cfg, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/calendar.ics", nil))
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("expected a calendar, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	ics := w.Body.String()
+	if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") || !strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+		t.Errorf("expected a CRLF-delimited VCALENDAR, got %q", ics)
+	}
+	if n := strings.Count(ics, "BEGIN:VEVENT"); n != 1 {
+		t.Errorf("expected one event (the B1 has no connection, the T2 is too late), got %d", n)
+	}
+	for _, want := range []string{
+		"DTSTART:" + icsTime(now.Add(10*time.Minute)),
+		"DTEND:" + icsTime(now.Add(35*time.Minute)),
+		"SUMMARY:T1: Home → Work (To Work)",
+		`DESCRIPTION:Departs ` + now.Add(10*time.Minute).Format("15:04") + ` from Home\nT1 towards City\, Central\nArriv`,
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("expected %q in\n%s", want, ics)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/calendar.ics?trip=To+Work&hours=6", nil))
+	if n := strings.Count(w.Body.String(), "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("expected 6 hours to reach the T2, got %d events", n)
+	}
+
+	for url, code := range map[string]int{"/calendar.ics?trip=Pool": 404, "/calendar.ics?hours=0": 400, "/calendar.ics?hours=25": 400} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", url, code, w.Code)
+		}
+	}
+}
+
+func TestICSWriter_Folds(t *testing.T) {
+	var ics icsWriter
+	ics.line("SUMMARY", strings.Repeat("→", 60))
+	for i, l := range strings.Split(strings.TrimSuffix(ics.b.String(), "\r\n"), "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line %d is %d octets", i, len(l))
+		}
+		if i > 0 && !strings.HasPrefix(l, " ") {
+			t.Errorf("expected continuation line %d to start with a space", i)
+		}
+	}
+	if got := strings.ReplaceAll(ics.b.String(), "\r\n ", ""); got != "SUMMARY:"+strings.Repeat("→", 60)+"\r\n" {
+		t.Errorf("expected unfolding to restore the line, got %q", got)
+	}
+}
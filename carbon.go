@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// defaultCarbonFactors are approximate grams of CO₂e per passenger-km by mode
+// (UK DEFRA conversion factors), used unless carbon.factors overrides them.
+var defaultCarbonFactors = map[string]float64{
+	"train":      35,
+	"metro":      30,
+	"light_rail": 29,
+	"bus":        102,
+	"coach":      27,
+	"ferry":      19,
+	"car":        170,
+}
+
+type CarbonConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Factors map[string]float64 `yaml:"factors,omitempty"`
+}
+
+func (c CarbonConfig) factor(mode string) (float64, bool) {
+	if f, ok := c.Factors[mode]; ok {
+		return f, true
+	}
+	f, ok := defaultCarbonFactors[mode]
+	return f, ok
+}
+
+// validate checks that every route with a distance has a mode with a known
+// emission factor.
+func (c CarbonConfig) validate(trips []TripConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, ok := c.factor("car"); !ok {
+		return fmt.Errorf("missing factor for car")
+	}
+	for _, trip := range trips {
+		for _, route := range trip.Routes {
+			if route.DistanceKm == 0 {
+				continue
+			}
+			if _, ok := c.factor(route.Mode); !ok {
+				return fmt.Errorf("trip %q: unknown mode %q", trip.Name, route.Mode)
+			}
+		}
+	}
+	return nil
+}
+
+// label estimates a route's CO₂ per passenger from its mode and distance,
+// compared with driving the same distance, e.g. "420 g CO₂ · 2.1 kg by car".
+// Routes without a distance get no label.
+func (c CarbonConfig) label(route RouteConfig) string {
+	if !c.Enabled || route.DistanceKm <= 0 {
+		return ""
+	}
+	f, ok := c.factor(route.Mode)
+	if !ok {
+		return ""
+	}
+	car, _ := c.factor("car")
+	return fmt.Sprintf("%s CO₂ · %s by car", formatGrams(f*route.DistanceKm), formatGrams(car*route.DistanceKm))
+}
+
+func formatGrams(g float64) string {
+	if g < 1000 {
+		return fmt.Sprintf("%d g", int(math.Round(g/10)*10))
+	}
+	return fmt.Sprintf("%.1f kg", g/1000)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCarbonLabel(t *testing.T) {
+	c := CarbonConfig{Enabled: true}
+
+	tests := []struct {
+		route    RouteConfig
+		expected string
+	}{
+		{RouteConfig{Mode: "train", DistanceKm: 12}, "420 g CO₂ · 2.0 kg by car"},
+		{RouteConfig{Mode: "bus", DistanceKm: 30}, "3.1 kg CO₂ · 5.1 kg by car"},
+		{RouteConfig{Mode: "train"}, ""},
+		{RouteConfig{Mode: "hovercraft", DistanceKm: 5}, ""},
+	}
+	for _, tc := range tests {
+		if got := c.label(tc.route); got != tc.expected {
+			t.Errorf("%+v: expected %q, got %q", tc.route, tc.expected, got)
+		}
+	}
+
+	custom := CarbonConfig{Enabled: true, Factors: map[string]float64{"train": 10, "car": 100}}
+	if got := custom.label(RouteConfig{Mode: "train", DistanceKm: 10}); got != "100 g CO₂ · 1.0 kg by car" {
+		t.Errorf("expected factor overrides, got %q", got)
+	}
+
+	if got := (CarbonConfig{}).label(RouteConfig{Mode: "train", DistanceKm: 12}); got != "" {
+		t.Errorf("expected no label when disabled, got %q", got)
+	}
+}
+
+func TestLoadConfig_CarbonUnknownMode(t *testing.T) {
+	yaml := `
+carbon:
+  enabled: true
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+        mode: "zeppelin"
+        distance_km: 10
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
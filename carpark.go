@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCarParkURL = "https://api.transport.nsw.gov.au/v1/carpark"
+
+	// carParkTTL is how long an occupancy reading, or a failure to get one,
+	// is reused; the TfNSW feed updates every few minutes.
+	carParkTTL = 2 * time.Minute
+)
+
+type ParkAndRideConfig struct {
+	APIKey string `yaml:"api_key,omitempty"`
+	URL    string `yaml:"url,omitempty"`
+}
+
+type CarParkView struct {
+	Name      string `json:"name"`
+	Available int    `json:"available"`
+	Total     int    `json:"total"`
+}
+
+// flexInt decodes numbers the TfNSW API sends either bare or as strings.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*n = flexInt(v)
+	return nil
+}
+
+type carParkEntry struct {
+	view      CarParkView
+	err       error
+	fetchedAt time.Time
+}
+
+// carParks reads Park&Ride occupancy from the TfNSW car park API.
+type carParks struct {
+	url    string
+	apiKey string
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]carParkEntry
+}
+
+func newCarParks(cfg ParkAndRideConfig) *carParks {
+	c := &carParks{
+		url:     cfg.URL,
+		apiKey:  cfg.APIKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		entries: make(map[string]carParkEntry),
+	}
+	if c.url == "" {
+		c.url = defaultCarParkURL
+	}
+	if c.apiKey == "" {
+		c.apiKey = os.Getenv("TFNSW_API_KEY")
+	}
+	return c
+}
+
+func (c *carParks) fetch(ctx context.Context, facility string) (CarParkView, error) {
+	c.mu.Lock()
+	e, ok := c.entries[facility]
+	c.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < carParkTTL {
+		return e.view, e.err
+	}
+
+	view, err := c.get(ctx, facility)
+	c.mu.Lock()
+	c.entries[facility] = carParkEntry{view: view, err: err, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return view, err
+}
+
+func (c *carParks) get(ctx context.Context, facility string) (CarParkView, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"?facility="+url.QueryEscape(facility), nil)
+	if err != nil {
+		return CarParkView{}, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "apikey "+c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return CarParkView{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return CarParkView{}, fmt.Errorf("car park API returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		FacilityName string  `json:"facility_name"`
+		Spots        flexInt `json:"spots"`
+		Occupancy    struct {
+			Total flexInt `json:"total"`
+		} `json:"occupancy"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return CarParkView{}, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return CarParkView{
+		Name:      strings.TrimPrefix(body.FacilityName, "Park&Ride - "),
+		Total:     int(body.Spots),
+		Available: max(int(body.Spots)-int(body.Occupancy.Total), 0),
+	}, nil
+}
+
+// tripCarParks returns the occupancy of the car parks attached to a trip's
+// departure stops, each listed once. They are read concurrently; car parks
+// that can't be read are logged and left out.
+func (c *carParks) tripCarParks(ctx context.Context, trip TripConfig) []CarParkView {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, route := range trip.Routes {
+		if id := route.CarParkFacility; id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	results := make([]CarParkView, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = c.fetch(ctx, id)
+		}()
+	}
+	wg.Wait()
+
+	var views []CarParkView
+	for i, id := range ids {
+		if errs[i] != nil {
+			log.Printf("car park %s: %v", id, errs[i])
+			continue
+		}
+		views = append(views, results[i])
+	}
+	return views
+}
+
+// usesCarParks reports whether any route has a Park&Ride car park attached.
+func (c Config) usesCarParks() bool {
+	for _, trip := range c.Trips {
+		for _, route := range trip.Routes {
+			if route.CarParkFacility != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
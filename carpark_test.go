@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCarParks_TripCarParks(t *testing.T) {
+	var calls, authed atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") == "apikey secret" {
+			authed.Add(1)
+		}
+		switch r.URL.Query().Get("facility") {
+		case "486":
+			fmt.Fprint(w, `{"facility_id":"486","facility_name":"Park&Ride - Tallawong P1","spots":"1004","occupancy":{"total":"880"}}`)
+		case "487":
+			fmt.Fprint(w, `{"facility_id":"487","facility_name":"Park&Ride - Tallawong P2","spots":300,"occupancy":{"total":305}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mock.Close()
+
+	c := newCarParks(ParkAndRideConfig{URL: mock.URL, APIKey: "secret"})
+	trip := TripConfig{Routes: []RouteConfig{
+		{DepartureStopID: "100", CarParkFacility: "486"},
+		{DepartureStopID: "101", CarParkFacility: "486"},
+		{DepartureStopID: "102", CarParkFacility: "487"},
+		{DepartureStopID: "103", CarParkFacility: "999"},
+		{DepartureStopID: "104"},
+	}}
+
+	views := c.tripCarParks(context.Background(), trip)
+	if authed.Load() != calls.Load() {
+		t.Errorf("expected every request to send the apikey authorization header, %d of %d did", authed.Load(), calls.Load())
+	}
+	if len(views) != 2 {
+		t.Fatalf("expected each readable car park once, got %+v", views)
+	}
+	if views[0] != (CarParkView{Name: "Tallawong P1", Available: 124, Total: 1004}) {
+		t.Errorf("unexpected first car park: %+v", views[0])
+	}
+	if views[1].Available != 0 {
+		t.Errorf("expected an over-full car park to show 0 spaces, got %d", views[1].Available)
+	}
+
+	c.tripCarParks(context.Background(), trip)
+	if calls.Load() != 3 {
+		t.Errorf("expected readings and failures reused within the TTL, got %d calls", calls.Load())
+	}
+}
+
+func TestCarParks_Template(t *testing.T) {
+	var b strings.Builder
+	tv := TripView{Name: "Trip", CarParks: []CarParkView{
+		{Name: "P1", Available: 12, Total: 100},
+		{Name: "P2", Total: 300},
+		{Name: "P3"},
+	}}
+	if err := parseTemplate().Execute(&b, PageData{Trips: []TripView{tv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"P1: 12 of 100 spaces", "P2: full", "P3: no occupancy data"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected %q on the board", want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// runValidate implements `departure-board validate`: it loads the config and
+// checks the stops it names against the GTFS departure service, printing any
+// problems, and fails if there are some.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file to check")
+	offline := fs.Bool("offline", false, "don't check stop IDs against the GTFS departure service")
+	if help, err := parseFlags(fs, args); help || err != nil {
+		return err
+	}
+	return validateConfig(context.Background(), os.Stdout, *configPath, *offline)
+}
+
+func validateConfig(ctx context.Context, w io.Writer, path string, offline bool) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	if !offline {
+		if err := cfg.startSources(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		problems = validateStops(ctx, cfg.apiURL(), cfg)
+		cancel()
+	}
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems", path, len(problems))
+	}
+	fmt.Fprintf(w, "%s: OK\n", path)
+	return nil
+}
+
+// runRender implements `departure-board render`: it fetches the board once
+// and prints it as text (as /text shows it) or JSON (as /api/departures
+// does), failing if a trip couldn't be loaded.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file to load")
+	format := fs.String("format", "text", "output format: text or json")
+	trip := fs.String("trip", "", "only render this trip, by index or name")
+	if help, err := parseFlags(fs, args); help || err != nil {
+		return err
+	}
+	cfg, err := setupConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	configureGTFSClient(cfg)
+	if cfg.RateLimit.RequestsPerSecond > 0 {
+		gtfsTransport = newRateLimitTransport(gtfsTransport, cfg.RateLimit)
+	}
+	if cfg.Retry.Enabled {
+		gtfsTransport = newRetryTransport(gtfsTransport, cfg.Retry)
+	}
+	if err := cfg.startSources(context.Background()); err != nil {
+		return err
+	}
+	cfg.startClients()
+	return renderOnce(context.Background(), os.Stdout, cfg, *format, *trip)
+}
+
+func renderOnce(ctx context.Context, w io.Writer, cfg Config, format, tripKey string) error {
+	trips := cfg.Trips
+	if tripKey != "" {
+		trip, ok := selectTrip(cfg.Trips, tripKey)
+		if !ok {
+			return fmt.Errorf("unknown trip %q", tripKey)
+		}
+		trips = []TripConfig{trip}
+	}
+
+	cache := newDepartureCache(departureCacheTTL)
+	failed := 0
+	switch format {
+	case "text":
+		data := buildPageData(ctx, cache, cfg.apiURL(), cfg, trips)
+		writeBoardText(w, data.Trips, 0, len(trips) > 1)
+		for _, tv := range data.Trips {
+			if tv.Error != "" {
+				failed++
+			}
+		}
+	case "json":
+		deps := buildDepartures(ctx, cache, cfg.apiURL(), cfg, trips)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(deps); err != nil {
+			return err
+		}
+		for _, at := range deps.Trips {
+			if at.Error != "" {
+				failed++
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected text or json", format)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d trips failed to load", failed, len(trips))
+	}
+	return nil
+}
+
+// exitStatus is an error that ends the program with that status and no
+// message, for subcommands whose status means more than failed or not.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// NextJourney is what `departure-board next -format json` prints. Departure
+// is null when no departure makes its connection within the window.
+type NextJourney struct {
+	Trip      string        `json:"trip"`
+	Departure *APIDeparture `json:"departure"`
+}
+
+// runNext implements `departure-board next`: it prints a trip's next
+// departure that makes its connection and exits 0, or exits 1 if none does
+// within the window, so scripts can branch on it. Anything else that goes
+// wrong, a trip that fails to load included, exits 2.
+func runNext(args []string) error {
+	fs := flag.NewFlagSet("next", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file to load")
+	format := fs.String("format", "text", "output format: text or json")
+	trip := fs.String("trip", "", "trip to check, by index or name (default the first)")
+	help, err := parseFlags(fs, args)
+	if help {
+		return nil
+	}
+	if err != nil {
+		return exitStatus(2)
+	}
+	found, err := func() (bool, error) {
+		cfg, err := setupConfig(*configPath)
+		if err != nil {
+			return false, err
+		}
+		configureGTFSClient(cfg)
+		if cfg.RateLimit.RequestsPerSecond > 0 {
+			gtfsTransport = newRateLimitTransport(gtfsTransport, cfg.RateLimit)
+		}
+		if cfg.Retry.Enabled {
+			gtfsTransport = newRetryTransport(gtfsTransport, cfg.Retry)
+		}
+		if err := cfg.startSources(context.Background()); err != nil {
+			return false, err
+		}
+		cfg.startClients()
+		return printNext(context.Background(), os.Stdout, cfg, *format, *trip)
+	}()
+	switch {
+	case err != nil:
+		log.Printf("next: %v", err)
+		return exitStatus(2)
+	case !found:
+		return exitStatus(1)
+	}
+	return nil
+}
+
+// printNext prints trip's next departure with a connection, or that there
+// isn't one, and reports which.
+func printNext(ctx context.Context, w io.Writer, cfg Config, format, tripKey string) (bool, error) {
+	if format != "text" && format != "json" {
+		return false, fmt.Errorf("unknown format %q, expected text or json", format)
+	}
+	trip, ok := selectTrip(cfg.Trips, tripKey)
+	if !ok {
+		return false, fmt.Errorf("unknown trip %q", tripKey)
+	}
+	now := time.Now().In(boardTZ)
+	tv, err := buildTripView(ctx, newDepartureCache(departureCacheTTL), cfg.apiURL(), cfg, trip, now)
+	if err != nil {
+		return false, err
+	}
+	next := NextJourney{Trip: trip.Name}
+	if dv, ok := nextDeparture(tv.Departures); ok {
+		ad := apiDeparture(dv)
+		next.Departure = &ad
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return next.Departure != nil, enc.Encode(next)
+	}
+	if next.Departure == nil {
+		fmt.Fprintf(w, "No connection in next %d min\n", tv.WindowMinutes)
+		return false, nil
+	}
+	tv.Departures = []DepartureView{next.Departure.DepartureView}
+	tv.AsOf, tv.UpdatedAgo = "", ""
+	writeBoardText(w, []TripView{tv}, 1, false)
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stops/100" {
+			json.NewEncoder(w).Encode(Stop{StopID: "100"})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mock.Close()
+	dir := t.TempDir()
+	write := func(name, yaml string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("gtfs_api_url: "+mock.URL+"\n"+yaml), 0644)
+		return path
+	}
+
+	var out strings.Builder
+	good := write("good.yaml", "trips: [{name: A, routes: [{departure_stop_id: '100', final_arrival_stop: '100'}]}]\n")
+	if err := validateConfig(context.Background(), &out, good, false); err != nil || out.String() != good+": OK\n" {
+		t.Errorf("expected the config to pass, got %v, %q", err, out.String())
+	}
+
+	out.Reset()
+	unknown := write("unknown.yaml", "trips: [{name: A, routes: [{departure_stop_id: '100', final_arrival_stop: '999'}]}]\n")
+	if err := validateConfig(context.Background(), &out, unknown, false); err == nil || !strings.Contains(out.String(), `unknown stop "999"`) {
+		t.Errorf("expected the unknown stop to fail validation, got %v, %q", err, out.String())
+	}
+	if err := validateConfig(context.Background(), &out, unknown, true); err != nil {
+		t.Errorf("expected -offline to skip the stop lookups, got %v", err)
+	}
+
+	if err := validateConfig(context.Background(), &out, write("bad.yaml", "trips: []\n"), true); err == nil {
+		t.Error("expected a config without trips to fail")
+	}
+}
+
+func TestRenderOnce(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{RouteShortName: "T1", Headsign: "City", ScheduledDeparture: now.Add(5*time.Minute + 30*time.Second),
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}}}},
+	})
+	defer mock.Close()
+	cfg := Config{GtfsAPIURL: mock.URL, Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "To Gym", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "400"}}},
+	}}
+
+	var out strings.Builder
+	if err := renderOnce(context.Background(), &out, cfg, "text", "To Work"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "5 mins  T1  City") {
+		t.Errorf("expected the T1 as text, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := renderOnce(context.Background(), &out, cfg, "json", ""); err != nil {
+		t.Fatal(err)
+	}
+	var deps Departures
+	if err := json.Unmarshal([]byte(out.String()), &deps); err != nil || len(deps.Trips) != 2 || len(deps.Trips[0].Departures) != 1 {
+		t.Errorf("expected both trips as JSON, got %v: %s", err, out.String())
+	}
+
+	if err := renderOnce(context.Background(), &out, cfg, "yaml", ""); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+	if err := renderOnce(context.Background(), &out, cfg, "text", "Pool"); err == nil {
+		t.Error("expected an unknown trip to fail")
+	}
+	cfg.GtfsAPIURL = "http://127.0.0.1:1"
+	if err := renderOnce(context.Background(), &out, cfg, "text", ""); err == nil || !strings.Contains(err.Error(), "2 of 2 trips") {
+		t.Errorf("expected trips that fail to load to fail the render, got %v", err)
+	}
+}
+
+func TestPrintNext(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {
+			{RouteShortName: "T1", Headsign: "City", ScheduledDeparture: now.Add(2 * time.Minute)},
+			{RouteShortName: "T2", Headsign: "City", ScheduledDeparture: now.Add(5*time.Minute + 30*time.Second),
+				Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}}},
+		},
+		"200": {{RouteShortName: "B1", ScheduledDeparture: now.Add(3 * time.Minute)}},
+	})
+	defer mock.Close()
+	cfg := Config{GtfsAPIURL: mock.URL, Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "To Gym", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "400"}}},
+	}}
+
+	var out strings.Builder
+	found, err := printNext(context.Background(), &out, cfg, "text", "To Work")
+	if err != nil || !found || !strings.HasPrefix(out.String(), "5 mins  T2  City") {
+		t.Errorf("expected the T2, the first with a connection, got %v, %v, %q", found, err, out.String())
+	}
+
+	out.Reset()
+	found, err = printNext(context.Background(), &out, cfg, "json", "0")
+	var next NextJourney
+	if err != nil || !found {
+		t.Fatalf("expected a departure, got %v, %v", found, err)
+	}
+	if err := json.Unmarshal([]byte(out.String()), &next); err != nil || next.Trip != "To Work" || next.Departure == nil || next.Departure.RouteShortName != "T2" || next.Departure.ArrivesAt == nil {
+		t.Errorf("expected the T2 as JSON, got %v: %s", err, out.String())
+	}
+
+	out.Reset()
+	found, err = printNext(context.Background(), &out, cfg, "json", "To Gym")
+	if err != nil || found || !strings.Contains(out.String(), `"departure": null`) {
+		t.Errorf("expected no connection, got %v, %v, %q", found, err, out.String())
+	}
+	out.Reset()
+	if found, _ := printNext(context.Background(), &out, cfg, "text", "To Gym"); found || !strings.HasPrefix(out.String(), "No connection in next") {
+		t.Errorf("expected no connection as text, got %q", out.String())
+	}
+
+	if _, err := printNext(context.Background(), &out, cfg, "text", "Pool"); err == nil {
+		t.Error("expected an unknown trip to fail")
+	}
+	if _, err := printNext(context.Background(), &out, cfg, "yaml", ""); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+	cfg.GtfsAPIURL = "http://127.0.0.1:1"
+	if _, err := printNext(context.Background(), &out, cfg, "text", ""); err == nil {
+		t.Error("expected a trip that fails to load to fail")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// gtfsRequestTimeout bounds each request to the GTFS departure service,
+// retries included, unless upstream_timeout sets another.
+const gtfsRequestTimeout = 10 * time.Second
+
+// gtfsDialTimeout bounds connecting to the GTFS departure service, unless
+// upstream_dial_timeout sets another. It is the standard library's default.
+const gtfsDialTimeout = 30 * time.Second
+
+// gtfsClient sends every request to the GTFS departure service. It is shared
+// so connections are kept alive between page loads and polls.
+var gtfsClient = &http.Client{Timeout: gtfsRequestTimeout, Transport: gtfsRoundTripper{}}
+
+// gtfsTransport carries every request to the GTFS departure service. main
+// wraps it in a faultTransport when fault_injection is configured, and in a
+// retryTransport when retry is enabled.
+var gtfsTransport http.RoundTripper = newGTFSPool(gtfsDialTimeout, gtfsRequestTimeout)
+
+// gtfsRoundTripper looks gtfsTransport up on each request, so wrapping it
+// after gtfsClient is created still takes effect.
+type gtfsRoundTripper struct{}
+
+func (gtfsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return gtfsTransport.RoundTrip(req)
+}
+
+// newGTFSPool returns the connection pool for the GTFS departure service.
+// Routes are fetched up to maxParallelRoutes at a time, so that many idle
+// connections are kept to the one host rather than the default two.
+func newGTFSPool(dial, header time.Duration) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: dial, KeepAlive: 30 * time.Second}).DialContext
+	t.MaxIdleConnsPerHost = maxParallelRoutes
+	t.IdleConnTimeout = 90 * time.Second
+	t.ResponseHeaderTimeout = header
+	return t
+}
+
+// configureGTFSClient applies the config's upstream timeouts and headers. It
+// replaces gtfsTransport, so it comes before anything wraps it.
+func configureGTFSClient(cfg Config) {
+	gtfsClient.Timeout = cfg.upstreamTimeout()
+	gtfsTransport = &headerTransport{next: newGTFSPool(cfg.upstreamDialTimeout(), cfg.upstreamHeaderTimeout())}
+	setUpstreamHeaders(cfg)
+}
+
+// upstreamHeaders maps each GTFS departure service's host to the headers
+// sent to it: gtfs_api_headers for the board's gtfs_api_url, and a trip or
+// route's own for its gtfs_api_url. They're replaced on a config reload.
+var upstreamHeaders atomic.Pointer[map[string]http.Header]
+
+// setUpstreamHeaders has requests carry cfg's headers from now on.
+func setUpstreamHeaders(cfg Config) {
+	// Validated by parseConfig
+	headers, _ := cfg.upstreamHeaders()
+	upstreamHeaders.Store(&headers)
+}
+
+// upstreamHeaders returns the headers for each upstream by host, with
+// environment variables substituted. A route's headers come from its trip
+// unless it has its own gtfs_api_url. Two different sets for one host are an
+// error, as a request can only carry one.
+func (c Config) upstreamHeaders() (map[string]http.Header, error) {
+	hosts := make(map[string]http.Header)
+	raw := make(map[string]map[string]string)
+	add := func(where, apiURL string, headers map[string]string) error {
+		if len(headers) == 0 {
+			return nil
+		}
+		if apiURL == "" {
+			return fmt.Errorf("%sgtfs_api_headers needs gtfs_api_url", where)
+		}
+		u, err := url.Parse(apiURL)
+		if err != nil || u.Host == "" {
+			return nil
+		}
+		if prev, ok := raw[u.Host]; ok {
+			if !maps.Equal(prev, headers) {
+				return fmt.Errorf("%sgtfs_api_headers: %s already has other headers", where, u.Host)
+			}
+			return nil
+		}
+		h, err := parseHeaders(headers)
+		if err != nil {
+			return fmt.Errorf("%sgtfs_api_headers: %w", where, err)
+		}
+		hosts[u.Host], raw[u.Host] = h, headers
+		return nil
+	}
+	if err := add("", c.apiURL(), c.GtfsAPIHeaders); err != nil {
+		return nil, err
+	}
+	for _, trip := range c.Trips {
+		if err := add(fmt.Sprintf("trip %q: ", trip.Name), trip.GtfsAPIURL, trip.GtfsAPIHeaders); err != nil {
+			return nil, err
+		}
+		for _, route := range trip.Routes {
+			if err := add(fmt.Sprintf("trip %q: route %q: ", trip.Name, route.RouteName), route.GtfsAPIURL, route.GtfsAPIHeaders); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// parseHeaders returns headers (name → value) ready to send, their values
+// passed through expandEnv.
+func parseHeaders(headers map[string]string) (http.Header, error) {
+	h := make(http.Header, len(headers))
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		value, err := expandEnv(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%s: value can't span lines", name)
+		}
+		h.Set(name, value)
+	}
+	return h, nil
+}
+
+// expandEnv substitutes environment variables in a key or header value:
+// "Bearer ${API_KEY}" or "$API_KEY", and "$$" for a dollar sign. A variable
+// that isn't set is an error rather than an empty key.
+func expandEnv(s string) (string, error) {
+	var missing string
+	s = os.Expand(s, func(v string) string {
+		if v == "$" {
+			return "$"
+		}
+		val, ok := os.LookupEnv(v)
+		if !ok && missing == "" {
+			missing = v
+		}
+		return val
+	})
+	if missing != "" {
+		return "", fmt.Errorf("$%s isn't set", missing)
+	}
+	return s, nil
+}
+
+// headerTransport adds an upstream's headers, such as an API key, to each
+// request to its host. Requests elsewhere (alerts feeds, say) go without, so
+// a key isn't handed to other services.
+type headerTransport struct {
+	next http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hosts := upstreamHeaders.Load()
+	if hosts == nil || len((*hosts)[req.URL.Host]) == 0 {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range (*hosts)[req.URL.Host] {
+		req.Header[name] = values
+	}
+	return t.next.RoundTrip(req)
+}
+
+// upstreamTimeout bounds a whole request to the GTFS departure service, from
+// connecting to reading the body, retries and rate limiting included.
+func (c Config) upstreamTimeout() time.Duration {
+	if c.UpstreamTimeout > 0 {
+		return time.Duration(c.UpstreamTimeout) * time.Second
+	}
+	return gtfsRequestTimeout
+}
+
+// upstreamDialTimeout bounds connecting, so an unreachable host fails fast.
+func (c Config) upstreamDialTimeout() time.Duration {
+	if c.UpstreamDialTimeout > 0 {
+		return time.Duration(c.UpstreamDialTimeout) * time.Second
+	}
+	return min(gtfsDialTimeout, c.upstreamTimeout())
+}
+
+// upstreamHeaderTimeout bounds the wait for a response once the request is
+// sent, so a service that accepts connections but hangs fails fast.
+func (c Config) upstreamHeaderTimeout() time.Duration {
+	if c.UpstreamHeaderTimeout > 0 {
+		return time.Duration(c.UpstreamHeaderTimeout) * time.Second
+	}
+	return c.upstreamTimeout()
+}
+
+func (c Config) validateTimeouts() error {
+	if c.UpstreamTimeout < 0 || c.UpstreamDialTimeout < 0 || c.UpstreamHeaderTimeout < 0 {
+		return fmt.Errorf("upstream_timeout, upstream_dial_timeout and upstream_header_timeout can't be negative")
+	}
+	total := c.upstreamTimeout()
+	if d := time.Duration(c.UpstreamDialTimeout) * time.Second; d > total {
+		return fmt.Errorf("upstream_dial_timeout (%v) can't be longer than upstream_timeout (%v)", d, total)
+	}
+	if h := time.Duration(c.UpstreamHeaderTimeout) * time.Second; h > total {
+		return fmt.Errorf("upstream_header_timeout (%v) can't be longer than upstream_timeout (%v)", h, total)
+	}
+	return nil
+}
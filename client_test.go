@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGTFSClient_ReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	mock := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	mock.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	mock.Start()
+	defer mock.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := fetchDepartures(context.Background(), mock.URL, "100", "300", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected one kept-alive connection, got %d", n)
+	}
+
+	// Wrapping gtfsTransport after the client exists still applies.
+	defer func(orig http.RoundTripper) { gtfsTransport = orig }(gtfsTransport)
+	var wrapped atomic.Int32
+	gtfsTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		wrapped.Add(1)
+		return newGTFSPool(gtfsDialTimeout, gtfsRequestTimeout).RoundTrip(req)
+	})
+	if _, err := fetchDepartures(context.Background(), mock.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.Load() != 1 {
+		t.Error("expected the request to go through the replaced transport")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestConfigureGTFSClient(t *testing.T) {
+	defer func(orig http.RoundTripper, timeout time.Duration) {
+		gtfsTransport, gtfsClient.Timeout = orig, timeout
+	}(gtfsTransport, gtfsClient.Timeout)
+
+	configureGTFSClient(Config{})
+	pool := gtfsTransport.(*headerTransport).next.(*http.Transport)
+	if gtfsClient.Timeout != 10*time.Second || pool.ResponseHeaderTimeout != 10*time.Second {
+		t.Errorf("expected the 10 second default, got %v and %v", gtfsClient.Timeout, pool.ResponseHeaderTimeout)
+	}
+
+	cfg, err := parseConfig([]byte(`
+upstream_timeout: 20
+upstream_dial_timeout: 2
+upstream_header_timeout: 15
+trips:
+  - name: To Work
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureGTFSClient(cfg)
+	pool = gtfsTransport.(*headerTransport).next.(*http.Transport)
+	if gtfsClient.Timeout != 20*time.Second || pool.ResponseHeaderTimeout != 15*time.Second || cfg.upstreamDialTimeout() != 2*time.Second {
+		t.Errorf("unexpected timeouts %v, %v, %v", gtfsClient.Timeout, pool.ResponseHeaderTimeout, cfg.upstreamDialTimeout())
+	}
+	if d := (Config{UpstreamTimeout: 5}).upstreamDialTimeout(); d != 5*time.Second {
+		t.Errorf("expected the dial timeout to fit within upstream_timeout, got %v", d)
+	}
+}
+
+func TestConfig_ValidateTimeouts(t *testing.T) {
+	for _, c := range []Config{
+		{UpstreamTimeout: -1},
+		{UpstreamDialTimeout: 11},
+		{UpstreamTimeout: 5, UpstreamHeaderTimeout: 6},
+	} {
+		if c.validateTimeouts() == nil {
+			t.Errorf("expected %d/%d/%d to be invalid", c.UpstreamTimeout, c.UpstreamDialTimeout, c.UpstreamHeaderTimeout)
+		}
+	}
+	if err := (Config{UpstreamTimeout: 30, UpstreamDialTimeout: 30}).validateTimeouts(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConfigureGTFSClient_Headers(t *testing.T) {
+	defer func(orig http.RoundTripper, timeout time.Duration, headers *map[string]http.Header) {
+		gtfsTransport, gtfsClient.Timeout = orig, timeout
+		upstreamHeaders.Store(headers)
+	}(gtfsTransport, gtfsClient.Timeout, upstreamHeaders.Load())
+
+	var got http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("[]"))
+	})
+	mock := httptest.NewServer(handler)
+	defer mock.Close()
+	own := httptest.NewServer(handler)
+	defer own.Close()
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	t.Setenv("BOARD_TEST_KEY", "s3cret")
+	cfg, err := parseConfig([]byte(`
+gtfs_api_url: ` + mock.URL + `
+gtfs_api_headers:
+  Authorization: "apikey ${BOARD_TEST_KEY}"
+  X-Price: "$$5"
+trips:
+  - name: Ferry
+    gtfs_api_url: ` + own.URL + `
+    gtfs_api_headers:
+      X-Api-Key: "$BOARD_TEST_KEY"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+      - departure_stop_id: "101"
+        final_arrival_stop: "300"
+        gtfs_api_url: ` + other.URL + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureGTFSClient(cfg)
+
+	if _, err := fetchDepartures(context.Background(), mock.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "apikey s3cret" || got.Get("X-Price") != "$5" {
+		t.Errorf("expected the configured headers, got %v", got)
+	}
+
+	// The trip's own upstream gets its own headers, not the board's
+	if _, err := fetchDepartures(context.Background(), own.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Api-Key") != "s3cret" || got.Get("Authorization") != "" {
+		t.Errorf("expected the trip's headers, got %v", got)
+	}
+
+	// A route with its own gtfs_api_url and no headers gets none
+	if _, err := fetchDepartures(context.Background(), other.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "" || got.Get("X-Api-Key") != "" {
+		t.Errorf("expected no key sent to another host, got %v", got)
+	}
+
+	// A reload replaces them
+	cfg.GtfsAPIHeaders = map[string]string{"Authorization": "apikey rotated"}
+	setUpstreamHeaders(cfg)
+	if _, err := fetchDepartures(context.Background(), mock.URL, "100", "300", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "apikey rotated" {
+		t.Errorf("expected the reloaded headers, got %v", got)
+	}
+}
+
+func TestConfig_UpstreamHeaders(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"Authorization": "apikey ${BOARD_TEST_UNSET}"},
+		{"Bad Name": "x"},
+		{"X-Key": "a\r\nX-Injected: b"},
+	} {
+		if _, err := (Config{GtfsAPIHeaders: headers}).upstreamHeaders(); err == nil {
+			t.Errorf("expected %q to be rejected", headers)
+		}
+	}
+
+	for name, src := range map[string]string{
+		"headers without a URL": `
+trips:
+  - name: Ferry
+    gtfs_api_headers: {X-Api-Key: k}
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`,
+		"two keys for one host": `
+gtfs_api_url: http://gtfs.example
+gtfs_api_headers: {X-Api-Key: a}
+trips:
+  - name: Ferry
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+        gtfs_api_url: http://gtfs.example/v2
+        gtfs_api_headers: {X-Api-Key: b}
+`,
+	} {
+		if _, err := parseConfig([]byte(src)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestExpandEnv_SIRIAndGTFSKeys(t *testing.T) {
+	t.Setenv("BOARD_TEST_KEY", "s3cret")
+	if got := newSIRISource(SIRIConfig{URL: "http://siri.example", Headers: map[string]string{"X-Key": "${BOARD_TEST_KEY}"}}).headers.Get("X-Key"); got != "s3cret" {
+		t.Errorf("expected the SIRI header from the environment, got %q", got)
+	}
+	if err := (SIRIConfig{URL: "http://siri.example", Headers: map[string]string{"X-Key": "$BOARD_TEST_UNSET"}}).validate(); err == nil {
+		t.Error("expected an unset variable in siri.headers to be rejected")
+	}
+	if err := (GTFSConfig{Static: "feed.zip", APIKey: "$BOARD_TEST_UNSET"}).validate(); err == nil {
+		t.Error("expected an unset variable in gtfs.api_key to be rejected")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouteColorsConfig brands routes by their (aliased) short name: an exact
+// match in routes wins, then the longest matching prefix, then default.
+// Routes matching none of them keep the upstream's GTFS route_color, or the
+// built-in Sydney colours.
+type RouteColorsConfig struct {
+	Routes   map[string]string `yaml:"routes,omitempty"`
+	Prefixes map[string]string `yaml:"prefixes,omitempty"`
+	Default  string            `yaml:"default,omitempty"`
+}
+
+// cssColor matches the colours route_colors accepts: hex or a named colour.
+var cssColor = regexp.MustCompile(`^(#[0-9A-Fa-f]{3}|#[0-9A-Fa-f]{4}|#[0-9A-Fa-f]{6}|#[0-9A-Fa-f]{8}|[A-Za-z]+)$`)
+
+func (c RouteColorsConfig) validate() error {
+	check := func(field, key, color string) error {
+		if !cssColor.MatchString(color) {
+			return fmt.Errorf("%s[%q]: %q is not a hex or named colour", field, key, color)
+		}
+		return nil
+	}
+	for name, color := range c.Routes {
+		if err := check("routes", name, color); err != nil {
+			return err
+		}
+	}
+	for prefix, color := range c.Prefixes {
+		if err := check("prefixes", prefix, color); err != nil {
+			return err
+		}
+	}
+	if c.Default != "" && !cssColor.MatchString(c.Default) {
+		return fmt.Errorf("default: %q is not a hex or named colour", c.Default)
+	}
+	return nil
+}
+
+// color returns the configured colour for a route, or "" when none applies.
+func (c RouteColorsConfig) color(routeShortName string) string {
+	if color, ok := c.Routes[routeShortName]; ok {
+		return color
+	}
+	best := ""
+	for prefix := range c.Prefixes {
+		if strings.HasPrefix(routeShortName, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return c.Prefixes[best]
+	}
+	return c.Default
+}
+
+// gtfsHexColor matches a GTFS route_color or route_text_color.
+var gtfsHexColor = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// departureColors returns a departure's badge and text colours: the
+// upstream's GTFS route colours when it sends them, else the built-in colour
+// and the stylesheet's text colour ("").
+func departureColors(d Departure) (color, textColor string) {
+	color = routeColor(d.RouteShortName)
+	if gtfsHexColor.MatchString(d.RouteColor) {
+		color = "#" + d.RouteColor
+		if gtfsHexColor.MatchString(d.RouteTextColor) {
+			textColor = "#" + d.RouteTextColor
+		}
+	}
+	return color, textColor
+}
+
+// apply recolours a departure and its connections where the config names a
+// colour for them, overriding the upstream's. The upstream's text colour was
+// chosen for its own background, so it is dropped too.
+func (c RouteColorsConfig) apply(dv *DepartureView) {
+	if color := c.color(dv.RouteShortName); color != "" {
+		dv.RouteColor, dv.RouteTextColor = color, ""
+	}
+	for i := range dv.Connections {
+		if color := c.color(dv.Connections[i].RouteShortName); color != "" {
+			dv.Connections[i].RouteColor, dv.Connections[i].RouteTextColor = color, ""
+		}
+	}
+	if len(dv.Connections) > 0 {
+		dv.SecondLegRouteColor = dv.Connections[0].RouteColor
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteColors(t *testing.T) {
+	c := RouteColorsConfig{
+		Routes:   map[string]string{"T1": "#F99D1C"},
+		Prefixes: map[string]string{"T": "#F6891F", "T8": "#00954C", "F": "green"},
+	}
+	tests := []struct{ route, want string }{
+		{"T1", "#F99D1C"},
+		{"T4", "#F6891F"},
+		{"T8", "#00954C"},
+		{"F1", "green"},
+		{"333", ""},
+	}
+	for _, tc := range tests {
+		if got := c.color(tc.route); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.route, tc.want, got)
+		}
+	}
+	c.Default = "#00B5EF"
+	if got := c.color("333"); got != "#00B5EF" {
+		t.Errorf("expected the default, got %q", got)
+	}
+
+	dv := DepartureView{RouteShortName: "M1", RouteColor: routeColor("M1"), Connections: []LegView{{RouteShortName: "T4", RouteColor: routeColor("T4")}}}
+	RouteColorsConfig{Prefixes: map[string]string{"T": "#F6891F"}}.apply(&dv)
+	if dv.RouteColor != "#168388" || dv.Connections[0].RouteColor != "#F6891F" || dv.SecondLegRouteColor != "#F6891F" {
+		t.Errorf("expected only the connection to be recoloured, got %+v", dv)
+	}
+}
+
+func TestRouteColors_Validate(t *testing.T) {
+	_, err := parseConfig([]byte("route_colors: {routes: {T1: \"red;background:url(x)\"}}\ntrips: [{name: A}]\n"))
+	if err == nil || !strings.Contains(err.Error(), "route_colors") {
+		t.Errorf("expected a route_colors error, got %v", err)
+	}
+	if _, err := parseConfig([]byte("route_colors: {prefixes: {T: \"#F6891F\"}, default: navy}\ntrips: [{name: A}]\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDepartureColors(t *testing.T) {
+	tests := []struct {
+		name             string
+		d                Departure
+		color, textColor string
+	}{
+		{"built-in", Departure{RouteShortName: "M1"}, "#168388", ""},
+		{"gtfs", Departure{RouteShortName: "M1", RouteColor: "F6891F", RouteTextColor: "000000"}, "#F6891F", "#000000"},
+		{"gtfs without text colour", Departure{RouteShortName: "T1", RouteColor: "F6891F"}, "#F6891F", ""},
+		{"malformed", Departure{RouteShortName: "T1", RouteColor: "#F6891F", RouteTextColor: "000000"}, "#009ED7", ""},
+	}
+	for _, tc := range tests {
+		color, textColor := departureColors(tc.d)
+		if color != tc.color || textColor != tc.textColor {
+			t.Errorf("%s: expected %q/%q, got %q/%q", tc.name, tc.color, tc.textColor, color, textColor)
+		}
+	}
+
+	// Config colours override the upstream's, text colour included.
+	dv := DepartureView{RouteShortName: "T1", RouteColor: "#F6891F", RouteTextColor: "#000000"}
+	RouteColorsConfig{Routes: map[string]string{"T1": "navy"}}.apply(&dv)
+	if dv.RouteColor != "navy" || dv.RouteTextColor != "" {
+		t.Errorf("expected the config colour without the upstream text colour, got %q/%q", dv.RouteColor, dv.RouteTextColor)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// resolveRouteRefs replaces every route that sets ref with the named entry from
+// route_library. A referencing route takes the library definition as-is.
+func resolveRouteRefs(cfg *Config) error {
+	for i := range cfg.Trips {
+		trip := &cfg.Trips[i]
+		for j, route := range trip.Routes {
+			if route.Ref == "" {
+				continue
+			}
+			lib, ok := cfg.RouteLibrary[route.Ref]
+			if !ok {
+				return fmt.Errorf("trip %q: unknown route_library entry %q", trip.Name, route.Ref)
+			}
+			if lib.Ref != "" {
+				return fmt.Errorf("route_library entry %q: library routes cannot use ref", route.Ref)
+			}
+			if lib.RouteName == "" {
+				lib.RouteName = route.Ref
+			}
+			trip.Routes[j] = lib
+		}
+	}
+	return nil
+}
+
+// resolveStopAliases rewrites route stop fields that name an entry in stops:
+// to the entry's stop ID. Names, coordinates and walk times from the registry
+// fill in route fields that are left empty.
+func resolveStopAliases(cfg *Config) error {
+	if len(cfg.Stops) == 0 {
+		return nil
+	}
+	for alias, stop := range cfg.Stops {
+		if stop.StopID == "" {
+			return fmt.Errorf("stop %q: missing stop_id", alias)
+		}
+	}
+
+	for i := range cfg.Interchanges {
+		ic := &cfg.Interchanges[i]
+		if stop, ok := cfg.Stops[ic.From]; ok {
+			ic.From = stop.StopID
+		}
+		if stop, ok := cfg.Stops[ic.To]; ok {
+			ic.To = stop.StopID
+		}
+	}
+
+	for i := range cfg.Trips {
+		for j := range cfg.Trips[i].Routes {
+			route := &cfg.Trips[i].Routes[j]
+
+			if stop, ok := cfg.Stops[route.DepartureStopID]; ok {
+				route.DepartureStopID = stop.StopID
+				if route.DepartureName == "" {
+					route.DepartureName = stop.Name
+				}
+				if route.DepartureLat == 0 && route.DepartureLon == 0 {
+					route.DepartureLat, route.DepartureLon = stop.Lat, stop.Lon
+				}
+			}
+			if stop, ok := cfg.Stops[route.TransferArrivalStopID]; ok {
+				route.TransferArrivalStopID = stop.StopID
+				if route.TransferName == "" {
+					route.TransferName = stop.Name
+				}
+			}
+			if stop, ok := cfg.Stops[route.TransferDepartureStopID]; ok {
+				route.TransferDepartureStopID = stop.StopID
+				if route.TransferName == "" {
+					route.TransferName = stop.Name
+				}
+			}
+			for k := range route.Legs {
+				leg := &route.Legs[k]
+				if stop, ok := cfg.Stops[leg.TransferArrivalStopID]; ok {
+					leg.TransferArrivalStopID = stop.StopID
+					if leg.TransferName == "" {
+						leg.TransferName = stop.Name
+					}
+				}
+				if stop, ok := cfg.Stops[leg.TransferDepartureStopID]; ok {
+					leg.TransferDepartureStopID = stop.StopID
+					if leg.TransferName == "" {
+						leg.TransferName = stop.Name
+					}
+				}
+			}
+			if stop, ok := cfg.Stops[route.FinalArrivalStop]; ok {
+				route.FinalArrivalStop = stop.StopID
+				if route.ArrivalName == "" {
+					route.ArrivalName = stop.Name
+				}
+				if route.FinalWalkTime == 0 {
+					route.FinalWalkTime = stop.WalkTime
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expandReturnTrips appends a mirrored trip directly after every trip that sets
+// generate_return (or bidirectional), so commute pairs only need to be
+// configured in one direction.
+func expandReturnTrips(trips []TripConfig) []TripConfig {
+	var out []TripConfig
+	for _, trip := range trips {
+		out = append(out, trip)
+		if trip.GenerateReturn || trip.Bidirectional {
+			out = append(out, reverseTrip(trip))
+		}
+	}
+	return out
+}
+
+func reverseTrip(trip TripConfig) TripConfig {
+	rev := TripConfig{Name: trip.ReturnName, Timezone: trip.Timezone, WindowMinutes: trip.WindowMinutes, RefreshSeconds: trip.RefreshSeconds, MaxJourneyMinutes: trip.MaxJourneyMinutes, Sort: trip.Sort, GtfsAPIURL: trip.GtfsAPIURL, loc: trip.loc}
+	if rev.Name == "" {
+		rev.Name = reverseTripName(trip.Name)
+	}
+	for _, route := range trip.Routes {
+		rev.Routes = append(rev.Routes, reverseRoute(route))
+	}
+	return rev
+}
+
+// reverseTripName turns "Home → Work" into "Work → Home", falling back to a
+// "(return)" suffix when the name doesn't describe a direction.
+func reverseTripName(name string) string {
+	if from, to, ok := strings.Cut(name, "→"); ok {
+		return strings.TrimSpace(to) + " → " + strings.TrimSpace(from)
+	}
+	return name + " (return)"
+}
+
+// reverseRoute swaps the ends of a route and the order of its legs. The walks
+// swap ends too: the walk to the original departure stop becomes the final
+// walk, and the walk from the original final stop the initial one. Headsign
+// filters are left off, as the services run the other way.
+func reverseRoute(route RouteConfig) RouteConfig {
+	rev := RouteConfig{
+		RouteName:           route.RouteName,
+		DepartureStopID:     route.FinalArrivalStop,
+		DepartureName:       route.ArrivalName,
+		FinalArrivalStop:    route.DepartureStopID,
+		ArrivalName:         route.DepartureName,
+		FinalWalkTime:       route.InitialWalkTime,
+		InitialWalkTime:     route.FinalWalkTime,
+		Mode:                route.Mode,
+		DistanceKm:          route.DistanceKm,
+		GtfsAPIURL:          route.GtfsAPIURL,
+		MinConnectionBuffer: route.MinConnectionBuffer,
+	}
+
+	if len(route.Legs) > 0 {
+		reverseLegs(&rev, route)
+		return rev
+	}
+
+	if route.TransferArrivalStopID == "" {
+		rev.Leg1Services, rev.Leg1ExcludeServices = route.Leg1Services, route.Leg1ExcludeServices
+		return rev
+	}
+
+	if route.TransferDepartureStopID == route.FinalArrivalStop {
+		// Walk-only transfer: the reverse journey starts with the walk, which
+		// isn't modelled, so board directly at the original alighting stop.
+		rev.DepartureStopID = route.TransferArrivalStopID
+		rev.DepartureName = route.TransferName
+		rev.InitialWalkTime += route.TransferTime
+		rev.Leg1Services, rev.Leg1ExcludeServices = route.Leg1Services, route.Leg1ExcludeServices
+		return rev
+	}
+
+	rev.TransferArrivalStopID = route.TransferDepartureStopID
+	rev.TransferDepartureStopID = route.TransferArrivalStopID
+	rev.TransferTime = route.TransferTime
+	rev.TransferName = route.TransferName
+	rev.Leg1Services, rev.Leg1ExcludeServices = route.Leg2Services, route.Leg2ExcludeServices
+	rev.Leg2Services, rev.Leg2ExcludeServices = route.Leg1Services, route.Leg1ExcludeServices
+	return rev
+}
+
+// reverseLegs sets the reversed legs of a route that uses legs:, riding the
+// same services in the opposite order.
+func reverseLegs(rev *RouteConfig, route RouteConfig) {
+	legs := route.Legs
+	services := [][]string{route.Leg1Services}
+	excluded := [][]string{route.Leg1ExcludeServices}
+	for _, leg := range legs {
+		services = append(services, leg.Services)
+		excluded = append(excluded, leg.ExcludeServices)
+	}
+
+	last := legs[len(legs)-1]
+	if last.TransferDepartureStopID == route.FinalArrivalStop {
+		// As with a single walk-only transfer, board directly at the stop the
+		// original journey walks from.
+		rev.DepartureStopID = last.TransferArrivalStopID
+		rev.DepartureName = last.TransferName
+		rev.InitialWalkTime += last.TransferTime
+		legs = legs[:len(legs)-1]
+		services = services[:len(services)-1]
+		excluded = excluded[:len(excluded)-1]
+	}
+
+	rev.Leg1Services = services[len(services)-1]
+	rev.Leg1ExcludeServices = excluded[len(excluded)-1]
+	for i := len(legs) - 1; i >= 0; i-- {
+		rev.Legs = append(rev.Legs, LegConfig{
+			TransferArrivalStopID:   legs[i].TransferDepartureStopID,
+			TransferTime:            legs[i].TransferTime,
+			TransferDepartureStopID: legs[i].TransferArrivalStopID,
+			TransferName:            legs[i].TransferName,
+			Services:                services[i],
+			ExcludeServices:         excluded[i],
+		})
+	}
+}
+
+// validateLegs checks that each route describes its changes either with legs
+// or with the single transfer_* fields, and that every leg names its stops.
+func validateLegs(trips []TripConfig) error {
+	for _, trip := range trips {
+		for _, route := range trip.Routes {
+			if len(route.Legs) == 0 {
+				continue
+			}
+			if route.TransferArrivalStopID != "" || route.TransferDepartureStopID != "" || len(route.Leg2Services) > 0 || len(route.Leg2ExcludeServices) > 0 || len(route.Leg2Headsigns) > 0 {
+				return fmt.Errorf("trip %q: route %q: use either legs or transfer_* fields, not both", trip.Name, route.RouteName)
+			}
+			for i, leg := range route.Legs {
+				if leg.TransferArrivalStopID == "" || leg.TransferDepartureStopID == "" {
+					return fmt.Errorf("trip %q: route %q: legs[%d]: transfer_arrival_stop_id and transfer_departure_stop_id are required", trip.Name, route.RouteName, i)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ic InterchangeConfig) validate() error {
+	if ic.From == "" || ic.To == "" {
+		return fmt.Errorf("from and to are required")
+	}
+	if ic.MinConnection <= 0 {
+		return fmt.Errorf("min_connection must be positive")
+	}
+	return nil
+}
+
+// minConnection returns the minimum connection time in seconds configured for
+// changing from one stop to another, or 0 if the interchange isn't listed.
+func (c Config) minConnection(from, to string) int {
+	for _, ic := range c.Interchanges {
+		if ic.From == from && ic.To == to {
+			return ic.MinConnection
+		}
+	}
+	return 0
+}
+
+// connectionBuffer returns the spare time a route's connections should leave
+// after the transfer walk: the route's min_connection_buffer, else the
+// board's.
+func (c Config) connectionBuffer(route RouteConfig) time.Duration {
+	if route.MinConnectionBuffer > 0 {
+		return time.Duration(route.MinConnectionBuffer) * time.Second
+	}
+	return time.Duration(c.MinConnectionBuffer) * time.Second
+}
+
+// validateServicePatterns checks the glob and regular expression patterns in
+// each route's service filters.
+func validateServicePatterns(trips []TripConfig) error {
+	for _, trip := range trips {
+		for _, route := range trip.Routes {
+			filters := [][]string{route.Leg1Services, route.Leg1ExcludeServices, route.Leg2Services, route.Leg2ExcludeServices}
+			for _, leg := range route.Legs {
+				filters = append(filters, leg.Services, leg.ExcludeServices)
+			}
+			for _, f := range filters {
+				for _, pattern := range f {
+					if err := validateServicePattern(pattern); err != nil {
+						return fmt.Errorf("trip %q: route %q: %w", trip.Name, route.RouteName, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
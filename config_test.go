@@ -0,0 +1,527 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReverseRoute_Transfer(t *testing.T) {
+	route := RouteConfig{
+		DepartureStopID:         "100",
+		DepartureName:           "Home",
+		InitialWalkTime:         240,
+		Leg1Services:            []string{"333"},
+		TransferArrivalStopID:   "200",
+		TransferTime:            120,
+		TransferDepartureStopID: "201",
+		TransferName:            "Central",
+		Leg2Services:            []string{"T8"},
+		Leg2ExcludeServices:     []string{"T8X"},
+		FinalArrivalStop:        "300",
+		FinalWalkTime:           600,
+		ArrivalName:             "Work",
+	}
+
+	rev := reverseRoute(route)
+	if rev.DepartureStopID != "300" || rev.DepartureName != "Work" {
+		t.Errorf("expected departure from 300/Work, got %s/%s", rev.DepartureStopID, rev.DepartureName)
+	}
+	if rev.FinalArrivalStop != "100" || rev.ArrivalName != "Home" {
+		t.Errorf("expected arrival at 100/Home, got %s/%s", rev.FinalArrivalStop, rev.ArrivalName)
+	}
+	if rev.TransferArrivalStopID != "201" || rev.TransferDepartureStopID != "200" {
+		t.Errorf("expected transfer stops swapped, got %s -> %s", rev.TransferArrivalStopID, rev.TransferDepartureStopID)
+	}
+	if rev.TransferTime != 120 || rev.TransferName != "Central" {
+		t.Errorf("expected transfer time and name kept, got %d/%s", rev.TransferTime, rev.TransferName)
+	}
+	if len(rev.Leg1Services) != 1 || rev.Leg1Services[0] != "T8" {
+		t.Errorf("expected leg 1 services [T8], got %v", rev.Leg1Services)
+	}
+	if len(rev.Leg2Services) != 1 || rev.Leg2Services[0] != "333" {
+		t.Errorf("expected leg 2 services [333], got %v", rev.Leg2Services)
+	}
+	if len(rev.Leg1ExcludeServices) != 1 || rev.Leg1ExcludeServices[0] != "T8X" || len(rev.Leg2ExcludeServices) != 0 {
+		t.Errorf("expected T8X excluded on leg 1, got %v and %v", rev.Leg1ExcludeServices, rev.Leg2ExcludeServices)
+	}
+	if rev.InitialWalkTime != 600 || rev.FinalWalkTime != 240 {
+		t.Errorf("expected the walks to swap ends, got initial %d and final %d", rev.InitialWalkTime, rev.FinalWalkTime)
+	}
+}
+
+func TestReverseRoute_WalkOnlyTransfer(t *testing.T) {
+	route := RouteConfig{
+		DepartureStopID:         "100",
+		DepartureName:           "Home",
+		Leg1Services:            []string{"333"},
+		TransferArrivalStopID:   "200",
+		TransferTime:            300,
+		TransferDepartureStopID: "300",
+		TransferName:            "Museum",
+		FinalArrivalStop:        "300",
+		FinalWalkTime:           60,
+		ArrivalName:             "Work",
+	}
+
+	rev := reverseRoute(route)
+	if rev.DepartureStopID != "200" || rev.DepartureName != "Museum" {
+		t.Errorf("expected departure from 200/Museum, got %s/%s", rev.DepartureStopID, rev.DepartureName)
+	}
+	if rev.InitialWalkTime != 360 {
+		t.Errorf("expected the transfer walk added to the initial walk, got %d", rev.InitialWalkTime)
+	}
+	if rev.TransferArrivalStopID != "" {
+		t.Errorf("expected a direct route, got transfer at %s", rev.TransferArrivalStopID)
+	}
+	if rev.FinalArrivalStop != "100" {
+		t.Errorf("expected arrival at 100, got %s", rev.FinalArrivalStop)
+	}
+	if len(rev.Leg1Services) != 1 || rev.Leg1Services[0] != "333" {
+		t.Errorf("expected leg 1 services [333], got %v", rev.Leg1Services)
+	}
+}
+
+func TestReverseRoute_Legs(t *testing.T) {
+	route := RouteConfig{
+		DepartureStopID: "100",
+		Leg1Services:    []string{"333"},
+		Legs: []LegConfig{
+			{TransferArrivalStopID: "200", TransferTime: 240, TransferDepartureStopID: "201", TransferName: "Central", Services: []string{"T1"}},
+			{TransferArrivalStopID: "300", TransferTime: 300, TransferDepartureStopID: "301", TransferName: "Chatswood", Services: []string{"M1"}},
+		},
+		FinalArrivalStop: "400",
+	}
+
+	rev := reverseRoute(route)
+	if rev.DepartureStopID != "400" || rev.FinalArrivalStop != "100" {
+		t.Errorf("expected 400 -> 100, got %s -> %s", rev.DepartureStopID, rev.FinalArrivalStop)
+	}
+	if len(rev.Leg1Services) != 1 || rev.Leg1Services[0] != "M1" {
+		t.Errorf("expected leg 1 services [M1], got %v", rev.Leg1Services)
+	}
+	want := []LegConfig{
+		{TransferArrivalStopID: "301", TransferTime: 300, TransferDepartureStopID: "300", TransferName: "Chatswood", Services: []string{"T1"}},
+		{TransferArrivalStopID: "201", TransferTime: 240, TransferDepartureStopID: "200", TransferName: "Central", Services: []string{"333"}},
+	}
+	if len(rev.Legs) != len(want) {
+		t.Fatalf("expected %d legs, got %+v", len(want), rev.Legs)
+	}
+	for i := range want {
+		got := rev.Legs[i]
+		if got.TransferArrivalStopID != want[i].TransferArrivalStopID || got.TransferDepartureStopID != want[i].TransferDepartureStopID ||
+			got.TransferName != want[i].TransferName || len(got.Services) != 1 || got.Services[0] != want[i].Services[0] {
+			t.Errorf("leg %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+}
+
+func TestLoadConfig_LegsAndTransfer(t *testing.T) {
+	yaml := `
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: "100"
+        transfer_arrival_stop_id: "200"
+        transfer_departure_stop_id: "201"
+        legs:
+          - transfer_arrival_stop_id: "200"
+            transfer_departure_stop_id: "201"
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for a route with both legs and transfer fields")
+	}
+}
+
+func TestReverseTripName(t *testing.T) {
+	tests := map[string]string{
+		"Home → Work": "Work → Home",
+		"Commute":     "Commute (return)",
+	}
+	for in, want := range tests {
+		if got := reverseTripName(in); got != want {
+			t.Errorf("reverseTripName(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestLoadConfig_GenerateReturn(t *testing.T) {
+	yaml := `
+trips:
+  - name: "Home → Work"
+    generate_return: true
+    routes:
+      - departure_stop_id: "100"
+        departure_name: "Home"
+        final_arrival_stop: "300"
+        arrival_name: "Work"
+  - name: "Gym"
+    generate_return: true
+    return_name: "Gym → Home"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "400"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Trips) != 4 {
+		t.Fatalf("expected 4 trips, got %d", len(cfg.Trips))
+	}
+	if cfg.Trips[1].Name != "Work → Home" {
+		t.Errorf("expected generated return 'Work → Home' after its trip, got %q", cfg.Trips[1].Name)
+	}
+	if cfg.Trips[1].Routes[0].DepartureStopID != "300" {
+		t.Errorf("expected return trip to depart from 300, got %s", cfg.Trips[1].Routes[0].DepartureStopID)
+	}
+	if cfg.Trips[3].Name != "Gym → Home" {
+		t.Errorf("expected return_name to be used, got %q", cfg.Trips[3].Name)
+	}
+}
+
+func TestLoadConfig_Bidirectional(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+trips:
+  - name: "Home → Work"
+    bidirectional: true
+    routes:
+      - departure_stop_id: "100"
+        transfer_arrival_stop_id: "200"
+        transfer_time: 120
+        transfer_departure_stop_id: "201"
+        final_arrival_stop: "300"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Trips) != 2 || cfg.Trips[1].Name != "Work → Home" {
+		t.Fatalf("expected the return trip after its trip, got %+v", cfg.Trips)
+	}
+	if r := cfg.Trips[1].Routes[0]; r.DepartureStopID != "300" || r.TransferArrivalStopID != "201" || r.TransferDepartureStopID != "200" || r.FinalArrivalStop != "100" {
+		t.Errorf("expected the route mirrored, got %+v", r)
+	}
+}
+
+func TestLoadConfig_RouteLibrary(t *testing.T) {
+	yaml := `
+route_library:
+  scg-central:
+    departure_stop_id: "100"
+    departure_name: "SCG"
+    transfer_arrival_stop_id: "200"
+    transfer_time: 270
+    transfer_departure_stop_id: "201"
+    transfer_name: "Central"
+    final_arrival_stop: "300"
+    arrival_name: "Airport"
+
+trips:
+  - name: "Morning"
+    routes:
+      - ref: scg-central
+  - name: "Weekend"
+    generate_return: true
+    routes:
+      - ref: scg-central
+      - departure_stop_id: "500"
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Trips) != 3 {
+		t.Fatalf("expected 3 trips, got %d", len(cfg.Trips))
+	}
+	for _, i := range []int{0, 1} {
+		r := cfg.Trips[i].Routes[0]
+		if r.TransferTime != 270 || r.DepartureStopID != "100" {
+			t.Errorf("trip %d: expected library route, got %+v", i, r)
+		}
+		if r.RouteName != "scg-central" {
+			t.Errorf("trip %d: expected route name to default to the ref, got %q", i, r.RouteName)
+		}
+	}
+	if len(cfg.Trips[1].Routes) != 2 || cfg.Trips[1].Routes[1].DepartureStopID != "500" {
+		t.Errorf("expected inline route to be kept alongside the ref")
+	}
+	if cfg.Trips[2].Routes[0].DepartureStopID != "300" {
+		t.Errorf("expected generated return to use the resolved route, got %+v", cfg.Trips[2].Routes[0])
+	}
+}
+
+func TestLoadConfig_UnknownRouteRef(t *testing.T) {
+	yaml := `
+trips:
+  - name: "Morning"
+    routes:
+      - ref: missing
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("expected error for unknown route ref")
+	}
+}
+
+func TestLoadConfig_StopAliases(t *testing.T) {
+	yaml := `
+stops:
+  home:
+    stop_id: "2021102"
+    name: "SCG"
+    lat: -33.891
+    lon: 151.224
+  central-arr:
+    stop_id: "2000448"
+    name: "Central"
+  central-dep:
+    stop_id: "2000343"
+  airport:
+    stop_id: "202092"
+    name: "Airport"
+    walk_time: 720
+
+trips:
+  - name: "Home → Work"
+    routes:
+      - departure_stop_id: home
+        transfer_arrival_stop_id: central-arr
+        transfer_time: 270
+        transfer_departure_stop_id: central-dep
+        final_arrival_stop: airport
+        arrival_name: "Office"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := cfg.Trips[0].Routes[0]
+	if r.DepartureStopID != "2021102" || r.TransferArrivalStopID != "2000448" ||
+		r.TransferDepartureStopID != "2000343" || r.FinalArrivalStop != "202092" {
+		t.Errorf("expected aliases resolved to stop IDs, got %+v", r)
+	}
+	if r.DepartureName != "SCG" || r.TransferName != "Central" {
+		t.Errorf("expected names filled from registry, got %q/%q", r.DepartureName, r.TransferName)
+	}
+	if r.ArrivalName != "Office" {
+		t.Errorf("expected explicit arrival_name to win, got %q", r.ArrivalName)
+	}
+	if r.FinalWalkTime != 720 {
+		t.Errorf("expected walk time 720 from registry, got %d", r.FinalWalkTime)
+	}
+	if r.DepartureLat != -33.891 || r.DepartureLon != 151.224 {
+		t.Errorf("expected departure coordinates from registry, got %v,%v", r.DepartureLat, r.DepartureLon)
+	}
+}
+
+func TestLoadConfig_StopAliasMissingID(t *testing.T) {
+	yaml := `
+stops:
+  home:
+    name: "Home"
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: home
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for stop alias without stop_id")
+	}
+}
+
+func TestLoadConfig_Interchanges(t *testing.T) {
+	yaml := `
+stops:
+  central-16:
+    stop_id: "2000336"
+  central-23:
+    stop_id: "2000343"
+interchanges:
+  - from: central-16
+    to: central-23
+    min_connection: 360
+  - from: "2000343"
+    to: "2000336"
+    min_connection: 300
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.minConnection("2000336", "2000343"); got != 360 {
+		t.Errorf("expected aliased interchange to resolve, got %d", got)
+	}
+	if got := cfg.minConnection("2000343", "2000336"); got != 300 {
+		t.Errorf("expected interchanges to be directional, got %d", got)
+	}
+	if got := cfg.minConnection("2000336", "999"); got != 0 {
+		t.Errorf("expected 0 for an unlisted interchange, got %d", got)
+	}
+}
+
+func TestLoadConfig_InvalidInterchange(t *testing.T) {
+	yaml := `
+interchanges:
+  - from: "2000336"
+    to: "2000343"
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for interchange without min_connection")
+	}
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	t.Setenv("CONFIG_PATH", "")
+	if got := defaultConfigPath(); got != "config.yaml" {
+		t.Errorf("expected config.yaml, got %q", got)
+	}
+	t.Setenv("CONFIG_PATH", "/etc/departure-board/kitchen.yaml")
+	if got := defaultConfigPath(); got != "/etc/departure-board/kitchen.yaml" {
+		t.Errorf("expected $CONFIG_PATH, got %q", got)
+	}
+}
+
+func TestLoadConfig_TemplatePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "board.html")
+	os.WriteFile(path, []byte(`<p lang="{{(locale).Lang}}">{{len .Trips}} trips</p>`), 0644)
+	cfg, err := parseConfig([]byte("template_path: " + path + "\ntrips: [{name: A}]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := cfg.boardPage(parseTemplate()).Execute(&b, PageData{Trips: make([]TripView, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != `<p lang="en">2 trips</p>` {
+		t.Errorf("expected the custom template, got %q", b.String())
+	}
+
+	def := parseTemplate()
+	if cfg, _ := parseConfig([]byte("trips: [{name: A}]\n")); cfg.boardPage(def) != def {
+		t.Error("expected the built-in template without template_path")
+	}
+	os.WriteFile(path, []byte(`{{if}}`), 0644)
+	if _, err := parseConfig([]byte("template_path: " + path + "\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "template_path") {
+		t.Errorf("expected a template_path error, got %v", err)
+	}
+	if _, err := parseConfig([]byte("template_path: " + filepath.Join(dir, "missing.html") + "\ntrips: [{name: A}]\n")); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestLoadConfig_GtfsAPIURL(t *testing.T) {
+	yaml := `
+gtfs_api_url: http://board
+trips:
+  - name: "Home → Work"
+    gtfs_api_url: http://trains
+    generate_return: true
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+      - departure_stop_id: "100"
+        final_arrival_stop: "400"
+        gtfs_api_url: http://buses
+  - name: "Gym"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "500"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		trip, route int
+		want        string
+	}{
+		{0, 0, "http://trains"},
+		{0, 1, "http://buses"},
+		{1, 0, "http://trains"},
+		{1, 1, "http://buses"},
+		{2, 0, "http://board"},
+	} {
+		if got := cfg.Trips[tc.trip].Routes[tc.route].upstreamURL(cfg.apiURL()); got != tc.want {
+			t.Errorf("trip %d route %d: expected %s, got %s", tc.trip, tc.route, tc.want, got)
+		}
+	}
+}
+
+func TestLoadConfig_RefreshSeconds(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+refresh_seconds: 120
+trips:
+  - name: Commute
+    refresh_seconds: 15
+    generate_return: true
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+  - name: Gym
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "500"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, want := range []int{15, 15, 120} {
+		if got := cfg.refreshSeconds(cfg.Trips[i]); got != want {
+			t.Errorf("trip %d: expected %d seconds, got %d", i, want, got)
+		}
+	}
+	if got := (Config{}).refreshSeconds(TripConfig{}); got != defaultRefreshSeconds {
+		t.Errorf("expected the default of %d seconds, got %d", defaultRefreshSeconds, got)
+	}
+
+	for _, yaml := range []string{
+		"refresh_seconds: 2\ntrips:\n  - name: a\n    routes: []\n",
+		"trips:\n  - name: a\n    refresh_seconds: 7200\n    routes: []\n",
+	} {
+		if _, err := parseConfig([]byte(yaml)); err == nil || !strings.Contains(err.Error(), "refresh_seconds") {
+			t.Errorf("expected a refresh_seconds error for %q, got %v", yaml, err)
+		}
+	}
+}
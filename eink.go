@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEInkSize fits the common 7.5" 800x480 panels.
+const defaultEInkSize = "800x480"
+
+// EInkConfig renders the board (and /embed) as a static page for e-paper
+// displays whenever enabled is set; otherwise ?mode=eink asks for one.
+// size is the panel resolution in pixels, which ?size= overrides.
+type EInkConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Size    string `yaml:"size,omitempty"`
+}
+
+// EInkView is the page size of an e-ink render.
+type EInkView struct {
+	Width, Height int
+}
+
+func (c EInkConfig) validate() error {
+	if c.Size == "" {
+		return nil
+	}
+	_, err := parseEInkSize(c.Size)
+	return err
+}
+
+// parseEInkSize parses a "WIDTHxHEIGHT" panel size.
+func parseEInkSize(s string) (EInkView, error) {
+	w, h, ok := strings.Cut(s, "x")
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if !ok || err1 != nil || err2 != nil || width < 100 || height < 100 || width > 4000 || height > 4000 {
+		return EInkView{}, fmt.Errorf("size %q is not WIDTHxHEIGHT in pixels (100 to 4000)", s)
+	}
+	return EInkView{Width: width, Height: height}, nil
+}
+
+// einkPage returns the e-ink page size r asks for, or nil for the normal
+// page. ?mode=web gets the normal page even with eink.enabled.
+func einkPage(r *http.Request, c EInkConfig) (*EInkView, error) {
+	q := r.URL.Query()
+	switch q.Get("mode") {
+	case "eink":
+	case "web":
+		return nil, nil
+	default:
+		if !c.Enabled {
+			return nil, nil
+		}
+	}
+	size := q.Get("size")
+	if size == "" {
+		size = c.Size
+	}
+	if size == "" {
+		size = defaultEInkSize
+	}
+	v, err := parseEInkSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// setEInk turns data into a static e-ink render: no scripts, refresh,
+// client rendering or dark scheme, which would leave the display half drawn.
+func (data *PageData) setEInk(v *EInkView) {
+	data.EInk = v
+	data.ClientRender, data.Board = false, Board{}
+	data.WebPush, data.Habits, data.Geolocation, data.Kiosk = false, false, false, false
+	data.Dark, data.AutoDark, data.ThemeChange = false, false, time.Time{}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEInkPage(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  EInkConfig
+		url  string
+		want string
+	}{
+		{"off", EInkConfig{}, "/", ""},
+		{"query", EInkConfig{}, "/?mode=eink", "800x480"},
+		{"query size", EInkConfig{}, "/?mode=eink&size=296x128", "296x128"},
+		{"config", EInkConfig{Enabled: true, Size: "640x384"}, "/", "640x384"},
+		{"size overrides config", EInkConfig{Enabled: true, Size: "640x384"}, "/?size=1200x825", "1200x825"},
+		{"web opts out", EInkConfig{Enabled: true}, "/?mode=web", ""},
+	}
+	for _, tc := range tests {
+		v, err := einkPage(httptest.NewRequest("GET", tc.url, nil), tc.cfg)
+		got := ""
+		if v != nil {
+			got = fmt.Sprintf("%dx%d", v.Width, v.Height)
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.name, tc.want, got, err)
+		}
+	}
+
+	for _, size := range []string{"800", "800x", "10x10", "800x480x2", "wide"} {
+		if _, err := einkPage(httptest.NewRequest("GET", "/?mode=eink&size="+size, nil), EInkConfig{}); err == nil {
+			t.Errorf("%q: expected an error", size)
+		}
+	}
+	if _, err := parseConfig([]byte("eink: {enabled: true, size: huge}\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "eink") {
+		t.Errorf("expected an eink error from parseConfig, got %v", err)
+	}
+}
+
+func TestBoardTemplate_EInk(t *testing.T) {
+	data := PageData{
+		Now:          time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ),
+		Trips:        []TripView{{Name: "To Work"}, {Name: "Home"}},
+		ClientRender: true,
+		Dark:         true,
+		ThemeChange:  time.Date(2026, 3, 2, 19, 30, 0, 0, boardTZ),
+	}
+	data.setEInk(&EInkView{Width: 800, Height: 480})
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{`<body class=" eink" style="width:800px;height:480px">`, `<h2 class="trip-name">To Work</h2>`, `<h2 class="trip-name">Home</h2>`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in the page", want)
+		}
+	}
+	for _, unwanted := range []string{"<script", `http-equiv="refresh"`, "fonts.googleapis.com", `class="dark"`, `class="topbar tabs"`} {
+		if strings.Contains(page, unwanted) {
+			t.Errorf("expected no %q in an e-ink page", unwanted)
+		}
+	}
+}
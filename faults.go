@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+// faultKinds are the failures fault injection can simulate.
+var faultKinds = []string{"timeout", "error", "malformed"}
+
+type FaultInjectionConfig struct {
+	Rate   float64  `yaml:"rate"`
+	Faults []string `yaml:"faults,omitempty"`
+}
+
+func (c FaultInjectionConfig) validate() error {
+	if c.Rate < 0 || c.Rate > 1 {
+		return fmt.Errorf("rate must be between 0 and 1")
+	}
+	for _, f := range c.Faults {
+		known := false
+		for _, k := range faultKinds {
+			known = known || f == k
+		}
+		if !known {
+			return fmt.Errorf("unknown fault %q (want one of %s)", f, strings.Join(faultKinds, ", "))
+		}
+	}
+	return nil
+}
+
+// faultTransport fails a random share of requests with a timeout, a 5xx
+// response or a truncated JSON body, so the board's error handling can be
+// exercised against a healthy backend.
+type faultTransport struct {
+	next   http.RoundTripper
+	rate   float64
+	faults []string
+	rand   func() float64
+}
+
+func newFaultTransport(next http.RoundTripper, cfg FaultInjectionConfig) *faultTransport {
+	faults := cfg.Faults
+	if len(faults) == 0 {
+		faults = faultKinds
+	}
+	return &faultTransport{next: next, rate: cfg.Rate, faults: faults, rand: rand.Float64}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.rand() >= t.rate {
+		return t.next.RoundTrip(req)
+	}
+
+	fault := t.faults[int(t.rand()*float64(len(t.faults)))%len(t.faults)]
+	switch fault {
+	case "timeout":
+		// Hang until the client gives up, as an unresponsive backend would.
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	case "error":
+		return faultResponse(req, http.StatusServiceUnavailable, `{"error":"injected fault"}`), nil
+	default:
+		return faultResponse(req, http.StatusOK, `[{"trip_id":"injected`), nil
+	}
+}
+
+func faultResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	defer func(orig http.RoundTripper) { gtfsTransport = orig }(gtfsTransport)
+
+	fetch := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := fetchDepartures(ctx, srv.URL, "100", "300", 0)
+		return err
+	}
+
+	tests := []struct {
+		fault string
+		want  string
+	}{
+		{"timeout", "deadline exceeded"},
+		{"error", "injected fault"},
+		{"malformed", "decoding response"},
+	}
+	for _, tt := range tests {
+		gtfsTransport = newFaultTransport(http.DefaultTransport, FaultInjectionConfig{Rate: 1, Faults: []string{tt.fault}})
+		err := fetch()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.fault, tt.want, err)
+		}
+	}
+
+	tr := newFaultTransport(http.DefaultTransport, FaultInjectionConfig{Rate: 0.5})
+	tr.rand = func() float64 { return 0.5 }
+	gtfsTransport = tr
+	if err := fetch(); err != nil {
+		t.Errorf("expected requests above the rate to pass through, got %v", err)
+	}
+}
+
+func TestFaultInjectionConfig_Validate(t *testing.T) {
+	if err := (FaultInjectionConfig{Rate: 0.2, Faults: []string{"error"}}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (FaultInjectionConfig{Rate: 1.5}).validate(); err == nil {
+		t.Error("expected an error for a rate above 1")
+	}
+	if err := (FaultInjectionConfig{Rate: 0.2, Faults: []string{"fire"}}).validate(); err == nil {
+		t.Error("expected an error for an unknown fault")
+	}
+}
@@ -0,0 +1,336 @@
+//go:build ignore
+
+// fontgen rasterises the printable ASCII glyphs of a monospaced TrueType font
+// into the glyph atlases /board.png draws text with:
+//
+//	go run fontgen.go -ttf /usr/share/fonts/truetype/dejavu/DejaVuSansMono-Bold.ttf
+//
+// Each atlas is a grey PNG of 16 by 6 cells, one per character from ' ' to
+// '~', with the glyph's coverage as its brightness.
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+type point struct{ x, y float64 }
+
+type font struct {
+	data       []byte
+	tables     map[string][]byte
+	unitsPerEm float64
+	ascent     float64
+	descent    float64
+	longLoca   bool
+}
+
+func main() {
+	ttf := flag.String("ttf", "/usr/share/fonts/truetype/dejavu/DejaVuSansMono-Bold.ttf", "monospaced TrueType font")
+	out := flag.String("out", "fonts", "output directory")
+	flag.Parse()
+
+	data, err := os.ReadFile(*ttf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := parse(data)
+	for _, px := range []int{16, 24, 40} {
+		atlas := f.atlas(float64(px))
+		path := filepath.Join(*out, fmt.Sprintf("mono-%d.png", px))
+		w, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := png.Encode(w, atlas); err != nil {
+			log.Fatal(err)
+		}
+		w.Close()
+		log.Printf("wrote %s (%dx%d)", path, atlas.Bounds().Dx(), atlas.Bounds().Dy())
+	}
+}
+
+func u16(b []byte, off int) int { return int(binary.BigEndian.Uint16(b[off:])) }
+func i16(b []byte, off int) int { return int(int16(binary.BigEndian.Uint16(b[off:]))) }
+
+func parse(data []byte) *font {
+	f := &font{data: data, tables: make(map[string][]byte)}
+	for i := 0; i < u16(data, 4); i++ {
+		rec := data[12+16*i:]
+		off, n := binary.BigEndian.Uint32(rec[8:]), binary.BigEndian.Uint32(rec[12:])
+		f.tables[string(rec[:4])] = data[off : off+n]
+	}
+	head, hhea := f.tables["head"], f.tables["hhea"]
+	f.unitsPerEm = float64(u16(head, 18))
+	f.longLoca = i16(head, 50) == 1
+	f.ascent, f.descent = float64(i16(hhea, 4)), float64(i16(hhea, 6))
+	return f
+}
+
+// glyphIndex looks r up in the font's format 4 (BMP) cmap.
+func (f *font) glyphIndex(r rune) int {
+	cmap := f.tables["cmap"]
+	for i := 0; i < u16(cmap, 2); i++ {
+		rec := cmap[4+8*i:]
+		if u16(rec, 0) != 3 || u16(rec, 2) != 1 {
+			continue
+		}
+		sub := cmap[binary.BigEndian.Uint32(rec[4:]):]
+		segs := u16(sub, 6) / 2
+		ends, starts := 14, 16+2*segs
+		deltas, ranges := starts+2*segs, starts+4*segs
+		for s := 0; s < segs; s++ {
+			if int(r) > u16(sub, ends+2*s) || int(r) < u16(sub, starts+2*s) {
+				continue
+			}
+			ro := u16(sub, ranges+2*s)
+			if ro == 0 {
+				return (int(r) + i16(sub, deltas+2*s)) & 0xffff
+			}
+			g := u16(sub, ranges+2*s+ro+2*(int(r)-u16(sub, starts+2*s)))
+			if g == 0 {
+				return 0
+			}
+			return (g + i16(sub, deltas+2*s)) & 0xffff
+		}
+	}
+	return 0
+}
+
+func (f *font) advance(g int) float64 {
+	hmtx, n := f.tables["hmtx"], u16(f.tables["hhea"], 34)
+	return float64(u16(hmtx, 4*min(g, n-1)))
+}
+
+// contours returns glyph g's outline as closed polygons, its quadratic curves
+// flattened.
+func (f *font) contours(g int) [][]point {
+	loca := f.tables["loca"]
+	var start, end int
+	if f.longLoca {
+		start, end = int(binary.BigEndian.Uint32(loca[4*g:])), int(binary.BigEndian.Uint32(loca[4*g+4:]))
+	} else {
+		start, end = 2*u16(loca, 2*g), 2*u16(loca, 2*g+2)
+	}
+	if start == end {
+		return nil
+	}
+	b := f.tables["glyf"][start:end]
+	n := i16(b, 0)
+	if n < 0 {
+		return f.composite(b[10:])
+	}
+
+	endPts := make([]int, n)
+	for i := range endPts {
+		endPts[i] = u16(b, 10+2*i)
+	}
+	count := endPts[n-1] + 1
+	p := 10 + 2*n
+	p += 2 + u16(b, p)
+	flags := make([]byte, 0, count)
+	for len(flags) < count {
+		fl := b[p]
+		p++
+		flags = append(flags, fl)
+		if fl&8 != 0 {
+			for r := b[p]; r > 0; r-- {
+				flags = append(flags, fl)
+			}
+			p++
+		}
+	}
+	coords := func(short, same byte) []float64 {
+		vs := make([]float64, count)
+		v := 0
+		for i, fl := range flags {
+			switch {
+			case fl&short != 0:
+				d := int(b[p])
+				p++
+				if fl&same == 0 {
+					d = -d
+				}
+				v += d
+			case fl&same == 0:
+				v += i16(b, p)
+				p += 2
+			}
+			vs[i] = float64(v)
+		}
+		return vs
+	}
+	xs := coords(2, 16)
+	ys := coords(4, 32)
+
+	var out [][]point
+	first := 0
+	for _, last := range endPts {
+		var pts []point
+		var on []bool
+		for i := first; i <= last; i++ {
+			pts = append(pts, point{xs[i], ys[i]})
+			on = append(on, flags[i]&1 != 0)
+		}
+		out = append(out, flatten(pts, on))
+		first = last + 1
+	}
+	return out
+}
+
+func (f *font) composite(b []byte) [][]point {
+	var out [][]point
+	for {
+		flags, g := u16(b, 0), u16(b, 2)
+		var dx, dy float64
+		if flags&1 != 0 {
+			dx, dy = float64(i16(b, 4)), float64(i16(b, 6))
+			b = b[8:]
+		} else {
+			dx, dy = float64(int8(b[4])), float64(int8(b[5]))
+			b = b[6:]
+		}
+		switch {
+		case flags&8 != 0:
+			b = b[2:]
+		case flags&0x40 != 0:
+			b = b[4:]
+		case flags&0x80 != 0:
+			b = b[8:]
+		}
+		for _, c := range f.contours(g) {
+			moved := make([]point, len(c))
+			for i, pt := range c {
+				moved[i] = point{pt.x + dx, pt.y + dy}
+			}
+			out = append(out, moved)
+		}
+		if flags&0x20 == 0 {
+			return out
+		}
+	}
+}
+
+// flatten turns a TrueType contour (on- and off-curve points) into a polygon.
+func flatten(pts []point, on []bool) []point {
+	n := len(pts)
+	startAt := -1
+	for i := range pts {
+		if on[i] {
+			startAt = i
+			break
+		}
+	}
+	var start point
+	if startAt < 0 {
+		start = mid(pts[0], pts[1])
+		startAt = 0
+	} else {
+		start = pts[startAt]
+		startAt++
+	}
+	poly := []point{start}
+	cur := start
+	var ctrl *point
+	for k := 0; k < n; k++ {
+		i := (startAt + k) % n
+		pt := pts[i]
+		if on[i] {
+			if ctrl != nil {
+				poly = append(poly, quad(cur, *ctrl, pt)...)
+				ctrl = nil
+			} else {
+				poly = append(poly, pt)
+			}
+			cur = pt
+			continue
+		}
+		if ctrl != nil {
+			m := mid(*ctrl, pt)
+			poly = append(poly, quad(cur, *ctrl, m)...)
+			cur = m
+		}
+		c := pt
+		ctrl = &c
+	}
+	if ctrl != nil {
+		poly = append(poly, quad(cur, *ctrl, start)...)
+	}
+	return poly
+}
+
+func mid(a, b point) point { return point{(a.x + b.x) / 2, (a.y + b.y) / 2} }
+
+func quad(a, c, b point) []point {
+	const steps = 8
+	out := make([]point, 0, steps)
+	for s := 1; s <= steps; s++ {
+		t := float64(s) / steps
+		u := 1 - t
+		out = append(out, point{u*u*a.x + 2*u*t*c.x + t*t*b.x, u*u*a.y + 2*u*t*c.y + t*t*b.y})
+	}
+	return out
+}
+
+// winding is the nonzero winding number of the polygons around p.
+func winding(polys [][]point, p point) int {
+	w := 0
+	for _, poly := range polys {
+		for i := range poly {
+			a, b := poly[i], poly[(i+1)%len(poly)]
+			if a.y <= p.y {
+				if b.y > p.y && cross(a, b, p) > 0 {
+					w++
+				}
+			} else if b.y <= p.y && cross(a, b, p) < 0 {
+				w--
+			}
+		}
+	}
+	return w
+}
+
+func cross(a, b, p point) float64 {
+	return (b.x-a.x)*(p.y-a.y) - (p.x-a.x)*(b.y-a.y)
+}
+
+// atlas renders ' ' to '~' at px pixels per em, 4x4 supersampled.
+func (f *font) atlas(px float64) *image.Gray {
+	const ss = 4
+	scale := px / f.unitsPerEm
+	cellW := int(math.Round(f.advance(f.glyphIndex('M')) * scale))
+	ascent := math.Ceil(f.ascent * scale)
+	cellH := int(ascent + math.Ceil(-f.descent*scale))
+	img := image.NewGray(image.Rect(0, 0, 16*cellW, 6*cellH))
+	for r := ' '; r <= '~'; r++ {
+		polys := f.contours(f.glyphIndex(r))
+		i := int(r - ' ')
+		ox, oy := (i%16)*cellW, (i/16)*cellH
+		for y := 0; y < cellH; y++ {
+			for x := 0; x < cellW; x++ {
+				hits := 0
+				for sy := 0; sy < ss; sy++ {
+					for sx := 0; sx < ss; sx++ {
+						p := point{
+							(float64(x) + (float64(sx)+0.5)/ss) / scale,
+							(ascent - float64(y) - (float64(sy)+0.5)/ss) / scale,
+						}
+						if winding(polys, p) != 0 {
+							hits++
+						}
+					}
+				}
+				img.SetGray(ox+x, oy+y, color.Gray{uint8(hits * 255 / (ss * ss))})
+			}
+		}
+	}
+	return img
+}
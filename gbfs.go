@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bikeStatusTTL is how long GBFS station status is reused, and how long a
+// failed refresh waits before the next. Feeds typically publish a ttl of 60
+// seconds or less.
+const bikeStatusTTL = time.Minute
+
+// bikeStatusMaxAge is the oldest station status shown while the feed is
+// failing.
+const bikeStatusMaxAge = 10 * time.Minute
+
+type BikeShareConfig struct {
+	FeedURL string `yaml:"feed_url"`
+}
+
+type TripBikeShare struct {
+	Origin       []string `yaml:"origin,omitempty"`
+	Destination  []string `yaml:"destination,omitempty"`
+	CycleMinutes int      `yaml:"cycle_minutes,omitempty"`
+}
+
+type BikeStationView struct {
+	Name        string `json:"name"`
+	Destination bool   `json:"destination,omitempty"`
+	Bikes       int    `json:"bikes"`
+	Docks       int    `json:"docks"`
+}
+
+type gbfsStation struct {
+	name         string
+	bikes, docks int
+	renting      bool
+	returning    bool
+}
+
+// bikeShare reads station names and availability from a GBFS feed (v2 or v3
+// auto-discovery document), caching the status for bikeStatusTTL. Stale
+// status is refreshed in the background, so page renders don't wait on the
+// feed.
+type bikeShare struct {
+	feedURL string
+	client  *http.Client
+
+	// The feed list and station names, only touched by fetch.
+	feeds map[string]string
+	names map[string]string
+
+	mu        sync.Mutex
+	stations  map[string]gbfsStation
+	fetchedAt time.Time
+	// checkedAt and err are of the last refresh, successful or not.
+	checkedAt time.Time
+	err       error
+	// refreshing is closed when the refresh under way finishes.
+	refreshing chan struct{}
+}
+
+func newBikeShare(cfg BikeShareConfig) *bikeShare {
+	return &bikeShare{feedURL: cfg.FeedURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (b *bikeShare) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GBFS %s returned status %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s: %w", u, err)
+	}
+	return nil
+}
+
+// discover reads the auto-discovery document. GBFS v2 nests the feed list
+// under a language code; v3 lists it directly.
+func (b *bikeShare) discover(ctx context.Context) (map[string]string, error) {
+	type feedList struct {
+		Feeds []struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"feeds"`
+	}
+	var doc struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := b.getJSON(ctx, b.feedURL, &doc); err != nil {
+		return nil, err
+	}
+
+	var lists []feedList
+	var v3 feedList
+	if err := json.Unmarshal(doc.Data, &v3); err == nil && len(v3.Feeds) > 0 {
+		lists = append(lists, v3)
+	} else {
+		var v2 map[string]feedList
+		if err := json.Unmarshal(doc.Data, &v2); err != nil {
+			return nil, fmt.Errorf("decoding GBFS discovery: %w", err)
+		}
+		if en, ok := v2["en"]; ok {
+			lists = append(lists, en)
+		}
+		for _, l := range v2 {
+			lists = append(lists, l)
+		}
+	}
+
+	feeds := make(map[string]string)
+	for _, l := range lists {
+		for _, f := range l.Feeds {
+			if _, ok := feeds[f.Name]; !ok {
+				feeds[f.Name] = f.URL
+			}
+		}
+	}
+	if feeds["station_information"] == "" || feeds["station_status"] == "" {
+		return nil, fmt.Errorf("GBFS feed lists no station_information/station_status")
+	}
+	return feeds, nil
+}
+
+// status returns every station's availability. Only the first call waits
+// for the feed; after that the last status is returned at once, and a
+// refresh is started in the background when it is stale.
+func (b *bikeShare) status(ctx context.Context) (map[string]gbfsStation, error) {
+	b.mu.Lock()
+	if b.refreshing == nil && time.Since(b.checkedAt) >= bikeStatusTTL {
+		b.refreshing = make(chan struct{})
+		go b.refresh(b.refreshing)
+	}
+	done, first := b.refreshing, b.checkedAt.IsZero()
+	b.mu.Unlock()
+
+	if first {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stations == nil || time.Since(b.fetchedAt) > bikeStatusMaxAge {
+		return nil, b.err
+	}
+	return b.stations, nil
+}
+
+// refresh fetches the status and records the outcome; a failure is kept
+// too, so the feed isn't tried again within bikeStatusTTL.
+func (b *bikeShare) refresh(done chan struct{}) {
+	stations, err := b.fetch(context.Background())
+	b.mu.Lock()
+	b.checkedAt, b.err = time.Now(), err
+	if err == nil {
+		b.stations, b.fetchedAt = stations, b.checkedAt
+	}
+	b.refreshing = nil
+	b.mu.Unlock()
+	close(done)
+}
+
+func (b *bikeShare) fetch(ctx context.Context) (map[string]gbfsStation, error) {
+	if b.feeds == nil {
+		feeds, err := b.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.feeds = feeds
+	}
+
+	if b.names == nil {
+		var info struct {
+			Data struct {
+				Stations []struct {
+					StationID string          `json:"station_id"`
+					Name      json.RawMessage `json:"name"`
+				} `json:"stations"`
+			} `json:"data"`
+		}
+		if err := b.getJSON(ctx, b.feeds["station_information"], &info); err != nil {
+			return nil, err
+		}
+		b.names = make(map[string]string)
+		for _, s := range info.Data.Stations {
+			b.names[s.StationID] = gbfsText(s.Name)
+		}
+	}
+
+	var status struct {
+		Data struct {
+			Stations []struct {
+				StationID         string `json:"station_id"`
+				NumBikesAvailable int    `json:"num_bikes_available"`
+				NumDocksAvailable int    `json:"num_docks_available"`
+				IsRenting         bool   `json:"is_renting"`
+				IsReturning       bool   `json:"is_returning"`
+			} `json:"stations"`
+		} `json:"data"`
+	}
+	if err := b.getJSON(ctx, b.feeds["station_status"], &status); err != nil {
+		return nil, err
+	}
+	stations := make(map[string]gbfsStation)
+	for _, s := range status.Data.Stations {
+		stations[s.StationID] = gbfsStation{
+			name:      b.names[s.StationID],
+			bikes:     s.NumBikesAvailable,
+			docks:     s.NumDocksAvailable,
+			renting:   s.IsRenting,
+			returning: s.IsReturning,
+		}
+	}
+	return stations, nil
+}
+
+// gbfsText reads a station name, which is a plain string in v2 and a list of
+// localised strings in v3.
+func gbfsText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var localised []struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &localised) == nil && len(localised) > 0 {
+		return localised[0].Text
+	}
+	return ""
+}
+
+// tripBikes lists availability at a trip's configured stations and, when the
+// trip sets cycle_minutes, the arrival time of cycling now if that beats every
+// public-transport option and a bike and a dock are both available.
+func (b *bikeShare) tripBikes(ctx context.Context, cfg TripBikeShare, deps []DepartureView, now time.Time) ([]BikeStationView, string) {
+	stations, err := b.status(ctx)
+	if err != nil {
+		log.Printf("bike share: %v", err)
+		return nil, ""
+	}
+
+	var views []BikeStationView
+	bikes, docks := 0, 0
+	for _, id := range cfg.Origin {
+		if s, ok := stations[id]; ok && s.renting {
+			views = append(views, BikeStationView{Name: s.name, Bikes: s.bikes, Docks: s.docks})
+			bikes += s.bikes
+		}
+	}
+	for _, id := range cfg.Destination {
+		if s, ok := stations[id]; ok && s.returning {
+			views = append(views, BikeStationView{Name: s.name, Destination: true, Bikes: s.bikes, Docks: s.docks})
+			docks += s.docks
+		}
+	}
+
+	if cfg.CycleMinutes <= 0 || bikes == 0 || docks == 0 {
+		return views, ""
+	}
+	arrive := now.Add(time.Duration(cfg.CycleMinutes) * time.Minute)
+	for _, dv := range deps {
+		if dv.HasConnection && !dv.finalArrivalSort.After(arrive) {
+			return views, ""
+		}
+	}
+	return views, displayLocale.Clock(arrive.In(now.Location()))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newMockGBFS(t *testing.T, version string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var statusCalls atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gbfs.json":
+			feeds := fmt.Sprintf(`{"feeds":[{"name":"station_information","url":"%[1]s/info"},{"name":"station_status","url":"%[1]s/status"}]}`, srv.URL)
+			if version == "2" {
+				fmt.Fprintf(w, `{"data":{"en":%s}}`, feeds)
+			} else {
+				fmt.Fprintf(w, `{"data":%s}`, feeds)
+			}
+		case "/info":
+			if version == "2" {
+				fmt.Fprint(w, `{"data":{"stations":[{"station_id":"a","name":"Home St"},{"station_id":"b","name":"Office Sq"}]}}`)
+			} else {
+				fmt.Fprint(w, `{"data":{"stations":[{"station_id":"a","name":[{"text":"Home St","language":"en"}]},{"station_id":"b","name":[{"text":"Office Sq","language":"en"}]}]}}`)
+			}
+		case "/status":
+			statusCalls.Add(1)
+			fmt.Fprint(w, `{"data":{"stations":[
+				{"station_id":"a","num_bikes_available":4,"num_docks_available":6,"is_renting":true,"is_returning":true},
+				{"station_id":"b","num_bikes_available":9,"num_docks_available":3,"is_renting":true,"is_returning":true}]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv, &statusCalls
+}
+
+func TestBikeShare_Status(t *testing.T) {
+	for _, version := range []string{"2", "3"} {
+		srv, calls := newMockGBFS(t, version)
+		b := newBikeShare(BikeShareConfig{FeedURL: srv.URL + "/gbfs.json"})
+
+		stations, err := b.status(context.Background())
+		if err != nil {
+			t.Fatalf("v%s: unexpected error: %v", version, err)
+		}
+		if s := stations["a"]; s.name != "Home St" || s.bikes != 4 || s.docks != 6 {
+			t.Errorf("v%s: unexpected station a: %+v", version, s)
+		}
+
+		b.status(context.Background())
+		if calls.Load() != 1 {
+			t.Errorf("v%s: expected status cached within its TTL, got %d calls", version, calls.Load())
+		}
+		srv.Close()
+	}
+}
+
+func TestBikeShare_StatusFailing(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	b := newBikeShare(BikeShareConfig{FeedURL: srv.URL + "/gbfs.json"})
+	if _, err := b.status(context.Background()); err == nil {
+		t.Fatal("expected the failing feed's error")
+	}
+	if _, err := b.status(context.Background()); err == nil || calls.Load() != 1 {
+		t.Errorf("expected the failure cached within its TTL, got %v after %d calls", err, calls.Load())
+	}
+
+	// With a status to show, a stale one is served while the feed hangs
+	b.mu.Lock()
+	b.feedURL = srv.URL + "/slow"
+	b.stations = map[string]gbfsStation{"a": {name: "Home St", bikes: 2}}
+	b.fetchedAt = time.Now().Add(-2 * bikeStatusTTL)
+	b.checkedAt = b.fetchedAt
+	b.mu.Unlock()
+	start := time.Now()
+	stations, err := b.status(context.Background())
+	if err != nil || stations["a"].bikes != 2 {
+		t.Errorf("expected the last status, got %v, %v", stations, err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected the render not to wait on the feed, waited %v", waited)
+	}
+}
+
+func TestBikeShare_TripBikes(t *testing.T) {
+	srv, _ := newMockGBFS(t, "2")
+	defer srv.Close()
+	b := newBikeShare(BikeShareConfig{FeedURL: srv.URL + "/gbfs.json"})
+
+	now := time.Date(2024, 6, 3, 8, 0, 0, 0, boardTZ)
+	cfg := TripBikeShare{Origin: []string{"a"}, Destination: []string{"b"}, CycleMinutes: 20}
+
+	slowBus := []DepartureView{{HasConnection: true, finalArrivalSort: now.Add(35 * time.Minute)}}
+	views, cycle := b.tripBikes(context.Background(), cfg, slowBus, now)
+	if len(views) != 2 || views[0].Bikes != 4 || !views[1].Destination || views[1].Docks != 3 {
+		t.Errorf("unexpected station views: %+v", views)
+	}
+	if cycle != "08:20" {
+		t.Errorf("expected cycling to beat the bus, got %q", cycle)
+	}
+
+	fastTrain := []DepartureView{{HasConnection: true, finalArrivalSort: now.Add(15 * time.Minute)}}
+	if _, cycle := b.tripBikes(context.Background(), cfg, fastTrain, now); cycle != "" {
+		t.Errorf("expected no cycling suggestion when transit is faster, got %q", cycle)
+	}
+
+	noDocks := TripBikeShare{Origin: []string{"a"}, Destination: []string{"missing"}, CycleMinutes: 20}
+	if _, cycle := b.tripBikes(context.Background(), noDocks, nil, now); cycle != "" {
+		t.Errorf("expected no cycling suggestion without a destination dock, got %q", cycle)
+	}
+}
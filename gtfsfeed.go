@@ -0,0 +1,705 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GTFSConfig has the board read a GTFS feed itself rather than ask a GTFS
+// departure service: the static schedule from a zip and, optionally, delays
+// and cancellations from a GTFS-realtime TripUpdates feed.
+type GTFSConfig struct {
+	// Static is the path or http(s) URL of the GTFS zip.
+	Static         string `yaml:"static"`
+	TripUpdatesURL string `yaml:"trip_updates_url,omitempty"`
+	// APIKey is sent as "Authorization: apikey <key>" with requests for
+	// both, as TfNSW expects. $VAR is replaced from the environment.
+	APIKey string `yaml:"api_key,omitempty"`
+	// ReloadHours is how often the static feed is reloaded; 24 by default.
+	ReloadHours int `yaml:"reload_hours,omitempty"`
+}
+
+func (c GTFSConfig) validate() error {
+	if c.Static == "" {
+		if c.TripUpdatesURL != "" {
+			return errors.New("trip_updates_url needs static")
+		}
+		return nil
+	}
+	if c.ReloadHours < 0 {
+		return errors.New("reload_hours must be positive")
+	}
+	if _, err := expandEnv(c.APIKey); err != nil {
+		return fmt.Errorf("api_key: %w", err)
+	}
+	return nil
+}
+
+const (
+	defaultGTFSReloadHours = 24
+	// tripUpdatesTTL is how long a fetched TripUpdates feed is used for.
+	tripUpdatesTTL = 30 * time.Second
+	// maxGTFSDelay is how late a service scheduled before the window can
+	// run and still be looked for in it.
+	maxGTFSDelay = time.Hour
+)
+
+// localGTFSURL stands in for the upstream's URL when the board reads a GTFS
+// feed itself, so stop queries carry it like any other upstream; sources
+// maps it to the feed.
+const localGTFSURL = "gtfs:local"
+
+type gtfsFeed struct {
+	cfg      GTFSConfig
+	client   *http.Client
+	schedule atomic.Pointer[gtfsSchedule]
+
+	mu        sync.Mutex
+	updates   map[string]tripUpdate
+	updatedAt time.Time
+}
+
+func loadGTFSFeed(ctx context.Context, cfg GTFSConfig) (*gtfsFeed, error) {
+	// Validated by parseConfig
+	cfg.APIKey, _ = expandEnv(cfg.APIKey)
+	f := &gtfsFeed{cfg: cfg, client: &http.Client{Timeout: 5 * time.Minute}}
+	if err := f.reload(ctx); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *gtfsFeed) reload(ctx context.Context) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(f.cfg.Static, "http://") || strings.HasPrefix(f.cfg.Static, "https://") {
+		data, err = f.get(ctx, f.cfg.Static, "application/zip")
+	} else {
+		data, err = os.ReadFile(f.cfg.Static)
+	}
+	if err != nil {
+		return err
+	}
+	s, err := parseGTFSSchedule(data)
+	if err != nil {
+		return err
+	}
+	f.schedule.Store(s)
+	return nil
+}
+
+// run reloads the static feed every reload_hours until ctx is cancelled. A
+// feed that fails to load is logged and the old one kept.
+func (f *gtfsFeed) run(ctx context.Context) {
+	hours := f.cfg.ReloadHours
+	if hours == 0 {
+		hours = defaultGTFSReloadHours
+	}
+	ticker := time.NewTicker(time.Duration(hours) * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.reload(ctx); err != nil {
+				log.Printf("reloading GTFS feed: %v", err)
+			}
+		}
+	}
+}
+
+func (f *gtfsFeed) get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if f.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "apikey "+f.cfg.APIKey)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// tripUpdates returns the TripUpdates feed's updates by trip ID, fetching it
+// at most every tripUpdatesTTL. Without a feed, or when it fails to load,
+// the schedule is used as is.
+func (f *gtfsFeed) tripUpdates(ctx context.Context) map[string]tripUpdate {
+	if f.cfg.TripUpdatesURL == "" {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.updatedAt) < tripUpdatesTTL {
+		return f.updates
+	}
+	ctx, cancel := context.WithTimeout(ctx, gtfsClient.Timeout)
+	defer cancel()
+	data, err := f.get(ctx, f.cfg.TripUpdatesURL, "application/x-protobuf")
+	if err == nil {
+		f.updates, err = parseGTFSRTTripUpdates(data)
+	}
+	if err != nil {
+		log.Printf("GTFS-realtime trip updates: %v", err)
+		f.updates = nil
+	}
+	f.updatedAt = time.Now()
+	return f.updates
+}
+
+// Departures answers a departures query the way the GTFS departure service
+// does: the next hour of departures, or the next minutes when that is set.
+func (f *gtfsFeed) Departures(ctx context.Context, stopID, arrivalStops string, minutes int) ([]Departure, error) {
+	if minutes <= 0 {
+		minutes = departureWindowMinutes
+	}
+	now := time.Now()
+	return f.between(ctx, stopID, arrivalStops, now, now.Add(time.Duration(minutes)*time.Minute))
+}
+
+// between lists the services leaving stopID, or one of its platforms,
+// between from and to, with their arrivals at arrivalStops (comma
+// separated), in departure order.
+func (f *gtfsFeed) between(ctx context.Context, stopID, arrivalStops string, from, to time.Time) ([]Departure, error) {
+	s := f.schedule.Load()
+	updates := f.tripUpdates(ctx)
+	var deps []Departure
+	// A service day's times run past 24:00, so yesterday's can still be
+	// departing.
+	day := from.In(s.loc).AddDate(0, 0, -1)
+	for ; !serviceDayStart(day).After(to); day = day.AddDate(0, 0, 1) {
+		base := serviceDayStart(day)
+		lo := int32(from.Add(-maxGTFSDelay).Sub(base).Seconds())
+		hi := int32(to.Sub(base).Seconds())
+		for _, d := range s.dayDepartures(day, stopID, arrivalStops, lo, hi, updates) {
+			if at := effectiveDeparture(d); !at.Before(from) && !at.After(to) {
+				deps = append(deps, d)
+			}
+		}
+	}
+	sortDepartures(deps)
+	return deps, nil
+}
+
+// DeparturesOn answers a departures query for every departure of one
+// service day (date as YYYY-MM-DD).
+func (f *gtfsFeed) DeparturesOn(ctx context.Context, stopID, arrivalStops, date string) ([]Departure, error) {
+	s := f.schedule.Load()
+	day, err := time.ParseInLocation(dateLayout, date, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	deps := s.dayDepartures(day, stopID, arrivalStops, -1<<31, 1<<31-1, f.tripUpdates(ctx))
+	sortDepartures(deps)
+	return deps, nil
+}
+
+func (f *gtfsFeed) Stop(ctx context.Context, stopID string) (*Stop, error) {
+	st, ok := f.schedule.Load().stops[stopID]
+	if !ok {
+		return nil, errStopNotFound
+	}
+	return &Stop{StopID: st.id, StopName: st.name, StopLat: st.lat, StopLon: st.lon}, nil
+}
+
+// maxStopResults caps the stops a search returns.
+const maxStopResults = 20
+
+// SearchStops finds the stations and stops whose names contain query,
+// ignoring case; platforms of a station are left out.
+func (f *gtfsFeed) SearchStops(ctx context.Context, query string) ([]Stop, error) {
+	query = strings.ToLower(query)
+	var stops []Stop
+	for _, st := range f.schedule.Load().stops {
+		if st.parent == "" && strings.Contains(strings.ToLower(st.name), query) {
+			stops = append(stops, Stop{StopID: st.id, StopName: st.name, StopLat: st.lat, StopLon: st.lon})
+		}
+	}
+	slices.SortFunc(stops, func(a, b Stop) int {
+		return strings.Compare(a.StopName+"\x00"+a.StopID, b.StopName+"\x00"+b.StopID)
+	})
+	return stops[:min(len(stops), maxStopResults)], nil
+}
+
+func sortDepartures(deps []Departure) {
+	sort.SliceStable(deps, func(i, j int) bool {
+		return effectiveDeparture(deps[i]).Before(effectiveDeparture(deps[j]))
+	})
+}
+
+// serviceDayStart is where a service day's times count from: noon less 12
+// hours, which is midnight except on days the clocks change.
+func serviceDayStart(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, day.Location()).Add(-12 * time.Hour)
+}
+
+type gtfsSchedule struct {
+	loc      *time.Location
+	stops    map[string]*gtfsStop
+	children map[string][]string
+	services map[string]*gtfsService
+	// visits are the boarding points at each stop, by departure time.
+	visits map[string][]gtfsVisit
+}
+
+type gtfsStop struct {
+	id, name, platform, parent string
+	lat, lon                   float64
+}
+
+type gtfsRoute struct {
+	shortName, longName, color, textColor string
+}
+
+type gtfsTrip struct {
+	id, serviceID, headsign string
+	route                   *gtfsRoute
+	times                   []gtfsStopTime
+}
+
+type gtfsStopTime struct {
+	stop *gtfsStop
+	seq  int
+	// arrive and depart are seconds from the start of the service day.
+	arrive, depart      int32
+	headsign            string
+	noPickup, noDropOff bool
+}
+
+type gtfsVisit struct {
+	trip   *gtfsTrip
+	i      int
+	depart int32
+}
+
+type gtfsService struct {
+	weekdays         [7]bool
+	start, end       string
+	added, cancelled map[string]bool
+}
+
+// runs reports whether the service runs on day.
+func (s *gtfsService) runs(day time.Time) bool {
+	date := day.Format("20060102")
+	switch {
+	case s.cancelled[date]:
+		return false
+	case s.added[date]:
+		return true
+	}
+	return s.weekdays[day.Weekday()] && date >= s.start && date <= s.end
+}
+
+// platforms returns stopID and, for a station, the stops within it.
+func (s *gtfsSchedule) platforms(stopID string) []string {
+	return append([]string{stopID}, s.children[stopID]...)
+}
+
+// dayDepartures lists the departures from stopID of trips running on the
+// service day day that are scheduled between lo and hi seconds into it.
+func (s *gtfsSchedule) dayDepartures(day time.Time, stopID, arrivalStops string, lo, hi int32, updates map[string]tripUpdate) []Departure {
+	arriveAt := make(map[string]string)
+	for _, id := range strings.Split(arrivalStops, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			for _, p := range s.platforms(id) {
+				arriveAt[p] = id
+			}
+		}
+	}
+	base := serviceDayStart(day)
+	date := day.Format("20060102")
+
+	var deps []Departure
+	for _, stop := range s.platforms(stopID) {
+		visits := s.visits[stop]
+		i := sort.Search(len(visits), func(i int) bool { return visits[i].depart >= lo })
+		for ; i < len(visits) && visits[i].depart <= hi; i++ {
+			v := visits[i]
+			svc := s.services[v.trip.serviceID]
+			if svc == nil || !svc.runs(day) {
+				continue
+			}
+			var u *tripUpdate
+			if tu, ok := updates[v.trip.id]; ok && (tu.startDate == "" || tu.startDate == date) {
+				u = &tu
+			}
+			if d, ok := tripDeparture(v, base, u, arriveAt); ok {
+				deps = append(deps, d)
+			}
+		}
+	}
+	return deps
+}
+
+// tripDeparture builds the Departure for a visit, with its arrivals at the
+// stops in arriveAt (reported under the stop IDs asked for) and any
+// realtime update applied. It reports false for a cancelled trip or a
+// skipped stop.
+func tripDeparture(v gtfsVisit, base time.Time, u *tripUpdate, arriveAt map[string]string) (Departure, bool) {
+	trip := v.trip
+	st := trip.times[v.i]
+	headsign := st.headsign
+	if headsign == "" {
+		headsign = trip.headsign
+	}
+	if headsign == "" {
+		headsign = trip.times[len(trip.times)-1].stop.name
+	}
+	d := Departure{
+		TripID:             trip.id,
+		ServiceID:          trip.serviceID,
+		RouteShortName:     trip.route.shortName,
+		RouteLongName:      trip.route.longName,
+		RouteColor:         trip.route.color,
+		RouteTextColor:     trip.route.textColor,
+		Headsign:           headsign,
+		ScheduledDeparture: base.Add(time.Duration(st.depart) * time.Second),
+		PlatformCode:       st.stop.platform,
+	}
+	if u != nil {
+		if u.cancelled {
+			return Departure{}, false
+		}
+		at, skipped, ok := u.at(trip, v.i, base, true)
+		if skipped {
+			return Departure{}, false
+		}
+		if ok {
+			delay := int(at.Sub(d.ScheduledDeparture).Seconds())
+			d.RealtimeDeparture, d.DelaySeconds = &at, &delay
+		}
+	}
+
+	seen := make(map[string]bool)
+	for j := v.i + 1; j < len(trip.times); j++ {
+		st := trip.times[j]
+		id, ok := arriveAt[st.stop.id]
+		if !ok || st.noDropOff || seen[id] {
+			continue
+		}
+		a := ArrivalDetail{
+			StopID:           id,
+			StopName:         st.stop.name,
+			ScheduledArrival: base.Add(time.Duration(st.arrive) * time.Second),
+			PlatformCode:     st.stop.platform,
+		}
+		if u != nil {
+			at, skipped, ok := u.at(trip, j, base, false)
+			if skipped {
+				continue
+			}
+			if ok {
+				a.RealtimeArrival = &at
+			}
+		}
+		seen[id] = true
+		d.Arrivals = append(d.Arrivals, a)
+	}
+	return d, true
+}
+
+// at returns when the trip's ith stop is now expected, departing or
+// arriving: from that stop's update, else with the delay at the last stop
+// before it that has one. ok is false when nothing is known, and skipped
+// true when the stop won't be served.
+func (u *tripUpdate) at(trip *gtfsTrip, i int, base time.Time, departing bool) (at time.Time, skipped, ok bool) {
+	scheduled := func(k int, departing bool) time.Time {
+		secs := trip.times[k].arrive
+		if departing {
+			secs = trip.times[k].depart
+		}
+		return base.Add(time.Duration(secs) * time.Second)
+	}
+
+	var last *stopTimeUpdate
+	lastPos := -1
+	for k := range u.stops {
+		su := &u.stops[k]
+		pos := su.position(trip)
+		if pos < 0 || pos > i || pos < lastPos || (pos < i && su.skipped) {
+			continue
+		}
+		last, lastPos = su, pos
+	}
+	if last == nil {
+		if u.delay != nil {
+			return scheduled(i, departing).Add(time.Duration(*u.delay) * time.Second), false, true
+		}
+		return time.Time{}, false, false
+	}
+	if last.noData {
+		return time.Time{}, false, false
+	}
+	if lastPos == i && last.skipped {
+		return time.Time{}, true, false
+	}
+
+	// The delay at the updated stop, departing where it can be (that's what
+	// carries on to later stops) unless this is the arrival there.
+	ev, evDeparting := last.departure, true
+	if (lastPos == i && !departing && last.arrival.set) || !ev.set {
+		ev, evDeparting = last.arrival, false
+	}
+	if !ev.set {
+		return time.Time{}, false, false
+	}
+	delay := time.Duration(ev.delay) * time.Second
+	if ev.time != 0 {
+		delay = time.Unix(ev.time, 0).Sub(scheduled(lastPos, evDeparting))
+	}
+	return scheduled(i, departing).Add(delay), false, true
+}
+
+// position finds the update's stop in the trip: by stop_sequence when it has
+// one, else by stop_id. It is -1 when the stop isn't on the trip.
+func (su *stopTimeUpdate) position(trip *gtfsTrip) int {
+	for k, st := range trip.times {
+		if (su.seq >= 0 && st.seq == su.seq) || (su.seq < 0 && st.stop.id == su.stopID) {
+			return k
+		}
+	}
+	return -1
+}
+
+// parseGTFSSchedule reads the tables of a GTFS zip the board needs: agency
+// (for its timezone), stops, routes, trips, stop_times and calendar and/or
+// calendar_dates.
+func parseGTFSSchedule(data []byte) (*gtfsSchedule, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	s := &gtfsSchedule{
+		loc:      boardTZ,
+		stops:    make(map[string]*gtfsStop),
+		children: make(map[string][]string),
+		services: make(map[string]*gtfsService),
+		visits:   make(map[string][]gtfsVisit),
+	}
+
+	err = readGTFSTable(z, "agency.txt", false, func(col func(string) string) error {
+		if tz := col("agency_timezone"); tz != "" && s.loc == boardTZ {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return err
+			}
+			s.loc = loc
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readGTFSTable(z, "stops.txt", true, func(col func(string) string) error {
+		st := &gtfsStop{id: col("stop_id"), name: col("stop_name"), platform: col("platform_code"), parent: col("parent_station")}
+		st.lat, _ = strconv.ParseFloat(col("stop_lat"), 64)
+		st.lon, _ = strconv.ParseFloat(col("stop_lon"), 64)
+		s.stops[st.id] = st
+		if st.parent != "" {
+			s.children[st.parent] = append(s.children[st.parent], st.id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make(map[string]*gtfsRoute)
+	err = readGTFSTable(z, "routes.txt", true, func(col func(string) string) error {
+		routes[col("route_id")] = &gtfsRoute{
+			shortName: col("route_short_name"),
+			longName:  col("route_long_name"),
+			color:     strings.ToUpper(col("route_color")),
+			textColor: strings.ToUpper(col("route_text_color")),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make(map[string]*gtfsTrip)
+	err = readGTFSTable(z, "trips.txt", true, func(col func(string) string) error {
+		route, ok := routes[col("route_id")]
+		if !ok {
+			return fmt.Errorf("unknown route_id %q", col("route_id"))
+		}
+		trips[col("trip_id")] = &gtfsTrip{id: col("trip_id"), serviceID: col("service_id"), headsign: col("trip_headsign"), route: route}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readGTFSTable(z, "stop_times.txt", true, func(col func(string) string) error {
+		trip, ok := trips[col("trip_id")]
+		if !ok {
+			return fmt.Errorf("unknown trip_id %q", col("trip_id"))
+		}
+		stop, ok := s.stops[col("stop_id")]
+		if !ok {
+			return fmt.Errorf("unknown stop_id %q", col("stop_id"))
+		}
+		arrive, depart := col("arrival_time"), col("departure_time")
+		if arrive == "" && depart == "" {
+			// Untimed stops (between timepoints) can't be boarded or
+			// arrived at with a time, so are left out.
+			return nil
+		}
+		if arrive == "" {
+			arrive = depart
+		} else if depart == "" {
+			depart = arrive
+		}
+		st := gtfsStopTime{stop: stop, headsign: col("stop_headsign"), noPickup: col("pickup_type") == "1", noDropOff: col("drop_off_type") == "1"}
+		var err error
+		if st.seq, err = strconv.Atoi(col("stop_sequence")); err != nil {
+			return fmt.Errorf("stop_sequence: %w", err)
+		}
+		if st.arrive, err = parseGTFSTime(arrive); err != nil {
+			return fmt.Errorf("arrival_time: %w", err)
+		}
+		if st.depart, err = parseGTFSTime(depart); err != nil {
+			return fmt.Errorf("departure_time: %w", err)
+		}
+		trip.times = append(trip.times, st)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readGTFSTable(z, "calendar.txt", false, func(col func(string) string) error {
+		svc := s.service(col("service_id"))
+		for i, day := range []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"} {
+			svc.weekdays[i] = col(day) == "1"
+		}
+		svc.start, svc.end = col("start_date"), col("end_date")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readGTFSTable(z, "calendar_dates.txt", false, func(col func(string) string) error {
+		svc := s.service(col("service_id"))
+		switch col("exception_type") {
+		case "1":
+			svc.added[col("date")] = true
+		case "2":
+			svc.cancelled[col("date")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.services) == 0 {
+		return nil, errors.New("needs calendar.txt or calendar_dates.txt")
+	}
+
+	for _, trip := range trips {
+		slices.SortFunc(trip.times, func(a, b gtfsStopTime) int { return a.seq - b.seq })
+		for i, st := range trip.times[:max(len(trip.times)-1, 0)] {
+			if !st.noPickup {
+				s.visits[st.stop.id] = append(s.visits[st.stop.id], gtfsVisit{trip, i, st.depart})
+			}
+		}
+	}
+	for _, visits := range s.visits {
+		slices.SortFunc(visits, func(a, b gtfsVisit) int { return int(a.depart - b.depart) })
+	}
+	return s, nil
+}
+
+func (s *gtfsSchedule) service(id string) *gtfsService {
+	svc, ok := s.services[id]
+	if !ok {
+		svc = &gtfsService{added: make(map[string]bool), cancelled: make(map[string]bool)}
+		s.services[id] = svc
+	}
+	return svc
+}
+
+// readGTFSTable calls fn with each row of a table in the zip, given as a
+// lookup by column name. A missing table is an error only when required.
+func readGTFSTable(z *zip.Reader, name string, required bool, fn func(col func(string) string) error) error {
+	f, err := z.Open(name)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))] = i
+	}
+	var rec []string
+	col := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	for line := 2; ; line++ {
+		rec, err = r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := fn(col); err != nil {
+			return fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+	}
+}
+
+// parseGTFSTime parses an H:MM:SS time, which runs past 24:00 for trips
+// after midnight, into seconds.
+func parseGTFSTime(s string) (int32, error) {
+	h, rest, ok1 := strings.Cut(s, ":")
+	m, sec, ok2 := strings.Cut(rest, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	ss, err3 := strconv.Atoi(sec)
+	if !ok1 || !ok2 || err1 != nil || err2 != nil || err3 != nil || hh < 0 || mm < 0 || mm > 59 || ss < 0 || ss > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return int32(hh*3600 + mm*60 + ss), nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testGTFSTables = map[string]string{
+	"agency.txt": `agency_id,agency_name,agency_url,agency_timezone
+a,Test Trains,http://example.com,Australia/Sydney`,
+	"stops.txt": "\ufeff" + `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,platform_code
+C1,Central,-33.88,151.21,1,,
+100,Central Platform 1,-33.88,151.21,0,C1,1
+200,Redfern,-33.89,151.2,0,,
+201,Redfern Platform 2,-33.89,151.2,0,,
+300,Airport,-33.94,151.18,0,,`,
+	"routes.txt": `route_id,route_short_name,route_long_name,route_type,route_color
+r1,T8,Airport Line,2,00954c`,
+	"trips.txt": `route_id,service_id,trip_id,trip_headsign
+r1,wk,t1,Airport
+r1,wk,t2,
+r1,wk,late,Airport
+r1,hol,h1,Airport`,
+	"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type
+t1,08:00:00,08:00:00,100,1,0,0
+t1,08:05:00,08:05:00,200,2,0,0
+t1,08:20:00,08:20:00,300,3,0,0
+t2,08:30:00,08:30:00,100,1,,
+t2,08:35:00,08:35:00,200,2,,
+t2,,,201,3,,
+t2,08:50:00,08:50:00,300,4,,
+late,24:10:00,24:10:00,100,1,,
+late,24:30:00,24:30:00,300,2,,
+h1,08:10:00,08:10:00,100,1,,
+h1,08:30:00,08:30:00,300,2,,`,
+	"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+wk,1,1,1,1,1,1,1,20260101,20261231`,
+	"calendar_dates.txt": `service_id,date,exception_type
+hol,20260302,1
+wk,20260303,2`,
+}
+
+// writeTestGTFS writes tables as a GTFS zip and returns its path.
+func writeTestGTFS(t *testing.T, tables map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gtfs.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for name, content := range tables {
+		w, _ := z.Create(name)
+		w.Write([]byte(strings.ReplaceAll(content, "\n", "\r\n") + "\r\n"))
+	}
+	z.Close()
+	f.Close()
+	return path
+}
+
+func TestGTFSFeed_Departures(t *testing.T) {
+	f, err := loadGTFSFeed(context.Background(), GTFSConfig{Static: writeTestGTFS(t, testGTFSTables)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syd, _ := time.LoadLocation("Australia/Sydney")
+	at := func(day, h, m int) time.Time { return time.Date(2026, 3, day, h, m, 0, 0, syd) }
+
+	deps, err := f.between(context.Background(), "C1", "300", at(2, 7, 55), at(2, 9, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trips []string
+	for _, d := range deps {
+		trips = append(trips, d.TripID)
+	}
+	if strings.Join(trips, ",") != "t1,h1,t2" {
+		t.Fatalf("expected t1, h1 and t2 from Central's platform, got %v", trips)
+	}
+	t1 := deps[0]
+	if t1.RouteShortName != "T8" || t1.RouteColor != "00954C" || t1.Headsign != "Airport" || t1.PlatformCode != "1" ||
+		!t1.ScheduledDeparture.Equal(at(2, 8, 0)) || t1.RealtimeDeparture != nil {
+		t.Errorf("unexpected departure %+v", t1)
+	}
+	if len(t1.Arrivals) != 1 || t1.Arrivals[0].StopID != "300" || !t1.Arrivals[0].ScheduledArrival.Equal(at(2, 8, 20)) {
+		t.Errorf("expected the arrival at the airport, got %+v", t1.Arrivals)
+	}
+	if deps[2].Headsign != "Airport" {
+		t.Errorf("expected a trip without a headsign to show its last stop, got %q", deps[2].Headsign)
+	}
+
+	deps, _ = f.between(context.Background(), "100", "300", at(3, 0, 0), at(3, 0, 30))
+	if len(deps) != 1 || deps[0].TripID != "late" || !deps[0].ScheduledDeparture.Equal(at(3, 0, 10)) {
+		t.Errorf("expected the previous service day's 24:10, got %+v", deps)
+	}
+
+	deps, _ = f.DeparturesOn(context.Background(), "100", "300", "2026-03-03")
+	if len(deps) != 0 {
+		t.Errorf("expected nothing on a day the service is cancelled, got %+v", deps)
+	}
+	deps, _ = f.DeparturesOn(context.Background(), "100", "300", "2026-03-02")
+	if len(deps) != 4 {
+		t.Errorf("expected all four of the day's trips, got %d", len(deps))
+	}
+}
+
+func TestGTFSFeed_TripUpdates(t *testing.T) {
+	syd, _ := time.LoadLocation("Australia/Sydney")
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, syd) }
+	feed := append(pbBytes(1, pbBytes(1, []byte("2.0"))),
+		pbBytes(2, pbBytes(1, []byte("e1")), pbBytes(3,
+			pbBytes(1, pbBytes(1, []byte("t1")), pbBytes(3, []byte("20260302"))),
+			pbBytes(2, pbVarint(1, 1), pbBytes(3, pbVarint(1, 120))),
+			pbBytes(2, pbVarint(1, 2), pbVarint(5, 1)),
+		))...)
+	feed = append(feed, pbBytes(2, pbBytes(1, []byte("e2")), pbBytes(3, pbBytes(1, pbBytes(1, []byte("t2")), pbVarint(4, 3))))...)
+	feed = append(feed, pbBytes(2, pbBytes(1, []byte("e3")), pbBytes(3,
+		pbBytes(1, pbBytes(1, []byte("h1"))),
+		pbBytes(2, pbBytes(4, []byte("300")), pbBytes(2, pbVarint(2, uint64(at(8, 35).Unix())))),
+	))...)
+	early := int64(-60)
+	feed = append(feed, pbBytes(2, pbBytes(1, []byte("e4")), pbBytes(3, pbBytes(1, pbBytes(1, []byte("late"))), pbVarint(5, uint64(early))))...)
+
+	var fetches int
+	rt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("Authorization") != "apikey secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(feed)
+	}))
+	defer rt.Close()
+	f, err := loadGTFSFeed(context.Background(), GTFSConfig{Static: writeTestGTFS(t, testGTFSTables), TripUpdatesURL: rt.URL, APIKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps, _ := f.between(context.Background(), "100", "300", at(7, 55), at(9, 0))
+	if len(deps) != 2 {
+		t.Fatalf("expected t1 and h1 without the cancelled t2, got %+v", deps)
+	}
+	t1, h1 := deps[0], deps[1]
+	if t1.RealtimeDeparture == nil || !t1.RealtimeDeparture.Equal(at(8, 2)) || *t1.DelaySeconds != 120 {
+		t.Errorf("expected t1 two minutes late, got %+v", t1)
+	}
+	if a := t1.Arrivals[0]; a.RealtimeArrival == nil || !a.RealtimeArrival.Equal(at(8, 22)) {
+		t.Errorf("expected t1's delay carried on past the skipped stop to the airport, got %+v", a)
+	}
+	if h1.RealtimeDeparture != nil || h1.Arrivals[0].RealtimeArrival == nil || !h1.Arrivals[0].RealtimeArrival.Equal(at(8, 35)) {
+		t.Errorf("expected only h1's arrival predicted, got %+v", h1)
+	}
+
+	deps, _ = f.between(context.Background(), "200", "300", at(7, 55), at(8, 40))
+	if len(deps) != 0 {
+		t.Errorf("expected t1 to skip Redfern and t2 to be cancelled, got %+v", deps)
+	}
+	deps, _ = f.between(context.Background(), "100", "300", at(23, 0).Add(time.Hour), at(23, 0).Add(90*time.Minute))
+	if len(deps) != 1 || *deps[0].DelaySeconds != -60 {
+		t.Errorf("expected the late trip a minute early, got %+v", deps)
+	}
+	if fetches != 1 {
+		t.Errorf("expected the trip updates to be fetched once, got %d", fetches)
+	}
+}
+
+func TestGTFSFeed_Stops(t *testing.T) {
+	f, err := loadGTFSFeed(context.Background(), GTFSConfig{Static: writeTestGTFS(t, testGTFSTables)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources[localGTFSURL] = f
+	defer delete(sources, localGTFSURL)
+
+	stop, err := fetchStop(context.Background(), localGTFSURL, "100")
+	if err != nil || stop.StopName != "Central Platform 1" || stop.StopLat != -33.88 {
+		t.Errorf("unexpected stop %+v, %v", stop, err)
+	}
+	if _, err := fetchStop(context.Background(), localGTFSURL, "999"); !errors.Is(err, errStopNotFound) {
+		t.Errorf("expected an unknown stop, got %v", err)
+	}
+	stops, err := searchStops(context.Background(), localGTFSURL, "central")
+	if err != nil || len(stops) != 1 || stops[0].StopID != "C1" {
+		t.Errorf("expected the station without its platform, got %+v, %v", stops, err)
+	}
+}
+
+func TestParseGTFSSchedule_Invalid(t *testing.T) {
+	for name, change := range map[string][2]string{
+		"bad time":      {"stop_times.txt", strings.Replace(testGTFSTables["stop_times.txt"], "08:05:00,08:05:00", "8:5,8:5", 1)},
+		"unknown stop":  {"stop_times.txt", testGTFSTables["stop_times.txt"] + "\nh1,09:00:00,09:00:00,999,3,,"},
+		"unknown route": {"trips.txt", testGTFSTables["trips.txt"] + "\nr9,wk,x,Nowhere"},
+		"no calendar":   {"calendar.txt", ""},
+	} {
+		tables := maps.Clone(testGTFSTables)
+		if change[1] == "" {
+			delete(tables, change[0])
+			delete(tables, "calendar_dates.txt")
+		} else {
+			tables[change[0]] = change[1]
+		}
+		if _, err := loadGTFSFeed(context.Background(), GTFSConfig{Static: writeTestGTFS(t, tables)}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
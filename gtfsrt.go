@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GTFS-realtime is protobuf. Only the alert fields the board shows are read,
+// so rather than pull in a protobuf library the feed is walked field by field.
+//
+//	FeedMessage  2: entity
+//	FeedEntity   1: id, 3: is_deleted, 5: alert
+//	Alert        1: active_period, 5: informed_entity, 10: header_text,
+//	             11: description_text, 14: severity_level
+//	TimeRange    1: start, 2: end
+//	EntitySelector 2: route_id, 5: stop_id
+//	TranslatedString 1: translation (1: text, 2: language)
+
+// protoFields calls fn with each field of a protobuf message. v holds varint
+// and fixed-width values; data holds length-delimited ones (strings and
+// embedded messages) and is nil otherwise.
+func protoFields(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case 0:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("field %d: bad varint", field)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			data, b = b[n:n+int(size)], b[n+int(size):]
+		case 5:
+			if len(b) < 4 {
+				return fmt.Errorf("field %d: truncated", field)
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", field, key&7)
+		}
+		if err := fn(field, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseGTFSRTAlerts reads the alerts in a GTFS-realtime feed. An alert with
+// several active periods becomes one Alert per period, sharing its ID.
+func parseGTFSRTAlerts(feed []byte) ([]Alert, error) {
+	var alerts []Alert
+	err := protoFields(feed, func(field int, _ uint64, entity []byte) error {
+		if field != 2 || entity == nil {
+			return nil
+		}
+		var id string
+		var alert []byte
+		deleted := false
+		if err := protoFields(entity, func(field int, v uint64, data []byte) error {
+			switch field {
+			case 1:
+				id = string(data)
+			case 3:
+				deleted = v != 0
+			case 5:
+				alert = data
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("entity: %w", err)
+		}
+		if alert == nil || deleted {
+			return nil
+		}
+		parsed, err := parseGTFSRTAlert(id, alert)
+		if err != nil {
+			return fmt.Errorf("alert %q: %w", id, err)
+		}
+		alerts = append(alerts, parsed...)
+		return nil
+	})
+	return alerts, err
+}
+
+func parseGTFSRTAlert(id string, b []byte) ([]Alert, error) {
+	a := Alert{ID: id}
+	type period struct{ from, to uint64 }
+	var periods []period
+	err := protoFields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			var p period
+			if err := protoFields(data, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case 1:
+					p.from = v
+				case 2:
+					p.to = v
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			periods = append(periods, p)
+		case 5:
+			return protoFields(data, func(field int, _ uint64, data []byte) error {
+				switch {
+				case field == 2 && len(data) > 0:
+					a.Routes = append(a.Routes, string(data))
+				case field == 5 && len(data) > 0:
+					a.StopIDs = append(a.StopIDs, string(data))
+				}
+				return nil
+			})
+		case 10, 11:
+			text, err := translatedText(data)
+			if err != nil {
+				return err
+			}
+			if field == 10 {
+				a.Header = text
+			} else {
+				a.Description = text
+			}
+		case 14:
+			switch v {
+			case 2:
+				a.Severity = "info"
+			case 3:
+				a.Severity = "warning"
+			case 4:
+				a.Severity = "severe"
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(periods) == 0 {
+		return []Alert{a}, nil
+	}
+	alerts := make([]Alert, 0, len(periods))
+	for _, p := range periods {
+		pa := a
+		if p.from > 0 {
+			from := time.Unix(int64(p.from), 0)
+			pa.ActiveFrom = &from
+		}
+		if p.to > 0 {
+			to := time.Unix(int64(p.to), 0)
+			pa.ActiveTo = &to
+		}
+		alerts = append(alerts, pa)
+	}
+	return alerts, nil
+}
+
+// translatedText picks the English translation of a TranslatedString, or the
+// first one when there is none.
+func translatedText(b []byte) (string, error) {
+	var texts, langs []string
+	err := protoFields(b, func(field int, _ uint64, data []byte) error {
+		if field != 1 {
+			return nil
+		}
+		var text, lang string
+		if err := protoFields(data, func(field int, _ uint64, data []byte) error {
+			switch field {
+			case 1:
+				text = string(data)
+			case 2:
+				lang = string(data)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		texts, langs = append(texts, text), append(langs, lang)
+		return nil
+	})
+	if err != nil || len(texts) == 0 {
+		return "", err
+	}
+	for i, lang := range langs {
+		if lang == "en" || strings.HasPrefix(lang, "en-") {
+			return texts[i], nil
+		}
+	}
+	return texts[0], nil
+}
+
+// The TripUpdates the board reads, for gtfs.trip_updates_url:
+//
+//	FeedEntity     3: trip_update
+//	TripUpdate     1: trip, 2: stop_time_update, 5: delay
+//	TripDescriptor 1: trip_id, 3: start_date, 4: schedule_relationship
+//	StopTimeUpdate 1: stop_sequence, 2: arrival, 3: departure, 4: stop_id,
+//	               5: schedule_relationship
+//	StopTimeEvent  1: delay, 2: time
+
+type tripUpdate struct {
+	startDate string
+	cancelled bool
+	// delay is the whole trip's delay in seconds, for updates without
+	// stop time updates.
+	delay *int
+	stops []stopTimeUpdate
+}
+
+type stopTimeUpdate struct {
+	// seq is the stop_sequence, or -1 when the update gives a stop_id.
+	seq                int
+	stopID             string
+	arrival, departure stopTimeEvent
+	skipped, noData    bool
+}
+
+// stopTimeEvent is a predicted arrival or departure: time, in Unix seconds,
+// when given, else the delay in seconds.
+type stopTimeEvent struct {
+	set   bool
+	delay int
+	time  int64
+}
+
+// parseGTFSRTTripUpdates reads the trip updates in a GTFS-realtime feed, by
+// trip ID.
+func parseGTFSRTTripUpdates(feed []byte) (map[string]tripUpdate, error) {
+	updates := make(map[string]tripUpdate)
+	err := protoFields(feed, func(field int, _ uint64, entity []byte) error {
+		if field != 2 || entity == nil {
+			return nil
+		}
+		return protoFields(entity, func(field int, _ uint64, data []byte) error {
+			if field != 3 || data == nil {
+				return nil
+			}
+			tripID, u, err := parseTripUpdate(data)
+			if err != nil {
+				return fmt.Errorf("trip update: %w", err)
+			}
+			if tripID != "" {
+				updates[tripID] = u
+			}
+			return nil
+		})
+	})
+	return updates, err
+}
+
+func parseTripUpdate(b []byte) (string, tripUpdate, error) {
+	var tripID string
+	var u tripUpdate
+	err := protoFields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			return protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					tripID = string(data)
+				case 3:
+					u.startDate = string(data)
+				case 4:
+					u.cancelled = v == 3
+				}
+				return nil
+			})
+		case 2:
+			su := stopTimeUpdate{seq: -1}
+			if err := protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					su.seq = int(v)
+				case 2, 3:
+					ev, err := parseStopTimeEvent(data)
+					if field == 2 {
+						su.arrival = ev
+					} else {
+						su.departure = ev
+					}
+					return err
+				case 4:
+					su.stopID = string(data)
+				case 5:
+					su.skipped, su.noData = v == 1, v == 2
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			u.stops = append(u.stops, su)
+		case 5:
+			delay := int(int32(v))
+			u.delay = &delay
+		}
+		return nil
+	})
+	return tripID, u, err
+}
+
+func parseStopTimeEvent(b []byte) (stopTimeEvent, error) {
+	ev := stopTimeEvent{set: true}
+	err := protoFields(b, func(field int, v uint64, _ []byte) error {
+		switch field {
+		case 1:
+			ev.delay = int(int32(v))
+		case 2:
+			ev.time = int64(v)
+		}
+		return nil
+	})
+	return ev, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pbVarint and pbBytes encode a protobuf field, for building test feeds.
+func pbVarint(field int, v uint64) []byte {
+	b := binary.AppendUvarint(nil, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func pbBytes(field int, parts ...[]byte) []byte {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	b := binary.AppendUvarint(nil, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func pbText(field int, texts ...[2]string) []byte {
+	var translations [][]byte
+	for _, t := range texts {
+		translations = append(translations, pbBytes(1, pbBytes(1, []byte(t[0])), pbBytes(2, []byte(t[1]))))
+	}
+	return pbBytes(field, translations...)
+}
+
+func testAlertFeed(start, end time.Time) []byte {
+	trackwork := pbBytes(5,
+		pbBytes(1, pbVarint(1, uint64(start.Unix())), pbVarint(2, uint64(end.Unix()))),
+		pbBytes(1, pbVarint(1, uint64(end.Add(24*time.Hour).Unix()))),
+		pbBytes(5, pbBytes(2, []byte("T8"))),
+		pbBytes(5, pbBytes(5, []byte("200"))),
+		pbText(10, [2]string{"Trackwork", "en"}, [2]string{"Travaux", "fr"}),
+		pbText(11, [2]string{"Buses replace trains", ""}),
+		pbVarint(14, 4),
+	)
+	return append(append(pbBytes(1, pbBytes(1, []byte("2.0"))),
+		pbBytes(2, pbBytes(1, []byte("a1")), trackwork)...),
+		pbBytes(2, pbBytes(1, []byte("gone")), pbVarint(3, 1), pbBytes(5, pbText(10, [2]string{"Deleted", ""})))...)
+}
+
+func TestParseGTFSRTAlerts(t *testing.T) {
+	start := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	end := start.Add(2 * time.Hour)
+
+	alerts, err := parseGTFSRTAlerts(testAlertFeed(start, end))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected one alert per active period without the deleted one, got %+v", alerts)
+	}
+	a := alerts[0]
+	if a.ID != "a1" || a.Header != "Trackwork" || a.Description != "Buses replace trains" || a.Severity != "severe" {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	if len(a.Routes) != 1 || a.Routes[0] != "T8" || len(a.StopIDs) != 1 || a.StopIDs[0] != "200" {
+		t.Errorf("expected route T8 and stop 200, got %v and %v", a.Routes, a.StopIDs)
+	}
+	if !a.ActiveFrom.Equal(start) || !a.ActiveTo.Equal(end) {
+		t.Errorf("expected the first period, got %v to %v", a.ActiveFrom, a.ActiveTo)
+	}
+	if alerts[1].ActiveTo != nil {
+		t.Errorf("expected an open-ended second period, got %v", alerts[1].ActiveTo)
+	}
+
+	if _, err := parseGTFSRTAlerts([]byte{0x12, 0x05, 0x0a}); err == nil {
+		t.Error("expected an error for a truncated feed")
+	}
+}
+
+func TestServiceAlerts_GTFSRTFeed(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	var key string
+	requests := 0
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		key = r.Header.Get("Authorization")
+		w.Write(testAlertFeed(now.Add(-time.Hour), now.Add(time.Hour)))
+	}))
+	defer feed.Close()
+
+	alerts := newServiceAlerts(AlertsConfig{Enabled: true, GTFSRTURL: feed.URL, APIKey: "secret"})
+	work := TripConfig{Name: "Work", Routes: []RouteConfig{{DepartureStopID: "100", TransferArrivalStopID: "200", TransferDepartureStopID: "201", FinalArrivalStop: "300"}}}
+	beach := TripConfig{Name: "Beach", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "400"}}}
+
+	got := alerts.tripAlerts(context.Background(), "", work, nil, now, 60)
+	if len(got) != 1 || got[0] != (AlertView{Header: "Trackwork", Description: "Buses replace trains", Severity: "severe"}) {
+		t.Errorf("expected the trackwork alert once, got %+v", got)
+	}
+	if key != "apikey secret" {
+		t.Errorf("expected the API key to be sent, got %q", key)
+	}
+	if got := alerts.tripAlerts(context.Background(), "", beach, nil, now, 60); len(got) != 0 {
+		t.Errorf("expected no alerts for a trip away from stop 200 and the T8, got %+v", got)
+	}
+	beachT8 := []DepartureView{{RouteShortName: "333", Connections: []LegView{{RouteShortName: "T8"}}}}
+	if got := alerts.tripAlerts(context.Background(), "", beach, beachT8, now, 60); len(got) != 1 {
+		t.Errorf("expected the alert for a trip connecting to the T8, got %+v", got)
+	}
+	if requests != 1 {
+		t.Errorf("expected the feed to be fetched once and shared, got %d requests", requests)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHabitsFile    = "habits.jsonl"
+	defaultHabitsMinDays = 3
+
+	// habitLookbackDays is how far back sightings count towards a habit.
+	habitLookbackDays = 28
+
+	// habitSessionGap is how long a browser must be away from a trip before
+	// looking at it again counts as a new sighting. It stops an always-on
+	// display from marking every service as a habit.
+	habitSessionGap = 20 * time.Minute
+
+	// A habitual service is checked while it is between habitCheckFrom and
+	// habitCheckUntil away, and inside the trip's departure window.
+	habitCheckFrom  = 5 * time.Minute
+	habitCheckUntil = 45 * time.Minute
+)
+
+type HabitsConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	File         string `yaml:"file,omitempty"`
+	MinDays      int    `yaml:"min_days,omitempty"`
+	DelayMinutes int    `yaml:"delay_minutes,omitempty"`
+}
+
+func (c HabitsConfig) file() string {
+	if c.File != "" {
+		return c.File
+	}
+	return defaultHabitsFile
+}
+
+func (c HabitsConfig) minDays() int {
+	if c.MinDays > 0 {
+		return c.MinDays
+	}
+	return defaultHabitsMinDays
+}
+
+// habitDelayMinutes is the delay that triggers a habit alert, by default the
+// same as for web push delay alerts.
+func (c Config) habitDelayMinutes() int {
+	if c.Habits.DelayMinutes > 0 {
+		return c.Habits.DelayMinutes
+	}
+	return c.WebPush.delayMinutes()
+}
+
+// Sighting records the service a trip was about to catch when someone looked
+// at the trip on the board.
+type Sighting struct {
+	Date    string `json:"date"`
+	Trip    string `json:"trip"`
+	StopID  string `json:"stop_id"`
+	Route   string `json:"route"`
+	Time    string `json:"time"`
+	Weekend bool   `json:"weekend"`
+}
+
+// Habit is a service seen on at least min_days days of the lookback period.
+type Habit struct {
+	StopID string
+	Route  string
+	Time   string
+	Days   int
+}
+
+// habitStore learns which services are usually targeted from the trips
+// browsers report looking at, persisting sightings as JSON Lines.
+type habitStore struct {
+	path    string
+	minDays int
+
+	mu        sync.Mutex
+	sightings []Sighting
+	seen      map[Sighting]bool
+	sessions  map[string]time.Time
+}
+
+func loadHabits(cfg HabitsConfig) (*habitStore, error) {
+	h := &habitStore{
+		path:     cfg.file(),
+		minDays:  cfg.minDays(),
+		seen:     make(map[Sighting]bool),
+		sessions: make(map[string]time.Time),
+	}
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading habits: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var s Sighting
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			log.Printf("habits %s:%d: %v", h.path, line, err)
+			continue
+		}
+		h.add(s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading habits: %w", err)
+	}
+	return h, nil
+}
+
+func (h *habitStore) add(s Sighting) bool {
+	if h.seen[s] {
+		return false
+	}
+	h.seen[s] = true
+	h.sightings = append(h.sightings, s)
+	return true
+}
+
+func isWeekend(t time.Time) bool {
+	wd := t.Weekday()
+	return wd == time.Saturday || wd == time.Sunday
+}
+
+// sight records that client is looking at a trip, noting the first departure
+// with a connection, unless the same client already looked at it recently.
+func (h *habitStore) sight(client string, tv TripView, now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := client + "|" + tv.Name
+	last, ok := h.sessions[key]
+	h.sessions[key] = now
+	for k, at := range h.sessions {
+		if now.Sub(at) > habitSessionGap {
+			delete(h.sessions, k)
+		}
+	}
+	if ok && now.Sub(last) <= habitSessionGap {
+		return nil
+	}
+
+	var target *DepartureView
+	for i := range tv.Departures {
+		if tv.Departures[i].HasConnection {
+			target = &tv.Departures[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil
+	}
+	local := now.In(boardTZ)
+	s := Sighting{
+		Date:    local.Format("2006-01-02"),
+		Trip:    tv.Name,
+		StopID:  target.departureStopID,
+		Route:   target.RouteShortName,
+		Time:    target.scheduledAt.In(boardTZ).Format("15:04"),
+		Weekend: isWeekend(local),
+	}
+	if !h.add(s) {
+		return nil
+	}
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// habits returns the services of a trip seen on at least minDays days of the
+// lookback period, on the same kind of day (weekday or weekend) as now.
+func (h *habitStore) habits(trip string, now time.Time) []Habit {
+	local := now.In(boardTZ)
+	since := local.AddDate(0, 0, -habitLookbackDays).Format("2006-01-02")
+	weekend := isWeekend(local)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	type key struct{ stopID, route, time string }
+	days := make(map[key]int)
+	var order []key
+	for _, s := range h.sightings {
+		if s.Trip != trip || s.Weekend != weekend || s.Date < since {
+			continue
+		}
+		k := key{s.StopID, s.Route, s.Time}
+		if days[k] == 0 {
+			order = append(order, k)
+		}
+		days[k]++
+	}
+	var out []Habit
+	for _, k := range order {
+		if days[k] >= h.minDays {
+			out = append(out, Habit{StopID: k.stopID, Route: k.route, Time: k.time, Days: days[k]})
+		}
+	}
+	return out
+}
+
+// habitMessages warns about a trip's habitual services that are missing from
+// the board (cancelled, or no longer connecting) or running at least
+// delayMinutes late, once per service per day. A service is matched by route
+// and timetabled departure, whichever of the trip's stops it was seen from.
+// Trips planned around arrive_by aren't checked: their board leaves out
+// services for the arrival time, not because they've gone.
+func (s *pushService) habitMessages(tv TripView, habits []Habit, delayMinutes int, now time.Time) []PushMessage {
+	if tv.ArriveBy != "" {
+		return nil
+	}
+	local := now.In(boardTZ)
+	checkUntil := habitCheckUntil
+	if tv.WindowMinutes > 0 {
+		checkUntil = min(checkUntil, time.Duration(tv.WindowMinutes)*time.Minute)
+	}
+	var msgs []PushMessage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, hb := range habits {
+		clock, err := time.ParseInLocation("15:04", hb.Time, boardTZ)
+		if err != nil {
+			continue
+		}
+		sched := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, boardTZ)
+		if until := sched.Sub(now); until < habitCheckFrom || until > checkUntil {
+			continue
+		}
+
+		var found *DepartureView
+		for i := range tv.Departures {
+			dv := &tv.Departures[i]
+			if dv.RouteShortName == hb.Route && dv.scheduledAt.Equal(sched) {
+				found = dv
+				break
+			}
+		}
+
+		key := "habit|" + tv.Name + "|" + hb.StopID + "|" + hb.Route + "|" + sched.Format(time.RFC3339)
+		if _, done := s.sent[key]; done {
+			continue
+		}
+		var body string
+		switch {
+		case found == nil:
+			body = fmt.Sprintf("Your usual %s %s isn't on the board. It may be cancelled or no longer connect.", displayLocale.Clock(sched), hb.Route)
+		case found.DelayMinutes >= delayMinutes:
+			body = fmt.Sprintf("Your usual %s %s is running %d min late, now departs %s", displayLocale.Clock(sched), hb.Route, found.DelayMinutes, found.DepartureTime)
+		default:
+			continue
+		}
+		s.sent[key] = now
+		msgs = append(msgs, PushMessage{Title: tv.Name, Body: body, Tag: tv.Name + "-habit"})
+	}
+	return msgs
+}
+
+// buildSeenHandler records which trip a browser is looking at. The board
+// sends it as a beacon when a trip's tab is shown.
+func buildSeenHandler(apiURL string, cfg Config, cache *departureCache, habits *habitStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		trip, ok := selectTrip(cfg.Trips, r.URL.Query().Get("trip"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		now := time.Now().In(boardTZ)
+		tv, err := buildTripView(r.Context(), cache, apiURL, cfg, trip, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if err := habits.sight(host+"|"+r.UserAgent(), tv, now); err != nil {
+			log.Printf("recording habit: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func habitTripView(sched time.Time, delayMins int) TripView {
+	return TripView{Name: "Work", Departures: []DepartureView{{
+		RouteShortName:  "T1",
+		HasConnection:   true,
+		DelayMinutes:    delayMins,
+		DepartureTime:   sched.Add(time.Duration(delayMins) * time.Minute).Format("15:04"),
+		scheduledAt:     sched,
+		departureStopID: "2135",
+	}}}
+}
+
+func TestHabitStore_Learn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "habits.jsonl")
+	h, _ := loadHabits(HabitsConfig{File: path, MinDays: 3})
+
+	// Monday to Wednesday, looking at the board around 07:50 for the 08:02
+	for day := 3; day <= 5; day++ {
+		now := time.Date(2024, 6, day, 7, 50, 0, 0, boardTZ)
+		tv := habitTripView(time.Date(2024, 6, day, 8, 2, 0, 0, boardTZ), 0)
+		h.sight("phone", tv, now)
+		// Looking again within the session, when the next service is first, doesn't count
+		later := habitTripView(time.Date(2024, 6, day, 8, 12, 0, 0, boardTZ), 0)
+		h.sight("phone", later, now.Add(10*time.Minute))
+	}
+
+	monday := time.Date(2024, 6, 10, 7, 0, 0, 0, boardTZ)
+	habits := h.habits("Work", monday)
+	if len(habits) != 1 || habits[0].Time != "08:02" || habits[0].StopID != "2135" || habits[0].Days != 3 {
+		t.Fatalf("expected the 08:02 as a habit, got %+v", habits)
+	}
+	if got := h.habits("Work", time.Date(2024, 6, 8, 7, 0, 0, 0, boardTZ)); len(got) != 0 {
+		t.Errorf("expected no weekend habits, got %+v", got)
+	}
+
+	reloaded, err := loadHabits(HabitsConfig{File: path, MinDays: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reloaded.habits("Work", monday)) != 1 {
+		t.Error("expected sightings reloaded from disk")
+	}
+}
+
+func TestPushService_HabitMessages(t *testing.T) {
+	s := &pushService{sent: make(map[string]time.Time)}
+	now := time.Date(2024, 6, 10, 7, 40, 0, 0, boardTZ)
+	sched := time.Date(2024, 6, 10, 8, 2, 0, 0, boardTZ)
+	habits := []Habit{{StopID: "2135", Route: "T1", Time: "08:02"}}
+
+	if msgs := s.habitMessages(habitTripView(sched, 2), habits, 5, now); len(msgs) != 0 {
+		t.Errorf("expected no alert for a small delay, got %+v", msgs)
+	}
+	msgs := s.habitMessages(habitTripView(sched, 9), habits, 5, now)
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Body, "usual 08:02 T1 is running 9 min late") {
+		t.Fatalf("expected a delay alert, got %+v", msgs)
+	}
+	if msgs := s.habitMessages(habitTripView(sched, 12), habits, 5, now); len(msgs) != 0 {
+		t.Error("expected one alert per service")
+	}
+
+	s = &pushService{sent: make(map[string]time.Time)}
+	msgs = s.habitMessages(TripView{Name: "Work"}, habits, 5, now)
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Body, "may be cancelled") {
+		t.Errorf("expected a missing-service alert, got %+v", msgs)
+	}
+
+	s = &pushService{sent: make(map[string]time.Time)}
+	if msgs := s.habitMessages(TripView{Name: "Work"}, habits, 5, sched.Add(-2*time.Hour)); len(msgs) != 0 {
+		t.Error("expected no alert long before the service")
+	}
+
+	// 22 minutes away is past a 15-minute window, so the board can't list it
+	if msgs := s.habitMessages(TripView{Name: "Work", WindowMinutes: 15}, habits, 5, now); len(msgs) != 0 {
+		t.Errorf("expected no alert for a service outside the window, got %+v", msgs)
+	}
+	if msgs := s.habitMessages(TripView{Name: "Work", ArriveBy: "09:00"}, habits, 5, now); len(msgs) != 0 {
+		t.Errorf("expected arrive_by trips left alone, got %+v", msgs)
+	}
+	// The same service found from the trip's other stop is still the habit
+	other := habitTripView(sched, 0)
+	other.Departures[0].departureStopID = "2136"
+	if msgs := s.habitMessages(other, habits, 5, now); len(msgs) != 0 {
+		t.Errorf("expected the service matched from another stop, got %+v", msgs)
+	}
+}
+
+func TestSeenHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{
+			RouteShortName:     "T1",
+			ScheduledDeparture: now.Add(10 * time.Minute),
+			Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+		}},
+	})
+	defer mock.Close()
+
+	cfg := Config{Trips: []TripConfig{{Name: "Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}}}
+	h, _ := loadHabits(HabitsConfig{File: filepath.Join(t.TempDir(), "habits.jsonl")})
+	handler := buildSeenHandler(mock.URL, cfg, nil, h)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/api/seen?trip=0", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if len(h.sightings) != 1 || h.sightings[0].StopID != "100" || h.sightings[0].Route != "T1" {
+		t.Errorf("expected a sighting of the T1 from 100, got %+v", h.sightings)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/api/seen?trip=Home", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown trip, got %d", w.Code)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHistoryFile            = "history.jsonl"
+	defaultHistoryAbnormalMinutes = 5
+
+	// historyMinSamples is how many past observations a route/stop/hour needs
+	// before the board compares today against them.
+	historyMinSamples = 5
+)
+
+type HistoryConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	File            string `yaml:"file,omitempty"`
+	AbnormalMinutes int    `yaml:"abnormal_minutes,omitempty"`
+	RetentionDays   int    `yaml:"retention_days,omitempty"`
+}
+
+func (c HistoryConfig) file() string {
+	if c.File != "" {
+		return c.File
+	}
+	return defaultHistoryFile
+}
+
+func (c HistoryConfig) abnormalMinutes() int {
+	if c.AbnormalMinutes > 0 {
+		return c.AbnormalMinutes
+	}
+	return defaultHistoryAbnormalMinutes
+}
+
+// Observation is the last known delay of one service at one stop, recorded
+// once it has departed.
+type Observation struct {
+	Scheduled    time.Time `json:"scheduled"`
+	StopID       string    `json:"stop_id"`
+	Route        string    `json:"route"`
+	TripID       string    `json:"trip_id,omitempty"`
+	DelaySeconds int       `json:"delay_seconds"`
+}
+
+// historyKey groups observations that are expected to behave alike: the same
+// route at the same stop, in the same hour of a weekday or weekend day.
+type historyKey struct {
+	stopID, route string
+	weekend       bool
+	hour          int
+}
+
+func newHistoryKey(stopID, route string, t time.Time) historyKey {
+	t = t.In(boardTZ)
+	wd := t.Weekday()
+	return historyKey{stopID, route, wd == time.Saturday || wd == time.Sunday, t.Hour()}
+}
+
+// historyStore records observed delays to an append-only JSON Lines file and
+// keeps them indexed in memory.
+type historyStore struct {
+	path      string
+	aliases   map[string]string
+	retention time.Duration
+
+	mu       sync.Mutex
+	obs      []Observation
+	index    map[historyKey][]Observation
+	pending  map[string]Observation
+	prunedAt time.Time
+}
+
+func loadHistory(cfg HistoryConfig, aliases map[string]string) (*historyStore, error) {
+	path := cfg.file()
+	h := &historyStore{
+		path:      path,
+		aliases:   aliases,
+		retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		index:     make(map[historyKey][]Observation),
+		pending:   make(map[string]Observation),
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var o Observation
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			log.Printf("history %s:%d: %v", path, line, err)
+			continue
+		}
+		h.add(o)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	if err := h.prune(time.Now()); err != nil {
+		return nil, fmt.Errorf("pruning history: %w", err)
+	}
+	return h, nil
+}
+
+func (h *historyStore) add(o Observation) {
+	h.obs = append(h.obs, o)
+	k := newHistoryKey(o.StopID, o.Route, o.Scheduled)
+	h.index[k] = append(h.index[k], o)
+}
+
+// record notes the latest delay of every departure in a fresh upstream
+// response, and persists those that have since departed.
+func (h *historyStore) record(stopID string, deps []Departure, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, d := range deps {
+		if d.DelaySeconds == nil {
+			continue
+		}
+		route := d.RouteShortName
+		if alias, ok := h.aliases[route]; ok {
+			route = alias
+		}
+		key := stopID + "|" + d.TripID + "|" + d.ScheduledDeparture.Format(time.RFC3339)
+		h.pending[key] = Observation{
+			Scheduled:    d.ScheduledDeparture,
+			StopID:       stopID,
+			Route:        route,
+			TripID:       d.TripID,
+			DelaySeconds: *d.DelaySeconds,
+		}
+	}
+
+	var departed []Observation
+	for key, o := range h.pending {
+		if o.Scheduled.Add(time.Duration(o.DelaySeconds) * time.Second).Before(now) {
+			departed = append(departed, o)
+			delete(h.pending, key)
+		}
+	}
+	if len(departed) == 0 {
+		return
+	}
+	sort.Slice(departed, func(i, j int) bool { return departed[i].Scheduled.Before(departed[j].Scheduled) })
+	for _, o := range departed {
+		h.add(o)
+	}
+	if err := h.appendFile(departed); err != nil {
+		log.Printf("writing history: %v", err)
+	}
+	if now.Sub(h.prunedAt) >= 24*time.Hour {
+		if err := h.prune(now); err != nil {
+			log.Printf("pruning history: %v", err)
+		}
+	}
+}
+
+// prune drops observations older than the retention period and rewrites the
+// file without them. The caller must hold h.mu, or have sole access.
+func (h *historyStore) prune(now time.Time) error {
+	h.prunedAt = now
+	if h.retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-h.retention)
+	kept := make([]Observation, 0, len(h.obs))
+	for _, o := range h.obs {
+		if !o.Scheduled.Before(cutoff) {
+			kept = append(kept, o)
+		}
+	}
+	if len(kept) == len(h.obs) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, o := range kept {
+		if err := enc.Encode(o); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(h.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	h.obs, h.index = nil, make(map[historyKey][]Observation)
+	for _, o := range kept {
+		h.add(o)
+	}
+	return nil
+}
+
+// observations returns a copy of the recorded observations scheduled in
+// [from, to); zero times leave that end open.
+func (h *historyStore) observations(from, to time.Time) []Observation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Observation
+	for _, o := range h.obs {
+		if (!from.IsZero() && o.Scheduled.Before(from)) || (!to.IsZero() && !o.Scheduled.Before(to)) {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+func (h *historyStore) appendFile(obs []Observation) error {
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, o := range obs {
+		if err := enc.Encode(o); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// typicalDelay returns the median delay of a route at a stop around the given
+// time on previous days, and whether there were enough observations to say.
+func (h *historyStore) typicalDelay(stopID, route string, at time.Time) (time.Duration, bool) {
+	local := at.In(boardTZ)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, boardTZ)
+
+	h.mu.Lock()
+	var delays []int
+	for _, o := range h.index[newHistoryKey(stopID, route, at)] {
+		if o.Scheduled.Before(today) {
+			delays = append(delays, o.DelaySeconds)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(delays) < historyMinSamples {
+		return 0, false
+	}
+	sort.Ints(delays)
+	return time.Duration(delays[len(delays)/2]) * time.Second, true
+}
+
+// annotate labels departures from stopID that are running at least
+// abnormalMinutes worse than usual for that route and time of day.
+func (h *historyStore) annotate(deps []DepartureView, stopID string, abnormalMinutes int) {
+	if h == nil {
+		return
+	}
+	for i := range deps {
+		dv := &deps[i]
+		if !dv.IsRealtime {
+			continue
+		}
+		typical, ok := h.typicalDelay(stopID, dv.RouteShortName, dv.departureAt)
+		if !ok {
+			continue
+		}
+		worse := dv.DelayMinutes - int(typical.Minutes())
+		if worse >= abnormalMinutes {
+			dv.UsualNote = fmt.Sprintf("%d min worse than usual for %s", worse, dv.departureAt.In(boardTZ).Format("3pm"))
+		}
+	}
+}
+
+// buildHistoryExportHandler dumps recorded observations for offline analysis
+// as CSV (default), Parquet (?format=parquet) or JSON Lines (?format=jsonl),
+// optionally limited to
+// ?from= and ?to= dates (YYYY-MM-DD, board time, to inclusive).
+func buildHistoryExportHandler(h *historyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var from, to time.Time
+		for _, p := range []struct {
+			name string
+			t    *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			v := q.Get(p.name)
+			if v == "" {
+				continue
+			}
+			d, err := time.ParseInLocation("2006-01-02", v, boardTZ)
+			if err != nil {
+				http.Error(w, "invalid "+p.name+" date", http.StatusBadRequest)
+				return
+			}
+			*p.t = d
+		}
+		if !to.IsZero() {
+			to = to.AddDate(0, 0, 1)
+		}
+		obs := h.observations(from, to)
+
+		switch q.Get("format") {
+		case "", "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"scheduled", "stop_id", "route", "trip_id", "delay_seconds"})
+			for _, o := range obs {
+				cw.Write([]string{o.Scheduled.Format(time.RFC3339), o.StopID, o.Route, o.TripID, strconv.Itoa(o.DelaySeconds)})
+			}
+			cw.Flush()
+		case "parquet":
+			w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+			w.Header().Set("Content-Disposition", `attachment; filename="history.parquet"`)
+			writeParquet(w, len(obs), historyParquetColumns(obs))
+		case "jsonl":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="history.jsonl"`)
+			enc := json.NewEncoder(w)
+			for _, o := range obs {
+				enc.Encode(o)
+			}
+		default:
+			http.Error(w, "format must be csv, parquet or jsonl", http.StatusBadRequest)
+		}
+	}
+}
+
+// historyParquetColumns lays observations out as the CSV export's columns,
+// with scheduled as a UTC timestamp.
+func historyParquetColumns(obs []Observation) []parquetColumn {
+	cols := []parquetColumn{
+		{name: "scheduled", typ: parquetInt64, converted: parquetTimestampMillis},
+		{name: "stop_id", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "route", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "trip_id", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "delay_seconds", typ: parquetInt32, converted: parquetNoConversion},
+	}
+	for _, o := range obs {
+		cols[0].appendInt64(o.Scheduled.UnixMilli())
+		cols[1].appendString(o.StopID)
+		cols[2].appendString(o.Route)
+		cols[3].appendString(o.TripID)
+		cols[4].appendInt32(int32(o.DelaySeconds))
+	}
+	return cols
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryStore_RecordAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := loadHistory(HistoryConfig{File: path}, map[string]string{"SYD_T1": "T1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2024, 6, 3, 8, 0, 0, 0, boardTZ)
+	delay := 120
+	deps := []Departure{
+		{TripID: "a", RouteShortName: "SYD_T1", ScheduledDeparture: now.Add(5 * time.Minute), DelaySeconds: &delay},
+		{TripID: "b", RouteShortName: "T2", ScheduledDeparture: now.Add(10 * time.Minute)}, // no realtime
+	}
+	h.record("100", deps, now)
+	if len(h.obs) != 0 {
+		t.Fatal("expected nothing persisted before the service departs")
+	}
+
+	later := 240
+	deps[0].DelaySeconds = &later
+	h.record("100", deps, now.Add(2*time.Minute))
+	h.record("100", nil, now.Add(10*time.Minute))
+	if len(h.obs) != 1 {
+		t.Fatalf("expected one observation once departed, got %d", len(h.obs))
+	}
+	if o := h.obs[0]; o.Route != "T1" || o.DelaySeconds != 240 || o.TripID != "a" {
+		t.Errorf("expected latest delay under the route alias, got %+v", o)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected one JSON line, got %q", data)
+	}
+
+	reloaded, err := loadHistory(HistoryConfig{File: path}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reloaded.obs) != 1 || reloaded.obs[0].DelaySeconds != 240 {
+		t.Errorf("expected observation reloaded from disk, got %+v", reloaded.obs)
+	}
+}
+
+func TestHistoryStore_TypicalDelay(t *testing.T) {
+	h, _ := loadHistory(HistoryConfig{File: filepath.Join(t.TempDir(), "history.jsonl")}, nil)
+	// Monday 2024-06-10, 08:10
+	now := time.Date(2024, 6, 10, 8, 10, 0, 0, boardTZ)
+
+	// Previous weekdays around 8am: 0,1,2,2,3 min late
+	for i, mins := range []int{0, 1, 2, 2, 3} {
+		day := now.AddDate(0, 0, -(i + 1))
+		if wd := day.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			day = day.AddDate(0, 0, -2)
+		}
+		h.add(Observation{Scheduled: day, StopID: "100", Route: "T1", DelaySeconds: mins * 60})
+	}
+	// Today's and weekend observations don't count
+	h.add(Observation{Scheduled: now.Add(-5 * time.Minute), StopID: "100", Route: "T1", DelaySeconds: 1200})
+	h.add(Observation{Scheduled: time.Date(2024, 6, 8, 8, 0, 0, 0, boardTZ), StopID: "100", Route: "T1", DelaySeconds: 1200})
+
+	typical, ok := h.typicalDelay("100", "T1", now)
+	if !ok || typical != 2*time.Minute {
+		t.Errorf("expected median of 2 min, got %v (%v)", typical, ok)
+	}
+	if _, ok := h.typicalDelay("100", "T1", now.Add(4*time.Hour)); ok {
+		t.Error("expected no typical delay for an hour without observations")
+	}
+
+	deps := []DepartureView{
+		{RouteShortName: "T1", IsRealtime: true, DelayMinutes: 11, departureAt: now},
+		{RouteShortName: "T1", IsRealtime: true, DelayMinutes: 4, departureAt: now},
+	}
+	h.annotate(deps, "100", 5)
+	if deps[0].UsualNote != "9 min worse than usual for 8am" {
+		t.Errorf("unexpected note %q", deps[0].UsualNote)
+	}
+	if deps[1].UsualNote != "" {
+		t.Errorf("expected normal variance to be left alone, got %q", deps[1].UsualNote)
+	}
+}
+
+func TestHistoryStore_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, _ := loadHistory(HistoryConfig{File: path, RetentionDays: 30}, nil)
+	now := time.Date(2024, 6, 10, 8, 0, 0, 0, boardTZ)
+
+	for _, daysAgo := range []int{45, 31, 29, 1} {
+		h.add(Observation{Scheduled: now.AddDate(0, 0, -daysAgo), StopID: "100", Route: "T1"})
+	}
+	if err := h.prune(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(h.obs) != 2 {
+		t.Fatalf("expected 2 observations within 30 days, got %d", len(h.obs))
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), "\n") != 2 {
+		t.Errorf("expected the file rewritten with 2 lines, got %q", data)
+	}
+	indexed := 0
+	for _, obs := range h.index {
+		indexed += len(obs)
+	}
+	if indexed != 2 {
+		t.Error("expected the index rebuilt from the kept observations")
+	}
+}
+
+func TestHistoryExportHandler(t *testing.T) {
+	h, _ := loadHistory(HistoryConfig{File: filepath.Join(t.TempDir(), "history.jsonl")}, nil)
+	h.add(Observation{Scheduled: time.Date(2024, 6, 3, 8, 0, 0, 0, boardTZ), StopID: "100", Route: "T1", TripID: "a", DelaySeconds: 60})
+	h.add(Observation{Scheduled: time.Date(2024, 6, 5, 8, 0, 0, 0, boardTZ), StopID: "100", Route: "T1", TripID: "b", DelaySeconds: 120})
+	handler := buildHistoryExportHandler(h)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/history/export?from=2024-06-04&to=2024-06-05", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || lines[0] != "scheduled,stop_id,route,trip_id,delay_seconds" {
+		t.Fatalf("expected a header and one row, got %q", w.Body.String())
+	}
+	if !strings.HasSuffix(lines[1], ",100,T1,b,120") {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/history/export?format=jsonl", nil))
+	if n := strings.Count(w.Body.String(), "\n"); n != 2 {
+		t.Errorf("expected 2 JSON lines, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/history/export?format=parquet", nil))
+	if body := w.Body.String(); w.Code != 200 || !strings.HasPrefix(body, "PAR1") || !strings.HasSuffix(body, "PAR1") || !strings.Contains(body, "delay_seconds") {
+		t.Errorf("expected a Parquet file, got %d %q", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/history/export?format=xlsx", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unsupported format, got %d", w.Code)
+	}
+}
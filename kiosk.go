@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// defaultKioskRotateSeconds is how long a kiosk page shows each trip.
+const defaultKioskRotateSeconds = 20
+
+// KioskConfig renders the board for wall displays with no input devices
+// whenever enabled is set; otherwise ?kiosk=1 asks for one. A kiosk page
+// shows each trip in turn for rotate_seconds, without the controls or the
+// remembered tab of the normal page.
+type KioskConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	RotateSeconds int  `yaml:"rotate_seconds,omitempty"`
+}
+
+func (c KioskConfig) validate() error {
+	if c.RotateSeconds < 0 {
+		return errors.New("rotate_seconds must be positive")
+	}
+	return nil
+}
+
+// kioskPage reports whether r gets the kiosk page. ?kiosk=0 gets the normal
+// page even with kiosk.enabled.
+func kioskPage(r *http.Request, c KioskConfig) bool {
+	switch r.URL.Query().Get("kiosk") {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	return c.Enabled
+}
+
+// setKiosk turns data into a kiosk page rotating every c.RotateSeconds:
+// no push notifications, habit tracking or geolocation, which either need a
+// hand on the screen or would fight the rotation for the active tab.
+func (data *PageData) setKiosk(c KioskConfig) {
+	data.Kiosk = true
+	data.RotateSeconds = c.RotateSeconds
+	if data.RotateSeconds == 0 {
+		data.RotateSeconds = defaultKioskRotateSeconds
+	}
+	data.WebPush, data.Habits, data.Geolocation = false, false, false
+}
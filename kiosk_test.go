@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKioskPage(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  KioskConfig
+		url  string
+		want bool
+	}{
+		{"off", KioskConfig{}, "/", false},
+		{"query", KioskConfig{}, "/?kiosk=1", true},
+		{"config", KioskConfig{Enabled: true}, "/", true},
+		{"query opts out", KioskConfig{Enabled: true}, "/?kiosk=0", false},
+	}
+	for _, tc := range tests {
+		if got := kioskPage(httptest.NewRequest("GET", tc.url, nil), tc.cfg); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := parseConfig([]byte("kiosk:\n  rotate_seconds: -5\ntrips:\n  - name: a\n    routes: []\n")); err == nil || !strings.Contains(err.Error(), "kiosk") {
+		t.Errorf("expected a kiosk error from parseConfig, got %v", err)
+	}
+}
+
+func TestBoardTemplate_Kiosk(t *testing.T) {
+	data := PageData{
+		Now:            time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ),
+		Trips:          []TripView{{Name: "To Work"}, {Name: "Home"}},
+		RefreshSeconds: defaultRefreshSeconds,
+		WebPush:        true,
+		Habits:         true,
+	}
+	data.setKiosk(KioskConfig{RotateSeconds: 45})
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{`<body class=" kiosk">`, `class="topbar tabs"`, "ms= 45 *1000"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in the page", want)
+		}
+	}
+	for _, unwanted := range []string{"onclick=", "localStorage.setItem('activeTab'", "localStorage.getItem('activeTab')", "/api/seen", "enablePush"} {
+		if strings.Contains(page, unwanted) {
+			t.Errorf("expected no %q in a kiosk page", unwanted)
+		}
+	}
+
+	data = PageData{Trips: []TripView{{Name: "To Work"}, {Name: "Home"}}, RefreshSeconds: defaultRefreshSeconds}
+	b.Reset()
+	if err := parseTemplate().Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	if page := b.String(); strings.Contains(page, "rotate()") || !strings.Contains(page, "localStorage.setItem('activeTab'") {
+		t.Error("expected the normal page to remember its tab and not rotate")
+	}
+}
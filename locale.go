@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Locale controls how the board lays out text and renders times and dates.
+// The zero value is the board's original behaviour: English, left to right,
+// 24-hour clock, day before month.
+type Locale struct {
+	Tag        string
+	RTL        bool
+	Hour12     bool
+	MonthFirst bool
+	AM, PM     string
+}
+
+// displayLocale is the locale every page renders with, set from the config's
+// locale at startup.
+var displayLocale Locale
+
+var localeTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+var (
+	// rtlLanguages are written right to left.
+	rtlLanguages = map[string]bool{"ar": true, "he": true, "iw": true, "fa": true, "ur": true, "yi": true, "ps": true, "ckb": true, "dv": true, "sd": true, "ug": true}
+
+	// hour12Regions conventionally use a 12-hour clock, as do hour12Languages
+	// when the tag has no region.
+	hour12Regions   = map[string]bool{"US": true, "CA": true, "AU": true, "NZ": true, "IN": true, "PH": true, "PK": true, "BD": true, "MY": true, "EG": true, "SA": true, "AE": true, "JO": true, "KW": true, "QA": true, "OM": true, "BH": true, "IQ": true}
+	hour12Languages = map[string]bool{"ar": true, "hi": true, "ur": true, "bn": true}
+
+	// dayPeriods are the am/pm markers for languages that don't use the
+	// English ones.
+	dayPeriods = map[string][2]string{"ar": {"ص", "م"}}
+)
+
+// parseLocale reads a BCP 47 language tag such as "he-IL" or "en-US". An
+// empty tag gives the zero Locale.
+func parseLocale(tag string) (Locale, error) {
+	if tag == "" {
+		return Locale{}, nil
+	}
+	if !localeTagPattern.MatchString(tag) {
+		return Locale{}, fmt.Errorf("invalid language tag %q", tag)
+	}
+	parts := strings.Split(tag, "-")
+	lang := strings.ToLower(parts[0])
+	region := ""
+	for _, p := range parts[1:] {
+		// The region is the first two-letter or three-digit subtag after the
+		// language, skipping any script subtag (e.g. "Arab").
+		if len(p) == 2 || (len(p) == 3 && p[0] >= '0' && p[0] <= '9') {
+			region = strings.ToUpper(p)
+			break
+		}
+	}
+
+	l := Locale{Tag: tag, RTL: rtlLanguages[lang], MonthFirst: region == "US", AM: "am", PM: "pm"}
+	if region != "" {
+		l.Hour12 = hour12Regions[region]
+	} else {
+		l.Hour12 = hour12Languages[lang]
+	}
+	if p, ok := dayPeriods[lang]; ok {
+		l.AM, l.PM = p[0], p[1]
+	}
+	return l, nil
+}
+
+// Lang is the value for the html lang attribute.
+func (l Locale) Lang() string {
+	if l.Tag == "" {
+		return "en"
+	}
+	return l.Tag
+}
+
+// Dir is the value for the html dir attribute.
+func (l Locale) Dir() string {
+	if l.RTL {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+func (l Locale) period(t time.Time) string {
+	if t.Hour() < 12 {
+		return l.AM
+	}
+	return l.PM
+}
+
+// Clock formats a time of day, e.g. "15:04" or "3:04 pm".
+func (l Locale) Clock(t time.Time) string {
+	if !l.Hour12 {
+		return t.Format("15:04")
+	}
+	return t.Format("3:04") + " " + l.period(t)
+}
+
+// Hour formats the hour a time falls in, for hour group headers: "15:00" or
+// "3 pm".
+func (l Locale) Hour(t time.Time) string {
+	if !l.Hour12 {
+		return t.Format("15:00")
+	}
+	return t.Format("3") + " " + l.period(t)
+}
+
+// ShortDate formats a date as "Mon 2 Jan", or "Mon, Jan 2" for month-first
+// locales.
+func (l Locale) ShortDate(t time.Time) string {
+	if l.MonthFirst {
+		return t.Format("Mon, Jan 2")
+	}
+	return t.Format("Mon 2 Jan")
+}
+
+// LongDate formats a date as "Monday 2 January 2006", or
+// "Monday, January 2, 2006" for month-first locales.
+func (l Locale) LongDate(t time.Time) string {
+	if l.MonthFirst {
+		return t.Format("Monday, January 2, 2006")
+	}
+	return t.Format("Monday 2 January 2006")
+}
+
+// localeFuncs exposes displayLocale to page templates.
+func localeFuncs() template.FuncMap {
+	return template.FuncMap{
+		"locale": func() Locale { return displayLocale },
+	}
+}
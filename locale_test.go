@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLocale(t *testing.T) {
+	tests := []struct {
+		tag        string
+		dir        string
+		hour12     bool
+		monthFirst bool
+	}{
+		{"", "ltr", false, false},
+		{"en", "ltr", false, false},
+		{"en-GB", "ltr", false, false},
+		{"en-US", "ltr", true, true},
+		{"en-AU", "ltr", true, false},
+		{"he-IL", "rtl", false, false},
+		{"ar", "rtl", true, false},
+		{"ar-Arab-MA", "rtl", false, false},
+		{"fa-IR", "rtl", false, false},
+		{"de-DE", "ltr", false, false},
+	}
+	for _, tc := range tests {
+		l, err := parseLocale(tc.tag)
+		if err != nil {
+			t.Errorf("%q: %v", tc.tag, err)
+			continue
+		}
+		if l.Dir() != tc.dir || l.Hour12 != tc.hour12 || l.MonthFirst != tc.monthFirst {
+			t.Errorf("%q: got dir %s, hour12 %v, month first %v", tc.tag, l.Dir(), l.Hour12, l.MonthFirst)
+		}
+	}
+
+	for _, tag := range []string{"english", "en_US", "e", "en-"} {
+		if _, err := parseLocale(tag); err == nil {
+			t.Errorf("%q: expected an error", tag)
+		}
+	}
+	if _, err := parseConfig([]byte("locale: en_US\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "locale") {
+		t.Errorf("expected a locale error from parseConfig, got %v", err)
+	}
+}
+
+func TestLocaleFormatting(t *testing.T) {
+	at := time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ)
+	morning := time.Date(2026, 3, 2, 0, 30, 0, 0, boardTZ)
+	us, _ := parseLocale("en-US")
+	ar, _ := parseLocale("ar-EG")
+
+	tests := []struct {
+		name, got, want string
+	}{
+		{"default clock", Locale{}.Clock(at), "15:04"},
+		{"default hour", Locale{}.Hour(at), "15:00"},
+		{"default short date", Locale{}.ShortDate(at), "Mon 2 Mar"},
+		{"default long date", Locale{}.LongDate(at), "Monday 2 March 2026"},
+		{"12-hour clock", us.Clock(at), "3:04 pm"},
+		{"12-hour midnight", us.Clock(morning), "12:30 am"},
+		{"12-hour hour", us.Hour(at), "3 pm"},
+		{"month-first short date", us.ShortDate(at), "Mon, Mar 2"},
+		{"month-first long date", us.LongDate(at), "Monday, March 2, 2026"},
+		{"arabic day period", ar.Clock(at), "3:04 م"},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, tc.got)
+		}
+	}
+}
+
+func TestBoardTemplate_RTL(t *testing.T) {
+	defer func(l Locale) { displayLocale = l }(displayLocale)
+	displayLocale, _ = parseLocale("he-IL")
+
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, PageData{Now: time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<html lang="he-IL" dir="rtl">`) {
+		t.Errorf("expected an RTL html element, got %.200s", b.String())
+	}
+
+	displayLocale, _ = parseLocale("en-US")
+	board := newBoard(PageData{
+		Now:   time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ),
+		Trips: []TripView{{Departures: []DepartureView{{departureAt: time.Date(2026, 3, 2, 18, 10, 0, 0, boardTZ)}}}},
+	})
+	if !board.Hour12 || board.PM != "pm" {
+		t.Errorf("expected a 12-hour board, got hour12 %v pm %q", board.Hour12, board.PM)
+	}
+	if h := board.Trips[0].Departures[0].Hour; h != "6 pm" {
+		t.Errorf("expected hour header %q, got %q", "6 pm", h)
+	}
+}
@@ -1,52 +1,306 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	_ "embed"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
 // Config types
 
 type Config struct {
-	GtfsAPIURL string       `yaml:"gtfs_api_url"`
-	Port       string       `yaml:"port"`
-	Trips      []TripConfig `yaml:"trips"`
+	GtfsAPIURL             string                 `yaml:"gtfs_api_url"`
+	GtfsAPIHeaders         map[string]string      `yaml:"gtfs_api_headers,omitempty"`
+	GTFS                   GTFSConfig             `yaml:"gtfs,omitempty"`
+	SIRI                   SIRIConfig             `yaml:"siri,omitempty"`
+	Port                   string                 `yaml:"port"`
+	Locale                 string                 `yaml:"locale,omitempty"`
+	Timezone               string                 `yaml:"timezone,omitempty"`
+	Geolocation            GeolocationConfig      `yaml:"geolocation,omitempty"`
+	Prewarm                []PrewarmWindow        `yaml:"prewarm,omitempty"`
+	AdaptivePolling        AdaptivePollingConfig  `yaml:"adaptive_polling,omitempty"`
+	BatchQueries           bool                   `yaml:"batch_queries,omitempty"`
+	PollInterval           int                    `yaml:"poll_interval,omitempty"`
+	UpstreamTimeout        int                    `yaml:"upstream_timeout,omitempty"`
+	UpstreamDialTimeout    int                    `yaml:"upstream_dial_timeout,omitempty"`
+	UpstreamHeaderTimeout  int                    `yaml:"upstream_header_timeout,omitempty"`
+	WindowMinutes          int                    `yaml:"window_minutes,omitempty"`
+	RefreshSeconds         int                    `yaml:"refresh_seconds,omitempty"`
+	StaleWhileRevalidate   StaleConfig            `yaml:"stale_while_revalidate,omitempty"`
+	ClientRender           bool                   `yaml:"client_render,omitempty"`
+	ConfigPreview          bool                   `yaml:"config_preview,omitempty"`
+	TemplatePath           string                 `yaml:"template_path,omitempty"`
+	Theme                  ThemeConfig            `yaml:"theme,omitempty"`
+	EInk                   EInkConfig             `yaml:"eink,omitempty"`
+	Kiosk                  KioskConfig            `yaml:"kiosk,omitempty"`
+	ShowUnknownConnections bool                   `yaml:"show_unknown_connections,omitempty"`
+	Stops                  map[string]StopConfig  `yaml:"stops,omitempty"`
+	Interchanges           []InterchangeConfig    `yaml:"interchanges,omitempty"`
+	MinConnectionBuffer    int                    `yaml:"min_connection_buffer,omitempty"`
+	NextConnections        int                    `yaml:"next_connections,omitempty"`
+	RouteLibrary           map[string]RouteConfig `yaml:"route_library,omitempty"`
+	Headsigns              map[string]string      `yaml:"headsigns,omitempty"`
+	RouteAliases           map[string]string      `yaml:"route_aliases,omitempty"`
+	RouteColors            RouteColorsConfig      `yaml:"route_colors,omitempty"`
+	School                 SchoolConfig           `yaml:"school,omitempty"`
+	WebPush                WebPushConfig          `yaml:"web_push,omitempty"`
+	Notify                 NotifyConfig           `yaml:"notify,omitempty"`
+	MQTT                   MQTTConfig             `yaml:"mqtt,omitempty"`
+	Announcements          AnnouncementsConfig    `yaml:"announcements,omitempty"`
+	History                HistoryConfig          `yaml:"history,omitempty"`
+	Habits                 HabitsConfig           `yaml:"habits,omitempty"`
+	Carbon                 CarbonConfig           `yaml:"carbon,omitempty"`
+	BikeShare              BikeShareConfig        `yaml:"bike_share,omitempty"`
+	ParkAndRide            ParkAndRideConfig      `yaml:"park_and_ride,omitempty"`
+	Alerts                 AlertsConfig           `yaml:"alerts,omitempty"`
+	Retry                  RetryConfig            `yaml:"retry,omitempty"`
+	RateLimit              RateLimitConfig        `yaml:"rate_limit,omitempty"`
+	FaultInjection         FaultInjectionConfig   `yaml:"fault_injection,omitempty"`
+	Admin                  AdminConfig            `yaml:"admin,omitempty"`
+	Auth                   AuthConfig             `yaml:"auth,omitempty"`
+	TLS                    TLSConfig              `yaml:"tls,omitempty"`
+	Trips                  []TripConfig           `yaml:"trips"`
+
+	// warnings are problems found by checking the config against the
+	// upstream (e.g. unknown stop IDs), shown above the board.
+	warnings []string
+
+	// bikes reads the bike_share feed, when configured.
+	bikes *bikeShare
+	// carParks reads Park&Ride occupancy for routes with car_park_facility.
+	carParks *carParks
+	// alerts reads service alerts, when enabled.
+	alerts *serviceAlerts
+	// locale is the parsed Locale.
+	locale Locale
+	// loc is the loaded timezone, nil when the config doesn't set one.
+	loc *time.Location
+	// tmpl is the board template loaded from template_path, if set.
+	tmpl *template.Template
+	// source is the YAML the config was parsed from.
+	source []byte
+}
+
+type StopConfig struct {
+	StopID   string  `yaml:"stop_id"`
+	Name     string  `yaml:"name,omitempty"`
+	Lat      float64 `yaml:"lat,omitempty"`
+	Lon      float64 `yaml:"lon,omitempty"`
+	WalkTime int     `yaml:"walk_time,omitempty"`
+}
+
+type InterchangeConfig struct {
+	From          string `yaml:"from"`
+	To            string `yaml:"to"`
+	MinConnection int    `yaml:"min_connection"`
+}
+
+type PrewarmWindow struct {
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Days     []string `yaml:"days,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+type AdaptivePollingConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	NearMinutes  int  `yaml:"near_minutes,omitempty"`
+	MinInterval  int  `yaml:"min_interval,omitempty"`
+	IdleInterval int  `yaml:"idle_interval,omitempty"`
+}
+
+type GeolocationConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxDistance int  `yaml:"max_distance,omitempty"`
 }
 
 type TripConfig struct {
-	Name   string        `yaml:"name"`
-	Routes []RouteConfig `yaml:"routes"`
+	Name           string        `yaml:"name"`
+	Routes         []RouteConfig `yaml:"routes"`
+	GenerateReturn bool          `yaml:"generate_return,omitempty"`
+	// Bidirectional is another name for GenerateReturn.
+	Bidirectional  bool              `yaml:"bidirectional,omitempty"`
+	ReturnName     string            `yaml:"return_name,omitempty"`
+	Chime          *ChimeConfig      `yaml:"chime,omitempty"`
+	PollInterval   int               `yaml:"poll_interval,omitempty"`
+	GtfsAPIURL     string            `yaml:"gtfs_api_url,omitempty"`
+	GtfsAPIHeaders map[string]string `yaml:"gtfs_api_headers,omitempty"`
+	BikeShare      *TripBikeShare    `yaml:"bike_share,omitempty"`
+	Fallback       *FallbackConfig   `yaml:"fallback,omitempty"`
+	Timezone       string            `yaml:"timezone,omitempty"`
+	WindowMinutes  int               `yaml:"window_minutes,omitempty"`
+	RefreshSeconds int               `yaml:"refresh_seconds,omitempty"`
+	ArriveBy       string            `yaml:"arrive_by,omitempty"`
+	// Disabled trips are left off the board, as if they weren't there.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// MaxJourneyMinutes leaves out departures whose journey, from leaving
+	// for the stop to the final arrival, takes longer.
+	MaxJourneyMinutes int `yaml:"max_journey_minutes,omitempty"`
+	// Sort orders the trip's departures: "arrival" (the default) by final
+	// arrival, "departure" by when to leave for the stop, or "duration" by
+	// journey time.
+	Sort string `yaml:"sort,omitempty"`
+	// VisibleBetween ("HH:MM", "HH:MM") and VisibleDays limit when the
+	// trip is on the board.
+	VisibleBetween []string `yaml:"visible_between,omitempty"`
+	VisibleDays    []string `yaml:"visible_days,omitempty"`
+
+	// loc is the loaded timezone, nil when the trip doesn't set one.
+	loc *time.Location
+}
+
+// windowMinutes returns how far ahead the trip's departures are shown: the
+// trip's window_minutes, else the board's, else an hour.
+func (c Config) windowMinutes(trip TripConfig) int {
+	switch {
+	case trip.WindowMinutes > 0:
+		return trip.WindowMinutes
+	case c.WindowMinutes > 0:
+		return c.WindowMinutes
+	}
+	return departureWindowMinutes
+}
+
+// refreshSeconds returns how often the trip's departures are reloaded: the
+// trip's refresh_seconds, else the board's, else every 30 seconds.
+func (c Config) refreshSeconds(trip TripConfig) int {
+	switch {
+	case trip.RefreshSeconds > 0:
+		return trip.RefreshSeconds
+	case c.RefreshSeconds > 0:
+		return c.RefreshSeconds
+	}
+	return defaultRefreshSeconds
+}
+
+// timeZone returns the timezone the trip's times are shown in.
+func (t TripConfig) timeZone() *time.Location {
+	if t.loc != nil {
+		return t.loc
+	}
+	return boardTZ
+}
+
+type FallbackConfig struct {
+	DriveMinutes int    `yaml:"drive_minutes"`
+	Label        string `yaml:"label,omitempty"`
+	Link         string `yaml:"link,omitempty"`
+}
+
+type ChimeConfig struct {
+	Threshold int    `yaml:"threshold"`
+	Sound     string `yaml:"sound,omitempty"`
+	Flash     bool   `yaml:"flash,omitempty"`
 }
 
 type RouteConfig struct {
-	RouteName               string   `yaml:"route_name"`
-	DepartureStopID         string   `yaml:"departure_stop_id"`
-	DepartureName           string   `yaml:"departure_name"`
-	Leg1Services            []string `yaml:"leg_1_services,omitempty"`
-	TransferArrivalStopID   string   `yaml:"transfer_arrival_stop_id,omitempty"`
+	Ref                     string            `yaml:"ref,omitempty"`
+	RouteName               string            `yaml:"route_name"`
+	DepartureStopID         string            `yaml:"departure_stop_id"`
+	DepartureName           string            `yaml:"departure_name"`
+	DepartureLat            float64           `yaml:"departure_lat,omitempty"`
+	DepartureLon            float64           `yaml:"departure_lon,omitempty"`
+	InitialWalkTime         int               `yaml:"initial_walk_time,omitempty"`
+	Leg1Services            []string          `yaml:"leg_1_services,omitempty"`
+	Leg1ExcludeServices     []string          `yaml:"leg_1_exclude_services,omitempty"`
+	Leg1Headsigns           []string          `yaml:"leg_1_headsigns,omitempty"`
+	TransferArrivalStopID   string            `yaml:"transfer_arrival_stop_id,omitempty"`
+	TransferTime            int               `yaml:"transfer_time,omitempty"`
+	TransferDepartureStopID string            `yaml:"transfer_departure_stop_id,omitempty"`
+	TransferName            string            `yaml:"transfer_name,omitempty"`
+	Leg2Services            []string          `yaml:"leg_2_services,omitempty"`
+	Leg2ExcludeServices     []string          `yaml:"leg_2_exclude_services,omitempty"`
+	Leg2Headsigns           []string          `yaml:"leg_2_headsigns,omitempty"`
+	Legs                    []LegConfig       `yaml:"legs,omitempty"`
+	FinalArrivalStop        string            `yaml:"final_arrival_stop"`
+	FinalWalkTime           int               `yaml:"final_walk_time"`
+	ArrivalName             string            `yaml:"arrival_name"`
+	PollInterval            int               `yaml:"poll_interval,omitempty"`
+	GtfsAPIURL              string            `yaml:"gtfs_api_url,omitempty"`
+	GtfsAPIHeaders          map[string]string `yaml:"gtfs_api_headers,omitempty"`
+	Mode                    string            `yaml:"mode,omitempty"`
+	DistanceKm              float64           `yaml:"distance_km,omitempty"`
+	CarParkFacility         string            `yaml:"car_park_facility,omitempty"`
+	MinConnectionBuffer     int               `yaml:"min_connection_buffer,omitempty"`
+
+	// window is the departure window of the route's trip in minutes, set by
+	// parseConfig; 0 for the default.
+	window int
+}
+
+// windowMinutes returns how far ahead the route's departures are shown.
+func (r RouteConfig) windowMinutes() int {
+	if r.window > 0 {
+		return r.window
+	}
+	return departureWindowMinutes
+}
+
+// upstreamURL returns the GTFS departure service the route's departures come
+// from: its gtfs_api_url (or its trip's, which parseConfig copies down), else
+// def, the board's.
+func (r RouteConfig) upstreamURL(def string) string {
+	if r.GtfsAPIURL != "" {
+		return r.GtfsAPIURL
+	}
+	return def
+}
+
+// upstreamMinutes is the minutes to ask the upstream for, 0 when its default
+// hour covers the window.
+func (r RouteConfig) upstreamMinutes() int {
+	if w := r.windowMinutes(); w > departureWindowMinutes {
+		return w
+	}
+	return 0
+}
+
+// LegConfig is one change of service on a route with several: alight at
+// transfer_arrival_stop_id, allow transfer_time seconds to reach
+// transfer_departure_stop_id and board one of services there. It rides to the
+// next leg's transfer_arrival_stop_id, or the route's final_arrival_stop after
+// the last leg; if it would board where it alights, the change is a walk.
+type LegConfig struct {
+	TransferArrivalStopID   string   `yaml:"transfer_arrival_stop_id"`
 	TransferTime            int      `yaml:"transfer_time,omitempty"`
-	TransferDepartureStopID string   `yaml:"transfer_departure_stop_id,omitempty"`
+	TransferDepartureStopID string   `yaml:"transfer_departure_stop_id"`
 	TransferName            string   `yaml:"transfer_name,omitempty"`
-	Leg2Services            []string `yaml:"leg_2_services,omitempty"`
-	FinalArrivalStop        string   `yaml:"final_arrival_stop"`
-	FinalWalkTime           int      `yaml:"final_walk_time"`
-	ArrivalName             string   `yaml:"arrival_name"`
+	Services                []string `yaml:"services,omitempty"`
+	ExcludeServices         []string `yaml:"exclude_services,omitempty"`
+	Headsigns               []string `yaml:"headsigns,omitempty"`
 }
 
 // API types
 
 type Departure struct {
 	TripID             string          `json:"trip_id"`
+	ServiceID          string          `json:"service_id,omitempty"`
 	RouteShortName     string          `json:"route_short_name"`
 	RouteLongName      string          `json:"route_long_name"`
 	Headsign           string          `json:"headsign"`
@@ -54,6 +308,21 @@ type Departure struct {
 	RealtimeDeparture  *time.Time      `json:"realtime_departure"`
 	DelaySeconds       *int            `json:"delay_seconds"`
 	Arrivals           []ArrivalDetail `json:"arrivals,omitempty"`
+
+	// GTFS route colours, six hex digits without a '#', when the upstream
+	// has them
+	RouteColor     string `json:"route_color,omitempty"`
+	RouteTextColor string `json:"route_text_color,omitempty"`
+
+	// PlatformCode is the platform (or stand) the service leaves from, when
+	// the upstream knows it.
+	PlatformCode string `json:"platform_code,omitempty"`
+
+	// Demand-responsive (GTFS-Flex) services
+	BookingRequired      bool       `json:"booking_required,omitempty"`
+	BookingNoticeMinutes int        `json:"booking_notice_minutes,omitempty"`
+	PickupWindowStart    *time.Time `json:"pickup_window_start,omitempty"`
+	PickupWindowEnd      *time.Time `json:"pickup_window_end,omitempty"`
 }
 
 type ArrivalDetail struct {
@@ -61,63 +330,304 @@ type ArrivalDetail struct {
 	StopName         string     `json:"stop_name"`
 	ScheduledArrival time.Time  `json:"scheduled_arrival"`
 	RealtimeArrival  *time.Time `json:"realtime_arrival"`
+	PlatformCode     string     `json:"platform_code,omitempty"`
 }
 
 // View types
 
+// departureWindowMinutes is the default departure window, and how far ahead
+// the upstream looks unless asked for more.
 const departureWindowMinutes = 60
 
+// maxWindowMinutes caps window_minutes at a day.
+const maxWindowMinutes = 24 * 60
+
+// defaultRefreshSeconds is how often the board reloads unless
+// refresh_seconds says otherwise, between minRefreshSeconds and an hour.
+const (
+	defaultRefreshSeconds = 30
+	minRefreshSeconds     = 5
+	maxRefreshSeconds     = 60 * 60
+)
+
+// Windows longer than this group departures under hour headers.
+const hourGroupMinutes = 90
+
+// Origins further than this (in metres) from the browser's position are never
+// auto-selected.
+const defaultGeolocationMaxDistance = 500
+
 type PageData struct {
 	Trips         []TripView
 	Now           time.Time
-	Error         string
+	Warnings      []string
 	WindowMinutes int
+	// RefreshSeconds is how often the page reloads (or, client rendered,
+	// refetches the board): the shortest of its trips' intervals.
+	RefreshSeconds int
+	Geolocation    bool
+	Embed          bool
+	WebPush        bool
+	Habits         bool
+	Transparent    bool
+	GeoMaxDistance int
+	ClientRender   bool
+	Board          Board
+	Theme          ThemeConfig
+	// Dark renders the page in the dark scheme; with AutoDark the browser
+	// switches to it when it prefers a dark colour scheme.
+	Dark     bool
+	AutoDark bool
+	// ThemeChange is when sunset mode next switches scheme; the page
+	// reloads then.
+	ThemeChange time.Time
+	// EInk sizes a static e-paper render, nil for the normal page.
+	EInk *EInkView
+	// Active is the index of the trip whose tab is open. Pinned is the
+	// ?trip= (or /trip/) key that chose it, when one did; the browser then
+	// neither restores its remembered tab nor rotates.
+	Active int
+	Pinned string
+	// Kiosk rotates through the trips every RotateSeconds, with no
+	// controls and no remembered tab.
+	Kiosk         bool
+	RotateSeconds int
 }
 
 type TripView struct {
-	Name       string
-	Departures []DepartureView
+	Name           string
+	Departures     []DepartureView
+	Origins        []LatLon
+	Chime          *ChimeConfig
+	Bikes          []BikeStationView
+	CycleArrival   string
+	CarParks       []CarParkView
+	Alerts         []AlertView
+	Fallback       *FallbackView
+	AsOf           string
+	UpdatedAgo     string
+	Error          string
+	WindowMinutes  int
+	RefreshSeconds int
+	// ArriveBy is the trip's arrive_by target, when it has one.
+	ArriveBy string
+}
+
+type FallbackView struct {
+	Label  string `json:"label"`
+	Link   string `json:"link,omitempty"`
+	Arrive string `json:"arrive"`
+}
+
+// LegView is a connecting service a departure changes to.
+type LegView struct {
+	RouteShortName string `json:"route_short_name"`
+	RouteColor     string `json:"route_color"`
+	RouteTextColor string `json:"route_text_color,omitempty"`
+	Headsign       string `json:"headsign,omitempty"`
+	TransferName   string `json:"transfer_name,omitempty"`
+	Platform       string `json:"platform,omitempty"`
+	WaitMins       int    `json:"wait_mins"`
+	// SpareMins is the time left over after the transfer walk. Tight is set
+	// when it's less than the connection buffer, which happens only when no
+	// service meets the buffer.
+	SpareMins int  `json:"spare_mins"`
+	Tight     bool `json:"tight,omitempty"`
+	// Next are the services after this one that also make the leg, with
+	// next_connections set.
+	Next []NextConnection `json:"next,omitempty"`
+}
+
+// NextConnection is a later service on a leg: the fallback if the one
+// before it is missed.
+type NextConnection struct {
+	RouteShortName string `json:"route_short_name"`
+	RouteColor     string `json:"route_color"`
+	RouteTextColor string `json:"route_text_color,omitempty"`
+	Platform       string `json:"platform,omitempty"`
+	DepartureTime  string `json:"departure_time"`
+	ArrivalTime    string `json:"arrival_time"`
+}
+
+type LatLon struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
 }
 
 type DepartureView struct {
-	RouteShortName      string
-	RouteColor          string
-	Headsign            string
-	DepartureTime       string
-	MinutesAway         string
-	MinutesAwayLabel    string
-	IsRealtime          bool
-	IsDelayed           bool
-	DelayMinutes        int
-	FinalArrivalTime    string
-	FinalArrivalMins    string
-	HasConnection       bool
-	SecondLegRouteShort string
-	SecondLegRouteColor string
-	SecondLegHeadsign   string
-	TransferWaitMins    int
-	DepartureName       string
-	TransferName        string
-	ArrivalName         string
+	RouteShortName string `json:"route_short_name"`
+	RouteColor     string `json:"route_color"`
+	RouteTextColor string `json:"route_text_color,omitempty"`
+	Headsign       string `json:"headsign,omitempty"`
+	DepartureTime  string `json:"departure_time"`
+	// ScheduledTime is the timetabled departure time, set only when the
+	// realtime one is a minute or more away from it.
+	ScheduledTime       string    `json:"scheduled_time,omitempty"`
+	Platform            string    `json:"platform,omitempty"`
+	MinutesAway         string    `json:"minutes_away"`
+	MinutesAwayLabel    string    `json:"minutes_away_label"`
+	LeaveBy             string    `json:"leave_by,omitempty"`
+	IsRealtime          bool      `json:"is_realtime,omitempty"`
+	IsDelayed           bool      `json:"is_delayed,omitempty"`
+	DelayMinutes        int       `json:"delay_minutes,omitempty"`
+	FinalArrivalTime    string    `json:"final_arrival_time"`
+	FinalArrivalMins    string    `json:"final_arrival_mins"`
+	ArrivalPlatform     string    `json:"arrival_platform,omitempty"`
+	HasConnection       bool      `json:"has_connection,omitempty"`
+	LastFeasible        bool      `json:"last_feasible,omitempty"`
+	ConnectionUnknown   bool      `json:"connection_unknown,omitempty"`
+	Connections         []LegView `json:"connections,omitempty"`
+	SecondLegRouteShort string    `json:"second_leg_route_short,omitempty"`
+	SecondLegRouteColor string    `json:"second_leg_route_color,omitempty"`
+	SecondLegHeadsign   string    `json:"second_leg_headsign,omitempty"`
+	TransferWaitMins    int       `json:"transfer_wait_mins,omitempty"`
+	TightConnection     bool      `json:"tight_connection,omitempty"`
+	JourneyMins         int       `json:"journey_mins,omitempty"`
+	JourneySummary      string    `json:"journey_summary,omitempty"`
+	DepartureName       string    `json:"departure_name"`
+	TransferName        string    `json:"transfer_name,omitempty"`
+	ArrivalName         string    `json:"arrival_name"`
+	IsOnDemand          bool      `json:"is_on_demand,omitempty"`
+	PickupWindow        string    `json:"pickup_window,omitempty"`
+	BookingNote         string    `json:"booking_note,omitempty"`
+	SchoolDaysOnly      bool      `json:"school_days_only,omitempty"`
+	UsualNote           string    `json:"usual_note,omitempty"`
+	Carbon              string    `json:"carbon,omitempty"`
+	HourHeader          string    `json:"-"`
+	departureAt         time.Time
+	leaveAt             time.Time
 	finalArrivalSort    time.Time
+	scheduledAt         time.Time
+	departureStopID     string
+	tripID              string
 }
 
-var sydneyTZ *time.Location
+// HasNextConnections reports whether any of the departure's legs has later
+// services to show.
+func (dv DepartureView) HasNextConnections() bool {
+	for _, leg := range dv.Connections {
+		if len(leg.Next) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// maxNextConnections caps next_connections.
+const maxNextConnections = 5
+
+// boardTZ is the timezone the board works in: Australia/Sydney unless the
+// config sets timezone. Trips can override it with their own timezone.
+var boardTZ *time.Location
 
 func init() {
 	var err error
-	sydneyTZ, err = time.LoadLocation("Australia/Sydney")
+	boardTZ, err = time.LoadLocation("Australia/Sydney")
 	if err != nil {
 		log.Fatal("failed to load timezone: ", err)
 	}
 }
 
+// subcommands run instead of the board when named as the first argument.
+var subcommands = map[string]func(args []string) error{
+	"serve":      runServe,
+	"validate":   runValidate,
+	"render":     runRender,
+	"next":       runNext,
+	"mockserver": runMockServer,
+	"backup":     runBackup,
+	"restore":    runRestore,
+}
+
+// defaultConfigPath is the config file used without -config: $CONFIG_PATH,
+// or config.yaml in the working directory.
+func defaultConfigPath() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "config.yaml"
+}
+
+// main runs the subcommand named by the first argument, or serve when there
+// is none (or only flags), so `departure-board -config x.yaml` still serves.
 func main() {
-	configPath := "config.yaml"
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	run, ok := subcommands[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(subcommands))
+		log.Fatalf("unknown command %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	if err := run(args); err != nil {
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		log.Fatalf("%s: %v", name, err)
+	}
+}
 
-	cfg, err := loadConfig(configPath)
+// parseFlags parses a subcommand's flags. -h is not an error.
+func parseFlags(fs *flag.FlagSet, args []string) (help bool, err error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// setupConfig loads the config at path and makes its locale and timezone
+// the board's.
+func setupConfig(path string) (Config, error) {
+	cfg, err := loadConfig(path)
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		abs, _ := filepath.Abs(path)
+		return Config{}, fmt.Errorf("failed to load config %s: %w", abs, err)
+	}
+	displayLocale = cfg.locale
+	if cfg.loc != nil {
+		boardTZ = cfg.loc
+	}
+	return cfg, nil
+}
+
+// apiURL returns the GTFS departure service's base URL: gtfs_api_url, else
+// $GTFS_API_URL, else a local one. With gtfs: or siri: it is localGTFSURL
+// or localSIRIURL instead.
+func (c Config) apiURL() string {
+	switch {
+	case c.GTFS.Static != "":
+		return localGTFSURL
+	case c.SIRI.URL != "":
+		return localSIRIURL
+	}
+	if c.GtfsAPIURL != "" {
+		return c.GtfsAPIURL
+	}
+	if url := os.Getenv("GTFS_API_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+// runServe implements `departure-board serve`, the default: the board's web
+// server.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var configPath string
+	fs.StringVar(&configPath, "config", defaultConfigPath(), "config file to load ($CONFIG_PATH sets the default)")
+	if help, err := parseFlags(fs, args); help || err != nil {
+		return err
+	}
+
+	watcher := newConfigWatcher(configPath)
+	cfg, err := setupConfig(configPath)
+	if err != nil {
+		return err
 	}
 
 	port := cfg.Port
@@ -128,19 +638,215 @@ func main() {
 		}
 	}
 
-	apiURL := cfg.GtfsAPIURL
-	if apiURL == "" {
-		apiURL = os.Getenv("GTFS_API_URL")
-		if apiURL == "" {
-			apiURL = "http://localhost:8080"
+	apiURL := cfg.apiURL()
+
+	// ctx is cancelled by SIGINT or SIGTERM, which stops the background
+	// workers and shuts the server down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var bg sync.WaitGroup
+	background := func(run func()) {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			run()
+		}()
+	}
+
+	configureGTFSClient(cfg)
+	if err := cfg.startSources(ctx); err != nil {
+		return err
+	}
+	if f, ok := sources[localGTFSURL].(*gtfsFeed); ok {
+		background(func() { f.run(ctx) })
+	}
+
+	// Limited before the first request, stop validation included
+	if rl := cfg.RateLimit; rl.RequestsPerSecond > 0 {
+		gtfsTransport = newRateLimitTransport(gtfsTransport, rl)
+		log.Printf("GTFS API requests limited to %g a second (burst %d)", rl.RequestsPerSecond, rl.burst())
+	}
+
+	validateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	cfg.warnings = validateStops(validateCtx, apiURL, cfg)
+	cancel()
+	for _, w := range cfg.warnings {
+		log.Printf("config: %s", w)
+	}
+
+	if fi := cfg.FaultInjection; fi.Rate > 0 {
+		gtfsTransport = newFaultTransport(gtfsTransport, fi)
+		log.Printf("Fault injection enabled: %.0f%% of GTFS API requests will fail", fi.Rate*100)
+	}
+	var stats *backendStats
+	if cfg.Admin.Password != "" {
+		stats = newBackendStats()
+		gtfsTransport = &statsTransport{next: gtfsTransport, stats: stats}
+	}
+	if cfg.Retry.Enabled {
+		gtfsTransport = newRetryTransport(gtfsTransport, cfg.Retry)
+	}
+
+	cfg.startClients()
+
+	cache := newDepartureCache(departureCacheTTL)
+	if cfg.StaleWhileRevalidate.Enabled {
+		cache.maxStale = cfg.StaleWhileRevalidate.maxAge()
+	}
+	if cfg.History.Enabled {
+		cache.history, err = loadHistory(cfg.History, cfg.RouteAliases)
+		if err != nil {
+			return fmt.Errorf("failed to load history: %w", err)
+		}
+		http.HandleFunc("/api/history/export", buildHistoryExportHandler(cache.history))
+	}
+	background(func() { cache.prefetch(ctx, apiURL, cfg) })
+	p := &poller{cache: cache, apiURL: apiURL, cfg: cfg}
+	background(func() { p.run(ctx) })
+
+	live := newLiveConfig(cfg)
+	// apply makes next the running config, on a reload or an edit from
+	// /admin/trips.
+	apply := func(next Config) {
+		validateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		next.warnings = validateStops(validateCtx, apiURL, next)
+		cancel()
+		for _, w := range next.warnings {
+			log.Printf("config: %s", w)
+		}
+		next.startClients()
+		setUpstreamHeaders(next)
+		live.store(next)
+		p.setConfig(next)
+		background(func() { cache.prefetch(ctx, apiURL, next) })
+	}
+	background(func() {
+		watcher.run(ctx, func(next Config) {
+			apply(next)
+			log.Printf("config: reloaded %s", configPath)
+		})
+	})
+
+	if cfg.WebPush.Enabled {
+		push, err := newPushService(cfg.WebPush)
+		if err != nil {
+			return fmt.Errorf("failed to start web push: %w", err)
 		}
+		if cfg.Habits.Enabled {
+			push.habits, err = loadHabits(cfg.Habits)
+			if err != nil {
+				return fmt.Errorf("failed to load habits: %w", err)
+			}
+			http.HandleFunc("/api/seen", live.handler(func(cfg Config) http.HandlerFunc {
+				return buildSeenHandler(apiURL, cfg, cache, push.habits)
+			}))
+		}
+		background(func() { push.run(ctx, apiURL, live.Load, cache) })
+		http.HandleFunc("/push/key", buildPushKeyHandler(push))
+		http.HandleFunc("/push/subscribe", live.handler(func(cfg Config) http.HandlerFunc {
+			return buildPushSubscribeHandler(push, cfg)
+		}))
 	}
 
+	if cfg.MQTT.Enabled {
+		mqtt := newMQTTPublisher(cfg.MQTT)
+		background(func() { mqtt.run(ctx, apiURL, live.Load, cache) })
+	}
+
+	// The notifier checks notify: on each run, so it can be turned on by a
+	// reload.
+	notify := newNotifier()
+	background(func() { notify.run(ctx, apiURL, live.Load, cache) })
+
 	tmpl := parseTemplate()
-	http.HandleFunc("/", buildHandler(tmpl, apiURL, cfg))
+	http.HandleFunc("/", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildHandler(cfg.boardPage(tmpl), apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/trip/", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildHandler(cfg.boardPage(tmpl), apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/embed", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildEmbedHandler(cfg.boardPage(tmpl), apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/api/next", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildNextHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/api/board", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildBoardHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/api/departures", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildDeparturesHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/metrics", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildMetricsHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/print", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildPrintHandler(apiURL, cfg)
+	}))
+	http.HandleFunc("/week", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildWeekHandler(apiURL, cfg)
+	}))
+	http.HandleFunc("/announce", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildAnnounceHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/board.png", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildPNGHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/calendar.ics", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildCalendarHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/text", live.handler(func(cfg Config) http.HandlerFunc {
+		return buildTextHandler(apiURL, cfg, cache)
+	}))
+	http.HandleFunc("/sw.js", serviceWorkerHandler)
+	http.HandleFunc("/manifest.webmanifest", live.handler(buildManifestHandler))
+	for _, size := range iconSizes {
+		http.HandleFunc(fmt.Sprintf("/icon-%d.png", size), live.handler(func(cfg Config) http.HandlerFunc {
+			return buildIconHandler(cfg, size)
+		}))
+	}
+	stopSearch := buildStopSearchHandler(apiURL)
+	http.HandleFunc("/api/stops", stopSearch)
+	http.HandleFunc("/api/stops/search", stopSearch)
+	if stats != nil {
+		http.HandleFunc("/admin/status", requireAdmin(cfg.Admin, live.handler(func(cfg Config) http.HandlerFunc {
+			return buildAdminStatusHandler(apiURL, cfg, cache, p, stats)
+		})))
+		trips := &tripAdmin{live: live, apply: apply}
+		if cfg.Admin.Persist {
+			trips.path = configPath
+		}
+		http.HandleFunc("/admin/trips", requireAdmin(cfg.Admin, trips.handle))
+		http.HandleFunc("/admin/trips/", requireAdmin(cfg.Admin, trips.handle))
+		// The form shows the whole config, secrets included
+		if cfg.ConfigPreview {
+			preview := &configPreview{path: configPath, apiURL: apiURL, tmpl: tmpl}
+			http.HandleFunc("/preview", requireAdmin(cfg.Admin, preview.handleForm))
+			http.HandleFunc("/preview/board", requireAdmin(cfg.Admin, preview.handleBoard))
+			http.HandleFunc("/preview/promote", requireAdmin(cfg.Admin, preview.handlePromote))
+		}
+	}
 
-	log.Printf("departure board listening on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	srv := &http.Server{Addr: ":" + port, Handler: accessLog(requireAuth(live.Load, http.DefaultServeMux))}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if cfg.TLS.enabled() {
+		tlsConfig, err := startTLS(ctx, cfg.TLS, port, background)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+	log.Printf("departure board listening on :%s (%s)", port, scheme)
+	if err := serve(ctx, srv, ln, &bg); err != nil {
+		return err
+	}
+	log.Printf("stopped")
+	return nil
 }
 
 func loadConfig(path string) (Config, error) {
@@ -148,37 +854,298 @@ func loadConfig(path string) (Config, error) {
 	if err != nil {
 		return Config{}, fmt.Errorf("reading config: %w", err)
 	}
+	return parseConfig(data)
+}
+
+// startClients creates the clients for the optional live data sources the
+// config uses.
+func (c *Config) startClients() {
+	if c.BikeShare.FeedURL != "" {
+		c.bikes = newBikeShare(c.BikeShare)
+	}
+	if c.usesCarParks() {
+		c.carParks = newCarParks(c.ParkAndRide)
+	}
+	if c.Alerts.Enabled {
+		c.alerts = newServiceAlerts(c.Alerts)
+	}
+}
+
+func parseConfig(data []byte) (Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return Config{}, fmt.Errorf("parsing config: %w", err)
 	}
+	cfg.Trips = slices.DeleteFunc(cfg.Trips, func(trip TripConfig) bool { return trip.Disabled })
 	if len(cfg.Trips) == 0 {
 		return Config{}, fmt.Errorf("no trips defined in config")
 	}
+	locale, err := parseLocale(cfg.Locale)
+	if err != nil {
+		return Config{}, fmt.Errorf("locale: %w", err)
+	}
+	cfg.locale = locale
+	if cfg.WindowMinutes < 0 || cfg.WindowMinutes > maxWindowMinutes {
+		return Config{}, fmt.Errorf("window_minutes must be between 1 and %d", maxWindowMinutes)
+	}
+	if cfg.RefreshSeconds != 0 && (cfg.RefreshSeconds < minRefreshSeconds || cfg.RefreshSeconds > maxRefreshSeconds) {
+		return Config{}, fmt.Errorf("refresh_seconds must be between %d and %d", minRefreshSeconds, maxRefreshSeconds)
+	}
+	if cfg.Timezone != "" {
+		if cfg.loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return Config{}, fmt.Errorf("timezone: %w", err)
+		}
+	}
+	if err := cfg.GTFS.validate(); err != nil {
+		return Config{}, fmt.Errorf("gtfs: %w", err)
+	}
+	if err := cfg.SIRI.validate(); err != nil {
+		return Config{}, fmt.Errorf("siri: %w", err)
+	}
+	if cfg.GTFS.Static != "" && cfg.SIRI.URL != "" {
+		return Config{}, fmt.Errorf("gtfs and siri can't both be set")
+	}
+	if err := cfg.RouteColors.validate(); err != nil {
+		return Config{}, fmt.Errorf("route_colors: %w", err)
+	}
+	if err := cfg.Theme.validate(); err != nil {
+		return Config{}, fmt.Errorf("theme: %w", err)
+	}
+	if err := cfg.EInk.validate(); err != nil {
+		return Config{}, fmt.Errorf("eink: %w", err)
+	}
+	if err := cfg.Kiosk.validate(); err != nil {
+		return Config{}, fmt.Errorf("kiosk: %w", err)
+	}
+	if err := cfg.School.validate(); err != nil {
+		return Config{}, fmt.Errorf("school: %w", err)
+	}
+	for i, w := range cfg.Prewarm {
+		if err := w.validate(); err != nil {
+			return Config{}, fmt.Errorf("prewarm[%d]: %w", i, err)
+		}
+	}
+	for i, trip := range cfg.Trips {
+		if trip.Timezone != "" {
+			if cfg.Trips[i].loc, err = time.LoadLocation(trip.Timezone); err != nil {
+				return Config{}, fmt.Errorf("trip %q: timezone: %w", trip.Name, err)
+			}
+		}
+		if trip.WindowMinutes < 0 || trip.WindowMinutes > maxWindowMinutes {
+			return Config{}, fmt.Errorf("trip %q: window_minutes must be between 1 and %d", trip.Name, maxWindowMinutes)
+		}
+		if trip.MaxJourneyMinutes < 0 {
+			return Config{}, fmt.Errorf("trip %q: max_journey_minutes can't be negative", trip.Name)
+		}
+		switch trip.Sort {
+		case "", "arrival", "departure", "duration":
+		default:
+			return Config{}, fmt.Errorf("trip %q: sort %q must be arrival, departure or duration", trip.Name, trip.Sort)
+		}
+		if err := trip.validateVisibility(); err != nil {
+			return Config{}, fmt.Errorf("trip %q: %w", trip.Name, err)
+		}
+		if trip.RefreshSeconds != 0 && (trip.RefreshSeconds < minRefreshSeconds || trip.RefreshSeconds > maxRefreshSeconds) {
+			return Config{}, fmt.Errorf("trip %q: refresh_seconds must be between %d and %d", trip.Name, minRefreshSeconds, maxRefreshSeconds)
+		}
+		if trip.ArriveBy != "" {
+			if _, err := parseClock(trip.ArriveBy); err != nil {
+				return Config{}, fmt.Errorf("trip %q: arrive_by: %w", trip.Name, err)
+			}
+		}
+		if trip.BikeShare != nil && cfg.BikeShare.FeedURL == "" {
+			return Config{}, fmt.Errorf("trip %q: bike_share needs a top-level bike_share.feed_url", trip.Name)
+		}
+		if trip.Fallback != nil && trip.Fallback.DriveMinutes <= 0 {
+			return Config{}, fmt.Errorf("trip %q: fallback needs drive_minutes", trip.Name)
+		}
+	}
+	for i, ic := range cfg.Interchanges {
+		if err := ic.validate(); err != nil {
+			return Config{}, fmt.Errorf("interchanges[%d]: %w", i, err)
+		}
+	}
+	if cfg.MinConnectionBuffer < 0 {
+		return Config{}, fmt.Errorf("min_connection_buffer can't be negative")
+	}
+	if cfg.NextConnections < 0 || cfg.NextConnections > maxNextConnections {
+		return Config{}, fmt.Errorf("next_connections: %d is not between 0 and %d", cfg.NextConnections, maxNextConnections)
+	}
+	for _, trip := range cfg.Trips {
+		for _, route := range trip.Routes {
+			if route.MinConnectionBuffer < 0 {
+				return Config{}, fmt.Errorf("trip %q: route %q: min_connection_buffer can't be negative", trip.Name, route.RouteName)
+			}
+		}
+	}
+	if err := resolveRouteRefs(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := validateLegs(cfg.Trips); err != nil {
+		return Config{}, err
+	}
+	if err := validateServicePatterns(cfg.Trips); err != nil {
+		return Config{}, err
+	}
+	if err := resolveStopAliases(&cfg); err != nil {
+		return Config{}, err
+	}
+	cfg.Trips = expandReturnTrips(cfg.Trips)
+	for i, trip := range cfg.Trips {
+		for j, route := range trip.Routes {
+			cfg.Trips[i].Routes[j].window = cfg.windowMinutes(trip)
+			if route.GtfsAPIURL == "" {
+				cfg.Trips[i].Routes[j].GtfsAPIURL = trip.GtfsAPIURL
+				if route.GtfsAPIHeaders == nil {
+					cfg.Trips[i].Routes[j].GtfsAPIHeaders = trip.GtfsAPIHeaders
+				}
+			}
+		}
+	}
+	if err := cfg.Carbon.validate(cfg.Trips); err != nil {
+		return Config{}, fmt.Errorf("carbon: %w", err)
+	}
+	if err := cfg.Notify.validate(cfg.Trips); err != nil {
+		return Config{}, fmt.Errorf("notify: %w", err)
+	}
+	if cfg.ConfigPreview && cfg.Admin.Password == "" {
+		return Config{}, fmt.Errorf("config_preview: needs admin.password")
+	}
+	if cfg.Habits.Enabled && !cfg.WebPush.Enabled {
+		return Config{}, fmt.Errorf("habits: needs web_push.enabled to send alerts")
+	}
+	if err := cfg.MQTT.validate(); err != nil {
+		return Config{}, fmt.Errorf("mqtt: %w", err)
+	}
+	if err := cfg.Retry.validate(); err != nil {
+		return Config{}, fmt.Errorf("retry: %w", err)
+	}
+	if err := cfg.validateTimeouts(); err != nil {
+		return Config{}, err
+	}
+	if _, err := cfg.upstreamHeaders(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		return Config{}, fmt.Errorf("rate_limit: %w", err)
+	}
+	if err := cfg.FaultInjection.validate(); err != nil {
+		return Config{}, fmt.Errorf("fault_injection: %w", err)
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return Config{}, fmt.Errorf("auth: %w", err)
+	}
+	if err := cfg.TLS.validate(); err != nil {
+		return Config{}, fmt.Errorf("tls: %w", err)
+	}
+	if cfg.TemplatePath != "" {
+		if cfg.tmpl, err = loadTemplate(cfg.TemplatePath); err != nil {
+			return Config{}, fmt.Errorf("template_path: %w", err)
+		}
+	}
+	cfg.source = data
 	return cfg, nil
 }
 
 func parseTemplate() *template.Template {
-	return template.Must(template.New("board").Parse(boardTemplate))
+	return template.Must(template.New("board").Funcs(localeFuncs()).Parse(boardTemplate))
 }
 
-func buildHandler(tmpl *template.Template, apiURL string, cfg Config) http.HandlerFunc {
+// loadTemplate parses a board template from path, with the same functions
+// as the built-in one.
+func loadTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New("board").Funcs(localeFuncs()).Parse(string(data))
+}
+
+// boardPage returns the template_path template, or def when there is none.
+func (c Config) boardPage(def *template.Template) *template.Template {
+	if c.tmpl != nil {
+		return c.tmpl
+	}
+	return def
+}
+
+func buildHandler(tmpl *template.Template, apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
+		if r.URL.Path != "/" && !strings.HasPrefix(r.URL.Path, "/trip/") {
 			http.NotFound(w, r)
 			return
 		}
+		if prefersText(r) {
+			serveText(w, r, apiURL, cfg, cache)
+			return
+		}
 
-		now := time.Now().In(sydneyTZ)
-		data := PageData{Now: now, WindowMinutes: departureWindowMinutes}
+		renderBoard(w, r, tmpl, apiURL, cfg, cache)
+	}
+}
 
-		for _, trip := range cfg.Trips {
-			tv, err := buildTripView(r.Context(), apiURL, trip, now)
-			if err != nil {
-				data.Error = fmt.Sprintf("Failed to load trip %q: %v", trip.Name, err)
-				break
-			}
-			data.Trips = append(data.Trips, tv)
+func renderBoard(w http.ResponseWriter, r *http.Request, tmpl *template.Template, apiURL string, cfg Config, cache *departureCache) {
+	eink, err := einkPage(r, cfg.EInk)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.URL.Query().Get("trip")
+	if slug, ok := strings.CutPrefix(r.URL.Path, "/trip/"); ok {
+		key = slug
+	}
+	trips, active, ok := cfg.pageTrips(time.Now(), key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data := buildPageData(r.Context(), cache, apiURL, cfg, trips)
+	data.Active, data.Pinned = active, key
+	data.WebPush = cfg.WebPush.Enabled
+	data.Habits = cfg.Habits.Enabled
+	if cfg.Geolocation.Enabled && key == "" {
+		data.Geolocation = true
+		data.GeoMaxDistance = cfg.Geolocation.MaxDistance
+		if data.GeoMaxDistance <= 0 {
+			data.GeoMaxDistance = defaultGeolocationMaxDistance
+		}
+	}
+	if cfg.ClientRender {
+		data.ClientRender = true
+		data.Board = newBoard(data)
+	}
+	if kioskPage(r, cfg.Kiosk) {
+		data.setKiosk(cfg.Kiosk)
+	}
+	if eink != nil {
+		data.setEInk(eink)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl.Execute(w, data)
+}
+
+// buildEmbedHandler renders a single trip without the header and tabs, for
+// iframes and stream overlays. ?trip= selects the trip by index or name and
+// ?transparent=1 drops the page background.
+func buildEmbedHandler(tmpl *template.Template, apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trip, ok := selectTrip(cfg.Trips, r.URL.Query().Get("trip"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		eink, err := einkPage(r, cfg.EInk)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data := buildPageData(r.Context(), cache, apiURL, cfg, []TripConfig{trip})
+		data.Embed = true
+		data.Transparent = r.URL.Query().Get("transparent") == "1"
+		if eink != nil {
+			data.setEInk(eink)
 		}
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -186,99 +1153,490 @@ func buildHandler(tmpl *template.Template, apiURL string, cfg Config) http.Handl
 	}
 }
 
-func buildTripView(ctx context.Context, apiURL string, trip TripConfig, now time.Time) (TripView, error) {
-	tv := TripView{Name: trip.Name}
+func buildPageData(ctx context.Context, cache *departureCache, apiURL string, cfg Config, trips []TripConfig) PageData {
+	now := time.Now().In(boardTZ)
+	data := PageData{Now: now, WindowMinutes: cfg.windowMinutes(TripConfig{}), RefreshSeconds: cfg.refreshSeconds(TripConfig{}), Warnings: cfg.warnings, Theme: cfg.Theme}
+	for i, trip := range trips {
+		if r := cfg.refreshSeconds(trip); i == 0 || r < data.RefreshSeconds {
+			data.RefreshSeconds = r
+		}
+	}
+	data.Dark, data.ThemeChange = cfg.Theme.darkAt(now)
+	data.AutoDark = cfg.Theme.Mode == "auto"
 
-	for _, route := range trip.Routes {
-		deps, err := buildRouteDepartures(ctx, apiURL, route, now)
-		if err != nil {
-			return tv, fmt.Errorf("building route %q: %w", route.RouteName, err)
+	if cfg.BatchQueries {
+		cache.warm(ctx, tripQueries(apiURL, trips))
+	}
+
+	// Build the trips concurrently, up to maxParallelTrips at a time. A trip
+	// that fails doesn't cancel the others. The page is assembled in config
+	// order afterwards.
+	views := make([]TripView, len(trips))
+	errs := make([]error, len(trips))
+	var g errgroup.Group
+	g.SetLimit(maxParallelTrips)
+	for i, trip := range trips {
+		g.Go(func() error {
+			views[i], errs[i] = buildTripView(ctx, cache, apiURL, cfg, trip, now)
+			return nil
+		})
+	}
+	g.Wait()
+
+	// A trip that fails to load shows its error in its own tab, leaving the
+	// other trips' departures in place.
+	for i, tv := range views {
+		if errs[i] != nil {
+			log.Printf("trip %q: %v", trips[i].Name, errs[i])
+			tv = TripView{Name: trips[i].Name, Origins: tripOrigins(trips[i]), WindowMinutes: cfg.windowMinutes(trips[i]), RefreshSeconds: cfg.refreshSeconds(trips[i]), Error: fmt.Sprintf("Failed to load departures: %v", errs[i])}
+		}
+		if tv.WindowMinutes > hourGroupMinutes {
+			markHourGroups(tv.Departures)
 		}
-		tv.Departures = append(tv.Departures, deps...)
+		data.Trips = append(data.Trips, tv)
 	}
+	return data
+}
 
-	sort.Slice(tv.Departures, func(i, j int) bool {
-		return tv.Departures[i].finalArrivalSort.Before(tv.Departures[j].finalArrivalSort)
-	})
+// markHourGroups sets an hour header ("18:00", or "6 pm" with a 12-hour
+// locale) on each departure that starts a later departure hour, keeping long
+// lists scannable. The list is sorted by arrival (or the trip's sort), not
+// departure, so a departure from an hour already passed stays under the
+// current header rather than repeating an earlier one.
+func markHourGroups(deps []DepartureView) {
+	var last time.Time
+	for i := range deps {
+		at := deps[i].departureAt
+		if hour := displayLocale.Hour(at); last.IsZero() || hour != displayLocale.Hour(last) && at.After(last) {
+			deps[i].HourHeader = hour
+			last = at
+		}
+	}
+}
+
+// selectTrip finds a trip by index, by name or by slug ("to-work" for "To
+// Work"). An empty key selects the first trip.
+func selectTrip(trips []TripConfig, key string) (TripConfig, bool) {
+	if len(trips) == 0 {
+		return TripConfig{}, false
+	}
+	if key == "" {
+		return trips[0], true
+	}
+	if i, err := strconv.Atoi(key); err == nil {
+		if i < 0 || i >= len(trips) {
+			return TripConfig{}, false
+		}
+		return trips[i], true
+	}
+	for _, trip := range trips {
+		if strings.EqualFold(trip.Name, key) || tripSlug(trip.Name) == key {
+			return trip, true
+		}
+	}
+	return TripConfig{}, false
+}
+
+// tripSlug turns a trip name into its /trip/ path: lower case, with each run
+// of anything but letters and digits a single hyphen ("Home → Work" is
+// "home-work").
+func tripSlug(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
 
+func buildTripView(ctx context.Context, cache *departureCache, apiURL string, cfg Config, trip TripConfig, now time.Time) (TripView, error) {
+	// Times are shown in now's location
+	now = now.In(trip.timeZone())
+	target, arriving := trip.arriveByTarget(now)
+	built := trip
+	if arriving {
+		built = trip.lookingAhead(now, target)
+	}
+	tv, err := collectTripView(ctx, built, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		return buildRouteDepartures(ctx, cache, apiURL, cfg, route, now)
+	})
+	if err != nil {
+		return tv, err
+	}
+	tv.WindowMinutes = cfg.windowMinutes(trip)
+	tv.RefreshSeconds = cfg.refreshSeconds(trip)
+	if trip.MaxJourneyMinutes > 0 {
+		tv.Departures = withinJourneyTime(tv.Departures, trip.MaxJourneyMinutes)
+	}
+	if arriving {
+		tv.Departures = arriveBy(tv.Departures, target)
+		tv.ArriveBy = displayLocale.Clock(target)
+	}
+	if trip.BikeShare != nil && cfg.bikes != nil {
+		tv.Bikes, tv.CycleArrival = cfg.bikes.tripBikes(ctx, *trip.BikeShare, tv.Departures, now)
+	}
+	if cfg.carParks != nil {
+		tv.CarParks = cfg.carParks.tripCarParks(ctx, trip)
+	}
+	if cfg.alerts != nil {
+		tv.Alerts = cfg.alerts.tripAlerts(ctx, apiURL, trip, tv.Departures, now, tv.WindowMinutes)
+	}
+	if trip.Fallback != nil {
+		tv.Fallback = tripFallback(*trip.Fallback, tv.Departures, now)
+	}
+	if cache != nil {
+		if at, ok := cache.staleSince(tripQueries(apiURL, []TripConfig{trip})); ok {
+			tv.AsOf = displayLocale.Clock(at.In(now.Location()))
+			tv.UpdatedAgo = updatedAgo(at, now)
+		}
+	}
 	return tv, nil
 }
 
-func buildRouteDepartures(ctx context.Context, apiURL string, route RouteConfig, now time.Time) ([]DepartureView, error) {
-	hasTransfer := route.TransferArrivalStopID != ""
+const defaultFallbackLabel = "Taxi / rideshare"
 
-	// Determine the arrival stop for the first-leg query
-	var firstLegArrivalStop string
-	if hasTransfer {
-		firstLegArrivalStop = route.TransferArrivalStopID
-	} else {
-		firstLegArrivalStop = route.FinalArrivalStop
+// tripFallback suggests a taxi or rideshare when no departure in the window has
+// a viable connection, e.g. after the last service has gone.
+func tripFallback(cfg FallbackConfig, deps []DepartureView, now time.Time) *FallbackView {
+	for _, dv := range deps {
+		if dv.HasConnection {
+			return nil
+		}
 	}
+	label := cfg.Label
+	if label == "" {
+		label = defaultFallbackLabel
+	}
+	arrive := now.Add(time.Duration(cfg.DriveMinutes) * time.Minute)
+	return &FallbackView{Label: label, Link: cfg.Link, Arrive: displayLocale.Clock(arrive.In(now.Location()))}
+}
 
-	departures, err := fetchDepartures(ctx, apiURL, route.DepartureStopID, firstLegArrivalStop)
-	if err != nil {
-		return nil, fmt.Errorf("fetching departures for stop %s: %w", route.DepartureStopID, err)
+// maxParallelTrips and maxParallelRoutes bound how many trips of a page, and
+// routes of a trip, are built at once, so a large board doesn't open dozens
+// of upstream connections for one request.
+const (
+	maxParallelTrips  = 4
+	maxParallelRoutes = 8
+)
+
+// collectTripView builds the trip's routes concurrently and merges their
+// departures, one per service, in the trip's sort order (final arrival by
+// default). The first route to fail cancels the rest, through the context
+// build is given.
+func collectTripView(ctx context.Context, trip TripConfig, build func(ctx context.Context, route RouteConfig) ([]DepartureView, error)) (TripView, error) {
+	tv := TripView{Name: trip.Name, Origins: tripOrigins(trip), Chime: trip.Chime}
+
+	results := make([][]DepartureView, len(trip.Routes))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelRoutes)
+	for i, route := range trip.Routes {
+		g.Go(func() error {
+			deps, err := build(gctx, route)
+			if err != nil {
+				return fmt.Errorf("building route %q: %w", route.RouteName, err)
+			}
+			results[i] = deps
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return tv, err
 	}
 
-	// Filter first-leg departures by allowed services
-	if len(route.Leg1Services) > 0 {
-		filtered := departures[:0]
-		for _, d := range departures {
-			if matchesServices(d.RouteShortName, route.Leg1Services) {
-				filtered = append(filtered, d)
+	for i := range trip.Routes {
+		tv.Departures = append(tv.Departures, results[i]...)
+	}
+	tv.Departures = dedupeTrips(tv.Departures)
+
+	// Departures with an unknown connection go last, in departure order
+	sort.Slice(tv.Departures, func(i, j int) bool {
+		a, b := tv.Departures[i], tv.Departures[j]
+		if a.ConnectionUnknown != b.ConnectionUnknown {
+			return b.ConnectionUnknown
+		}
+		switch trip.Sort {
+		case "departure":
+			if la, lb := a.leaveTime(), b.leaveTime(); !la.Equal(lb) {
+				return la.Before(lb)
+			}
+		case "duration":
+			if a.JourneyMins != b.JourneyMins {
+				return a.JourneyMins < b.JourneyMins
+			}
+		}
+		return a.finalArrivalSort.Before(b.finalArrivalSort)
+	})
+
+	return tv, nil
+}
+
+// dedupeTrips keeps one departure per trip ID, for trips whose routes
+// overlap and find the same service more than once: the one that makes its
+// connection with the earliest final arrival.
+func dedupeTrips(deps []DepartureView) []DepartureView {
+	kept := deps[:0]
+	index := make(map[string]int)
+	for _, dv := range deps {
+		i, seen := index[dv.tripID]
+		switch {
+		case dv.tripID == "":
+			kept = append(kept, dv)
+		case !seen:
+			index[dv.tripID] = len(kept)
+			kept = append(kept, dv)
+		case dv.HasConnection != kept[i].HasConnection:
+			if dv.HasConnection {
+				kept[i] = dv
 			}
+		case dv.finalArrivalSort.Before(kept[i].finalArrivalSort):
+			kept[i] = dv
 		}
-		departures = filtered
 	}
+	return kept
+}
 
-	// If there's a transfer and the second leg requires transit (different stops),
-	// fetch departures for the connecting service
-	var transferDepartures []Departure
-	needsSecondLeg := hasTransfer && route.TransferDepartureStopID != route.FinalArrivalStop
-	if needsSecondLeg {
-		transferDepartures, err = fetchDepartures(ctx, apiURL, route.TransferDepartureStopID, route.FinalArrivalStop)
-		if err != nil {
-			return nil, fmt.Errorf("fetching transfer departures: %w", err)
+// leaveTime is when to set off for the departure: leave_at when the route
+// has an initial walk, else the departure itself.
+func (dv DepartureView) leaveTime() time.Time {
+	if !dv.leaveAt.IsZero() {
+		return dv.leaveAt
+	}
+	return dv.departureAt
+}
+
+// nextDeparture is the next viable departure: the first to leave of those
+// that make their connection. The list itself may be sorted by arrival or
+// journey time, and hold rows with unknown connections.
+func nextDeparture(deps []DepartureView) (DepartureView, bool) {
+	var next DepartureView
+	ok := false
+	for _, dv := range deps {
+		if dv.HasConnection && (!ok || dv.leaveTime().Before(next.leaveTime())) {
+			next, ok = dv, true
 		}
+	}
+	return next, ok
+}
 
-		// Filter second-leg departures by allowed services
-		if len(route.Leg2Services) > 0 {
-			filtered := transferDepartures[:0]
-			for _, d := range transferDepartures {
-				if matchesServices(d.RouteShortName, route.Leg2Services) {
-					filtered = append(filtered, d)
-				}
+// tripOrigins returns the distinct coordinates of the trip's departure stops,
+// skipping routes that don't configure any.
+func tripOrigins(trip TripConfig) []LatLon {
+	var origins []LatLon
+	seen := make(map[LatLon]bool)
+	for _, route := range trip.Routes {
+		if route.DepartureLat == 0 && route.DepartureLon == 0 {
+			continue
+		}
+		o := LatLon{Lat: route.DepartureLat, Lon: route.DepartureLon}
+		if !seen[o] {
+			seen[o] = true
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+func buildRouteDepartures(ctx context.Context, cache *departureCache, apiURL string, cfg Config, route RouteConfig, now time.Time) ([]DepartureView, error) {
+	fetch := func(stopID, arrivalStops string) ([]Departure, error) {
+		return cache.fetch(ctx, route.upstreamURL(apiURL), stopID, arrivalStops, route.upstreamMinutes())
+	}
+	deps, err := routeDepartures(cfg, route, now, now.Add(time.Duration(route.windowMinutes())*time.Minute), fetch)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.history.annotate(deps, route.DepartureStopID, cfg.History.abnormalMinutes())
+	}
+	return deps, nil
+}
+
+// routeDepartures builds the viable departures of a route leaving between now
+// and until, fetching each leg's departures with fetch.
+func routeDepartures(cfg Config, route RouteConfig, now, until time.Time, fetch func(stopID, arrivalStops string) ([]Departure, error)) ([]DepartureView, error) {
+	transfers := slices.Clone(route.transfers())
+
+	departures, err := fetch(route.DepartureStopID, legEnd(route, transfers, 0))
+	if err != nil {
+		return nil, fmt.Errorf("fetching departures for stop %s: %w", route.DepartureStopID, err)
+	}
+	normalizeDepartures(departures, cfg)
+	departures = cfg.School.filterSchoolServices(departures)
+	departures = filterServices(departures, route.Leg1Services)
+	departures = excludeServices(departures, route.Leg1ExcludeServices)
+	departures = filterHeadsigns(departures, route.Leg1Headsigns)
+
+	// Fetch the connecting services for each transfer that involves one
+	// (different stops), rather than just a walk
+	connecting := make([][]Departure, len(transfers))
+	for i, t := range transfers {
+		to := legEnd(route, transfers, i+1)
+		if t.TransferDepartureStopID != to {
+			deps, err := fetch(t.TransferDepartureStopID, to)
+			if err != nil {
+				return nil, fmt.Errorf("fetching transfer departures: %w", err)
 			}
-			transferDepartures = filtered
+			normalizeDepartures(deps, cfg)
+			deps = cfg.School.filterSchoolServices(deps)
+			deps = excludeServices(filterServices(deps, t.Services), t.ExcludeServices)
+			connecting[i] = filterHeadsigns(deps, t.Headsigns)
 		}
+
+		// Some interchanges need longer than the transfer_time
+		transfers[i].TransferTime = max(t.TransferTime, cfg.minConnection(t.TransferArrivalStopID, t.TransferDepartureStopID))
 	}
 
 	var result []DepartureView
 	for _, d := range departures {
 		depTime := effectiveDeparture(d)
-		if depTime.Before(now) || depTime.After(now.Add(departureWindowMinutes*time.Minute)) {
+		if depTime.Before(now) || depTime.After(until) {
 			continue
 		}
 
 		dv := toDepartureView(d, route, now)
+		dv.SchoolDaysOnly = cfg.School.outOfTerm(d)
+		dv.Carbon = cfg.Carbon.label(route)
 
-		if hasTransfer {
-			calcTransferArrival(&dv, d, route, transferDepartures, needsSecondLeg, now)
+		if len(transfers) > 0 {
+			calcTransferArrival(&dv, d, cfg, route, transfers, connecting, now)
 		} else {
 			calcDirectArrival(&dv, d, route, now)
 		}
+		cfg.RouteColors.apply(&dv)
+		if dv.HasConnection {
+			dv.setJourney()
+		}
 
-		// Only show departures with valid connections
+		// Only show departures with valid connections, unless unconfirmed
+		// ones are wanted (the arrival data may just be missing)
 		if dv.HasConnection {
 			result = append(result, dv)
+		} else if cfg.ShowUnknownConnections {
+			dv.ConnectionUnknown = true
+			dv.finalArrivalSort = dv.departureAt
+			result = append(result, dv)
 		}
 	}
 
 	return result, nil
 }
 
-func calcTransferArrival(dv *DepartureView, d Departure, route RouteConfig, transferDepartures []Departure, needsSecondLeg bool, now time.Time) {
-	transferArrival := findArrival(d, route.TransferArrivalStopID)
+// transfers returns the route's changes of service in travel order: its legs,
+// or the single change described by the transfer_* fields, if any.
+func (r RouteConfig) transfers() []LegConfig {
+	if len(r.Legs) > 0 {
+		return r.Legs
+	}
+	if r.TransferArrivalStopID == "" {
+		return nil
+	}
+	return []LegConfig{{
+		TransferArrivalStopID:   r.TransferArrivalStopID,
+		TransferTime:            r.TransferTime,
+		TransferDepartureStopID: r.TransferDepartureStopID,
+		TransferName:            r.TransferName,
+		Services:                r.Leg2Services,
+		ExcludeServices:         r.Leg2ExcludeServices,
+		Headsigns:               r.Leg2Headsigns,
+	}}
+}
+
+// transferNames joins the names of the route's changes, e.g. "Central →
+// Chatswood".
+func (r RouteConfig) transferNames() string {
+	if len(r.Legs) == 0 {
+		return r.TransferName
+	}
+	var names []string
+	for _, t := range r.Legs {
+		if t.TransferName != "" {
+			names = append(names, t.TransferName)
+		}
+	}
+	return strings.Join(names, " → ")
+}
+
+// legEnd returns the stop where the service ridden on leg i of a route is left,
+// counting the first service as leg 0: the arrival stop of the transfer that
+// follows it, or the final stop.
+func legEnd(route RouteConfig, transfers []LegConfig, i int) string {
+	if i < len(transfers) {
+		return transfers[i].TransferArrivalStopID
+	}
+	return route.FinalArrivalStop
+}
+
+func filterServices(departures []Departure, allowed []string) []Departure {
+	if len(allowed) == 0 {
+		return departures
+	}
+	filtered := departures[:0]
+	for _, d := range departures {
+		if matchesServices(d.RouteShortName, allowed) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// excludeServices drops the departures whose route is one of excluded.
+func excludeServices(departures []Departure, excluded []string) []Departure {
+	if len(excluded) == 0 {
+		return departures
+	}
+	filtered := departures[:0]
+	for _, d := range departures {
+		if !matchesServices(d.RouteShortName, excluded) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// filterHeadsigns keeps the departures whose headsign matches one of allowed,
+// or all of them when allowed is empty.
+func filterHeadsigns(departures []Departure, allowed []string) []Departure {
+	if len(allowed) == 0 {
+		return departures
+	}
+	filtered := departures[:0]
+	for _, d := range departures {
+		if matchesHeadsign(d.Headsign, allowed) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// matchesHeadsign reports whether headsign contains one of allowed, ignoring
+// case. An entry starting with "=" must be the whole headsign instead.
+func matchesHeadsign(headsign string, allowed []string) bool {
+	for _, a := range allowed {
+		if exact, ok := strings.CutPrefix(a, "="); ok {
+			if strings.EqualFold(headsign, exact) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(headsign), strings.ToLower(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// calcTransferArrival chains a first-leg departure through each transfer,
+// taking the first connecting service that can be made at each (with the
+// connection buffer to spare, if one can), and adds the final walk to the
+// last arrival.
+func calcTransferArrival(dv *DepartureView, d Departure, cfg Config, route RouteConfig, transfers []LegConfig, connecting [][]Departure, now time.Time) {
+	buffer := cfg.connectionBuffer(route)
+	transferArrival := findArrival(d, transfers[0].TransferArrivalStopID)
 	if transferArrival == nil {
 		dv.HasConnection = false
 		dv.FinalArrivalMins = "No connection"
@@ -286,33 +1644,81 @@ func calcTransferArrival(dv *DepartureView, d Departure, route RouteConfig, tran
 	}
 
 	arrTime := effectiveArrival(*transferArrival)
+	arrPlatform := transferArrival.PlatformCode
+	var legs []LegView
+	for i, t := range transfers {
+		earliestTransferDept := arrTime.Add(time.Duration(t.TransferTime) * time.Second)
+		to := legEnd(route, transfers, i+1)
+		if t.TransferDepartureStopID == to {
+			// Walk-only transfer
+			arrTime = earliestTransferDept
+			arrPlatform = ""
+			continue
+		}
 
-	if needsSecondLeg {
-		// Need a connecting service from transfer departure stop to final stop
-		earliestTransferDept := arrTime.Add(time.Duration(route.TransferTime) * time.Second)
-		connection := findConnection(transferDepartures, earliestTransferDept, route.FinalArrivalStop)
+		connection := findConnection(connecting[i], earliestTransferDept, to, buffer)
 		if connection == nil {
 			dv.HasConnection = false
 			dv.FinalArrivalMins = "No connection"
 			return
 		}
-		finalArr := connection.ArrivalTime.Add(time.Duration(route.FinalWalkTime) * time.Second)
-		dv.HasConnection = true
-		dv.FinalArrivalTime = finalArr.In(sydneyTZ).Format("15:04")
-		dv.FinalArrivalMins = formatMinsAway(finalArr, now)
-		dv.finalArrivalSort = finalArr
-		dv.SecondLegRouteShort = connection.RouteShortName
-		dv.SecondLegRouteColor = routeColor(connection.RouteShortName)
-		dv.SecondLegHeadsign = connection.Headsign
-		dv.TransferWaitMins = int(connection.DepartureTime.Sub(arrTime).Minutes())
-	} else {
-		// Walk-only transfer: arrival at transfer stop + transfer walk + final walk
-		finalArr := arrTime.Add(time.Duration(route.TransferTime+route.FinalWalkTime) * time.Second)
-		dv.HasConnection = true
-		dv.FinalArrivalTime = finalArr.In(sydneyTZ).Format("15:04")
-		dv.FinalArrivalMins = formatMinsAway(finalArr, now)
-		dv.finalArrivalSort = finalArr
+		legs = append(legs, LegView{
+			RouteShortName: connection.RouteShortName,
+			RouteColor:     connection.RouteColor,
+			RouteTextColor: connection.RouteTextColor,
+			Headsign:       connection.Headsign,
+			TransferName:   t.TransferName,
+			Platform:       connection.Platform,
+			WaitMins:       int(connection.DepartureTime.Sub(arrTime).Minutes()),
+			SpareMins:      int(connection.DepartureTime.Sub(earliestTransferDept).Minutes()),
+			Tight:          connection.Tight,
+			Next:           nextConnections(connecting[i][connection.index+1:], to, cfg.NextConnections, now.Location()),
+		})
+		dv.TightConnection = dv.TightConnection || connection.Tight
+		arrTime = connection.ArrivalTime
+		arrPlatform = connection.ArrivalPlatform
 	}
+
+	finalArr := arrTime.Add(time.Duration(route.FinalWalkTime) * time.Second)
+	dv.HasConnection = true
+	dv.FinalArrivalTime = displayLocale.Clock(finalArr.In(now.Location()))
+	dv.FinalArrivalMins = formatMinsAway(finalArr, now)
+	dv.finalArrivalSort = finalArr
+	dv.ArrivalPlatform = arrPlatform
+	dv.Connections = legs
+	if len(legs) > 0 {
+		dv.SecondLegRouteShort = legs[0].RouteShortName
+		dv.SecondLegRouteColor = legs[0].RouteColor
+		dv.SecondLegHeadsign = legs[0].Headsign
+		dv.TransferWaitMins = legs[0].WaitMins
+	}
+}
+
+// setJourney sets the journey time and summary of a departure with a known
+// connection.
+func (dv *DepartureView) setJourney() {
+	dv.JourneyMins = int(dv.finalArrivalSort.Sub(dv.leaveTime()).Round(time.Minute).Minutes())
+	changes := "direct"
+	switch n := len(dv.Connections); n {
+	case 0:
+	case 1:
+		changes = "1 change"
+	default:
+		changes = fmt.Sprintf("%d changes", n)
+	}
+	dv.JourneySummary = fmt.Sprintf("%d min · %s", dv.JourneyMins, changes)
+}
+
+// withinJourneyTime drops the departures whose journey takes more than
+// maxMins. Those without a known connection are kept.
+func withinJourneyTime(deps []DepartureView, maxMins int) []DepartureView {
+	kept := deps[:0]
+	for _, dv := range deps {
+		if !dv.HasConnection || dv.JourneyMins <= maxMins {
+			kept = append(kept, dv)
+		}
+	}
+	return kept
 }
 
 func calcDirectArrival(dv *DepartureView, d Departure, route RouteConfig, now time.Time) {
@@ -326,9 +1732,10 @@ func calcDirectArrival(dv *DepartureView, d Departure, route RouteConfig, now ti
 	arrTime := effectiveArrival(*finalArrival)
 	finalArr := arrTime.Add(time.Duration(route.FinalWalkTime) * time.Second)
 	dv.HasConnection = true
-	dv.FinalArrivalTime = finalArr.In(sydneyTZ).Format("15:04")
+	dv.FinalArrivalTime = displayLocale.Clock(finalArr.In(now.Location()))
 	dv.FinalArrivalMins = formatMinsAway(finalArr, now)
 	dv.finalArrivalSort = finalArr
+	dv.ArrivalPlatform = finalArrival.PlatformCode
 }
 
 func formatMinsAway(t time.Time, now time.Time) string {
@@ -343,6 +1750,15 @@ func formatMinsAway(t time.Time, now time.Time) string {
 	}
 }
 
+// updatedAgo describes how long before now at was: "just now" or "N min ago".
+func updatedAgo(at, now time.Time) string {
+	mins := int(now.Sub(at).Minutes())
+	if mins < 1 {
+		return "just now"
+	}
+	return fmt.Sprintf("%d min ago", mins)
+}
+
 func formatMinsAwayLabel(t time.Time, now time.Time) string {
 	mins := int(t.Sub(now).Minutes())
 	switch {
@@ -382,40 +1798,160 @@ type ConnectionResult struct {
 	DepartureTime  time.Time
 	ArrivalTime    time.Time
 	RouteShortName string
+	RouteColor     string
+	RouteTextColor string
 	Headsign       string
+	// Platform is where the connecting service leaves from, and
+	// ArrivalPlatform where it arrives.
+	Platform        string
+	ArrivalPlatform string
+	// Tight is set when the connection leaves with less than the buffer
+	// findConnection was asked for to spare.
+	Tight bool
+	// index is the connection's position in the departures searched.
+	index int
 }
 
-func findConnection(transferDepartures []Departure, earliestDept time.Time, finalStopID string) *ConnectionResult {
-	for _, td := range transferDepartures {
+// findConnection returns the first of transferDepartures leaving at or after
+// earliestDept that reaches finalStopID. It prefers one that leaves buffer or
+// more after earliestDept, falling back to a tight one.
+func findConnection(transferDepartures []Departure, earliestDept time.Time, finalStopID string, buffer time.Duration) *ConnectionResult {
+	if buffer > 0 {
+		if c := findConnection(transferDepartures, earliestDept.Add(buffer), finalStopID, 0); c != nil {
+			return c
+		}
+	}
+	for i, td := range transferDepartures {
 		tdTime := effectiveDeparture(td)
 		if tdTime.Before(earliestDept) {
 			continue
 		}
 		arr := findArrival(td, finalStopID)
 		if arr != nil {
+			color, textColor := departureColors(td)
 			return &ConnectionResult{
-				DepartureTime:  tdTime,
-				ArrivalTime:    effectiveArrival(*arr),
-				RouteShortName: td.RouteShortName,
-				Headsign:       td.Headsign,
+				DepartureTime:   tdTime,
+				ArrivalTime:     effectiveArrival(*arr),
+				RouteShortName:  td.RouteShortName,
+				RouteColor:      color,
+				RouteTextColor:  textColor,
+				Headsign:        td.Headsign,
+				Platform:        td.PlatformCode,
+				ArrivalPlatform: arr.PlatformCode,
+				Tight:           buffer > 0,
+				index:           i,
 			}
 		}
 	}
 	return nil
 }
 
+// nextConnections returns up to n of departures that reach finalStopID, the
+// services after a connection.
+func nextConnections(departures []Departure, finalStopID string, n int, loc *time.Location) []NextConnection {
+	var next []NextConnection
+	for _, td := range departures {
+		if len(next) == n {
+			break
+		}
+		arr := findArrival(td, finalStopID)
+		if arr == nil {
+			continue
+		}
+		color, textColor := departureColors(td)
+		next = append(next, NextConnection{
+			RouteShortName: td.RouteShortName,
+			RouteColor:     color,
+			RouteTextColor: textColor,
+			Platform:       td.PlatformCode,
+			DepartureTime:  displayLocale.Clock(effectiveDeparture(td).In(loc)),
+			ArrivalTime:    displayLocale.Clock(effectiveArrival(*arr).In(loc)),
+		})
+	}
+	return next
+}
+
+// normalizeDepartures applies the config's route_aliases and headsigns
+// rewrites to freshly fetched departures, so service filters, badges and
+// headsigns all see the display names.
+func normalizeDepartures(departures []Departure, cfg Config) {
+	if len(cfg.RouteAliases) == 0 && len(cfg.Headsigns) == 0 {
+		return
+	}
+	for i := range departures {
+		if alias, ok := cfg.RouteAliases[departures[i].RouteShortName]; ok {
+			departures[i].RouteShortName = alias
+		}
+		if short, ok := cfg.Headsigns[departures[i].Headsign]; ok {
+			departures[i].Headsign = short
+		}
+	}
+}
+
+// matchesServices reports whether routeShortName is one of allowed, or true
+// when allowed is empty. Entries can be globs ("T*", "37?") or, between
+// slashes, regular expressions ("/^B[0-9]+$/").
 func matchesServices(routeShortName string, allowed []string) bool {
 	if len(allowed) == 0 {
 		return true
 	}
 	for _, a := range allowed {
-		if a == routeShortName {
+		if matchesService(routeShortName, a) {
 			return true
 		}
 	}
 	return false
 }
 
+func matchesService(routeShortName, pattern string) bool {
+	if re, ok := serviceRegexp(pattern); ok {
+		return re != nil && re.MatchString(routeShortName)
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, routeShortName)
+		return ok
+	}
+	return pattern == routeShortName
+}
+
+// serviceRegexps caches the compiled regular expressions of service patterns.
+var serviceRegexps sync.Map
+
+// serviceRegexp compiles pattern if it's a /regular expression/, reporting
+// whether it is one. It returns nil for one that doesn't compile, which
+// parseConfig rejects.
+func serviceRegexp(pattern string) (*regexp.Regexp, bool) {
+	if len(pattern) < 2 || pattern[0] != '/' || pattern[len(pattern)-1] != '/' {
+		return nil, false
+	}
+	if re, ok := serviceRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), true
+	}
+	re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+	if err != nil {
+		return nil, true
+	}
+	serviceRegexps.Store(pattern, re)
+	return re, true
+}
+
+// validateServicePattern checks that a glob or regular expression service
+// pattern is well formed.
+func validateServicePattern(pattern string) error {
+	if _, ok := serviceRegexp(pattern); ok {
+		if _, err := regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+			return fmt.Errorf("service %q: %w", pattern, err)
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("service %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// routeColor is the built-in colour of a route: Sydney Metro teal for M
+// routes, light rail red for L routes and train blue otherwise.
 func routeColor(routeShortName string) string {
 	if strings.HasPrefix(routeShortName, "M") {
 		return "#168388"
@@ -441,32 +1977,91 @@ func toDepartureView(d Departure, route RouteConfig, now time.Time) DepartureVie
 		delayMins = *d.DelaySeconds / 60
 	}
 
-	return DepartureView{
+	// With a walk to the stop the countdown is to leaving, not departure.
+	countdown := depTime
+	if route.InitialWalkTime > 0 {
+		countdown = depTime.Add(-time.Duration(route.InitialWalkTime) * time.Second)
+	}
+
+	color, textColor := departureColors(d)
+	dv := DepartureView{
 		RouteShortName:   d.RouteShortName,
-		RouteColor:       routeColor(d.RouteShortName),
+		RouteColor:       color,
+		RouteTextColor:   textColor,
 		Headsign:         d.Headsign,
-		DepartureTime:    depTime.In(sydneyTZ).Format("15:04"),
-		MinutesAway:      formatMinsAway(depTime, now),
-		MinutesAwayLabel: formatMinsAwayLabel(depTime, now),
+		DepartureTime:    displayLocale.Clock(depTime.In(now.Location())),
+		Platform:         d.PlatformCode,
+		MinutesAway:      formatMinsAway(countdown, now),
+		MinutesAwayLabel: formatMinsAwayLabel(countdown, now),
 		IsRealtime:       isRealtime,
 		IsDelayed:        isDelayed,
 		DelayMinutes:     delayMins,
 		DepartureName:    route.DepartureName,
-		TransferName:     route.TransferName,
+		TransferName:     route.transferNames(),
 		ArrivalName:      route.ArrivalName,
+		departureAt:      depTime.In(now.Location()),
+		scheduledAt:      d.ScheduledDeparture,
+		departureStopID:  route.DepartureStopID,
+		tripID:           d.TripID,
 	}
+	if isRealtime && depTime.Sub(d.ScheduledDeparture).Abs() >= time.Minute {
+		if sched := displayLocale.Clock(d.ScheduledDeparture.In(now.Location())); sched != dv.DepartureTime {
+			dv.ScheduledTime = sched
+		}
+	}
+	if route.InitialWalkTime > 0 {
+		dv.LeaveBy = displayLocale.Clock(countdown.In(now.Location()))
+		dv.leaveAt = countdown.In(now.Location())
+	}
+
+	// On-demand services have a pickup window rather than a fixed time
+	if d.PickupWindowStart != nil && d.PickupWindowEnd != nil {
+		dv.IsOnDemand = true
+		dv.PickupWindow = displayLocale.Clock(d.PickupWindowStart.In(now.Location())) + "–" + displayLocale.Clock(d.PickupWindowEnd.In(now.Location()))
+	}
+	if d.BookingRequired {
+		dv.BookingNote = "Booking required"
+		if d.BookingNoticeMinutes > 0 {
+			dv.BookingNote = fmt.Sprintf("Book %d min ahead", d.BookingNoticeMinutes)
+		}
+	}
+
+	return dv
 }
 
-func fetchDepartures(ctx context.Context, apiURL, stopID, arrivalStops string) ([]Departure, error) {
-	url := fmt.Sprintf("%s/departures/arrivals?stop_id=%s&arrival_stops=%s", apiURL, stopID, arrivalStops)
+// fetchDepartures fetches the departures of the next hour, or of the next
+// minutes when that is set.
+func fetchDepartures(ctx context.Context, apiURL, stopID, arrivalStops string, minutes int) ([]Departure, error) {
+	return sourceFor(apiURL).Departures(ctx, stopID, arrivalStops, minutes)
+}
+
+// fetchDeparturesOn fetches every departure of one service day (date as
+// YYYY-MM-DD) rather than the next hour.
+func fetchDeparturesOn(ctx context.Context, apiURL, stopID, arrivalStops, date string) ([]Departure, error) {
+	return sourceFor(apiURL).DeparturesOn(ctx, stopID, arrivalStops, date)
+}
+
+func (s apiSource) Departures(ctx context.Context, stopID, arrivalStops string, minutes int) ([]Departure, error) {
+	url := fmt.Sprintf("%s/departures/arrivals?stop_id=%s&arrival_stops=%s", s, stopID, arrivalStops)
+	if minutes > 0 {
+		url += fmt.Sprintf("&minutes=%d", minutes)
+	}
+	return getDepartures(ctx, url)
+}
+
+func (s apiSource) DeparturesOn(ctx context.Context, stopID, arrivalStops, date string) ([]Departure, error) {
+	url := fmt.Sprintf("%s/departures/arrivals?stop_id=%s&arrival_stops=%s&date=%s", s, stopID, arrivalStops, date)
+	return getDepartures(ctx, url)
+}
 
+func getDepartures(ctx context.Context, url string) ([]Departure, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	setRequestID(req)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := gtfsClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -490,129 +2085,71 @@ func fetchDepartures(ctx context.Context, apiURL, stopID, arrivalStops string) (
 	return departures, nil
 }
 
-var boardTemplate = strings.TrimSpace(`
-<!DOCTYPE html>
-<html lang="en">
-<head>
-<meta charset="utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1">
-<meta name="theme-color" content="#e4e4e4">
-<meta http-equiv="refresh" content="30">
-<title>Departure Board</title>
-<link rel="preconnect" href="https://fonts.googleapis.com">
-<link rel="preconnect" href="https://fonts.gstatic.com" crossorigin>
-<link href="https://fonts.googleapis.com/css2?family=IBM+Plex+Sans:ital,wght@0,100..700;1,100..700&display=swap" rel="stylesheet">
-<style>
-:root{--accent-color: #ea580c;--bg-color: #fafafa;--header-bg-color: #e4e4e4; --text-color: #1a1a1a; --secondary-text-color: #555}
-*{margin:0;padding:0;box-sizing:border-box}
-body{font-family:"IBM Plex Sans",system-ui,-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;background:var(--bg-color);color:var(--text-color);min-height:100vh}
-.topbar{background:var(--header-bg-color);padding-left:16px;padding-right:16px;display:flex;align-items:center;}
-.hdr{justify-content:space-between;padding-top:16px;padding-bottom:16px}
-.hdr h1{font-size:16px;font-weight:600}
-.hdr .time{font-size:13px;color:var(--secondary-text-color)}
-.tabs{gap:16px;justify-content:flex-start;overflow-x:auto;padding-top:0;padding-bottom:2px}
-.tab{padding:10px 0px;font-size:14px;font-weight:400;cursor:pointer;border-bottom:2px solid transparent;margin-bottom:-2px;white-space:nowrap;user-select:none}
-.tab.active{font-weight:700;border-bottom-color:var(--accent-color)}
-.trip{display:none}
-.trip.active{display:block}
-.dep{border-bottom:1px solid var(--header-bg-color)}
-.dep-row{display:flex;align-items:flex-start;padding:12px 16px;gap:16px}
-.route{color:var(--bg-color);font-weight:700;font-size:14px;padding:4px 8px;border-radius:4px;min-width:44px;text-align:center;flex-shrink:0}
-.info{flex-grow:3;flex-basis:70%;flex-shrink:1;display:flex;flex-direction:column;align-items:center;gap:8px;min-width:0}
-.info-top{display:flex;gap:8px;align-items:center;width:100%}
-.info-bottom{display:flex;gap:8px;align-items:center;width:100%}
-.route-details{font-size:13px;font-weight:400;white-space:nowrap;overflow:hidden;text-overflow:ellipsis}
-.sched{font-size:12px;opacity:.6;margin-top:2px}
-.sched .delay{color:#ff6b6b;opacity:1}
-.deptime{display:flex;flex-direction:row;align-items:center;gap:8px;width:50px;flex-shrink:0}
-.depindicator{width:8px;height:8px;border-radius:50%;background:var(--secondary-text-color)}
-.rt{background:#4ecca3}
-.delay{background:#ff6b6b}
-.mindep{display:flex;flex-direction:column;align-items:center}
-.minval{font-size:24px;font-weight:700}
-.minlabel{font-size:12px;color:var(--secondary-text-color)}
-.times{text-align:right;flex-grow:1;flex-basis:15%;flex-shrink:0;min-width:60px}
-.times .time{font-size:20px;font-weight:500}
-.times .lbl{font-size:12px;color:var(--secondary-text-color)}
-.transfer-wait{font-size:12px;color:var(--secondary-text-color);font-weight:500}
-.empty{padding:48px 16px;text-align:center;opacity:.5;font-size:14px}
-.err{padding:24px 16px;text-align:center;color:#ff6b6b;font-size:14px}
-@media (max-width: 540px) {
-	.departs{display:none}
-}
-</style>
-</head>
-<body>
-  <div class="topbar hdr">
-    <h1>Departure Board</h1>
-  	<span class="time">{{.Now.Format "15:04"}}</span>
-  </div>
-
-  {{if .Error}} 
-  <div class="err">
-    {{.Error}}
-  </div>
-  {{else}}
-
-  <div class="topbar tabs">
-  	{{range $i, $t := .Trips}}
-  	<div class="tab{{if eq $i 0}} active{{end}}" onclick="switchTab({{$i}})">{{$t.Name}}</div>
-  	{{end}}
-  </div>
-  
-
-{{range $i, $t := .Trips}}
-<div class="trip{{if eq $i 0}} active{{end}}" id="trip-{{$i}}">
-  {{if not $t.Departures}}
-    <div class="empty">No departures in next {{$.WindowMinutes}} min</div>
-  {{else}}
-    {{range $t.Departures}}
-    <div class="dep">
-    	<div class="dep-row">
-			<div class="deptime">
-				<div class="depindicator{{if .IsRealtime}} rt{{end}} {{if .IsDelayed}} delay{{end}}"></div>
-				<div class="mindep">
-					<span class="minval">{{.MinutesAway}}</span>
-					<span class="minlabel">{{.MinutesAwayLabel}}</span>
-					</div>
-			</div>
-    		<div class="info">
-				<div class="info-top">
-					<div class="route" style="background:{{.RouteColor}}">{{.RouteShortName}}</div>
-					{{if .SecondLegRouteShort}}<span class="transfer-wait">{{.TransferWaitMins}}m</span><div class="route" style="background:{{.SecondLegRouteColor}}">{{.SecondLegRouteShort}}</div>{{end}}
-				</div>
-				<div class="info-bottom">
-	        		<div class="route-details">{{.DepartureName}} →
-					{{if .TransferName}}{{.TransferName}} →{{end}}
-					{{.ArrivalName}}
-					</div>
-				</div>
-        	</div>
-        	<div class="times departs">
-          		<div class="lbl">Departs</div>
-		  		<div class="time">{{.DepartureTime}}</div>
-        	</div>
-        	<div class="times">
-          		<div class="lbl">Arrives</div>
-          		<div class="time">{{.FinalArrivalTime}}</div>
-        	</div>
-    	</div>
-    </div>
-    {{end}}
-  {{end}}
-</div>
-{{end}}
-<script>
-function switchTab(idx){
-  document.querySelectorAll('.tab').forEach(function(t,i){t.classList.toggle('active',i===idx)});
-  document.querySelectorAll('.trip').forEach(function(t,i){t.classList.toggle('active',i===idx)});
-  try{localStorage.setItem('activeTab',idx)}catch(e){}
-}
-(function(){
-  try{var s=localStorage.getItem('activeTab');if(s!==null)switchTab(parseInt(s))}catch(e){}
-})();
-</script>
-{{end}}
-</body>
-</html>
-`)
+// fetchDeparturesBatch asks the upstream for several stop queries in one POST
+// to /departures/arrivals/batch. The response holds each query's departures in
+// request order. Other sources answer the queries one by one.
+func fetchDeparturesBatch(ctx context.Context, apiURL string, queries []stopQuery) ([][]Departure, error) {
+	if src := sourceFor(apiURL); src != apiSource(apiURL) {
+		results := make([][]Departure, len(queries))
+		for i, q := range queries {
+			deps, err := src.Departures(ctx, q.stopID, q.arrivalStops, q.minutes)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = deps
+		}
+		return results, nil
+	}
+	type batchQuery struct {
+		StopID       string `json:"stop_id"`
+		ArrivalStops string `json:"arrival_stops"`
+		Minutes      int    `json:"minutes,omitempty"`
+	}
+	body := make([]batchQuery, len(queries))
+	for i, q := range queries {
+		body[i] = batchQuery{q.stopID, q.arrivalStops, q.minutes}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/departures/arrivals/batch", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setRequestID(req)
+
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error != "" {
+			return nil, fmt.Errorf("API error: %s", apiErr.Error)
+		}
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var results [][]Departure
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if len(results) != len(queries) {
+		return nil, fmt.Errorf("batch returned %d results for %d queries", len(results), len(queries))
+	}
+	return results, nil
+}
+
+// boardTemplate is the board page. template_path replaces it with a file
+// laid out the same way.
+//
+//go:embed templates/board.html
+var boardTemplate string
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -65,7 +70,7 @@ func TestLoadConfig_MissingFile(t *testing.T) {
 }
 
 func TestToDepartureView(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 	future := now.Add(12 * time.Minute)
 	delay := 120
 
@@ -105,8 +110,56 @@ func TestToDepartureView(t *testing.T) {
 	}
 }
 
+func TestToDepartureView_InitialWalk(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	d := Departure{RouteShortName: "333", ScheduledDeparture: now.Add(12 * time.Minute)}
+
+	view := toDepartureView(d, RouteConfig{InitialWalkTime: 300}, now)
+	if view.MinutesAway != "7" || view.LeaveBy != "08:07" {
+		t.Errorf("expected to leave in 7 mins at 08:07, got %s at %q", view.MinutesAway, view.LeaveBy)
+	}
+	if view.DepartureTime != "08:12" {
+		t.Errorf("expected the departure time unchanged, got %s", view.DepartureTime)
+	}
+
+	view = toDepartureView(d, RouteConfig{}, now)
+	if view.MinutesAway != "12" || view.LeaveBy != "" {
+		t.Errorf("expected a departure countdown without a walk, got %s and %q", view.MinutesAway, view.LeaveBy)
+	}
+
+	board := newBoard(PageData{Now: now, Trips: []TripView{{Departures: []DepartureView{toDepartureView(d, RouteConfig{InitialWalkTime: 300}, now)}}}})
+	if at := board.Trips[0].Departures[0].LeavesAt; at == nil || !at.Equal(now.Add(7*time.Minute)) {
+		t.Errorf("expected leaves_at 08:07, got %v", at)
+	}
+}
+
+func TestToDepartureView_ScheduledTime(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	sched := now.Add(10 * time.Minute)
+	for _, c := range []struct {
+		realtime time.Duration
+		want     string
+	}{
+		{3 * time.Minute, "08:10"},
+		{-2 * time.Minute, "08:10"},
+		{40 * time.Second, ""},
+		{0, ""},
+	} {
+		rt := sched.Add(c.realtime)
+		view := toDepartureView(Departure{RouteShortName: "T1", ScheduledDeparture: sched, RealtimeDeparture: &rt}, RouteConfig{}, now)
+		if view.ScheduledTime != c.want {
+			t.Errorf("realtime %v off: expected scheduled time %q, got %q", c.realtime, c.want, view.ScheduledTime)
+		}
+	}
+
+	view := toDepartureView(Departure{RouteShortName: "T1", ScheduledDeparture: sched}, RouteConfig{}, now)
+	if view.ScheduledTime != "" {
+		t.Errorf("expected no scheduled time without realtime, got %q", view.ScheduledTime)
+	}
+}
+
 func TestToDepartureView_Now(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 	past := now.Add(-1 * time.Minute)
 
 	d := Departure{
@@ -148,7 +201,7 @@ func TestFindArrival(t *testing.T) {
 }
 
 func TestFindConnection(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	transferDeps := []Departure{
 		{
@@ -167,7 +220,7 @@ func TestFindConnection(t *testing.T) {
 
 	// Should find second departure (first is too early)
 	earliest := now.Add(15 * time.Minute)
-	conn := findConnection(transferDeps, earliest, "300")
+	conn := findConnection(transferDeps, earliest, "300", 0)
 	if conn == nil {
 		t.Fatal("expected to find connection")
 	}
@@ -177,18 +230,119 @@ func TestFindConnection(t *testing.T) {
 	}
 
 	// No connection available
-	conn = findConnection(transferDeps, now.Add(60*time.Minute), "300")
+	conn = findConnection(transferDeps, now.Add(60*time.Minute), "300", 0)
 	if conn != nil {
 		t.Error("expected no connection")
 	}
 
 	// Wrong stop
-	conn = findConnection(transferDeps, now, "999")
+	conn = findConnection(transferDeps, now, "999", 0)
 	if conn != nil {
 		t.Error("expected no connection for wrong stop")
 	}
 }
 
+func TestFindConnection_Buffer(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return now.Add(time.Duration(mins) * time.Minute) }
+	transferDeps := []Departure{
+		{RouteShortName: "T1", ScheduledDeparture: at(11), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(20)}}},
+		{RouteShortName: "T1", ScheduledDeparture: at(15), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(24)}}},
+	}
+
+	// The 11 leaves a minute after the earliest the connection can be made,
+	// short of the 3 minute buffer, so the 15 is preferred
+	conn := findConnection(transferDeps, at(10), "300", 3*time.Minute)
+	if conn == nil || !conn.DepartureTime.Equal(at(15)) || conn.Tight {
+		t.Fatalf("expected the 15 with time to spare, got %+v", conn)
+	}
+
+	conn = findConnection(transferDeps[:1], at(10), "300", 3*time.Minute)
+	if conn == nil || !conn.DepartureTime.Equal(at(11)) || !conn.Tight {
+		t.Fatalf("expected a tight connection to the 11, got %+v", conn)
+	}
+
+	route := RouteConfig{DepartureStopID: "100", TransferArrivalStopID: "200", TransferTime: 120, TransferDepartureStopID: "201", FinalArrivalStop: "300", MinConnectionBuffer: 180}
+	first := Departure{RouteShortName: "333", ScheduledDeparture: at(1), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(8)}}}
+	var dv DepartureView
+	calcTransferArrival(&dv, first, Config{}, route, route.transfers(), [][]Departure{transferDeps[:1]}, now)
+	if !dv.TightConnection || len(dv.Connections) != 1 || !dv.Connections[0].Tight || dv.Connections[0].SpareMins != 1 || dv.Connections[0].WaitMins != 3 {
+		t.Errorf("expected a tight connection with a minute to spare, got %+v", dv.Connections)
+	}
+}
+
+func TestRouteDepartures_NextConnections(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return now.Add(time.Duration(mins) * time.Minute) }
+	responses := map[string][]Departure{
+		"100|200": {{RouteShortName: "333", ScheduledDeparture: at(5), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(15)}}}},
+		"201|300": {
+			{RouteShortName: "T1", ScheduledDeparture: at(18), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(30)}}},
+			{RouteShortName: "T1", ScheduledDeparture: at(21), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(33)}}},
+			{RouteShortName: "T9", ScheduledDeparture: at(23)},
+			{RouteShortName: "T1", ScheduledDeparture: at(24), PlatformCode: "2", Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(36)}}},
+			{RouteShortName: "T1", ScheduledDeparture: at(27), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(39)}}},
+		},
+	}
+	fetch := func(stopID, arrivalStops string) ([]Departure, error) {
+		return slices.Clone(responses[stopID+"|"+arrivalStops]), nil
+	}
+	route := RouteConfig{DepartureStopID: "100", TransferArrivalStopID: "200", TransferTime: 120, TransferDepartureStopID: "201", TransferName: "Central", FinalArrivalStop: "300"}
+
+	deps, err := routeDepartures(Config{NextConnections: 2}, route, now, at(60), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || len(deps[0].Connections) != 1 {
+		t.Fatalf("expected one departure with one connection, got %+v", deps)
+	}
+	next := deps[0].Connections[0].Next
+	if len(next) != 2 || next[0].DepartureTime != "08:21" || next[0].ArrivalTime != "08:33" || next[1].DepartureTime != "08:24" || next[1].Platform != "2" {
+		t.Errorf("expected the 08:21 and 08:24 after the 08:18, skipping the T9 that doesn't reach 300, got %+v", next)
+	}
+	if !deps[0].HasNextConnections() {
+		t.Error("expected next connections")
+	}
+
+	deps, _ = routeDepartures(Config{}, route, now, at(60), fetch)
+	if deps[0].HasNextConnections() {
+		t.Error("expected no next connections without next_connections")
+	}
+}
+
+func TestRouteDepartures_Journey(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return now.Add(time.Duration(mins) * time.Minute) }
+	responses := map[string][]Departure{
+		"100|200": {{RouteShortName: "333", ScheduledDeparture: at(10), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(20)}}}},
+		"201|300": {{RouteShortName: "T1", ScheduledDeparture: at(25), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(35)}}}},
+		"100|300": {{RouteShortName: "X1", ScheduledDeparture: at(12), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(50)}}}},
+	}
+	fetch := func(stopID, arrivalStops string) ([]Departure, error) {
+		return slices.Clone(responses[stopID+"|"+arrivalStops]), nil
+	}
+
+	// Leave at 08:05 for the 08:10, change at 200, arrive 08:35 and walk 3 min
+	route := RouteConfig{DepartureStopID: "100", InitialWalkTime: 300, TransferArrivalStopID: "200", TransferTime: 120, TransferDepartureStopID: "201", FinalArrivalStop: "300", FinalWalkTime: 180}
+	deps, err := routeDepartures(Config{}, route, now, at(60), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || deps[0].JourneyMins != 33 || deps[0].JourneySummary != "33 min · 1 change" {
+		t.Fatalf("expected a 33 min journey with a change, got %+v", deps)
+	}
+
+	deps, _ = routeDepartures(Config{}, RouteConfig{DepartureStopID: "100", FinalArrivalStop: "300"}, now, at(60), fetch)
+	if len(deps) != 1 || deps[0].JourneySummary != "38 min · direct" {
+		t.Fatalf("expected a direct 38 min journey, got %+v", deps)
+	}
+
+	kept := withinJourneyTime([]DepartureView{{HasConnection: true, JourneyMins: 33}, {HasConnection: true, JourneyMins: 38}, {ConnectionUnknown: true}}, 35)
+	if len(kept) != 2 || kept[0].JourneyMins != 33 || !kept[1].ConnectionUnknown {
+		t.Errorf("expected the 38 min journey left out, got %+v", kept)
+	}
+}
+
 func TestEffectiveDeparture(t *testing.T) {
 	now := time.Now()
 	rt := now.Add(5 * time.Minute)
@@ -256,7 +410,7 @@ func newMockAPI(t *testing.T, responses map[string][]Departure) *httptest.Server
 }
 
 func TestHandler_DirectTrip(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	responses := map[string][]Departure{
 		"100": {
@@ -291,7 +445,7 @@ func TestHandler_DirectTrip(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -317,7 +471,7 @@ func TestHandler_DirectTrip(t *testing.T) {
 }
 
 func TestHandler_TransferTrip(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	responses := map[string][]Departure{
 		// Departures from origin
@@ -369,7 +523,7 @@ func TestHandler_TransferTrip(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -391,8 +545,50 @@ func TestHandler_TransferTrip(t *testing.T) {
 	}
 }
 
+func TestHandler_Platforms(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{
+			TripID: "trip1", RouteShortName: "T1", ScheduledDeparture: now.Add(5 * time.Minute), PlatformCode: "3",
+			Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: now.Add(15 * time.Minute), PlatformCode: "18"}},
+		}},
+		"201": {{
+			TripID: "trip2", RouteShortName: "T2", ScheduledDeparture: now.Add(20 * time.Minute), PlatformCode: "16",
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(35 * time.Minute), PlatformCode: "2"}},
+		}},
+	})
+	defer mock.Close()
+
+	route := RouteConfig{DepartureStopID: "100", TransferArrivalStopID: "200", TransferTime: 300, TransferDepartureStopID: "201", FinalArrivalStop: "300"}
+	tv, err := buildTripView(context.Background(), nil, mock.URL, Config{}, TripConfig{Routes: []RouteConfig{route}}, now)
+	if err != nil || len(tv.Departures) != 1 {
+		t.Fatalf("expected one departure, got %v, %v", tv.Departures, err)
+	}
+	dv := tv.Departures[0]
+	if dv.Platform != "3" || dv.Connections[0].Platform != "16" || dv.ArrivalPlatform != "2" {
+		t.Errorf("expected platforms 3, 16 and 2, got %q, %q and %q", dv.Platform, dv.Connections[0].Platform, dv.ArrivalPlatform)
+	}
+
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, PageData{Now: now, Trips: []TripView{tv}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<span class="platform">Plat 3</span>`, `<span class="platform">Plat 16</span>`, `<div class="lbl">Plat 2</div>`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected %s in the page", want)
+		}
+	}
+
+	// Walking the last stretch means the board can't say where you arrive.
+	route.TransferDepartureStopID = "300"
+	tv, _ = buildTripView(context.Background(), nil, mock.URL, Config{}, TripConfig{Routes: []RouteConfig{route}}, now)
+	if len(tv.Departures) != 1 || tv.Departures[0].ArrivalPlatform != "" {
+		t.Errorf("expected no arrival platform after a walk, got %+v", tv.Departures)
+	}
+}
+
 func TestHandler_NoConnection(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	responses := map[string][]Departure{
 		"100": {
@@ -426,7 +622,7 @@ func TestHandler_NoConnection(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -466,7 +662,7 @@ func TestHandler_APIError(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -478,6 +674,51 @@ func TestHandler_APIError(t *testing.T) {
 	}
 }
 
+func TestHandler_TripErrorIsolated(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	responses := map[string][]Departure{
+		"100": {{
+			RouteShortName:     "T1",
+			ScheduledDeparture: now.Add(10 * time.Minute),
+			Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+		}},
+	}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stop_id") == "900" {
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]string{"error": "bus feed down"})
+			return
+		}
+		json.NewEncoder(w).Encode(responses[r.URL.Query().Get("stop_id")])
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "Bus", Routes: []RouteConfig{{DepartureStopID: "900", FinalArrivalStop: "901"}}},
+			{Name: "Train", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		},
+	}
+
+	data := buildPageData(context.Background(), nil, mock.URL, cfg, cfg.Trips)
+	if len(data.Trips) != 2 {
+		t.Fatalf("expected both trips, got %d", len(data.Trips))
+	}
+	if !strings.Contains(data.Trips[0].Error, "bus feed down") {
+		t.Errorf("expected the bus trip to carry its error, got %q", data.Trips[0].Error)
+	}
+	if data.Trips[1].Error != "" || len(data.Trips[1].Departures) != 1 {
+		t.Errorf("expected the train trip to load, got %+v", data.Trips[1])
+	}
+
+	w := httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<div class="err">Failed to load departures`) || !strings.Contains(body, ">T1</div>") {
+		t.Error("expected the bus error banner alongside the train departures")
+	}
+}
+
 func TestHandler_NotFound(t *testing.T) {
 	cfg := Config{
 		Trips: []TripConfig{
@@ -495,7 +736,7 @@ func TestHandler_NotFound(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, "http://localhost:9999", cfg)
+	handler := buildHandler(tmpl, "http://localhost:9999", cfg, nil)
 
 	req := httptest.NewRequest("GET", "/other", nil)
 	w := httptest.NewRecorder()
@@ -507,7 +748,7 @@ func TestHandler_NotFound(t *testing.T) {
 }
 
 func TestHandler_MultipleTabs(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	responses := map[string][]Departure{
 		"100": {
@@ -561,7 +802,7 @@ func TestHandler_MultipleTabs(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -597,8 +838,34 @@ func TestMatchesServices(t *testing.T) {
 	}
 }
 
+func TestMatchesServices_Patterns(t *testing.T) {
+	for _, c := range []struct {
+		route   string
+		allowed []string
+		want    bool
+	}{
+		{"T8", []string{"T*"}, true},
+		{"M1", []string{"T*"}, false},
+		{"370", []string{"37?"}, true},
+		{"B1", []string{"/^B[0-9]+$/"}, true},
+		{"BX1", []string{"/^B[0-9]+$/"}, false},
+		{"T*", []string{"T1"}, false},
+	} {
+		if got := matchesServices(c.route, c.allowed); got != c.want {
+			t.Errorf("matchesServices(%q, %v) = %v, want %v", c.route, c.allowed, got, c.want)
+		}
+	}
+
+	for _, pattern := range []string{"/^B[0-9+$/", "T[1"} {
+		yaml := "trips:\n  - name: A\n    routes:\n      - departure_stop_id: \"1\"\n        final_arrival_stop: \"2\"\n        leg_1_services: [\"" + pattern + "\"]\n"
+		if _, err := parseConfig([]byte(yaml)); err == nil {
+			t.Errorf("expected %q to be rejected", pattern)
+		}
+	}
+}
+
 func TestHandler_ServiceFilter(t *testing.T) {
-	now := time.Now().In(sydneyTZ)
+	now := time.Now().In(boardTZ)
 
 	responses := map[string][]Departure{
 		"100": {
@@ -641,7 +908,7 @@ func TestHandler_ServiceFilter(t *testing.T) {
 	}
 
 	tmpl := parseTemplate()
-	handler := buildHandler(tmpl, mock.URL, cfg)
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -655,3 +922,859 @@ func TestHandler_ServiceFilter(t *testing.T) {
 		t.Error("expected 333 to be filtered out")
 	}
 }
+
+func TestExcludeServices(t *testing.T) {
+	deps := []Departure{{RouteShortName: "333"}, {RouteShortName: "X84"}, {RouteShortName: "380"}}
+	var got []string
+	for _, d := range excludeServices(deps, []string{"X84"}) {
+		got = append(got, d.RouteShortName)
+	}
+	if strings.Join(got, ",") != "333,380" {
+		t.Errorf("expected X84 left out, got %v", got)
+	}
+	if len(excludeServices(deps[:2], nil)) != 2 {
+		t.Error("expected nothing left out without exclusions")
+	}
+}
+
+func TestMatchesHeadsign(t *testing.T) {
+	for _, c := range []struct {
+		headsign string
+		allowed  []string
+		want     bool
+	}{
+		{"City via Oxford St", []string{"city"}, true},
+		{"Bondi Junction", []string{"city", "Central"}, false},
+		{"City via Oxford St", []string{"=City"}, false},
+		{"city", []string{"=City"}, true},
+	} {
+		if got := matchesHeadsign(c.headsign, c.allowed); got != c.want {
+			t.Errorf("matchesHeadsign(%q, %v) = %v, want %v", c.headsign, c.allowed, got, c.want)
+		}
+	}
+}
+
+func TestRouteDepartures_HeadsignFilters(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return now.Add(time.Duration(mins) * time.Minute) }
+
+	// Both directions of each route call at the shared stops
+	responses := map[string][]Departure{
+		"100|200": {
+			{RouteShortName: "333", Headsign: "Bondi Beach", ScheduledDeparture: at(5), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(15)}}},
+			{RouteShortName: "333", Headsign: "City", ScheduledDeparture: at(7), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(17)}}},
+		},
+		"201|300": {
+			{RouteShortName: "T4", Headsign: "Cronulla", ScheduledDeparture: at(20), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(30)}}},
+			{RouteShortName: "T4", Headsign: "Bondi Junction", ScheduledDeparture: at(22), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(32)}}},
+		},
+	}
+	fetch := func(stopID, arrivalStops string) ([]Departure, error) {
+		return slices.Clone(responses[stopID+"|"+arrivalStops]), nil
+	}
+
+	route := RouteConfig{
+		DepartureStopID:         "100",
+		Leg1Headsigns:           []string{"=city"},
+		TransferArrivalStopID:   "200",
+		TransferTime:            120,
+		TransferDepartureStopID: "201",
+		Leg2Headsigns:           []string{"bondi"},
+		FinalArrivalStop:        "300",
+	}
+	deps, err := routeDepartures(Config{}, route, now, at(60), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 || deps[0].Headsign != "City" {
+		t.Fatalf("expected only the 333 to the City, got %+v", deps)
+	}
+	if c := deps[0].Connections; len(c) != 1 || !deps[0].finalArrivalSort.Equal(at(32)) {
+		t.Errorf("expected the T4 to Bondi Junction, arriving at %v, got %v", at(32), deps[0].finalArrivalSort)
+	}
+}
+
+func TestTripOrigins(t *testing.T) {
+	trip := TripConfig{
+		Name: "To Work",
+		Routes: []RouteConfig{
+			{DepartureStopID: "100", DepartureLat: -33.87, DepartureLon: 151.21},
+			{DepartureStopID: "100", DepartureLat: -33.87, DepartureLon: 151.21},
+			{DepartureStopID: "101"},
+		},
+	}
+
+	origins := tripOrigins(trip)
+	if len(origins) != 1 {
+		t.Fatalf("expected 1 distinct origin, got %d", len(origins))
+	}
+	if origins[0].Lat != -33.87 || origins[0].Lon != 151.21 {
+		t.Errorf("unexpected origin %+v", origins[0])
+	}
+}
+
+func TestHandler_Geolocation(t *testing.T) {
+	mock := newMockAPI(t, nil)
+	defer mock.Close()
+
+	cfg := Config{
+		Geolocation: GeolocationConfig{Enabled: true},
+		Trips: []TripConfig{
+			{
+				Name: "To Work",
+				Routes: []RouteConfig{{
+					DepartureStopID:  "100",
+					DepartureName:    "Home",
+					DepartureLat:     -33.87,
+					DepartureLon:     151.21,
+					FinalArrivalStop: "300",
+					ArrivalName:      "Work",
+				}},
+			},
+		},
+	}
+
+	tmpl := parseTemplate()
+	handler := buildHandler(tmpl, mock.URL, cfg, nil)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "navigator.geolocation") {
+		t.Error("expected geolocation script when enabled")
+	}
+	if !strings.Contains(body, `"lat":-33.87`) {
+		t.Error("expected origin coordinates in page")
+	}
+	if !strings.Contains(body, "var maxDist= 500 ") {
+		t.Error("expected default max distance of 500m")
+	}
+
+	cfg.Geolocation.Enabled = false
+	handler = buildHandler(tmpl, mock.URL, cfg, nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if strings.Contains(w.Body.String(), "navigator.geolocation") {
+		t.Error("expected no geolocation script when disabled")
+	}
+}
+
+func TestNormalizeDepartures(t *testing.T) {
+	deps := []Departure{
+		{RouteShortName: "SYD_333X", Headsign: "Emu Plains via Central"},
+		{RouteShortName: "T1", Headsign: "Hornsby"},
+	}
+	normalizeDepartures(deps, Config{
+		Headsigns:    map[string]string{"Emu Plains via Central": "City & West"},
+		RouteAliases: map[string]string{"SYD_333X": "333"},
+	})
+
+	if deps[0].Headsign != "City & West" {
+		t.Errorf("expected rewritten headsign, got %q", deps[0].Headsign)
+	}
+	if deps[0].RouteShortName != "333" {
+		t.Errorf("expected aliased route name, got %q", deps[0].RouteShortName)
+	}
+	if deps[1].Headsign != "Hornsby" || deps[1].RouteShortName != "T1" {
+		t.Errorf("expected unmapped departure unchanged, got %+v", deps[1])
+	}
+}
+
+func TestHandler_RouteAliasFilter(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "SYD_333X",
+				ScheduledDeparture: now.Add(5 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		RouteAliases: map[string]string{"SYD_333X": "333"},
+		Trips: []TripConfig{
+			{
+				Name: "Alias",
+				Routes: []RouteConfig{{
+					DepartureStopID:  "100",
+					Leg1Services:     []string{"333"},
+					FinalArrivalStop: "300",
+				}},
+			},
+		},
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, ">333</div>") {
+		t.Error("expected aliased route badge that passes the service filter")
+	}
+	if strings.Contains(body, "SYD_333X") {
+		t.Error("expected agency route name to be replaced")
+	}
+}
+
+func TestHandler_HeadsignRewrite(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				Headsign:           "Emu Plains via Central",
+				ScheduledDeparture: now.Add(5 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Headsigns: map[string]string{"Emu Plains via Central": "City & West"},
+		Trips: []TripConfig{
+			{
+				Name: "Rewrite",
+				Routes: []RouteConfig{{
+					DepartureStopID:  "100",
+					FinalArrivalStop: "300",
+				}},
+			},
+		},
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, "City &amp; West") {
+		t.Error("expected rewritten headsign in page")
+	}
+	if strings.Contains(body, "Emu Plains") {
+		t.Error("expected original headsign to be replaced")
+	}
+}
+
+func TestToDepartureView_OnDemand(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 40, 0, 0, boardTZ)
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, boardTZ)
+	end := time.Date(2024, 6, 3, 10, 30, 0, 0, boardTZ)
+
+	d := Departure{
+		RouteShortName:       "OD1",
+		ScheduledDeparture:   start,
+		BookingRequired:      true,
+		BookingNoticeMinutes: 15,
+		PickupWindowStart:    &start,
+		PickupWindowEnd:      &end,
+	}
+
+	view := toDepartureView(d, RouteConfig{}, now)
+	if !view.IsOnDemand {
+		t.Fatal("expected on-demand departure")
+	}
+	if view.PickupWindow != "10:00–10:30" {
+		t.Errorf("expected pickup window 10:00–10:30, got %q", view.PickupWindow)
+	}
+	if view.BookingNote != "Book 15 min ahead" {
+		t.Errorf("expected booking note, got %q", view.BookingNote)
+	}
+
+	d.BookingNoticeMinutes = 0
+	if view := toDepartureView(d, RouteConfig{}, now); view.BookingNote != "Booking required" {
+		t.Errorf("expected generic booking note, got %q", view.BookingNote)
+	}
+
+	fixed := toDepartureView(Departure{ScheduledDeparture: start}, RouteConfig{}, now)
+	if fixed.IsOnDemand || fixed.BookingNote != "" {
+		t.Error("expected fixed-time departure not to be on-demand")
+	}
+}
+
+func TestSelectTrip(t *testing.T) {
+	trips := []TripConfig{{Name: "To Work"}, {Name: "To Home"}}
+
+	tests := []struct {
+		key      string
+		expected string
+		ok       bool
+	}{
+		{"", "To Work", true},
+		{"1", "To Home", true},
+		{"to home", "To Home", true},
+		{"5", "", false},
+		{"Gym", "", false},
+	}
+	for _, tc := range tests {
+		trip, ok := selectTrip(trips, tc.key)
+		if ok != tc.ok || trip.Name != tc.expected {
+			t.Errorf("selectTrip(%q): expected %q/%v, got %q/%v", tc.key, tc.expected, tc.ok, trip.Name, ok)
+		}
+	}
+}
+
+func TestEmbedHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"200": {
+			{
+				RouteShortName:     "T2",
+				ScheduledDeparture: now.Add(8 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "100", ScheduledArrival: now.Add(40 * time.Minute)},
+				},
+			},
+		},
+	}
+
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+			{Name: "To Home", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "100"}}},
+		},
+	}
+
+	handler := buildEmbedHandler(parseTemplate(), mock.URL, cfg, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/embed?trip=To+Home&transparent=1", nil))
+
+	body := w.Body.String()
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(body, "T2") {
+		t.Error("expected selected trip's departures")
+	}
+	if strings.Contains(body, "To Work") || strings.Contains(body, `class="topbar`) {
+		t.Error("expected no header or tabs in embed layout")
+	}
+	if strings.Contains(body, "localStorage") {
+		t.Error("expected no tab persistence script in embed layout")
+	}
+	if !strings.Contains(body, `class="embed transparent"`) {
+		t.Error("expected transparent embed body class")
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/embed?trip=9", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 for unknown trip, got %d", w.Code)
+	}
+}
+
+func TestHandler_Chime(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				ScheduledDeparture: now.Add(5 * time.Minute),
+				Arrivals: []ArrivalDetail{
+					{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)},
+				},
+			},
+		},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{
+				Name:   "Chimes",
+				Chime:  &ChimeConfig{Threshold: 2, Sound: "/chime.mp3", Flash: true},
+				Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}},
+			},
+			{
+				Name:   "Silent",
+				Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}},
+			},
+		},
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, `id="trip-0" data-chime="2" data-sound="/chime.mp3" data-flash="1"`) {
+		t.Error("expected chime settings on the trip")
+	}
+	if !strings.Contains(body, `id="trip-1">`) {
+		t.Error("expected no chime settings on a trip without chime")
+	}
+	if !strings.Contains(body, `data-key="T1@`) {
+		t.Error("expected departure key for chime de-duplication")
+	}
+}
+
+func TestBuildTripView_UnknownConnections(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {
+			// Missing arrival data: connection can't be confirmed
+			{RouteShortName: "T1", ScheduledDeparture: now.Add(3 * time.Minute)},
+			{
+				RouteShortName:     "T2",
+				ScheduledDeparture: now.Add(10 * time.Minute),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(25 * time.Minute)}},
+			},
+		},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	trip := TripConfig{Name: "Direct", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}
+
+	tv, err := buildTripView(context.Background(), nil, mock.URL, Config{}, trip, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tv.Departures) != 1 {
+		t.Fatalf("expected unconfirmed departure to be hidden by default, got %d", len(tv.Departures))
+	}
+
+	tv, err = buildTripView(context.Background(), nil, mock.URL, Config{ShowUnknownConnections: true}, trip, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tv.Departures) != 2 {
+		t.Fatalf("expected both departures, got %d", len(tv.Departures))
+	}
+	if tv.Departures[0].RouteShortName != "T2" || tv.Departures[0].ConnectionUnknown {
+		t.Errorf("expected confirmed T2 first, got %+v", tv.Departures[0])
+	}
+	if !tv.Departures[1].ConnectionUnknown {
+		t.Error("expected T1 flagged as connection unknown")
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, Config{ShowUnknownConnections: true, Trips: []TripConfig{trip}}, nil)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "Connection unknown") {
+		t.Error("expected connection unknown badge on the board")
+	}
+}
+
+func TestTripFallback(t *testing.T) {
+	now := time.Date(2026, 3, 2, 23, 40, 0, 0, boardTZ)
+	cfg := FallbackConfig{DriveMinutes: 25, Link: "https://m.uber.com/ul/"}
+
+	fb := tripFallback(cfg, nil, now)
+	if fb == nil {
+		t.Fatal("expected a fallback with no departures")
+	}
+	if fb.Label != defaultFallbackLabel || fb.Arrive != "00:05" || fb.Link != cfg.Link {
+		t.Errorf("unexpected fallback %+v", fb)
+	}
+
+	if fb := tripFallback(cfg, []DepartureView{{ConnectionUnknown: true}}, now); fb == nil {
+		t.Error("expected a fallback when no departure connects")
+	}
+	if fb := tripFallback(cfg, []DepartureView{{HasConnection: true}}, now); fb != nil {
+		t.Errorf("expected no fallback with a viable connection, got %+v", fb)
+	}
+}
+
+func TestBuildTripView_InterchangeMinConnection(t *testing.T) {
+	now := time.Now().In(boardTZ)
+
+	responses := map[string][]Departure{
+		"100": {{
+			RouteShortName:     "T1",
+			ScheduledDeparture: now.Add(5 * time.Minute),
+			Arrivals:           []ArrivalDetail{{StopID: "200", ScheduledArrival: now.Add(15 * time.Minute)}},
+		}},
+		"201": {
+			{
+				RouteShortName:     "T2",
+				ScheduledDeparture: now.Add(18 * time.Minute),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+			},
+			{
+				RouteShortName:     "T3",
+				ScheduledDeparture: now.Add(25 * time.Minute),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(40 * time.Minute)}},
+			},
+		},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	trip := TripConfig{Name: "Transfer", Routes: []RouteConfig{{
+		DepartureStopID:         "100",
+		TransferArrivalStopID:   "200",
+		TransferTime:            120,
+		TransferDepartureStopID: "201",
+		FinalArrivalStop:        "300",
+	}}}
+
+	tv, err := buildTripView(context.Background(), nil, mock.URL, Config{}, trip, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tv.Departures) != 1 || tv.Departures[0].SecondLegRouteShort != "T2" {
+		t.Fatalf("expected T2 connection with the route's transfer time, got %+v", tv.Departures)
+	}
+
+	cfg := Config{Interchanges: []InterchangeConfig{{From: "200", To: "201", MinConnection: 360}}}
+	tv, err = buildTripView(context.Background(), nil, mock.URL, cfg, trip, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tv.Departures) != 1 || tv.Departures[0].SecondLegRouteShort != "T3" {
+		t.Errorf("expected interchange minimum to rule out T2, got %+v", tv.Departures)
+	}
+}
+
+func TestMarkHourGroups(t *testing.T) {
+	at := func(h, m int) DepartureView {
+		return DepartureView{departureAt: time.Date(2024, 6, 3, h, m, 0, 0, boardTZ)}
+	}
+	deps := []DepartureView{at(17, 40), at(17, 55), at(18, 5), at(18, 30), at(19, 0)}
+	markHourGroups(deps)
+
+	expected := []string{"17:00", "", "18:00", "", "19:00"}
+	for i, want := range expected {
+		if deps[i].HourHeader != want {
+			t.Errorf("departure %d: expected header %q, got %q", i, want, deps[i].HourHeader)
+		}
+	}
+
+	var buf strings.Builder
+	data := PageData{WindowMinutes: 180, Trips: []TripView{{Name: "Trip", Departures: deps}}}
+	if err := parseTemplate().Execute(&buf, data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Count(buf.String(), `<div class="hour">`); got != 3 {
+		t.Errorf("expected 3 hour headers rendered, got %d", got)
+	}
+}
+
+func TestMarkHourGroups_ArrivalOrder(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 6, 3, h, m, 0, 0, boardTZ) }
+	dep := func(route string, dh, dm, ah, am int) DepartureView {
+		return DepartureView{RouteShortName: route, HasConnection: true, departureAt: at(dh, dm), finalArrivalSort: at(ah, am)}
+	}
+	// The express leaves later but arrives first, so by arrival the
+	// departure hours go 18, 17, 18, 19
+	trip := TripConfig{Name: "Trip", Routes: []RouteConfig{{RouteName: "express"}, {RouteName: "stopping"}}}
+	tv, err := collectTripView(context.Background(), trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		if route.RouteName == "express" {
+			return []DepartureView{dep("X1", 18, 5, 18, 25), dep("X2", 19, 5, 19, 25)}, nil
+		}
+		return []DepartureView{dep("S1", 17, 50, 18, 35), dep("S2", 18, 40, 19, 20)}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	markHourGroups(tv.Departures)
+
+	var got []string
+	for _, dv := range tv.Departures {
+		got = append(got, dv.RouteShortName+" "+dv.HourHeader)
+	}
+	expected := []string{"X1 18:00", "S1 ", "S2 ", "X2 19:00"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected headers only as the hour moves on, got %q", got)
+	}
+}
+
+func TestRouteDepartures_MultipleLegs(t *testing.T) {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return now.Add(time.Duration(mins) * time.Minute) }
+
+	// Bus 100 -> 200, walk to 201, train 201 -> 300, walk to 301, metro 301 -> 400
+	responses := map[string][]Departure{
+		"100|200": {{RouteShortName: "333", ScheduledDeparture: at(5), Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: at(15)}}}},
+		"201|300": {
+			{RouteShortName: "T1", ScheduledDeparture: at(17), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(25)}}},
+			{RouteShortName: "T1", ScheduledDeparture: at(20), Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: at(30)}}},
+		},
+		"301|400": {
+			{RouteShortName: "M1", ScheduledDeparture: at(33), Arrivals: []ArrivalDetail{{StopID: "400", ScheduledArrival: at(45)}}},
+			{RouteShortName: "M1", ScheduledDeparture: at(36), Arrivals: []ArrivalDetail{{StopID: "400", ScheduledArrival: at(48)}}},
+		},
+	}
+	fetch := func(stopID, arrivalStops string) ([]Departure, error) {
+		return slices.Clone(responses[stopID+"|"+arrivalStops]), nil
+	}
+
+	route := RouteConfig{
+		DepartureStopID: "100",
+		DepartureName:   "Home",
+		Legs: []LegConfig{
+			{TransferArrivalStopID: "200", TransferTime: 240, TransferDepartureStopID: "201", TransferName: "Central"},
+			{TransferArrivalStopID: "300", TransferTime: 300, TransferDepartureStopID: "301", TransferName: "Chatswood"},
+		},
+		FinalArrivalStop: "400",
+		FinalWalkTime:    120,
+		ArrivalName:      "Work",
+	}
+	deps, err := routeDepartures(Config{}, route, now, at(60), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 {
+		t.Fatalf("expected 1 departure, got %d", len(deps))
+	}
+	dv := deps[0]
+	// 15 + 4 min transfer misses the 17 T1, takes the 20; 30 + 5 misses the
+	// 33 M1, takes the 36, arriving 48 + 2 min walk
+	if !dv.HasConnection || !dv.finalArrivalSort.Equal(at(50)) {
+		t.Fatalf("expected a connection arriving at %v, got %v (%v)", at(50), dv.finalArrivalSort, dv.HasConnection)
+	}
+	if len(dv.Connections) != 2 || dv.Connections[0].RouteShortName != "T1" || dv.Connections[1].RouteShortName != "M1" {
+		t.Fatalf("expected T1 then M1 connections, got %+v", dv.Connections)
+	}
+	if dv.Connections[0].WaitMins != 5 || dv.Connections[1].WaitMins != 6 {
+		t.Errorf("expected waits 5 and 6 min, got %d and %d", dv.Connections[0].WaitMins, dv.Connections[1].WaitMins)
+	}
+	if dv.SecondLegRouteShort != "T1" || dv.TransferWaitMins != 5 {
+		t.Errorf("expected second leg fields from the first connection, got %s %d", dv.SecondLegRouteShort, dv.TransferWaitMins)
+	}
+	if dv.TransferName != "Central → Chatswood" {
+		t.Errorf("expected joined transfer names, got %q", dv.TransferName)
+	}
+
+	// Without the later metro the journey has no connection
+	responses["301|400"] = responses["301|400"][:1]
+	deps, err = routeDepartures(Config{}, route, now, at(60), fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 0 {
+		t.Errorf("expected no viable departures, got %d", len(deps))
+	}
+
+	if got := routeQueries("u", route); len(got) != 3 || got[2] != (stopQuery{"u", "301", "400", 0}) {
+		t.Errorf("unexpected queries %v", got)
+	}
+}
+
+func TestParseConfig_WindowMinutes(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+window_minutes: 30
+trips:
+  - name: Metro
+    routes: [{route_name: M, departure_stop_id: "100", final_arrival_stop: "300"}]
+  - name: Ferry
+    window_minutes: 180
+    routes: [{route_name: F, departure_stop_id: "500", final_arrival_stop: "700"}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := cfg.windowMinutes(cfg.Trips[0]); w != 30 {
+		t.Errorf("expected the board window of 30, got %d", w)
+	}
+	if w := cfg.windowMinutes(cfg.Trips[1]); w != 180 {
+		t.Errorf("expected the ferry's window of 180, got %d", w)
+	}
+	if q := routeQueries("u", cfg.Trips[0].Routes[0]); q[0].minutes != 0 {
+		t.Errorf("expected a window within the hour to use the upstream default, got %d", q[0].minutes)
+	}
+	if q := routeQueries("u", cfg.Trips[1].Routes[0]); q[0].minutes != 180 {
+		t.Errorf("expected the ferry to ask the upstream for 180 minutes, got %d", q[0].minutes)
+	}
+
+	srv := newTestMockAPI("normal")
+	defer srv.Close()
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	for _, tc := range []struct {
+		trip int
+		want int
+	}{{0, 6}, {1, 36}} {
+		tv, err := buildTripView(context.Background(), nil, srv.URL, cfg, cfg.Trips[tc.trip], now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tv.Departures) != tc.want || tv.WindowMinutes != cfg.windowMinutes(cfg.Trips[tc.trip]) {
+			t.Errorf("%s: expected %d departures, got %d", tv.Name, tc.want, len(tv.Departures))
+		}
+	}
+
+	if _, err := parseConfig([]byte("trips: [{name: A, window_minutes: -5}]\n")); err == nil || !strings.Contains(err.Error(), "window_minutes") {
+		t.Errorf("expected a window_minutes error, got %v", err)
+	}
+}
+
+func TestParseConfig_Timezone(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+timezone: "Europe/London"
+trips:
+  - name: "Home"
+    routes: [{departure_stop_id: "100", final_arrival_stop: "200"}]
+  - name: "Holiday"
+    timezone: "America/New_York"
+    routes: [{departure_stop_id: "100", final_arrival_stop: "200"}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.loc == nil || cfg.loc.String() != "Europe/London" {
+		t.Errorf("expected Europe/London, got %v", cfg.loc)
+	}
+	if tz := cfg.Trips[0].timeZone(); tz != boardTZ {
+		t.Errorf("expected the first trip to use the board timezone, got %v", tz)
+	}
+
+	dep := time.Date(2026, 7, 1, 12, 30, 0, 0, time.UTC)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{RouteShortName: "1", ScheduledDeparture: dep, Arrivals: []ArrivalDetail{{StopID: "200", ScheduledArrival: dep.Add(10 * time.Minute)}}}},
+	})
+	defer mock.Close()
+	tv, err := buildTripView(context.Background(), nil, mock.URL, cfg, cfg.Trips[1], dep.Add(-5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tv.Departures) != 1 || tv.Departures[0].DepartureTime != "08:30" || tv.Departures[0].FinalArrivalTime != "08:40" {
+		t.Errorf("expected New York times 08:30 → 08:40, got %+v", tv.Departures)
+	}
+
+	if _, err := parseConfig([]byte("timezone: Mars/Olympus\ntrips: [{name: A}]\n")); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
+
+func TestCollectTripView_Sort(t *testing.T) {
+	base := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return base.Add(time.Duration(mins) * time.Minute) }
+	// The slow direct bus leaves first; the express leaves later, has a
+	// walk to its stop and arrives first.
+	build := func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		return []DepartureView{
+			{RouteShortName: "bus", HasConnection: true, departureAt: at(5), finalArrivalSort: at(50), JourneyMins: 45},
+			{RouteShortName: "express", HasConnection: true, departureAt: at(15), leaveAt: at(8), finalArrivalSort: at(40), JourneyMins: 32},
+			{RouteShortName: "tram", HasConnection: true, departureAt: at(7), finalArrivalSort: at(45), JourneyMins: 38},
+			{RouteShortName: "unknown", ConnectionUnknown: true, departureAt: at(1), finalArrivalSort: at(1)},
+		}, nil
+	}
+	for sort, want := range map[string][]string{
+		"":          {"express", "tram", "bus", "unknown"},
+		"departure": {"bus", "tram", "express", "unknown"},
+		"duration":  {"express", "tram", "bus", "unknown"},
+	} {
+		tv, err := collectTripView(context.Background(), TripConfig{Name: "T", Routes: []RouteConfig{{}}, Sort: sort}, build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, dv := range tv.Departures {
+			got = append(got, dv.RouteShortName)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("sort %q: expected %v, got %v", sort, want, got)
+		}
+	}
+
+	if _, err := parseConfig([]byte("trips:\n  - name: T\n    sort: fastest\n    routes: []\n")); err == nil {
+		t.Error("expected an unknown sort to be rejected")
+	}
+}
+
+func TestCollectTripView_Dedupe(t *testing.T) {
+	base := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	at := func(mins int) time.Time { return base.Add(time.Duration(mins) * time.Minute) }
+	// Routes A and B both take trip t1, B changing to something faster.
+	// C finds t2 but not its connection, which A makes.
+	trip := TripConfig{Name: "T", Routes: []RouteConfig{{RouteName: "A"}, {RouteName: "B"}, {RouteName: "C"}}}
+	tv, err := collectTripView(context.Background(), trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		switch route.RouteName {
+		case "A":
+			return []DepartureView{
+				{RouteShortName: "A", tripID: "t1", HasConnection: true, finalArrivalSort: at(40)},
+				{RouteShortName: "A", tripID: "t2", HasConnection: true, finalArrivalSort: at(55)},
+				{RouteShortName: "A", finalArrivalSort: at(60)},
+			}, nil
+		case "B":
+			return []DepartureView{{RouteShortName: "B", tripID: "t1", HasConnection: true, finalArrivalSort: at(35)}}, nil
+		}
+		return []DepartureView{
+			{RouteShortName: "C", tripID: "t2", ConnectionUnknown: true, finalArrivalSort: at(20)},
+			{RouteShortName: "C", finalArrivalSort: at(65)},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, dv := range tv.Departures {
+		got = append(got, dv.RouteShortName+":"+dv.tripID)
+	}
+	if want := []string{"B:t1", "A:t2", "A:", "C:"}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCollectTripView_Concurrent(t *testing.T) {
+	trip := TripConfig{Name: "T", Routes: []RouteConfig{{RouteName: "A"}, {RouteName: "B"}, {RouteName: "C"}}}
+	base := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+
+	// Each route waits until all three have started, so the build only
+	// completes if they run at the same time.
+	var started sync.WaitGroup
+	started.Add(len(trip.Routes))
+	tv, err := collectTripView(context.Background(), trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		started.Done()
+		started.Wait()
+		mins := map[string]int{"A": 30, "B": 20, "C": 10}[route.RouteName]
+		return []DepartureView{{RouteShortName: route.RouteName, finalArrivalSort: base.Add(time.Duration(mins) * time.Minute)}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, dv := range tv.Departures {
+		got = append(got, dv.RouteShortName)
+	}
+	if !slices.Equal(got, []string{"C", "B", "A"}) {
+		t.Errorf("expected departures ordered by arrival, got %v", got)
+	}
+
+	// B fails; A and C give up once it does rather than finish their fetch
+	_, err = collectTripView(context.Background(), trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		if route.RouteName == "B" {
+			return nil, fmt.Errorf("%s failed", route.RouteName)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err == nil || !strings.Contains(err.Error(), `building route "B": B failed`) {
+		t.Errorf("expected the failing route's error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collectTripView(ctx, trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled request to stop the build, got %v", err)
+	}
+}
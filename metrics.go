@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type metricSample struct {
+	trip  string
+	value float64
+}
+
+type metric struct {
+	name, help string
+	samples    []metricSample
+}
+
+// buildMetricsHandler exposes each trip's conclusions as Prometheus gauges.
+// Trips with no viable departure in the window have no sample for the
+// departure gauges, rather than a made-up value.
+func buildMetricsHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().In(boardTZ)
+		up := metric{name: "departure_board_trip_up", help: "Whether the trip's departures loaded (1) or failed (0)."}
+		mins := metric{name: "departure_board_next_departure_minutes", help: "Minutes until the next departure with a connection."}
+		delay := metric{name: "departure_board_next_departure_delay_minutes", help: "Delay of the next departure with a connection, in minutes."}
+		arrival := metric{name: "departure_board_best_arrival_timestamp_seconds", help: "Earliest final arrival among departures with a connection, as a Unix timestamp."}
+		count := metric{name: "departure_board_departures", help: "Departures with a connection in the departure window."}
+
+		for _, trip := range cfg.Trips {
+			tv, err := buildTripView(r.Context(), cache, apiURL, cfg, trip, now)
+			if err != nil {
+				log.Printf("metrics: trip %q: %v", trip.Name, err)
+				up.samples = append(up.samples, metricSample{trip.Name, 0})
+				continue
+			}
+			up.samples = append(up.samples, metricSample{trip.Name, 1})
+
+			var next *DepartureView
+			var best time.Time
+			viable := 0
+			for i := range tv.Departures {
+				dv := &tv.Departures[i]
+				if !dv.HasConnection {
+					continue
+				}
+				viable++
+				if next == nil || dv.departureAt.Before(next.departureAt) {
+					next = dv
+				}
+				if best.IsZero() || dv.finalArrivalSort.Before(best) {
+					best = dv.finalArrivalSort
+				}
+			}
+			count.samples = append(count.samples, metricSample{trip.Name, float64(viable)})
+			if next == nil {
+				continue
+			}
+			mins.samples = append(mins.samples, metricSample{trip.Name, float64(max(int(next.departureAt.Sub(now).Minutes()), 0))})
+			delay.samples = append(delay.samples, metricSample{trip.Name, float64(next.DelayMinutes)})
+			arrival.samples = append(arrival.samples, metricSample{trip.Name, float64(best.Unix())})
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range []metric{up, mins, delay, arrival, count} {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+			for _, s := range m.samples {
+				fmt.Fprintf(w, "%s{trip=\"%s\"} %s\n", m.name, escapeLabel(s.trip), strconv.FormatFloat(s.value, 'f', -1, 64))
+			}
+		}
+	}
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	now := time.Now().In(boardTZ).Truncate(time.Minute)
+	delay := 180
+	rt := now.Add(13 * time.Minute)
+	responses := map[string][]Departure{
+		"100": {
+			{
+				RouteShortName:     "T1",
+				ScheduledDeparture: now.Add(10 * time.Minute),
+				RealtimeDeparture:  &rt,
+				DelaySeconds:       &delay,
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(40 * time.Minute)}},
+			},
+			{
+				RouteShortName:     "T2",
+				ScheduledDeparture: now.Add(20 * time.Minute),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(35 * time.Minute)}},
+			},
+		},
+	}
+	mock := newMockAPI(t, responses)
+	defer mock.Close()
+
+	cfg := Config{Trips: []TripConfig{
+		{Name: `To "Work"`, Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "Empty", Routes: []RouteConfig{{DepartureStopID: "999", FinalArrivalStop: "300"}}},
+	}}
+
+	w := httptest.NewRecorder()
+	buildMetricsHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		"# TYPE departure_board_next_departure_minutes gauge",
+		`departure_board_trip_up{trip="To \"Work\""} 1`,
+		`departure_board_next_departure_delay_minutes{trip="To \"Work\""} 3`,
+		fmt.Sprintf(`departure_board_best_arrival_timestamp_seconds{trip="To \"Work\""} %d`, now.Add(35*time.Minute).Unix()),
+		`departure_board_departures{trip="Empty"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, `departure_board_next_departure_minutes{trip="To \"Work\""} 12`) &&
+		!strings.Contains(body, `departure_board_next_departure_minutes{trip="To \"Work\""} 13`) {
+		t.Errorf("expected about 13 minutes to the next departure, got:\n%s", body)
+	}
+	if strings.Contains(body, `departure_board_next_departure_minutes{trip="Empty"}`) {
+		t.Error("expected no next-departure sample for a trip without departures")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mockScenarios are the behaviours the mock GTFS API can simulate.
+var mockScenarios = []string{"normal", "delays", "cancellations", "empty", "slow"}
+
+const (
+	mockDepartures = 12
+	mockHeadway    = 5 * time.Minute
+	mockLegTime    = 12 * time.Minute
+)
+
+var mockRoutes = []string{"T1", "T2", "T8"}
+
+// mockAPI is a stand-in for the GTFS departure service. It invents a regular
+// service from every stop to whichever arrival stops are asked for, so any
+// config.yaml works against it.
+type mockAPI struct {
+	scenario string
+	latency  time.Duration
+	now      func() time.Time
+}
+
+func validMockScenario(name string) bool {
+	for _, s := range mockScenarios {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/departures/arrivals", func(w http.ResponseWriter, r *http.Request) {
+		scenario, ok := m.requestScenario(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		minutes, _ := strconv.Atoi(q.Get("minutes"))
+		writeMockJSON(w, m.departures(scenario, q.Get("stop_id"), q.Get("arrival_stops"), minutes))
+	})
+	mux.HandleFunc("/departures/arrivals/batch", func(w http.ResponseWriter, r *http.Request) {
+		scenario, ok := m.requestScenario(w, r)
+		if !ok {
+			return
+		}
+		var queries []struct {
+			StopID       string `json:"stop_id"`
+			ArrivalStops string `json:"arrival_stops"`
+			Minutes      int    `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeMockJSON(w, map[string]string{"error": err.Error()})
+			return
+		}
+		results := make([][]Departure, len(queries))
+		for i, q := range queries {
+			results[i] = m.departures(scenario, q.StopID, q.ArrivalStops, q.Minutes)
+		}
+		writeMockJSON(w, results)
+	})
+	mux.HandleFunc("/stops/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/stops/")
+		if id == "search" {
+			q := r.URL.Query().Get("q")
+			writeMockJSON(w, []Stop{{StopID: "mock-" + strings.ReplaceAll(strings.ToLower(q), " ", "-"), StopName: q}})
+			return
+		}
+		writeMockJSON(w, Stop{StopID: id, StopName: "Stop " + id})
+	})
+	return mux
+}
+
+// requestScenario returns the scenario for a request (?scenario= overrides
+// the server default) and applies its latency.
+func (m *mockAPI) requestScenario(w http.ResponseWriter, r *http.Request) (string, bool) {
+	scenario := m.scenario
+	if s := r.URL.Query().Get("scenario"); s != "" {
+		scenario = s
+	}
+	if !validMockScenario(scenario) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeMockJSON(w, map[string]string{"error": fmt.Sprintf("unknown scenario %q", scenario)})
+		return "", false
+	}
+	if scenario == "slow" {
+		select {
+		case <-time.After(m.latency):
+		case <-r.Context().Done():
+			return "", false
+		}
+	}
+	return scenario, true
+}
+
+// departures invents the next hour (or minutes, when set) of services from
+// stopID. Delays and cancellations are derived from the trip ID, so they stay
+// stable from one poll to the next.
+func (m *mockAPI) departures(scenario, stopID, arrivalStops string, minutes int) []Departure {
+	deps := []Departure{}
+	if scenario == "empty" {
+		return deps
+	}
+
+	var stops []string
+	if arrivalStops != "" {
+		stops = strings.Split(arrivalStops, ",")
+	}
+	count := mockDepartures
+	if minutes > 0 {
+		count = int(time.Duration(minutes) * time.Minute / mockHeadway)
+	}
+	first := m.now().Truncate(time.Minute).Add(2 * time.Minute)
+	for i := 0; i < count; i++ {
+		sched := first.Add(time.Duration(i) * mockHeadway)
+		tripID := fmt.Sprintf("mock-%s-%s", stopID, sched.In(boardTZ).Format("1504"))
+		h := fnv.New32a()
+		h.Write([]byte(tripID))
+		seed := h.Sum32() >> 8 // the low bits vary little between similar IDs
+
+		if scenario == "cancellations" && seed%3 == 0 {
+			continue
+		}
+		var delay time.Duration
+		if scenario == "delays" {
+			delay = time.Duration(seed%16)*time.Minute + time.Duration(seed%60)*time.Second
+		}
+		delaySecs := int(delay.Seconds())
+		rt := sched.Add(delay)
+
+		d := Departure{
+			TripID:             tripID,
+			RouteShortName:     mockRoutes[i%len(mockRoutes)],
+			RouteLongName:      "Mock Line",
+			Headsign:           "Mock Terminus",
+			ScheduledDeparture: sched,
+			RealtimeDeparture:  &rt,
+			DelaySeconds:       &delaySecs,
+		}
+		for j, stop := range stops {
+			arr := sched.Add(time.Duration(j+1) * mockLegTime)
+			rtArr := arr.Add(delay)
+			d.Arrivals = append(d.Arrivals, ArrivalDetail{
+				StopID:           stop,
+				StopName:         "Stop " + stop,
+				ScheduledArrival: arr,
+				RealtimeArrival:  &rtArr,
+			})
+		}
+		deps = append(deps, d)
+	}
+	return deps
+}
+
+func writeMockJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// runMockServer implements `departure-board mockserver`.
+func runMockServer(args []string) error {
+	fs := flag.NewFlagSet("mockserver", flag.ContinueOnError)
+	port := fs.String("port", "8080", "port to listen on")
+	scenario := fs.String("scenario", "normal", "default scenario: "+strings.Join(mockScenarios, ", "))
+	latency := fs.Duration("latency", 5*time.Second, "response delay in the slow scenario")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if !validMockScenario(*scenario) {
+		return fmt.Errorf("unknown scenario %q (want one of %s)", *scenario, strings.Join(mockScenarios, ", "))
+	}
+
+	m := &mockAPI{scenario: *scenario, latency: *latency, now: time.Now}
+	log.Printf("Mock GTFS API (%s) on http://localhost:%s", *scenario, *port)
+	return http.ListenAndServe(":"+*port, m.handler())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestMockAPI(scenario string) *httptest.Server {
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	m := &mockAPI{scenario: scenario, latency: 50 * time.Millisecond, now: func() time.Time { return now }}
+	return httptest.NewServer(m.handler())
+}
+
+func TestMockAPI_Scenarios(t *testing.T) {
+	ctx := context.Background()
+
+	srv := newTestMockAPI("normal")
+	defer srv.Close()
+	deps, err := fetchDepartures(ctx, srv.URL, "100", "200,300", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deps) != mockDepartures {
+		t.Fatalf("expected %d departures, got %d", mockDepartures, len(deps))
+	}
+	if len(deps[0].Arrivals) != 2 || deps[0].Arrivals[1].StopID != "300" {
+		t.Errorf("expected arrivals at both requested stops, got %+v", deps[0].Arrivals)
+	}
+	if *deps[0].DelaySeconds != 0 {
+		t.Errorf("expected on-time services, got delay %d", *deps[0].DelaySeconds)
+	}
+
+	fetch := func(scenario string) ([]Departure, error) {
+		srv := newTestMockAPI(scenario)
+		defer srv.Close()
+		return fetchDepartures(ctx, srv.URL, "100", "300", 0)
+	}
+
+	delayed, err := fetch("delays")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	late := 0
+	for _, d := range delayed {
+		if *d.DelaySeconds > 0 {
+			late++
+		}
+	}
+	if late == 0 {
+		t.Error("expected some delayed services")
+	}
+
+	cancelled, err := fetch("cancellations")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cancelled) == 0 || len(cancelled) >= mockDepartures {
+		t.Errorf("expected some but not all services cancelled, got %d", len(cancelled))
+	}
+
+	if empty, err := fetch("empty"); err != nil || len(empty) != 0 {
+		t.Errorf("expected no departures, got %d (err %v)", len(empty), err)
+	}
+
+	start := time.Now()
+	if _, err := fetch("slow"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("expected the slow scenario to delay the response")
+	}
+
+	resp, err := http.Get(srv.URL + "/departures/arrivals?stop_id=100&scenario=bogus")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown scenario, got %d", resp.StatusCode)
+	}
+}
+
+func TestMockAPI_BatchAndStops(t *testing.T) {
+	srv := newTestMockAPI("normal")
+	defer srv.Close()
+
+	results, err := fetchDeparturesBatch(context.Background(), srv.URL, []stopQuery{
+		{apiURL: srv.URL, stopID: "100", arrivalStops: "300"},
+		{apiURL: srv.URL, stopID: "200", arrivalStops: "400"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[1][0].Arrivals[0].StopID != "400" {
+		t.Errorf("unexpected batch results %+v", results)
+	}
+
+	stop, err := fetchStop(context.Background(), srv.URL, "100")
+	if err != nil || stop.StopID != "100" {
+		t.Errorf("expected stop 100, got %+v (err %v)", stop, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/stops/search?q=Central")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 from stop search, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMQTTClientID        = "departure-board"
+	defaultMQTTTopicPrefix     = "departure-board"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTInterval        = 30
+)
+
+// MQTTConfig has the board publish each trip's best departure to an MQTT
+// broker, with Home Assistant discovery messages so every trip appears as
+// sensors without any Home Assistant configuration.
+type MQTTConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Broker is the broker's URL: tcp:// or mqtt:// (port 1883 by default),
+	// or ssl:// or mqtts:// for TLS (port 8883).
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	ClientID string `yaml:"client_id,omitempty"`
+	// TopicPrefix is where states go: {topic_prefix}/{trip slug}/state,
+	// and {topic_prefix}/status for availability.
+	TopicPrefix     string `yaml:"topic_prefix,omitempty"`
+	DiscoveryPrefix string `yaml:"discovery_prefix,omitempty"`
+	// Interval is how often states are published, in seconds.
+	Interval int `yaml:"interval,omitempty"`
+}
+
+func (c MQTTConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, err := c.address(); err != nil {
+		return err
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval can't be negative")
+	}
+	return nil
+}
+
+// address returns the broker's host:port and whether it wants TLS.
+func (c MQTTConfig) address() (mqttAddress, error) {
+	u, err := url.Parse(c.Broker)
+	if err != nil || u.Hostname() == "" {
+		return mqttAddress{}, fmt.Errorf("broker %q must be a URL like tcp://host:1883", c.Broker)
+	}
+	a := mqttAddress{host: u.Hostname()}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "mqtts", "tls":
+		a.tls, port = true, "8883"
+	default:
+		return mqttAddress{}, fmt.Errorf("broker %q must be tcp://, mqtt://, ssl:// or mqtts://", c.Broker)
+	}
+	a.addr = net.JoinHostPort(a.host, firstOf(u.Port(), port))
+	return a, nil
+}
+
+type mqttAddress struct {
+	addr, host string
+	tls        bool
+}
+
+func (c MQTTConfig) clientID() string {
+	return firstOf(c.ClientID, defaultMQTTClientID)
+}
+
+func (c MQTTConfig) topicPrefix() string {
+	return strings.TrimSuffix(firstOf(c.TopicPrefix, defaultMQTTTopicPrefix), "/")
+}
+
+func (c MQTTConfig) discoveryPrefix() string {
+	return strings.TrimSuffix(firstOf(c.DiscoveryPrefix, defaultMQTTDiscoveryPrefix), "/")
+}
+
+func (c MQTTConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval) * time.Second
+	}
+	return defaultMQTTInterval * time.Second
+}
+
+func (c MQTTConfig) statusTopic() string {
+	return c.topicPrefix() + "/status"
+}
+
+func (c MQTTConfig) stateTopic(trip string) string {
+	return c.topicPrefix() + "/" + tripSlug(trip) + "/state"
+}
+
+// MQTTState is a trip's state message: its best departure (as notify:
+// picks it), or nulls when there is none.
+type MQTTState struct {
+	Minutes   *int       `json:"minutes"`
+	Delay     *int       `json:"delay"`
+	DepartsAt *time.Time `json:"departs_at"`
+	ArrivesAt *time.Time `json:"arrives_at"`
+	Route     string     `json:"route,omitempty"`
+	Headsign  string     `json:"headsign,omitempty"`
+	Summary   string     `json:"summary,omitempty"`
+}
+
+func mqttState(tv TripView, now time.Time) MQTTState {
+	dv, ok := bestDeparture(tv)
+	if !ok {
+		return MQTTState{}
+	}
+	mins := max(int(dv.departureAt.Sub(now).Minutes()), 0)
+	departs, arrives := dv.departureAt, dv.finalArrivalSort
+	return MQTTState{
+		Minutes:   &mins,
+		Delay:     &dv.DelayMinutes,
+		DepartsAt: &departs,
+		ArrivesAt: &arrives,
+		Route:     dv.RouteShortName,
+		Headsign:  dv.Headsign,
+		Summary:   dv.JourneySummary,
+	}
+}
+
+// mqttSensors are the Home Assistant sensors each trip gets.
+var mqttSensors = []struct {
+	key, name, field, unit, deviceClass string
+}{
+	{"next_departure", "next departure", "minutes", "min", "duration"},
+	{"delay", "delay", "delay", "min", "duration"},
+	{"arrival", "arrival", "arrives_at", "", "timestamp"},
+}
+
+// discoveryMessages returns the retained Home Assistant discovery configs
+// for a trip's sensors, by topic.
+func (c MQTTConfig) discoveryMessages(trip string) map[string][]byte {
+	node := mqttID(c.clientID())
+	object := mqttID(tripSlug(trip))
+	device := map[string]any{"identifiers": []string{node}, "name": "Departure Board", "manufacturer": "departure-board"}
+	msgs := make(map[string][]byte)
+	for _, s := range mqttSensors {
+		cfg := map[string]any{
+			"name":               trip + " " + s.name,
+			"unique_id":          node + "_" + object + "_" + s.key,
+			"object_id":          object + "_" + s.key,
+			"state_topic":        c.stateTopic(trip),
+			"value_template":     "{{ value_json." + s.field + " }}",
+			"availability_topic": c.statusTopic(),
+			"device_class":       s.deviceClass,
+			"device":             device,
+		}
+		if s.unit != "" {
+			cfg["unit_of_measurement"] = s.unit
+		}
+		if s.key == "next_departure" {
+			cfg["json_attributes_topic"] = c.stateTopic(trip)
+		}
+		data, _ := json.Marshal(cfg)
+		msgs[c.discoveryPrefix()+"/sensor/"+node+"/"+object+"_"+s.key+"/config"] = data
+	}
+	return msgs
+}
+
+// mqttID makes s safe for a discovery topic and object ID.
+func mqttID(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// mqttPublisher keeps a connection to the broker and publishes the trips'
+// states on an interval, announcing trips to Home Assistant as they appear
+// and withdrawing them when they're removed.
+type mqttPublisher struct {
+	cfg MQTTConfig
+
+	conn *mqttConn
+	// announced are the trips whose discovery configs were sent on conn.
+	announced map[string]bool
+}
+
+func newMQTTPublisher(cfg MQTTConfig) *mqttPublisher {
+	return &mqttPublisher{cfg: cfg}
+}
+
+func (p *mqttPublisher) run(ctx context.Context, apiURL string, config func() Config, cache *departureCache) {
+	ticker := time.NewTicker(p.cfg.interval())
+	defer ticker.Stop()
+	for {
+		if err := p.publish(ctx, apiURL, config(), cache, time.Now().In(boardTZ)); err != nil {
+			log.Printf("mqtt: %v", err)
+			p.close()
+		}
+		select {
+		case <-ctx.Done():
+			if p.conn != nil {
+				p.conn.publish(p.cfg.statusTopic(), []byte("offline"), true)
+				p.conn.disconnect()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends every trip's state, connecting and announcing trips first
+// as needed.
+func (p *mqttPublisher) publish(ctx context.Context, apiURL string, cfg Config, cache *departureCache, now time.Time) error {
+	if p.conn == nil {
+		conn, err := dialMQTT(ctx, p.cfg)
+		if err != nil {
+			return err
+		}
+		p.conn, p.announced = conn, make(map[string]bool)
+		if err := conn.publish(p.cfg.statusTopic(), []byte("online"), true); err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string]bool)
+	for _, trip := range cfg.Trips {
+		current[trip.Name] = true
+		if p.announced[trip.Name] {
+			continue
+		}
+		for topic, msg := range p.cfg.discoveryMessages(trip.Name) {
+			if err := p.conn.publish(topic, msg, true); err != nil {
+				return err
+			}
+		}
+		p.announced[trip.Name] = true
+	}
+	for name := range p.announced {
+		if current[name] {
+			continue
+		}
+		// An empty retained config removes the sensor from Home Assistant
+		for topic := range p.cfg.discoveryMessages(name) {
+			if err := p.conn.publish(topic, nil, true); err != nil {
+				return err
+			}
+		}
+		delete(p.announced, name)
+	}
+
+	for _, trip := range cfg.Trips {
+		tv, err := buildTripView(ctx, cache, apiURL, cfg, trip, now)
+		if err != nil {
+			log.Printf("mqtt: building trip %q: %v", trip.Name, err)
+			continue
+		}
+		state, _ := json.Marshal(mqttState(tv, now))
+		if err := p.conn.publish(p.cfg.stateTopic(trip.Name), state, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *mqttPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// mqttConn is a minimal MQTT 3.1.1 client: it connects, publishes at QoS 0
+// and disconnects, which is all the board needs.
+type mqttConn struct {
+	net.Conn
+	keepAlive time.Duration
+}
+
+// dialMQTT connects to the broker, setting a last will that marks the
+// board offline if the connection drops.
+func dialMQTT(ctx context.Context, cfg MQTTConfig) (*mqttConn, error) {
+	addr, err := cfg.address()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var nc net.Conn
+	if addr.tls {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: addr.host}}).DialContext(ctx, "tcp", addr.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", addr.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttConn{Conn: nc, keepAlive: 2*cfg.interval() + 30*time.Second}
+
+	var flags byte = 0x02 | 0x04 | 0x20 // clean session, with a retained will
+	payload := mqttString(cfg.clientID())
+	payload = append(payload, mqttString(cfg.statusTopic())...)
+	payload = append(payload, mqttString("offline")...)
+	if cfg.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(cfg.Username)...)
+	}
+	if cfg.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(cfg.Password)...)
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.keepAlive/time.Second))
+	body = append(body, payload...)
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.writePacket(0x10, body); err != nil {
+		c.Close()
+		return nil, err
+	}
+	typ, ack, err := readMQTTPacket(bufio.NewReader(c))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("reading CONNACK: %w", err)
+	}
+	if typ != 0x20 || len(ack) != 2 {
+		c.Close()
+		return nil, errors.New("broker didn't acknowledge the connection")
+	}
+	if ack[1] != 0 {
+		c.Close()
+		return nil, fmt.Errorf("broker refused the connection (%s)", mqttConnackReason(ack[1]))
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func mqttConnackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client ID rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorised"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+func (c *mqttConn) publish(topic string, payload []byte, retain bool) error {
+	var header byte = 0x30
+	if retain {
+		header |= 0x01
+	}
+	c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.writePacket(header, append(mqttString(topic), payload...))
+}
+
+func (c *mqttConn) disconnect() {
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writePacket(0xe0, nil)
+	c.Close()
+}
+
+func (c *mqttConn) writePacket(header byte, body []byte) error {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := c.Write(append(pkt, body...))
+	return err
+}
+
+// readMQTTPacket reads one packet, returning its type (the fixed header's
+// high nibble, as written) and body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
+
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// fakeBroker accepts one connection, answers its CONNECT with code and
+// sends what it's published on msgs.
+func fakeBroker(t *testing.T, code byte) (addr string, connect chan []byte, msgs chan mqttMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	connect, msgs = make(chan []byte, 1), make(chan mqttMessage, 100)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		if _, body, err := readMQTTPacket(r); err == nil {
+			connect <- body
+		}
+		c.Write([]byte{0x20, 2, 0, code})
+		for {
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			header, _ := r.Peek(1)
+			typ, body, err := readMQTTPacket(r)
+			if err != nil || typ != 0x30 {
+				close(msgs)
+				return
+			}
+			n := binary.BigEndian.Uint16(body)
+			msgs <- mqttMessage{topic: string(body[2 : 2+n]), payload: string(body[2+n:]), retain: header[0]&1 == 1}
+		}
+	}()
+	return ln.Addr().String(), connect, msgs
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	addr, connect, msgs := fakeBroker(t, 0)
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{
+			TripID: "t1", RouteShortName: "T1", ScheduledDeparture: now.Add(10*time.Minute + 30*time.Second),
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(40 * time.Minute)}},
+		}},
+	})
+	defer mock.Close()
+	cfg := Config{Trips: []TripConfig{{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}}}
+
+	p := newMQTTPublisher(MQTTConfig{Enabled: true, Broker: "tcp://" + addr, Username: "board", Password: "secret"})
+	if err := p.publish(context.Background(), mock.URL, cfg, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	p.conn.disconnect()
+
+	body := <-connect
+	if !strings.Contains(string(body), "departure-board/status") || !strings.HasSuffix(string(body), "board\x00\x06secret") || body[7]&0xe4 != 0xe4 {
+		t.Errorf("expected a will, username and password in the CONNECT, got %q", body)
+	}
+
+	got := make(map[string]mqttMessage)
+	for m := range msgs {
+		got[m.topic] = m
+	}
+	if m := got["departure-board/status"]; m.payload != "online" || !m.retain {
+		t.Errorf("expected a retained online status, got %+v", m)
+	}
+	disc, ok := got["homeassistant/sensor/departure_board/to_work_next_departure/config"]
+	var sensor map[string]any
+	if !ok || !disc.retain || json.Unmarshal([]byte(disc.payload), &sensor) != nil {
+		t.Fatalf("expected a retained discovery config, got %+v", got)
+	}
+	if sensor["state_topic"] != "departure-board/to-work/state" || sensor["unit_of_measurement"] != "min" || sensor["unique_id"] != "departure_board_to_work_next_departure" {
+		t.Errorf("unexpected discovery config %v", sensor)
+	}
+	for _, key := range []string{"delay", "arrival"} {
+		if _, ok := got["homeassistant/sensor/departure_board/to_work_"+key+"/config"]; !ok {
+			t.Errorf("expected a %s sensor", key)
+		}
+	}
+
+	var state MQTTState
+	json.Unmarshal([]byte(got["departure-board/to-work/state"].payload), &state)
+	if state.Minutes == nil || *state.Minutes != 10 || *state.Delay != 0 || state.Route != "T1" || state.ArrivesAt == nil {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestMQTTPublisher_Refused(t *testing.T) {
+	addr, _, _ := fakeBroker(t, 4)
+	_, err := dialMQTT(context.Background(), MQTTConfig{Enabled: true, Broker: "mqtt://" + addr})
+	if err == nil || !strings.Contains(err.Error(), "bad username or password") {
+		t.Errorf("expected the refusal's reason, got %v", err)
+	}
+}
+
+func TestMQTTState_NoDeparture(t *testing.T) {
+	data, _ := json.Marshal(mqttState(TripView{Name: "To Work"}, time.Now()))
+	if string(data) != `{"minutes":null,"delay":null,"departs_at":null,"arrives_at":null}` {
+		t.Errorf("expected nulls, got %s", data)
+	}
+}
+
+func TestMQTTConfig_Validate(t *testing.T) {
+	for _, c := range []MQTTConfig{
+		{Enabled: true},
+		{Enabled: true, Broker: "http://broker.local"},
+		{Enabled: true, Broker: "tcp://broker.local", Interval: -1},
+	} {
+		if c.validate() == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	a, err := MQTTConfig{Broker: "mqtts://broker.local"}.address()
+	if err != nil || a.addr != "broker.local:8883" || !a.tls {
+		t.Errorf("expected TLS on 8883, got %+v %v", a, err)
+	}
+}
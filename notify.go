@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNotifyDelayMinutes = 5
+
+	// notifyCheckInterval is how often watched trips are evaluated.
+	notifyCheckInterval = 30 * time.Second
+	// notifyCancelMargin is how long before it leaves a departure must
+	// vanish from the list to count as cancelled rather than gone.
+	notifyCancelMargin = 2 * time.Minute
+)
+
+// Telegram and Pushover endpoints, variables so tests can stand in for them.
+var (
+	telegramAPIURL = "https://api.telegram.org"
+	pushoverAPIURL = "https://api.pushover.net/1/messages.json"
+)
+
+// NotifyConfig has the board tell other systems when a watched trip's best
+// departure (the first that makes its connection, or on an arrive_by trip
+// the last that arrives in time) changes for the worse.
+type NotifyConfig struct {
+	// WebhookURL is POSTed a NotifyEvent as JSON.
+	WebhookURL         string          `yaml:"webhook_url,omitempty"`
+	Telegram           *TelegramConfig `yaml:"telegram,omitempty"`
+	Pushover           *PushoverConfig `yaml:"pushover,omitempty"`
+	DelayThresholdMins int             `yaml:"delay_threshold_mins,omitempty"`
+	// Trips are the trips to watch, by index, name or slug. Empty watches
+	// them all.
+	Trips []string `yaml:"trips,omitempty"`
+}
+
+// TelegramConfig sends events as messages from a Telegram bot to a chat.
+type TelegramConfig struct {
+	BotToken string `yaml:"bot_token"`
+	ChatID   string `yaml:"chat_id"`
+}
+
+// PushoverConfig sends events as Pushover notifications.
+type PushoverConfig struct {
+	// Token is the application's API token, User the user or group key.
+	Token string `yaml:"token"`
+	User  string `yaml:"user"`
+	// Device limits the notification to one of the user's devices.
+	Device string `yaml:"device,omitempty"`
+	// Priority is Pushover's, -2 to 1. Emergency (2) isn't supported as
+	// it needs acknowledging.
+	Priority int `yaml:"priority,omitempty"`
+}
+
+func (c NotifyConfig) enabled() bool {
+	return c.WebhookURL != "" || c.Telegram != nil || c.Pushover != nil
+}
+
+func (c NotifyConfig) delayMinutes() int {
+	if c.DelayThresholdMins > 0 {
+		return c.DelayThresholdMins
+	}
+	return defaultNotifyDelayMinutes
+}
+
+func (c NotifyConfig) validate(trips []TripConfig) error {
+	if !c.enabled() {
+		if c.DelayThresholdMins != 0 || len(c.Trips) > 0 {
+			return fmt.Errorf("webhook_url, telegram or pushover is required")
+		}
+		return nil
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook_url %q must be an http(s) URL", c.WebhookURL)
+		}
+	}
+	if t := c.Telegram; t != nil && (t.BotToken == "" || t.ChatID == "") {
+		return fmt.Errorf("telegram: bot_token and chat_id are required")
+	}
+	if p := c.Pushover; p != nil {
+		if p.Token == "" || p.User == "" {
+			return fmt.Errorf("pushover: token and user are required")
+		}
+		if p.Priority < -2 || p.Priority > 1 {
+			return fmt.Errorf("pushover: priority must be between -2 and 1")
+		}
+	}
+	if c.DelayThresholdMins < 0 {
+		return fmt.Errorf("delay_threshold_mins can't be negative")
+	}
+	for _, key := range c.Trips {
+		if _, ok := selectTrip(trips, key); !ok || key == "" {
+			return fmt.Errorf("unknown trip %q", key)
+		}
+	}
+	return nil
+}
+
+// watched returns the trips to watch.
+func (c NotifyConfig) watched(trips []TripConfig) []TripConfig {
+	if len(c.Trips) == 0 {
+		return trips
+	}
+	var out []TripConfig
+	for _, key := range c.Trips {
+		if trip, ok := selectTrip(trips, key); ok {
+			out = append(out, trip)
+		}
+	}
+	return out
+}
+
+// NotifyEvent is what the webhook is sent. Event is "delayed", "cancelled"
+// or "connection_lost"; Departure is the departure as the board last saw it.
+type NotifyEvent struct {
+	Event     string       `json:"event"`
+	Trip      string       `json:"trip"`
+	Message   string       `json:"message"`
+	Departure APIDeparture `json:"departure"`
+	Time      time.Time    `json:"time"`
+}
+
+// notifier watches trips' best departures and sends an event when one is
+// delayed past the threshold, disappears before it leaves, or stops making
+// its connection. Each event fires once per service.
+type notifier struct {
+	client *http.Client
+
+	mu   sync.Mutex
+	best map[string]DepartureView
+	sent map[string]time.Time
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		best:   make(map[string]DepartureView),
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// run checks the watched trips on an interval, reading notify: from the
+// live config so reloads take effect.
+func (n *notifier) run(ctx context.Context, apiURL string, config func() Config, cache *departureCache) {
+	ticker := time.NewTicker(notifyCheckInterval)
+	defer ticker.Stop()
+	for {
+		n.check(ctx, apiURL, config(), cache, time.Now().In(boardTZ))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *notifier) check(ctx context.Context, apiURL string, cfg Config, cache *departureCache, now time.Time) {
+	if !cfg.Notify.enabled() {
+		return
+	}
+	for _, trip := range cfg.Notify.watched(cfg.Trips) {
+		tv, err := buildTripView(ctx, cache, apiURL, cfg, trip, now)
+		if err != nil {
+			log.Printf("notify: building trip %q: %v", trip.Name, err)
+			continue
+		}
+		for _, ev := range n.eventsFor(tv, cfg.Notify.delayMinutes(), now) {
+			n.deliver(ctx, cfg.Notify, ev)
+		}
+	}
+}
+
+// eventsFor compares a trip's departures with its best departure at the
+// last check, and returns the events due.
+func (n *notifier) eventsFor(tv TripView, delayMins int, now time.Time) []NotifyEvent {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for k, at := range n.sent {
+		if now.Sub(at) > 12*time.Hour {
+			delete(n.sent, k)
+		}
+	}
+
+	var events []NotifyEvent
+	add := func(event string, dv DepartureView, msg string) {
+		key := event + "|" + tv.Name + "|" + serviceKey(dv)
+		if _, done := n.sent[key]; done {
+			return
+		}
+		n.sent[key] = now
+		events = append(events, NotifyEvent{Event: event, Trip: tv.Name, Message: msg, Departure: apiDeparture(dv), Time: now})
+	}
+
+	if prev, ok := n.best[tv.Name]; ok && prev.departureAt.After(now.Add(notifyCancelMargin)) {
+		cur, found := findService(tv.Departures, serviceKey(prev))
+		switch {
+		case !found && tv.ArriveBy != "":
+			add("connection_lost", prev, fmt.Sprintf("%s at %s no longer arrives by %s", prev.RouteShortName, prev.DepartureTime, tv.ArriveBy))
+		case !found:
+			add("cancelled", prev, fmt.Sprintf("%s at %s is cancelled", prev.RouteShortName, prev.DepartureTime))
+		case !cur.HasConnection:
+			add("connection_lost", cur, fmt.Sprintf("%s at %s no longer makes its connection", cur.RouteShortName, cur.DepartureTime))
+		}
+	}
+
+	delete(n.best, tv.Name)
+	if dv, ok := bestDeparture(tv); ok {
+		n.best[tv.Name] = dv
+		if dv.IsDelayed && dv.DelayMinutes >= delayMins {
+			add("delayed", dv, fmt.Sprintf("%s delayed %d min, now departs %s", dv.RouteShortName, dv.DelayMinutes, dv.DepartureTime))
+		}
+	}
+	return events
+}
+
+// bestDeparture is the departure a trip's notifications are about: the
+// last feasible one on an arrive_by trip, else the next viable one.
+func bestDeparture(tv TripView) (DepartureView, bool) {
+	if tv.ArriveBy == "" {
+		return nextDeparture(tv.Departures)
+	}
+	for _, dv := range tv.Departures {
+		if dv.LastFeasible {
+			return dv, true
+		}
+	}
+	return DepartureView{}, false
+}
+
+// serviceKey identifies a departure across checks: its trip ID, or its
+// route and timetabled departure when the upstream has no trip IDs.
+func serviceKey(dv DepartureView) string {
+	if dv.tripID != "" {
+		return dv.tripID
+	}
+	return dv.RouteShortName + "|" + dv.scheduledAt.Format(time.RFC3339)
+}
+
+func findService(deps []DepartureView, key string) (DepartureView, bool) {
+	for _, dv := range deps {
+		if serviceKey(dv) == key {
+			return dv, true
+		}
+	}
+	return DepartureView{}, false
+}
+
+// deliver sends ev to each configured provider.
+func (n *notifier) deliver(ctx context.Context, cfg NotifyConfig, ev NotifyEvent) {
+	if cfg.WebhookURL != "" {
+		body, _ := json.Marshal(ev)
+		if err := n.post(ctx, cfg.WebhookURL, "application/json", body); err != nil {
+			log.Printf("notify: webhook: %v", err)
+		}
+	}
+	if t := cfg.Telegram; t != nil {
+		body, _ := json.Marshal(map[string]string{"chat_id": t.ChatID, "text": ev.Trip + ": " + ev.Message})
+		if err := n.post(ctx, telegramAPIURL+"/bot"+t.BotToken+"/sendMessage", "application/json", body); err != nil {
+			log.Printf("notify: telegram: %v", err)
+		}
+	}
+	if p := cfg.Pushover; p != nil {
+		form := url.Values{"token": {p.Token}, "user": {p.User}, "title": {ev.Trip}, "message": {ev.Message}}
+		if p.Device != "" {
+			form.Set("device", p.Device)
+		}
+		if p.Priority != 0 {
+			form.Set("priority", fmt.Sprint(p.Priority))
+		}
+		if err := n.post(ctx, pushoverAPIURL, "application/x-www-form-urlencoded", []byte(form.Encode())); err != nil {
+			log.Printf("notify: pushover: %v", err)
+		}
+	}
+}
+
+// post sends body to u, failing on a non-2xx status. Errors leave out u,
+// which for Telegram has the bot token in it.
+func (n *notifier) post(ctx context.Context, u, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
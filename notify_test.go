@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifier_EventsFor(t *testing.T) {
+	n := newNotifier()
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	t1 := DepartureView{RouteShortName: "T1", DepartureTime: "08:10", HasConnection: true, departureAt: now.Add(10 * time.Minute), tripID: "t1"}
+	t2 := DepartureView{RouteShortName: "T2", DepartureTime: "08:20", HasConnection: true, departureAt: now.Add(20 * time.Minute), tripID: "t2"}
+	tv := func(deps ...DepartureView) TripView { return TripView{Name: "To Work", Departures: deps} }
+
+	if evs := n.eventsFor(tv(t1, t2), 5, now); len(evs) != 0 {
+		t.Fatalf("expected nothing on the first check, got %+v", evs)
+	}
+
+	lost := t1
+	lost.HasConnection = false
+	evs := n.eventsFor(tv(lost, t2), 5, now.Add(time.Minute))
+	if len(evs) != 1 || evs[0].Event != "connection_lost" || evs[0].Trip != "To Work" || evs[0].Departure.RouteShortName != "T1" {
+		t.Fatalf("expected T1 to lose its connection, got %+v", evs)
+	}
+
+	// T2 is now the best departure. Delayed, then gone.
+	late := t2
+	late.IsDelayed, late.DelayMinutes, late.DepartureTime = true, 6, "08:26"
+	evs = n.eventsFor(tv(lost, late), 5, now.Add(2*time.Minute))
+	if len(evs) != 1 || evs[0].Event != "delayed" || evs[0].Message != "T2 delayed 6 min, now departs 08:26" {
+		t.Fatalf("expected T2's delay, got %+v", evs)
+	}
+	if evs := n.eventsFor(tv(lost, late), 5, now.Add(3*time.Minute)); len(evs) != 0 {
+		t.Errorf("expected the delay to be sent once, got %+v", evs)
+	}
+	evs = n.eventsFor(tv(lost), 5, now.Add(4*time.Minute))
+	if len(evs) != 1 || evs[0].Event != "cancelled" || evs[0].Departure.RouteShortName != "T2" {
+		t.Fatalf("expected T2 to be cancelled, got %+v", evs)
+	}
+
+	// A departure that has left isn't cancelled.
+	n = newNotifier()
+	n.eventsFor(tv(t1), 5, now)
+	if evs := n.eventsFor(tv(), 5, now.Add(9*time.Minute)); len(evs) != 0 {
+		t.Errorf("expected nothing once T1 is due, got %+v", evs)
+	}
+}
+
+func TestNotifier_ArriveBy(t *testing.T) {
+	n := newNotifier()
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	early := DepartureView{RouteShortName: "S1", DepartureTime: "08:10", HasConnection: true, departureAt: now.Add(10 * time.Minute), tripID: "s1"}
+	last := DepartureView{RouteShortName: "S1", DepartureTime: "08:25", HasConnection: true, LastFeasible: true, departureAt: now.Add(25 * time.Minute), tripID: "s2"}
+	tv := TripView{Name: "Meeting", ArriveBy: "09:00", Departures: []DepartureView{early, last}}
+
+	n.eventsFor(tv, 5, now)
+	last.IsDelayed, last.DelayMinutes, last.DepartureTime = true, 5, "08:30"
+	tv.Departures = []DepartureView{early, last}
+	evs := n.eventsFor(tv, 5, now.Add(time.Minute))
+	if len(evs) != 1 || evs[0].Event != "delayed" || evs[0].Departure.RouteShortName != "S1" || evs[0].Departure.DepartureTime != "08:30" {
+		t.Fatalf("expected the last feasible departure's delay, got %+v", evs)
+	}
+
+	early.LastFeasible = true
+	tv.Departures = []DepartureView{early}
+	evs = n.eventsFor(tv, 5, now.Add(2*time.Minute))
+	if len(evs) != 1 || evs[0].Event != "connection_lost" || evs[0].Message != "S1 at 08:30 no longer arrives by 09:00" {
+		t.Fatalf("expected the 08:30 to no longer arrive in time, got %+v", evs)
+	}
+}
+
+func TestNotifier_Providers(t *testing.T) {
+	var telegram map[string]string
+	var telegramPath string
+	var pushover url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/bot"):
+			telegramPath = r.URL.Path
+			json.NewDecoder(r.Body).Decode(&telegram)
+		case r.URL.Path == "/pushover":
+			r.ParseForm()
+			pushover = r.PostForm
+		}
+	}))
+	defer srv.Close()
+	defer func(t, p string) { telegramAPIURL, pushoverAPIURL = t, p }(telegramAPIURL, pushoverAPIURL)
+	telegramAPIURL, pushoverAPIURL = srv.URL, srv.URL+"/pushover"
+
+	cfg := NotifyConfig{
+		Telegram: &TelegramConfig{BotToken: "123:abc", ChatID: "42"},
+		Pushover: &PushoverConfig{Token: "app", User: "me", Priority: 1},
+	}
+	ev := NotifyEvent{Event: "delayed", Trip: "To Work", Message: "T2 delayed 6 min, now departs 08:26"}
+	newNotifier().deliver(context.Background(), cfg, ev)
+
+	if telegramPath != "/bot123:abc/sendMessage" || telegram["chat_id"] != "42" || telegram["text"] != "To Work: T2 delayed 6 min, now departs 08:26" {
+		t.Errorf("unexpected Telegram message %s %v", telegramPath, telegram)
+	}
+	if pushover.Get("token") != "app" || pushover.Get("user") != "me" || pushover.Get("title") != "To Work" ||
+		pushover.Get("message") != ev.Message || pushover.Get("priority") != "1" || pushover.Has("device") {
+		t.Errorf("unexpected Pushover message %v", pushover)
+	}
+}
+
+func TestNotifier_Check(t *testing.T) {
+	var got []NotifyEvent
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev NotifyEvent
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&ev) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = append(got, ev)
+	}))
+	defer hook.Close()
+
+	now := time.Now().In(boardTZ)
+	delay := 480
+	realtime := now.Add(13 * time.Minute)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{
+			TripID: "t1", RouteShortName: "T1",
+			ScheduledDeparture: now.Add(5 * time.Minute), RealtimeDeparture: &realtime, DelaySeconds: &delay,
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+		}},
+	})
+	defer mock.Close()
+
+	route := RouteConfig{DepartureStopID: "100", FinalArrivalStop: "300"}
+	cfg := Config{
+		Notify: NotifyConfig{WebhookURL: hook.URL, Trips: []string{"to-work"}},
+		Trips:  []TripConfig{{Name: "Home", Routes: []RouteConfig{route}}, {Name: "To Work", Routes: []RouteConfig{route}}},
+	}
+	newNotifier().check(context.Background(), mock.URL, cfg, nil, now)
+	if len(got) != 1 || got[0].Event != "delayed" || got[0].Trip != "To Work" || got[0].Departure.DelayMinutes != 8 {
+		t.Errorf("expected one delay event for To Work, got %+v", got)
+	}
+}
+
+func TestNotifyConfig_Validate(t *testing.T) {
+	trips := []TripConfig{{Name: "To Work"}}
+	for _, c := range []NotifyConfig{
+		{Trips: []string{"To Work"}},
+		{WebhookURL: "hooks.example.com/board"},
+		{WebhookURL: "https://hooks.example.com/board", DelayThresholdMins: -1},
+		{WebhookURL: "https://hooks.example.com/board", Trips: []string{"Home"}},
+		{Telegram: &TelegramConfig{BotToken: "123:abc"}},
+		{Pushover: &PushoverConfig{Token: "app", User: "me", Priority: 2}},
+	} {
+		if c.validate(trips) == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	if err := (NotifyConfig{WebhookURL: "https://hooks.example.com/board", Trips: []string{"to-work"}}).validate(trips); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
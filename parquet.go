@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Parquet is only written, for the history export, and only flat tables of
+// required columns, so rather than pull in a Parquet library the file is
+// built by hand: one row group, one PLAIN-encoded, uncompressed data page per
+// column, and the footer in the Thrift compact protocol.
+//
+//	FileMetaData   1: version, 2: schema, 3: num_rows, 4: row_groups,
+//	               6: created_by
+//	SchemaElement  1: type, 3: repetition_type, 4: name, 5: num_children,
+//	               6: converted_type
+//	RowGroup       1: columns, 2: total_byte_size, 3: num_rows
+//	ColumnChunk    2: file_offset, 3: meta_data
+//	ColumnMetaData 1: type, 2: encodings, 3: path_in_schema, 4: codec,
+//	               5: num_values, 6: total_uncompressed_size,
+//	               7: total_compressed_size, 9: data_page_offset
+//	PageHeader     1: type, 2: uncompressed_page_size,
+//	               3: compressed_page_size, 5: data_page_header
+//	DataPageHeader 1: num_values, 2: encoding, 3: definition_level_encoding,
+//	               4: repetition_level_encoding
+
+const parquetMagic = "PAR1"
+
+// Parquet physical and converted types.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetNoConversion    = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// Thrift compact protocol field types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetColumn is one required column and its PLAIN-encoded values.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	values    []byte
+}
+
+func (c *parquetColumn) appendInt32(v int32) {
+	c.values = binary.LittleEndian.AppendUint32(c.values, uint32(v))
+}
+
+func (c *parquetColumn) appendInt64(v int64) {
+	c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v))
+}
+
+func (c *parquetColumn) appendString(s string) {
+	c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(s)))
+	c.values = append(c.values, s...)
+}
+
+// writeParquet writes rows rows of cols as a Parquet file.
+func writeParquet(w io.Writer, rows int, cols []parquetColumn) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	offsets := make([]int64, len(cols))
+	sizes := make([]int64, len(cols))
+	var total int64
+	if rows > 0 {
+		for i, c := range cols {
+			var h thriftWriter
+			h.begin(0)
+			h.i32(1, 0) // DATA_PAGE
+			h.i32(2, int32(len(c.values)))
+			h.i32(3, int32(len(c.values)))
+			h.begin(5)
+			h.i32(1, int32(rows))
+			h.i32(2, 0) // PLAIN
+			h.i32(3, 3) // RLE, though required columns have no levels
+			h.i32(4, 3)
+			h.end()
+			h.end()
+
+			offsets[i] = int64(file.Len())
+			sizes[i] = int64(h.Len() + len(c.values))
+			total += sizes[i]
+			file.Write(h.Bytes())
+			file.Write(c.values)
+		}
+	}
+
+	var m thriftWriter
+	m.begin(0)
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(cols)+1)
+	m.begin(0)
+	m.str(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.end()
+	for _, c := range cols {
+		m.begin(0)
+		m.i32(1, c.typ)
+		m.i32(3, 0) // REQUIRED
+		m.str(4, c.name)
+		if c.converted != parquetNoConversion {
+			m.i32(6, c.converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(rows))
+	if rows > 0 {
+		m.list(4, thriftStruct, 1)
+		m.begin(0)
+		m.list(1, thriftStruct, len(cols))
+		for i, c := range cols {
+			m.begin(0)
+			m.i64(2, offsets[i])
+			m.begin(3)
+			m.i32(1, c.typ)
+			m.list(2, thriftI32, 1)
+			m.varint(0) // PLAIN
+			m.list(3, thriftBinary, 1)
+			m.binary(c.name)
+			m.i32(4, 0) // UNCOMPRESSED
+			m.i64(5, int64(rows))
+			m.i64(6, sizes[i])
+			m.i64(7, sizes[i])
+			m.i64(9, offsets[i])
+			m.end()
+			m.end()
+		}
+		m.i64(2, total)
+		m.i64(3, int64(rows))
+		m.end()
+	} else {
+		m.list(4, thriftStruct, 0)
+	}
+	m.str(6, "departure-board")
+	m.end()
+
+	file.Write(m.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(m.Len())))
+	file.WriteString(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// thriftWriter encodes structs in the Thrift compact protocol. Field IDs are
+// written as deltas from the previous field of the same struct.
+type thriftWriter struct {
+	bytes.Buffer
+	last  int16
+	outer []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+// varint writes a zigzag-encoded integer, as i32 and i64 values are.
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.WriteByte(0xf0 | elem)
+		t.uvarint(uint64(n))
+	}
+}
+
+// begin starts a struct: field id of the enclosing struct, or with id 0 the
+// top-level struct or a list element.
+func (t *thriftWriter) begin(id int16) {
+	if id > 0 {
+		t.field(id, thriftStruct)
+	}
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.last, t.outer = t.outer[len(t.outer)-1], t.outer[:len(t.outer)-1]
+}
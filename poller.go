@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPrewarmInterval is how often the cache is refreshed inside a prewarm
+// window that doesn't set its own interval.
+const defaultPrewarmInterval = 15 * time.Second
+
+// pollerIdleInterval is the longest the poller sleeps, so it notices prewarm
+// windows starting.
+const pollerIdleInterval = time.Minute
+
+// Adaptive polling defaults: poll every 15s while something departs within 5
+// minutes, and every 10 minutes while nothing departs within the window.
+const (
+	defaultAdaptiveNearMinutes  = 5
+	defaultAdaptiveMinInterval  = 15
+	defaultAdaptiveIdleInterval = 600
+)
+
+// pollTTLSlack is added to a query's poll interval to get its cache TTL, so a
+// page load between two polls doesn't refetch.
+const pollTTLSlack = 10 * time.Second
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// poller keeps the departure cache fresh in the background. Queries of trips
+// or routes with a poll_interval are refreshed on that cadence; inside a
+// prewarm window every query is refreshed at least as often as the window's
+// interval.
+type poller struct {
+	cache  *departureCache
+	apiURL string
+
+	mu  sync.Mutex
+	cfg Config
+	// due is a copy of when each query is next polled, for the admin page.
+	due map[stopQuery]time.Time
+}
+
+func (p *poller) run(ctx context.Context) {
+	next := make(map[stopQuery]time.Time)
+	for {
+		wait := p.tick(ctx, time.Now(), next)
+		p.mu.Lock()
+		p.due = maps.Clone(next)
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p *poller) config() Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg
+}
+
+// setConfig makes the next tick poll the queries of cfg, after a config
+// reload.
+func (p *poller) setConfig(cfg Config) {
+	p.mu.Lock()
+	p.cfg = cfg
+	p.mu.Unlock()
+}
+
+// nextPoll returns when q is next polled, if it is polled at all.
+func (p *poller) nextPoll(q stopQuery) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at, ok := p.due[q]
+	return at, ok
+}
+
+// tick refreshes every query that is due at now, records when each is next
+// due, and returns how long to sleep.
+func (p *poller) tick(ctx context.Context, now time.Time, next map[stopQuery]time.Time) time.Duration {
+	cfg := p.config()
+	intervals := queryIntervals(p.apiURL, cfg)
+	window, inWindow := activePrewarmWindow(cfg.Prewarm, now.In(boardTZ))
+	if inWindow {
+		for _, q := range configQueries(p.apiURL, cfg) {
+			if iv, polled := intervals[q]; !polled || window.interval() < iv {
+				intervals[q] = window.interval()
+			}
+		}
+	}
+
+	var due []stopQuery
+	for q := range intervals {
+		if at, ok := next[q]; !ok || !now.Before(at) {
+			due = append(due, q)
+		}
+	}
+	refreshed := p.cache.refreshAll(ctx, due, cfg.BatchQueries, "poll")
+	windows := queryWindows(p.apiURL, cfg)
+
+	wait := pollerIdleInterval
+	for _, q := range due {
+		iv := intervals[q]
+		deps, ok := refreshed[q]
+		if !ok {
+			// A failed refresh says nothing about what departs: try again at
+			// the base interval, and leave the cached entry to expire as usual
+			next[q] = now.Add(iv)
+			continue
+		}
+		if cfg.AdaptivePolling.Enabled {
+			iv = cfg.AdaptivePolling.adapt(iv, deps, now, windows[q], !inWindow)
+		}
+		next[q] = now.Add(iv)
+		p.cache.setTTL(q, max(p.cache.ttl, iv+pollTTLSlack))
+	}
+	for q := range intervals {
+		wait = min(wait, next[q].Sub(now))
+	}
+	return wait
+}
+
+// adapt shortens a query's poll interval while one of its services departs
+// within near_minutes, and (when backOff is set) lengthens it to idle_interval
+// while nothing departs within window, the board's departure window. The idle
+// interval is capped at the window, so a service entering a short window
+// isn't left off the board for longer than the window itself.
+func (a AdaptivePollingConfig) adapt(iv time.Duration, deps []Departure, now time.Time, window time.Duration, backOff bool) time.Duration {
+	nearMins, minIv, idleIv := a.NearMinutes, a.MinInterval, a.IdleInterval
+	if nearMins <= 0 {
+		nearMins = defaultAdaptiveNearMinutes
+	}
+	if minIv <= 0 {
+		minIv = defaultAdaptiveMinInterval
+	}
+	if idleIv <= 0 {
+		idleIv = defaultAdaptiveIdleInterval
+	}
+	idle := min(time.Duration(idleIv)*time.Second, window)
+
+	var nextDep time.Time
+	for _, d := range deps {
+		t := effectiveDeparture(d)
+		if !t.Before(now) && (nextDep.IsZero() || t.Before(nextDep)) {
+			nextDep = t
+		}
+	}
+
+	switch {
+	case !nextDep.IsZero() && nextDep.Sub(now) <= time.Duration(nearMins)*time.Minute:
+		return min(iv, time.Duration(minIv)*time.Second)
+	case backOff && (nextDep.IsZero() || nextDep.Sub(now) > window):
+		return max(iv, idle)
+	}
+	return iv
+}
+
+// queryIntervals returns the poll interval of every stop query used by a route
+// that sets poll_interval (or whose trip does). A query shared by several
+// routes is polled at the shortest of their intervals.
+func queryIntervals(apiURL string, cfg Config) map[stopQuery]time.Duration {
+	intervals := make(map[stopQuery]time.Duration)
+	for _, trip := range cfg.Trips {
+		for _, route := range trip.Routes {
+			secs := route.PollInterval
+			if secs == 0 {
+				secs = trip.PollInterval
+			}
+			if secs == 0 {
+				secs = cfg.PollInterval
+			}
+			if secs <= 0 {
+				continue
+			}
+			iv := time.Duration(secs) * time.Second
+			for _, q := range routeQueries(apiURL, route) {
+				if cur, ok := intervals[q]; !ok || iv < cur {
+					intervals[q] = iv
+				}
+			}
+		}
+	}
+	return intervals
+}
+
+// queryWindows returns the departure window of the routes behind every stop
+// query, the shortest where several routes share one.
+func queryWindows(apiURL string, cfg Config) map[stopQuery]time.Duration {
+	windows := make(map[stopQuery]time.Duration)
+	for _, trip := range cfg.Trips {
+		for _, route := range trip.Routes {
+			w := time.Duration(route.windowMinutes()) * time.Minute
+			for _, q := range routeQueries(apiURL, route) {
+				if cur, ok := windows[q]; !ok || w < cur {
+					windows[q] = w
+				}
+			}
+		}
+	}
+	return windows
+}
+
+func activePrewarmWindow(windows []PrewarmWindow, now time.Time) (PrewarmWindow, bool) {
+	for _, w := range windows {
+		if w.contains(now) {
+			return w, true
+		}
+	}
+	return PrewarmWindow{}, false
+}
+
+func (w PrewarmWindow) contains(now time.Time) bool {
+	if len(w.Days) > 0 {
+		match := false
+		for _, d := range w.Days {
+			if weekdayNames[strings.ToLower(d)] == now.Weekday() {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	mins := now.Hour()*60 + now.Minute()
+	return mins >= start && mins < end
+}
+
+func (w PrewarmWindow) interval() time.Duration {
+	if w.Interval > 0 {
+		return time.Duration(w.Interval) * time.Second
+	}
+	return defaultPrewarmInterval
+}
+
+func (w PrewarmWindow) validate() error {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("window %s-%s ends before it starts", w.Start, w.End)
+	}
+	for _, d := range w.Days {
+		if _, ok := weekdayNames[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
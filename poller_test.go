@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrewarmWindowContains(t *testing.T) {
+	w := PrewarmWindow{Start: "06:45", End: "08:30", Days: []string{"Mon", "tue", "wed", "thu", "fri"}}
+
+	// 2024-06-03 is a Monday.
+	tests := []struct {
+		at       time.Time
+		expected bool
+	}{
+		{time.Date(2024, 6, 3, 6, 44, 0, 0, boardTZ), false},
+		{time.Date(2024, 6, 3, 6, 45, 0, 0, boardTZ), true},
+		{time.Date(2024, 6, 3, 8, 29, 0, 0, boardTZ), true},
+		{time.Date(2024, 6, 3, 8, 30, 0, 0, boardTZ), false},
+		{time.Date(2024, 6, 8, 7, 0, 0, 0, boardTZ), false}, // Saturday
+	}
+	for _, tc := range tests {
+		if got := w.contains(tc.at); got != tc.expected {
+			t.Errorf("%v: expected %v, got %v", tc.at, tc.expected, got)
+		}
+	}
+
+	everyDay := PrewarmWindow{Start: "17:00", End: "18:00"}
+	if !everyDay.contains(time.Date(2024, 6, 8, 17, 30, 0, 0, boardTZ)) {
+		t.Error("expected window without days to apply every day")
+	}
+}
+
+func TestActivePrewarmWindow(t *testing.T) {
+	windows := []PrewarmWindow{
+		{Start: "06:45", End: "08:30"},
+		{Start: "16:30", End: "18:00", Interval: 30},
+	}
+
+	w, ok := activePrewarmWindow(windows, time.Date(2024, 6, 3, 17, 0, 0, 0, boardTZ))
+	if !ok {
+		t.Fatal("expected an active window")
+	}
+	if w.interval() != 30*time.Second {
+		t.Errorf("expected 30s interval, got %v", w.interval())
+	}
+	if windows[0].interval() != defaultPrewarmInterval {
+		t.Errorf("expected default interval, got %v", windows[0].interval())
+	}
+
+	if _, ok := activePrewarmWindow(windows, time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)); ok {
+		t.Error("expected no active window at midday")
+	}
+}
+
+func TestPrewarmWindowValidate(t *testing.T) {
+	bad := []PrewarmWindow{
+		{Start: "6:45am", End: "08:30"},
+		{Start: "08:30", End: "06:45"},
+		{Start: "06:45", End: "08:30", Days: []string{"someday"}},
+	}
+	for _, w := range bad {
+		if err := w.validate(); err == nil {
+			t.Errorf("expected error for %+v", w)
+		}
+	}
+	if err := (PrewarmWindow{Start: "06:45", End: "08:30", Days: []string{"MON"}}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfig_InvalidPrewarm(t *testing.T) {
+	yaml := `
+prewarm:
+  - start: "25:00"
+    end: "26:00"
+trips:
+  - name: "Trip"
+    routes:
+      - departure_stop_id: "100"
+        final_arrival_stop: "300"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid prewarm window")
+	}
+}
+
+func TestQueryIntervals(t *testing.T) {
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "Metro", PollInterval: 15, Routes: []RouteConfig{
+				{DepartureStopID: "100", FinalArrivalStop: "300"},
+			}},
+			{Name: "Coach", Routes: []RouteConfig{
+				{DepartureStopID: "100", FinalArrivalStop: "300", PollInterval: 300},
+				{DepartureStopID: "500", TransferArrivalStopID: "600", TransferDepartureStopID: "601", FinalArrivalStop: "700", PollInterval: 300},
+			}},
+			{Name: "Unpolled", Routes: []RouteConfig{
+				{DepartureStopID: "800", FinalArrivalStop: "900"},
+			}},
+		},
+	}
+
+	intervals := queryIntervals("u", cfg)
+	if len(intervals) != 3 {
+		t.Fatalf("expected 3 polled queries, got %v", intervals)
+	}
+	if iv := intervals[stopQuery{"u", "100", "300", 0}]; iv != 15*time.Second {
+		t.Errorf("expected shared query to use the shortest interval, got %v", iv)
+	}
+	if iv := intervals[stopQuery{"u", "601", "700", 0}]; iv != 5*time.Minute {
+		t.Errorf("expected second leg polled every 5m, got %v", iv)
+	}
+	if _, ok := intervals[stopQuery{"u", "800", "900", 0}]; ok {
+		t.Error("expected trip without poll_interval not to be polled")
+	}
+
+	// A top-level poll_interval polls every query not set more specifically
+	cfg.PollInterval = 60
+	intervals = queryIntervals("u", cfg)
+	if iv := intervals[stopQuery{"u", "800", "900", 0}]; iv != time.Minute {
+		t.Errorf("expected the top-level interval for the unpolled trip, got %v", iv)
+	}
+	if iv := intervals[stopQuery{"u", "601", "700", 0}]; iv != 5*time.Minute {
+		t.Errorf("expected the route's own interval to win, got %v", iv)
+	}
+}
+
+func TestPollerTick(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]Departure{})
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "Metro", PollInterval: 15, Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+			{Name: "Coach", PollInterval: 300, Routes: []RouteConfig{{DepartureStopID: "500", FinalArrivalStop: "700"}}},
+		},
+	}
+	cache := newDepartureCache(departureCacheTTL)
+	p := &poller{cache: cache, apiURL: mock.URL, cfg: cfg}
+	next := make(map[stopQuery]time.Time)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)
+
+	if wait := p.tick(context.Background(), now, next); wait != 15*time.Second {
+		t.Errorf("expected to sleep until the metro is due, got %v", wait)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected both queries polled initially, got %d", calls.Load())
+	}
+
+	p.tick(context.Background(), now.Add(15*time.Second), next)
+	if calls.Load() != 3 {
+		t.Errorf("expected only the metro to be polled after 15s, got %d calls", calls.Load())
+	}
+
+	if ttl := cache.ttls[stopQuery{mock.URL, "500", "700", 0}]; ttl != 300*time.Second+pollTTLSlack {
+		t.Errorf("expected coach cache TTL to follow its poll interval, got %v", ttl)
+	}
+	if ttl := cache.ttls[stopQuery{mock.URL, "100", "300", 0}]; ttl != 25*time.Second {
+		t.Errorf("expected metro cache TTL of 25s, got %v", ttl)
+	}
+}
+
+func TestPollerTick_PrewarmWindow(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]Departure{})
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Prewarm: []PrewarmWindow{{Start: "06:45", End: "08:30", Interval: 10}},
+		Trips: []TripConfig{
+			{Name: "Coach", PollInterval: 300, Routes: []RouteConfig{{DepartureStopID: "500", FinalArrivalStop: "700"}}},
+			{Name: "Bus", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		},
+	}
+	p := &poller{cache: newDepartureCache(departureCacheTTL), apiURL: mock.URL, cfg: cfg}
+	next := make(map[stopQuery]time.Time)
+
+	outside := time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)
+	p.tick(context.Background(), outside, next)
+	if calls.Load() != 1 {
+		t.Fatalf("expected only the coach to be polled outside the window, got %d", calls.Load())
+	}
+
+	inside := time.Date(2024, 6, 4, 7, 0, 0, 0, boardTZ)
+	if wait := p.tick(context.Background(), inside, next); wait != 10*time.Second {
+		t.Errorf("expected window interval, got %v", wait)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected every query polled inside the window, got %d", calls.Load())
+	}
+}
+
+func TestAdaptivePollingAdapt(t *testing.T) {
+	a := AdaptivePollingConfig{Enabled: true}
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)
+	dep := func(mins int) []Departure {
+		return []Departure{{ScheduledDeparture: now.Add(time.Duration(mins) * time.Minute)}}
+	}
+
+	tests := []struct {
+		name     string
+		deps     []Departure
+		backOff  bool
+		expected time.Duration
+	}{
+		{"departing soon", dep(3), true, 15 * time.Second},
+		{"mid window", dep(30), true, 60 * time.Second},
+		{"nothing within the hour", dep(90), true, 600 * time.Second},
+		{"no departures", nil, true, 600 * time.Second},
+		{"no back-off in prewarm window", nil, false, 60 * time.Second},
+		{"departed already", dep(-2), true, 600 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := a.adapt(60*time.Second, tc.deps, now, time.Hour, tc.backOff); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	custom := AdaptivePollingConfig{Enabled: true, NearMinutes: 10, MinInterval: 20, IdleInterval: 900}
+	if got := custom.adapt(60*time.Second, dep(8), now, time.Hour, true); got != 20*time.Second {
+		t.Errorf("expected custom min interval, got %v", got)
+	}
+	if got := custom.adapt(60*time.Second, nil, now, time.Hour, true); got != 900*time.Second {
+		t.Errorf("expected custom idle interval, got %v", got)
+	}
+
+	// A 10-minute window: a departure 30 minutes out is outside it, and the
+	// back-off can't outlast it
+	if got := a.adapt(60*time.Second, dep(30), now, 10*time.Minute, true); got != 10*time.Minute {
+		t.Errorf("expected the back-off capped at the window, got %v", got)
+	}
+	if got := a.adapt(60*time.Second, dep(8), now, 10*time.Minute, true); got != 60*time.Second {
+		t.Errorf("expected no back-off with a departure in the window, got %v", got)
+	}
+}
+
+func TestPollerTick_Adaptive(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deps []Departure
+		if r.URL.Query().Get("stop_id") == "100" {
+			deps = []Departure{{ScheduledDeparture: now.Add(2 * time.Minute)}}
+		}
+		json.NewEncoder(w).Encode(deps)
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		AdaptivePolling: AdaptivePollingConfig{Enabled: true},
+		Trips: []TripConfig{
+			{Name: "Bus", PollInterval: 60, Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+			{Name: "Coach", PollInterval: 60, Routes: []RouteConfig{{DepartureStopID: "500", FinalArrivalStop: "700"}}},
+			{Name: "Ferry", PollInterval: 60, Routes: []RouteConfig{{DepartureStopID: "800", FinalArrivalStop: "900", window: 8}}},
+		},
+	}
+	p := &poller{cache: newDepartureCache(departureCacheTTL), apiURL: mock.URL, cfg: cfg}
+	next := make(map[stopQuery]time.Time)
+
+	if wait := p.tick(context.Background(), now, next); wait != 15*time.Second {
+		t.Errorf("expected to poll the imminent bus sooner, got %v", wait)
+	}
+	if due := next[stopQuery{mock.URL, "500", "700", 0}]; !due.Equal(now.Add(600 * time.Second)) {
+		t.Errorf("expected the idle coach to back off, next due %v", due)
+	}
+	if due := next[stopQuery{mock.URL, "800", "900", 0}]; !due.Equal(now.Add(8 * time.Minute)) {
+		t.Errorf("expected the ferry's back-off capped at its window, next due %v", due)
+	}
+}
+
+func TestPollerTick_AdaptiveFailedRefresh(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream down", http.StatusBadGateway)
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		AdaptivePolling: AdaptivePollingConfig{Enabled: true},
+		Trips: []TripConfig{
+			{Name: "Coach", PollInterval: 60, Routes: []RouteConfig{{DepartureStopID: "500", FinalArrivalStop: "700"}}},
+		},
+	}
+	cache := newDepartureCache(departureCacheTTL)
+	p := &poller{cache: cache, apiURL: mock.URL, cfg: cfg}
+	next := make(map[stopQuery]time.Time)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, boardTZ)
+
+	// An error isn't an empty board, so the query mustn't back off
+	q := stopQuery{mock.URL, "500", "700", 0}
+	if wait := p.tick(context.Background(), now, next); wait != 60*time.Second {
+		t.Errorf("expected a retry at the base interval, got %v", wait)
+	}
+	if due := next[q]; !due.Equal(now.Add(60 * time.Second)) {
+		t.Errorf("expected the failed query due at its base interval, got %v", due)
+	}
+	if ttl, ok := cache.ttls[q]; ok {
+		t.Errorf("expected the cache TTL left alone, got %v", ttl)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configPreview holds a candidate config uploaded at /preview so its board can
+// be checked before it replaces the live config file.
+type configPreview struct {
+	path   string
+	apiURL string
+	tmpl   *template.Template
+
+	mu     sync.Mutex
+	staged []byte
+	cfg    Config
+}
+
+type previewPage struct {
+	Config   string
+	Staged   bool
+	Error    string
+	Notice   string
+	Path     string
+	Warnings []string
+}
+
+var previewFormTemplate = template.Must(template.New("preview").Parse(previewTemplate))
+
+// stage parses and checks a candidate config and, if it is valid, replaces
+// any previously staged one.
+func (p *configPreview) stage(ctx context.Context, data []byte) error {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cfg.warnings = validateStops(ctx, p.apiURL, cfg)
+	cfg.startClients()
+
+	p.mu.Lock()
+	p.staged, p.cfg = data, cfg
+	p.mu.Unlock()
+	return nil
+}
+
+// promote atomically replaces the config file with the staged config.
+func (p *configPreview) promote() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.staged == nil {
+		return errors.New("no config staged")
+	}
+	if err := writeFileAtomic(p.path, p.staged); err != nil {
+		return err
+	}
+	p.staged, p.cfg = nil, Config{}
+	return nil
+}
+
+func (p *configPreview) discard() {
+	p.mu.Lock()
+	p.staged, p.cfg = nil, Config{}
+	p.mu.Unlock()
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it
+// into place, so readers see either the old file or the new one.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// handleForm shows the staged config (or the live one) for editing. POSTing
+// the form stages the edited config, or discards it with action=discard.
+func (p *configPreview) handleForm(w http.ResponseWriter, r *http.Request) {
+	page := previewPage{Path: p.path}
+	status := http.StatusOK
+
+	switch r.Method {
+	case http.MethodGet:
+		switch r.URL.Query().Get("done") {
+		case "promoted":
+			page.Notice = fmt.Sprintf("Promoted to %s. The board applies it within a few seconds.", p.path)
+		case "discarded":
+			page.Notice = "Staged config discarded."
+		}
+	case http.MethodPost:
+		if r.FormValue("action") == "discard" {
+			p.discard()
+			http.Redirect(w, r, "/preview?done=discarded", http.StatusSeeOther)
+			return
+		}
+		data := []byte(strings.ReplaceAll(r.FormValue("config"), "\r\n", "\n"))
+		if err := p.stage(r.Context(), data); err != nil {
+			page.Config, page.Error = string(data), err.Error()
+			status = http.StatusBadRequest
+			break
+		}
+		http.Redirect(w, r, "/preview", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.mu.Lock()
+	if p.staged != nil {
+		page.Staged, page.Warnings = true, p.cfg.warnings
+		if page.Config == "" {
+			page.Config = string(p.staged)
+		}
+	}
+	p.mu.Unlock()
+	if page.Config == "" {
+		live, err := os.ReadFile(p.path)
+		if err != nil {
+			page.Error = fmt.Sprintf("reading config: %v", err)
+		}
+		page.Config = string(live)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	previewFormTemplate.Execute(w, page)
+}
+
+// handleBoard renders the board from the staged config, uncached.
+func (p *configPreview) handleBoard(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	cfg, ok := p.cfg, p.staged != nil
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, "no config staged", http.StatusNotFound)
+		return
+	}
+	// The client renderer would poll the live /api/board.
+	cfg.ClientRender = false
+	renderBoard(w, r, cfg.boardPage(p.tmpl), p.apiURL, cfg, nil)
+}
+
+func (p *configPreview) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.promote(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Redirect(w, r, "/preview?done=promoted", http.StatusSeeOther)
+}
+
+var previewTemplate = strings.TrimSpace(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Config preview</title>
+<style>
+*{margin:0;padding:0;box-sizing:border-box}
+body{font-family:"IBM Plex Sans",system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;color:#1a1a1a;background:#fafafa;padding:16px}
+h1{font-size:18px;font-weight:600;margin-bottom:12px}
+.cols{display:flex;gap:16px;flex-wrap:wrap}
+.cols>*{flex:1 1 420px}
+textarea{width:100%;height:70vh;font:13px/1.4 ui-monospace,Menlo,monospace;padding:8px;border:1px solid #ccc;border-radius:4px}
+iframe{width:100%;height:70vh;border:1px solid #ccc;border-radius:4px;background:#fff}
+.placeholder{height:70vh;display:flex;align-items:center;justify-content:center;color:#555;border:1px dashed #ccc;border-radius:4px}
+.actions{margin-top:8px;display:flex;gap:8px}
+button{font:inherit;font-size:14px;padding:6px 14px;border-radius:4px;border:1px solid #ccc;background:#fff;cursor:pointer}
+button.primary{background:#1a1a1a;color:#fff;border-color:#1a1a1a}
+.err,.notice,.warn{font-size:14px;padding:8px 12px;border-radius:4px;margin-bottom:12px}
+.err{background:#ffe5e5;color:#a00}
+.notice{background:#e6f4ea;color:#1e6b35}
+.warn{background:#fff4e0;color:#8a4b00}
+</style>
+</head>
+<body>
+<h1>Config preview</h1>
+{{with .Notice}}<div class="notice">{{.}}</div>{{end}}
+{{with .Error}}<div class="err">{{.}}</div>{{end}}
+{{range .Warnings}}<div class="warn">{{.}}</div>{{end}}
+<div class="cols">
+<form method="post" action="/preview">
+<textarea name="config" spellcheck="false">{{.Config}}</textarea>
+<div class="actions">
+<button type="submit">Preview</button>
+{{if .Staged}}<button type="submit" name="action" value="discard">Discard</button>{{end}}
+</div>
+</form>
+<div>
+{{if .Staged}}
+<iframe src="/preview/board" title="Staged board"></iframe>
+<form method="post" action="/preview/promote" class="actions">
+<button type="submit" class="primary">Promote to {{.Path}}</button>
+</form>
+{{else}}
+<div class="placeholder">Edit the config and press Preview to see its board here.</div>
+{{end}}
+</div>
+</div>
+</body>
+</html>
+`)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPreview(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{
+			RouteShortName:     "T1",
+			ScheduledDeparture: now.Add(10 * time.Minute),
+			Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}},
+		}},
+	})
+	defer mock.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	live := "trips:\n  - name: Live\n    routes:\n      - departure_stop_id: \"100\"\n        final_arrival_stop: \"300\"\n"
+	if err := os.WriteFile(path, []byte(live), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &configPreview{path: path, apiURL: mock.URL, tmpl: parseTemplate()}
+
+	post := func(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	p.handleForm(w, httptest.NewRequest("GET", "/preview", nil))
+	if !strings.Contains(w.Body.String(), "name: Live") {
+		t.Error("expected the form to start from the live config")
+	}
+
+	if w := post(p.handleForm, "/preview", url.Values{"config": {"trips: []"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid config, got %d", w.Code)
+	}
+
+	candidate := strings.Replace(live, "Live", "Candidate", 1)
+	if w := post(p.handleForm, "/preview", url.Values{"config": {candidate}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect after staging, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	p.handleBoard(w, httptest.NewRequest("GET", "/preview/board", nil))
+	if !strings.Contains(w.Body.String(), "Candidate") {
+		t.Error("expected the preview board to use the staged config")
+	}
+	if data, _ := os.ReadFile(path); string(data) != live {
+		t.Error("expected the live config to be untouched before promotion")
+	}
+
+	if w := post(p.handlePromote, "/preview/promote", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect after promotion, got %d", w.Code)
+	}
+	if data, _ := os.ReadFile(path); string(data) != candidate {
+		t.Errorf("expected the staged config to be written, got %q", data)
+	}
+	if w := post(p.handlePromote, "/preview/promote", nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 with nothing staged, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	p.handleBoard(w, httptest.NewRequest("GET", "/preview/board", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for the preview board with nothing staged, got %d", w.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PrintData struct {
+	Trip      TripView
+	Date      time.Time
+	Generated time.Time
+}
+
+// buildPrintHandler renders one trip's viable journeys for a whole service day
+// as an A4 timetable. ?trip= selects the trip by index or name and ?date=
+// (YYYY-MM-DD) the day, defaulting to today.
+func buildPrintHandler(apiURL string, cfg Config) http.HandlerFunc {
+	tmpl := template.Must(template.New("print").Funcs(localeFuncs()).Parse(printTemplate))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		trip, ok := selectTrip(cfg.Trips, r.URL.Query().Get("trip"))
+		if !ok {
+			http.Error(w, "unknown trip", http.StatusNotFound)
+			return
+		}
+
+		loc := trip.timeZone()
+		now := time.Now().In(loc)
+		day := now
+		if d := r.URL.Query().Get("date"); d != "" {
+			var err error
+			day, err = time.ParseInLocation(dateLayout, d, loc)
+			if err != nil {
+				http.Error(w, "invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+		tv, err := buildDayTripView(r.Context(), apiURL, cfg, trip, start)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		tmpl.Execute(w, PrintData{Trip: tv, Date: start, Generated: now})
+	}
+}
+
+// buildDayTripView builds a trip's viable journeys over the service day
+// starting at midnight start, from the upstream's static schedule, in
+// departure order.
+func buildDayTripView(ctx context.Context, apiURL string, cfg Config, trip TripConfig, start time.Time) (TripView, error) {
+	end := start.AddDate(0, 0, 1)
+	date := start.Format(dateLayout)
+
+	tv, err := collectTripView(ctx, trip, func(ctx context.Context, route RouteConfig) ([]DepartureView, error) {
+		return routeDepartures(cfg, route, start, end, func(stopID, arrivalStops string) ([]Departure, error) {
+			return fetchDeparturesOn(ctx, route.upstreamURL(apiURL), stopID, arrivalStops, date)
+		})
+	})
+	if err != nil {
+		return tv, err
+	}
+
+	// A timetable reads in departure order
+	sort.SliceStable(tv.Departures, func(i, j int) bool {
+		return tv.Departures[i].departureAt.Before(tv.Departures[j].departureAt)
+	})
+	return tv, nil
+}
+
+var printTemplate = strings.TrimSpace(`
+<!DOCTYPE html>
+<html lang="{{(locale).Lang}}" dir="{{(locale).Dir}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Trip.Name}} — {{(locale).LongDate .Date}}</title>
+<style>
+@page{size:A4;margin:15mm}
+*{margin:0;padding:0;box-sizing:border-box}
+body{font-family:"IBM Plex Sans",system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;color:#000;background:#fff;font-size:11pt;max-width:180mm;margin:0 auto;padding:8mm 0}
+h1{font-size:18pt;font-weight:600}
+.date{font-size:12pt;margin:2pt 0 10pt}
+table{width:100%;border-collapse:collapse}
+th{text-align:start;font-size:9pt;text-transform:uppercase;letter-spacing:.05em;border-bottom:1.5pt solid #000;padding:4pt}
+td{padding:3pt 4pt;border-bottom:.5pt solid #bbb;vertical-align:top}
+tr{page-break-inside:avoid}
+.time{font-weight:600;font-variant-numeric:tabular-nums;white-space:nowrap}
+.route{font-weight:700}
+.note{font-size:9pt}
+.empty{padding:24pt 0;text-align:center}
+footer{margin-top:10pt;font-size:8pt;color:#555}
+.print{float:inline-end;font:inherit;font-size:10pt;padding:2pt 8pt;cursor:pointer}
+@media print{.print{display:none}}
+</style>
+</head>
+<body>
+<button class="print" onclick="window.print()">Print</button>
+<h1>{{.Trip.Name}}</h1>
+<div class="date">{{(locale).LongDate .Date}}</div>
+{{if not .Trip.Departures}}
+<div class="empty">No viable journeys on this day</div>
+{{else}}
+<table>
+<thead><tr><th>Departs</th><th>From</th><th>Service</th><th>Change</th><th>Arrives</th><th></th></tr></thead>
+<tbody>
+{{range .Trip.Departures}}
+<tr>
+<td class="time">{{if .IsOnDemand}}{{.PickupWindow}}{{else}}{{.DepartureTime}}{{end}}</td>
+<td>{{.DepartureName}}</td>
+<td><span class="route">{{.RouteShortName}}</span>{{if .Headsign}} {{.Headsign}}{{end}}</td>
+<td>{{if .Connections}}{{range $i, $c := .Connections}}{{if $i}}<br>{{end}}{{$c.TransferName}}: <span class="route">{{$c.RouteShortName}}</span> ({{$c.WaitMins}} min){{end}}{{else if .TransferName}}{{.TransferName}}{{end}}</td>
+<td class="time">{{if .ConnectionUnknown}}?{{else}}{{.FinalArrivalTime}}{{end}}</td>
+<td class="note">{{if .SchoolDaysOnly}}School days only {{end}}{{if .ConnectionUnknown}}Connection unknown {{end}}{{.BookingNote}}</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+{{end}}
+<footer>Scheduled times as of {{(locale).ShortDate .Generated}} {{(locale).Clock .Generated}}. Check for changes before travelling.</footer>
+</body>
+</html>
+`)
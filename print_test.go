@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintHandler(t *testing.T) {
+	day := time.Date(2024, 6, 3, 0, 0, 0, 0, boardTZ)
+	var gotDate string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotDate = r.URL.Query().Get("date")
+		deps := []Departure{
+			{
+				RouteShortName:     "T2",
+				ScheduledDeparture: day.Add(18 * time.Hour),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: day.Add(18*time.Hour + 20*time.Minute)}},
+			},
+			{
+				RouteShortName:     "T1",
+				ScheduledDeparture: day.Add(7 * time.Hour),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: day.Add(7*time.Hour + 20*time.Minute)}},
+			},
+			{
+				// Next service day
+				RouteShortName:     "T9",
+				ScheduledDeparture: day.Add(25 * time.Hour),
+				Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: day.Add(26 * time.Hour)}},
+			},
+		}
+		json.NewEncoder(w).Encode(deps)
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300", DepartureName: "Home"}}}},
+	}
+	handler := buildPrintHandler(mock.URL, cfg)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/print?trip=To+Work&date=2024-06-03", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if gotDate != "2024-06-03" {
+		t.Errorf("expected the day's schedule requested upstream, got date %q", gotDate)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Monday 3 June 2024") {
+		t.Error("expected the date in the heading")
+	}
+	if !strings.Contains(body, "@page{size:A4") {
+		t.Error("expected an A4 print stylesheet")
+	}
+	t1, t2 := strings.Index(body, "07:00"), strings.Index(body, "18:00")
+	if t1 < 0 || t2 < 0 || t1 > t2 {
+		t.Error("expected the day's journeys in departure order")
+	}
+	if strings.Contains(body, "T9") {
+		t.Error("expected departures from the next day to be left out")
+	}
+}
+
+func TestPrintHandler_BadRequest(t *testing.T) {
+	cfg := Config{Trips: []TripConfig{{Name: "To Work"}}}
+	handler := buildPrintHandler("http://unused", cfg)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/print?date=03/06/2024", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for malformed date, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/print?trip=Nowhere", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown trip, got %d", w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+)
+
+// WebManifest is /manifest.webmanifest, which lets browsers install the
+// board to a home screen as a standalone app.
+type WebManifest struct {
+	ID              string         `json:"id"`
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []ManifestIcon `json:"icons"`
+}
+
+type ManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// iconSizes are the sizes /icon-{size}.png serves: 180 for iOS's
+// apple-touch-icon, 192 and 512 for the manifest.
+var iconSizes = []int{180, 192, 512}
+
+const (
+	defaultIconAccent = "#ea580c"
+	defaultIconText   = "#fafafa"
+)
+
+// buildManifestHandler serves /manifest.webmanifest. With ?trip= (by index,
+// name or slug) the app opens on that trip alone, named after it, so a
+// phone can have the commute it's used for installed without the others.
+func buildManifestHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := WebManifest{
+			ID:              "/",
+			Name:            "Departure Board",
+			ShortName:       "Departures",
+			StartURL:        "/",
+			Display:         "standalone",
+			BackgroundColor: firstOf(cfg.Theme.Background, "#fafafa"),
+			ThemeColor:      firstOf(cfg.Theme.Header, "#e4e4e4"),
+		}
+		if key := r.URL.Query().Get("trip"); key != "" {
+			trip, ok := selectTrip(cfg.Trips, key)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			m.StartURL = "/trip/" + tripSlug(trip.Name)
+			m.ID, m.Name, m.ShortName = m.StartURL, trip.Name, trip.Name
+		}
+		for _, size := range iconSizes[1:] {
+			src, sizes := "/icon-"+strconv.Itoa(size)+".png", strconv.Itoa(size)+"x"+strconv.Itoa(size)
+			m.Icons = append(m.Icons,
+				ManifestIcon{Src: src, Sizes: sizes, Type: "image/png", Purpose: "any"},
+				ManifestIcon{Src: src, Sizes: sizes, Type: "image/png", Purpose: "maskable"})
+		}
+
+		w.Header().Set("Content-Type", "application/manifest+json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// buildIconHandler serves /icon-{size}.png: the app icon at size pixels
+// square, in the theme's accent colour when that's a hex colour.
+func buildIconHandler(cfg Config, size int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bg, ok := parseHexColor(cfg.Theme.Accent)
+		if !ok {
+			bg, _ = parseHexColor(defaultIconAccent)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, renderIcon(size, bg)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(buf.Bytes())
+	}
+}
+
+// renderIcon draws the app icon: three departure rows, each a route badge
+// and a line of text, on bg. The rows stay within the middle half, well
+// inside the circle a maskable icon may be cropped to.
+func renderIcon(size int, bg color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	fg, _ := parseHexColor(defaultIconText)
+	unit := func(n int) int { return n * size / 20 }
+	for i, end := range []int{15, 14, 12} {
+		y := unit(6 + 3*i)
+		draw.Draw(img, image.Rect(unit(5), y, unit(8), y+unit(2)), image.NewUniform(fg), image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(unit(9), y+unit(1)/2, unit(end), y+unit(2)-unit(1)/2), image.NewUniform(fg), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// parseHexColor reads a #rgb or #rrggbb colour.
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) == 4 && s[0] == '#' {
+		s = "#" + string([]byte{s[1], s[1], s[2], s[2], s[3], s[3]})
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// serviceWorkerHandler serves /sw.js. It shows push notifications when web
+// push is on, and keeps a copy of the last board page that loaded so one
+// still opens when the device is offline. The copy's banner saying it's
+// offline, hidden on the live page, is shown.
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, serviceWorkerJS)
+}
+
+var serviceWorkerJS = `
+var PAGES = 'board-pages-v1';
+self.addEventListener('install', function(e){ self.skipWaiting(); });
+self.addEventListener('activate', function(e){ e.waitUntil(clients.claim()); });
+self.addEventListener('fetch', function(e){
+  var u = new URL(e.request.url);
+  if (e.request.mode !== 'navigate' || u.origin !== location.origin) return;
+  if (u.pathname !== '/' && u.pathname.indexOf('/trip/') !== 0) return;
+  e.respondWith(fetch(e.request).then(function(resp){
+    if (resp.ok && (resp.headers.get('Content-Type') || '').indexOf('text/html') === 0) {
+      var copy = resp.clone();
+      e.waitUntil(caches.open(PAGES).then(function(c){ return c.put(e.request, copy); }));
+    }
+    return resp;
+  }).catch(function(err){
+    return caches.open(PAGES).then(function(c){
+      return c.match(e.request).then(function(r){ return r || c.match('/'); });
+    }).then(function(r){
+      if (!r) throw err;
+      return r.text().then(function(html){
+        return new Response(html.replace('id="offline" hidden', 'id="offline"'), {headers: {'Content-Type': 'text/html; charset=utf-8'}});
+      });
+    });
+  }));
+});
+self.addEventListener('push', function(e){
+  var d = {};
+  try { d = e.data.json(); } catch (_) { d = {body: e.data ? e.data.text() : ''}; }
+  e.waitUntil(self.registration.showNotification(d.title || 'Departure Board', {body: d.body, tag: d.tag, renotify: true}));
+});
+self.addEventListener('notificationclick', function(e){
+  e.notification.close();
+  e.waitUntil(clients.matchAll({type: 'window'}).then(function(ws){
+    if (ws.length) return ws[0].focus();
+    return clients.openWindow('/');
+  }));
+});
+`
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestManifestHandler(t *testing.T) {
+	cfg := Config{
+		Theme: ThemeConfig{ThemeColors: ThemeColors{Header: "#003366"}},
+		Trips: []TripConfig{{Name: "Home"}, {Name: "To Work"}},
+	}
+	h := buildManifestHandler(cfg)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/manifest.webmanifest", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/manifest+json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	var m WebManifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.StartURL != "/" || m.Display != "standalone" || m.ThemeColor != "#003366" || m.BackgroundColor != "#fafafa" {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	if len(m.Icons) != 4 || m.Icons[3].Src != "/icon-512.png" || m.Icons[3].Purpose != "maskable" {
+		t.Errorf("expected 192 and 512 icons, any and maskable, got %+v", m.Icons)
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/manifest.webmanifest?trip=to-work", nil))
+	m = WebManifest{}
+	json.Unmarshal(rec.Body.Bytes(), &m)
+	if m.StartURL != "/trip/to-work" || m.ID != m.StartURL || m.Name != "To Work" {
+		t.Errorf("expected the manifest to open on To Work, got %+v", m)
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/manifest.webmanifest?trip=nowhere", nil))
+	if rec.Code != 404 {
+		t.Errorf("expected 404 for an unknown trip, got %d", rec.Code)
+	}
+}
+
+func TestIconHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	buildIconHandler(Config{Theme: ThemeConfig{ThemeColors: ThemeColors{Accent: "#06c"}}}, 192)(rec, httptest.NewRequest("GET", "/icon-192.png", nil))
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 192 || b.Dy() != 192 {
+		t.Errorf("expected 192x192, got %v", b)
+	}
+	if c := color.RGBAModel.Convert(img.At(0, 0)); c != (color.RGBA{0x00, 0x66, 0xcc, 0xff}) {
+		t.Errorf("expected the accent colour in the corner, got %v", c)
+	}
+
+	rec = httptest.NewRecorder()
+	buildIconHandler(Config{Theme: ThemeConfig{ThemeColors: ThemeColors{Accent: "teal"}}}, 180)(rec, httptest.NewRequest("GET", "/icon-180.png", nil))
+	img, _ = png.Decode(rec.Body)
+	if c := color.RGBAModel.Convert(img.At(0, 0)); c != (color.RGBA{0xea, 0x58, 0x0c, 0xff}) {
+		t.Errorf("expected the default accent for a named colour, got %v", c)
+	}
+}
+
+func TestBoardTemplate_Manifest(t *testing.T) {
+	mock := newMockAPI(t, map[string][]Departure{})
+	cfg := Config{Trips: []TripConfig{{Name: "Home"}, {Name: "To Work"}}}
+	h := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `<link rel="manifest" href="/manifest.webmanifest"`) ||
+		!strings.Contains(body, `<link rel="apple-touch-icon" href="/icon-180.png">`) {
+		t.Error("expected the manifest and icon links")
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/trip/to-work", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `href="/manifest.webmanifest?trip=to-work"`) ||
+		!strings.Contains(body, `apple-mobile-web-app-title" content="To Work"`) {
+		t.Error("expected the pinned trip's manifest")
+	}
+}
+
+func TestServiceWorker_OfflineBanner(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serviceWorkerHandler(rec, httptest.NewRequest("GET", "/sw.js", nil))
+	sw := rec.Body.String()
+	if !strings.Contains(sw, "addEventListener('fetch'") || !strings.Contains(sw, "addEventListener('push'") {
+		t.Error("expected the service worker to handle fetches and pushes")
+	}
+
+	// The service worker shows the banner by dropping its hidden attribute
+	// from the copy of the page it kept.
+	mock := newMockAPI(t, map[string][]Departure{})
+	rec = httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, Config{Trips: []TripConfig{{Name: "Home"}}}, nil)(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `id="offline" hidden`) || !strings.Contains(sw, `'id="offline" hidden'`) {
+		t.Error("expected the page's hidden offline banner to match what the service worker shows")
+	}
+	if !strings.Contains(body, "register('/sw.js')") {
+		t.Error("expected the page to register the service worker")
+	}
+}
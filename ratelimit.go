@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig caps how fast the board sends requests to the GTFS
+// departure service, so a short poll interval or many clients can't get an
+// API key throttled. Up to burst requests go at once; after that they're
+// spaced out to requests_per_second.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst,omitempty"`
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.RequestsPerSecond < 0 || c.Burst < 0 {
+		return fmt.Errorf("requests_per_second and burst can't be negative")
+	}
+	if c.Burst > 0 && c.RequestsPerSecond == 0 {
+		return fmt.Errorf("burst needs requests_per_second")
+	}
+	return nil
+}
+
+// burst defaults to a second's worth of requests, and at least one.
+func (c RateLimitConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return max(1, int(math.Ceil(c.RequestsPerSecond)))
+}
+
+// rateLimitTransport holds requests back with a token bucket. A request
+// waits for its token within its own deadline; one that runs out of time
+// first fails without being sent.
+type rateLimitTransport struct {
+	next  http.RoundTripper
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimitTransport(next http.RoundTripper, cfg RateLimitConfig) *rateLimitTransport {
+	burst := float64(cfg.burst())
+	return &rateLimitTransport{next: next, rate: cfg.RequestsPerSecond, burst: burst, tokens: burst, now: time.Now}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := t.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			t.cancel()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// reserve takes a token and returns how long to wait until it's due.
+func (t *rateLimitTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if !t.last.IsZero() {
+		t.tokens = min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	}
+	t.last = now
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// cancel returns the token of a request that gave up waiting.
+func (t *rateLimitTransport) cancel() {
+	t.mu.Lock()
+	t.tokens = min(t.burst, t.tokens+1)
+	t.mu.Unlock()
+}
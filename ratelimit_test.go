@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTransport_Reserve(t *testing.T) {
+	clock := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	rt := newRateLimitTransport(http.DefaultTransport, RateLimitConfig{RequestsPerSecond: 2, Burst: 3})
+	rt.now = func() time.Time { return clock }
+
+	for i := range 3 {
+		if wait := rt.reserve(); wait != 0 {
+			t.Fatalf("request %d: expected the burst to go at once, waited %v", i+1, wait)
+		}
+	}
+	if wait := rt.reserve(); wait != 500*time.Millisecond {
+		t.Errorf("expected the fourth request to wait for the next token, got %v", wait)
+	}
+	if wait := rt.reserve(); wait != time.Second {
+		t.Errorf("expected the fifth to queue behind it, got %v", wait)
+	}
+
+	// A quiet spell refills the bucket, but only up to the burst
+	clock = clock.Add(time.Minute)
+	for i := range 3 {
+		if wait := rt.reserve(); wait != 0 {
+			t.Fatalf("request %d after a pause: expected no wait, got %v", i+1, wait)
+		}
+	}
+	if wait := rt.reserve(); wait == 0 {
+		t.Error("expected the bucket to hold no more than the burst")
+	}
+}
+
+func TestRateLimitTransport_Deadline(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("[]"))
+	}))
+	defer mock.Close()
+
+	rt := newRateLimitTransport(http.DefaultTransport, RateLimitConfig{RequestsPerSecond: 0.1})
+	client := &http.Client{Transport: rt}
+	resp, err := client.Get(mock.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The next token is ten seconds away, past this request's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, mock.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out waiting, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the timed out request not to be sent, got %d calls", calls.Load())
+	}
+	if rt.tokens < -0.001 || rt.tokens > 0.01 {
+		t.Errorf("expected the abandoned token back, have %v", rt.tokens)
+	}
+}
+
+func TestRateLimitConfig_Validate(t *testing.T) {
+	for _, c := range []RateLimitConfig{
+		{RequestsPerSecond: -1},
+		{RequestsPerSecond: 1, Burst: -1},
+		{Burst: 5},
+	} {
+		if c.validate() == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	if b := (RateLimitConfig{RequestsPerSecond: 2.5}).burst(); b != 3 {
+		t.Errorf("expected burst to default to a second's worth, got %d", b)
+	}
+	if b := (RateLimitConfig{RequestsPerSecond: 0.2}).burst(); b != 1 {
+		t.Errorf("expected a burst of at least one, got %d", b)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// configReloadInterval is how often the config file is checked for changes.
+const configReloadInterval = 2 * time.Second
+
+// liveConfig holds the running config. A config reload swaps in the new one
+// atomically; requests already in flight finish with the config they started
+// with.
+type liveConfig struct {
+	cur atomic.Pointer[Config]
+}
+
+func newLiveConfig(cfg Config) *liveConfig {
+	l := &liveConfig{}
+	l.cur.Store(&cfg)
+	return l
+}
+
+func (l *liveConfig) Load() Config {
+	return *l.cur.Load()
+}
+
+func (l *liveConfig) store(cfg Config) {
+	l.cur.Store(&cfg)
+}
+
+// handler serves each request with the handler build returns for the current
+// config. build is only called again after the config changes, so handler
+// builders can keep doing their setup (parsing templates and so on) up front.
+func (l *liveConfig) handler(build func(cfg Config) http.HandlerFunc) http.HandlerFunc {
+	var (
+		mu    sync.Mutex
+		built *Config
+		h     http.HandlerFunc
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := l.cur.Load()
+		mu.Lock()
+		if cfg != built {
+			built, h = cfg, build(*cfg)
+		}
+		serve := h
+		mu.Unlock()
+		serve(w, r)
+	}
+}
+
+// configWatcher polls a config file's modification time and size, and loads
+// the file again when either changes.
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newConfigWatcher(path string) *configWatcher {
+	w := &configWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed reports whether the file differs from when it was last checked.
+func (w *configWatcher) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+	return true
+}
+
+// run checks the file every configReloadInterval until ctx is done.
+func (w *configWatcher) run(ctx context.Context, apply func(Config)) {
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(apply)
+		}
+	}
+}
+
+// check calls apply with the new config if the file has changed and parses
+// cleanly. A config that fails to load is logged and the running one kept.
+func (w *configWatcher) check(apply func(Config)) {
+	if !w.changed() {
+		return
+	}
+	cfg, err := loadConfig(w.path)
+	if err != nil {
+		log.Printf("config: keeping the running config: %v", err)
+		return
+	}
+	apply(cfg)
+}
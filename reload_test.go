@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("trips: [{name: A}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := newConfigWatcher(path)
+
+	var applied []Config
+	apply := func(cfg Config) { applied = append(applied, cfg) }
+
+	w.check(apply)
+	if len(applied) != 0 {
+		t.Fatalf("expected no reload of an unchanged file, got %d", len(applied))
+	}
+
+	if err := os.WriteFile(path, []byte("trips: [{name: A}, {name: B}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.check(apply)
+	if len(applied) != 1 || len(applied[0].Trips) != 2 {
+		t.Fatalf("expected the edited config to be applied, got %+v", applied)
+	}
+
+	if err := os.WriteFile(path, []byte("trips: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.check(apply)
+	if len(applied) != 1 {
+		t.Errorf("expected an invalid config to be ignored, got %d reloads", len(applied))
+	}
+}
+
+func TestLiveConfigHandler(t *testing.T) {
+	live := newLiveConfig(Config{Trips: []TripConfig{{Name: "A"}}})
+	builds := 0
+	h := live.handler(func(cfg Config) http.HandlerFunc {
+		builds++
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(cfg.Trips[0].Name))
+		}
+	})
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+	if got := get() + get(); got != "AA" || builds != 1 {
+		t.Errorf("expected one build serving A twice, got %q after %d builds", got, builds)
+	}
+
+	live.store(Config{Trips: []TripConfig{{Name: "B"}}})
+	if got := get(); got != "B" || builds != 2 {
+		t.Errorf("expected a rebuild serving B, got %q after %d builds", got, builds)
+	}
+}
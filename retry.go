@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryAttempts     = 3
+	defaultRetryInitialDelay = 200 * time.Millisecond
+	defaultRetryJitter       = 0.2
+)
+
+// RetryConfig retries GTFS API GETs that fail with a transport error or a
+// 502, 503 or 504, waiting initial_delay_ms and doubling the wait after each
+// attempt.
+type RetryConfig struct {
+	Enabled        bool    `yaml:"enabled"`
+	Attempts       int     `yaml:"attempts,omitempty"`
+	InitialDelayMS int     `yaml:"initial_delay_ms,omitempty"`
+	Jitter         float64 `yaml:"jitter,omitempty"`
+}
+
+func (c RetryConfig) validate() error {
+	if c.Attempts < 0 || c.InitialDelayMS < 0 {
+		return fmt.Errorf("attempts and initial_delay_ms can't be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// attempts is the most times a request is sent, counting the first.
+func (c RetryConfig) attempts() int {
+	if c.Attempts > 0 {
+		return c.Attempts
+	}
+	return defaultRetryAttempts
+}
+
+func (c RetryConfig) initialDelay() time.Duration {
+	if c.InitialDelayMS > 0 {
+		return time.Duration(c.InitialDelayMS) * time.Millisecond
+	}
+	return defaultRetryInitialDelay
+}
+
+func (c RetryConfig) jitter() float64 {
+	if c.Jitter > 0 {
+		return c.Jitter
+	}
+	return defaultRetryJitter
+}
+
+// retryTransport resends idempotent requests that fail transiently. The
+// client's timeout covers every attempt, so retries never make a page wait
+// longer than a single request could.
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	delay    time.Duration
+	jitter   float64
+	rand     func() float64
+}
+
+func newRetryTransport(next http.RoundTripper, cfg RetryConfig) *retryTransport {
+	return &retryTransport{next: next, attempts: cfg.attempts(), delay: cfg.initialDelay(), jitter: cfg.jitter(), rand: rand.Float64}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	delay := t.delay
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.attempts || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		// Spread the wait by up to ±jitter so boards sharing an upstream
+		// don't retry in lockstep.
+		wait := time.Duration(float64(delay) * (1 + t.jitter*(2*t.rand()-1)))
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether a response or error looks transient.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
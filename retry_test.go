@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var calls, failures atomic.Int32
+	failures.Store(2)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer mock.Close()
+
+	rt := newRetryTransport(http.DefaultTransport, RetryConfig{Enabled: true, Attempts: 3, InitialDelayMS: 1})
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(mock.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("expected success on the third attempt, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	failures.Store(5)
+	resp, err = client.Get(mock.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || calls.Load() != 3 {
+		t.Errorf("expected the last 502 after 3 attempts, got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	resp, err = client.Post(mock.URL, "application/json", strings.NewReader("[]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("expected a POST to be sent once, got %d calls", calls.Load())
+	}
+
+	// A cancelled request stops waiting between attempts.
+	calls.Store(0)
+	slow := newRetryTransport(http.DefaultTransport, RetryConfig{Enabled: true, Attempts: 3, InitialDelayMS: 60000})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, mock.URL, nil)
+	if _, err := slow.RoundTrip(req); err == nil || calls.Load() != 1 {
+		t.Errorf("expected the retry wait to end with the context, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestRetryConfig_Validate(t *testing.T) {
+	if _, err := parseConfig([]byte("retry: {enabled: true, jitter: 2}\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "retry") {
+		t.Errorf("expected a retry error, got %v", err)
+	}
+	if _, err := parseConfig([]byte("retry: {enabled: true, attempts: 5}\ntrips: [{name: A}]\n")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+type SchoolConfig struct {
+	Terms       []DateRange `yaml:"terms"`
+	Routes      []string    `yaml:"routes,omitempty"`
+	ServiceIDs  []string    `yaml:"service_ids,omitempty"`
+	OutsideTerm string      `yaml:"outside_term,omitempty"`
+}
+
+type DateRange struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+func (s SchoolConfig) validate() error {
+	for i, r := range s.Terms {
+		start, err := time.Parse(dateLayout, r.Start)
+		if err != nil {
+			return fmt.Errorf("terms[%d]: invalid start date %q, expected YYYY-MM-DD", i, r.Start)
+		}
+		end, err := time.Parse(dateLayout, r.End)
+		if err != nil {
+			return fmt.Errorf("terms[%d]: invalid end date %q, expected YYYY-MM-DD", i, r.End)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("terms[%d]: ends before it starts", i)
+		}
+	}
+	switch s.OutsideTerm {
+	case "", "hide", "label":
+	default:
+		return fmt.Errorf("outside_term must be hide or label, got %q", s.OutsideTerm)
+	}
+	return nil
+}
+
+// isSchoolService reports whether d only runs on school days, matched by route
+// short name or by GTFS service ID.
+func (s SchoolConfig) isSchoolService(d Departure) bool {
+	if len(s.Routes) > 0 && matchesServices(d.RouteShortName, s.Routes) {
+		return true
+	}
+	if d.ServiceID == "" {
+		return false
+	}
+	for _, id := range s.ServiceIDs {
+		if id == d.ServiceID {
+			return true
+		}
+	}
+	return false
+}
+
+// isSchoolDay reports whether t falls on a weekday inside a configured term.
+func (s SchoolConfig) isSchoolDay(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	day := t.Format(dateLayout)
+	for _, r := range s.Terms {
+		if day >= r.Start && day <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// outOfTerm reports whether d is a school-only service departing on a day
+// that isn't a school day.
+func (s SchoolConfig) outOfTerm(d Departure) bool {
+	return s.isSchoolService(d) && !s.isSchoolDay(effectiveDeparture(d).In(boardTZ))
+}
+
+// filterSchoolServices drops school-only services outside school days, unless
+// outside_term is "label" in which case they are kept and flagged in the view.
+func (s SchoolConfig) filterSchoolServices(departures []Departure) []Departure {
+	if s.OutsideTerm == "label" || (len(s.Routes) == 0 && len(s.ServiceIDs) == 0) {
+		return departures
+	}
+	filtered := departures[:0]
+	for _, d := range departures {
+		if !s.outOfTerm(d) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchoolConfig_IsSchoolDay(t *testing.T) {
+	s := SchoolConfig{Terms: []DateRange{{Start: "2025-02-04", End: "2025-04-11"}}}
+
+	tests := []struct {
+		day      time.Time
+		expected bool
+	}{
+		{time.Date(2025, 2, 4, 8, 0, 0, 0, boardTZ), true},
+		{time.Date(2025, 4, 11, 15, 0, 0, 0, boardTZ), true},
+		{time.Date(2025, 2, 8, 8, 0, 0, 0, boardTZ), false}, // Saturday
+		{time.Date(2025, 1, 20, 8, 0, 0, 0, boardTZ), false},
+		{time.Date(2025, 4, 14, 8, 0, 0, 0, boardTZ), false},
+	}
+	for _, tc := range tests {
+		if got := s.isSchoolDay(tc.day); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.day.Format(dateLayout), tc.expected, got)
+		}
+	}
+}
+
+func TestSchoolConfig_IsSchoolService(t *testing.T) {
+	s := SchoolConfig{Routes: []string{"629S"}, ServiceIDs: []string{"SCH_1"}}
+
+	if !s.isSchoolService(Departure{RouteShortName: "629S"}) {
+		t.Error("expected route 629S to be a school service")
+	}
+	if !s.isSchoolService(Departure{RouteShortName: "629", ServiceID: "SCH_1"}) {
+		t.Error("expected service ID SCH_1 to be a school service")
+	}
+	if s.isSchoolService(Departure{RouteShortName: "629"}) {
+		t.Error("expected route 629 not to be a school service")
+	}
+	if (SchoolConfig{}).isSchoolService(Departure{RouteShortName: "629S"}) {
+		t.Error("expected no school services without config")
+	}
+}
+
+func TestSchoolConfig_FilterSchoolServices(t *testing.T) {
+	holidays := time.Date(2025, 1, 20, 8, 0, 0, 0, boardTZ)
+	termTime := time.Date(2025, 2, 5, 8, 0, 0, 0, boardTZ)
+
+	deps := func() []Departure {
+		return []Departure{
+			{RouteShortName: "629S", ScheduledDeparture: holidays},
+			{RouteShortName: "629", ScheduledDeparture: holidays},
+			{RouteShortName: "629S", ScheduledDeparture: termTime},
+		}
+	}
+
+	s := SchoolConfig{Terms: []DateRange{{Start: "2025-02-04", End: "2025-04-11"}}, Routes: []string{"629S"}}
+	got := s.filterSchoolServices(deps())
+	if len(got) != 2 || got[0].RouteShortName != "629" || !got[1].ScheduledDeparture.Equal(termTime) {
+		t.Errorf("expected holiday 629S to be hidden, got %+v", got)
+	}
+
+	s.OutsideTerm = "label"
+	if got := s.filterSchoolServices(deps()); len(got) != 3 {
+		t.Errorf("expected all departures kept in label mode, got %d", len(got))
+	}
+	if !s.outOfTerm(deps()[0]) {
+		t.Error("expected holiday 629S to be flagged as out of term")
+	}
+}
+
+func TestSchoolConfig_Validate(t *testing.T) {
+	bad := []SchoolConfig{
+		{Terms: []DateRange{{Start: "4 Feb", End: "2025-04-11"}}},
+		{Terms: []DateRange{{Start: "2025-04-11", End: "2025-02-04"}}},
+		{OutsideTerm: "strike"},
+	}
+	for _, s := range bad {
+		if err := s.validate(); err == nil {
+			t.Errorf("expected error for %+v", s)
+		}
+	}
+}
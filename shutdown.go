@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests and background work get
+// to finish after a SIGINT or SIGTERM.
+const shutdownTimeout = 15 * time.Second
+
+// serve answers requests on ln until ctx is done, then stops accepting
+// connections and waits for in-flight requests and the background workers in
+// bg to finish, giving up after shutdownTimeout. It only returns an error if
+// the server fails or can't drain in time.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, bg *sync.WaitGroup) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-shutdownCtx.Done():
+		return errors.New("background workers still running after shutdown timeout")
+	}
+}
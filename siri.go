@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SIRIConfig has the board ask a SIRI StopMonitoring (SIRI-SM) service for
+// departures rather than a GTFS departure service, as many European
+// operators publish. Only departures are available: the print page's day
+// timetable and stop search need the GTFS departure service.
+type SIRIConfig struct {
+	// URL is the StopMonitoring endpoint. Query parameters it has, such as
+	// an API key, are kept.
+	URL string `yaml:"url"`
+	// Format is the response format, "xml" (the default) or "json".
+	Format       string `yaml:"format,omitempty"`
+	RequestorRef string `yaml:"requestor_ref,omitempty"`
+	// Headers are sent with every request, for services that want a key
+	// or client name in one. $VAR is replaced from the environment, as in
+	// gtfs_api_headers.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func (c SIRIConfig) validate() error {
+	if c.URL == "" {
+		if c.Format != "" || c.RequestorRef != "" || len(c.Headers) > 0 {
+			return errors.New("url is required")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an http(s) URL", c.URL)
+	}
+	switch c.Format {
+	case "", "xml", "json":
+	default:
+		return fmt.Errorf("format %q must be xml or json", c.Format)
+	}
+	if _, err := parseHeaders(c.Headers); err != nil {
+		return fmt.Errorf("headers: %w", err)
+	}
+	return nil
+}
+
+// localSIRIURL stands in for the upstream's URL when departures come from
+// siri:, as localGTFSURL does for gtfs:.
+const localSIRIURL = "siri:local"
+
+type siriSource struct {
+	cfg     SIRIConfig
+	headers http.Header
+}
+
+func newSIRISource(cfg SIRIConfig) *siriSource {
+	// Validated by parseConfig
+	headers, _ := parseHeaders(cfg.Headers)
+	return &siriSource{cfg: cfg, headers: headers}
+}
+
+// Departures asks for the visits to stopID (its MonitoringRef) in the next
+// minutes, with their onward calls for the arrivals at arrivalStops.
+func (s *siriSource) Departures(ctx context.Context, stopID, arrivalStops string, minutes int) ([]Departure, error) {
+	if minutes <= 0 {
+		minutes = departureWindowMinutes
+	}
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("MonitoringRef", stopID)
+	q.Set("PreviewInterval", fmt.Sprintf("PT%dM", minutes))
+	q.Set("StopMonitoringDetailLevel", "calls")
+	if s.cfg.RequestorRef != "" {
+		q.Set("RequestorRef", s.cfg.RequestorRef)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestID(req)
+	if s.cfg.Format == "json" {
+		req.Header.Set("Accept", "application/json")
+	} else {
+		req.Header.Set("Accept", "application/xml")
+	}
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SIRI service returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := parseSIRIStopMonitoring(body, s.cfg.Format == "json")
+	if err != nil {
+		return nil, fmt.Errorf("decoding SIRI response: %w", err)
+	}
+	return siriDepartures(deliveries, arrivalStops)
+}
+
+func (s *siriSource) DeparturesOn(ctx context.Context, stopID, arrivalStops, date string) ([]Departure, error) {
+	return nil, fmt.Errorf("siri: a day's departures: %w", errors.ErrUnsupported)
+}
+
+func (s *siriSource) Stop(ctx context.Context, stopID string) (*Stop, error) {
+	return nil, fmt.Errorf("siri: stop lookup: %w", errors.ErrUnsupported)
+}
+
+func (s *siriSource) SearchStops(ctx context.Context, query string) ([]Stop, error) {
+	return nil, fmt.Errorf("siri: stop search: %w", errors.ErrUnsupported)
+}
+
+// siriDepartures turns the deliveries' visits into departures, in departure
+// order. Cancelled journeys, and journeys that end at the stop, are left out.
+func siriDepartures(deliveries []siriDelivery, arrivalStops string) ([]Departure, error) {
+	arriveAt := make(map[string]bool)
+	for _, id := range strings.Split(arrivalStops, ",") {
+		arriveAt[strings.TrimSpace(id)] = true
+	}
+
+	var deps []Departure
+	for _, del := range deliveries {
+		if e := del.ErrorCondition; e != nil {
+			return nil, fmt.Errorf("SIRI service: %s", e.text())
+		}
+		for _, v := range del.MonitoredStopVisit {
+			j := v.MonitoredVehicleJourney
+			call := j.MonitoredCall
+			if j.Cancellation || call.DepartureStatus == "cancelled" || call.AimedDepartureTime.IsZero() {
+				continue
+			}
+			d := Departure{
+				TripID:             j.FramedVehicleJourneyRef.DatedVehicleJourneyRef,
+				RouteShortName:     firstOf(string(j.PublishedLineName), j.LineRef),
+				Headsign:           firstOf(string(call.DestinationDisplay), string(j.DestinationName)),
+				ScheduledDeparture: call.AimedDepartureTime.Time,
+				PlatformCode:       string(call.DeparturePlatformName),
+			}
+			if at := call.ExpectedDepartureTime.Time; !at.IsZero() {
+				delay := int(at.Sub(d.ScheduledDeparture).Seconds())
+				d.RealtimeDeparture, d.DelaySeconds = &at, &delay
+			}
+
+			seen := make(map[string]bool)
+			for _, oc := range j.OnwardCalls.OnwardCall {
+				if !arriveAt[oc.StopPointRef] || seen[oc.StopPointRef] || oc.ArrivalStatus == "cancelled" {
+					continue
+				}
+				a := ArrivalDetail{
+					StopID:           oc.StopPointRef,
+					StopName:         string(oc.StopPointName),
+					ScheduledArrival: oc.AimedArrivalTime.Time,
+					PlatformCode:     string(oc.ArrivalPlatformName),
+				}
+				if a.ScheduledArrival.IsZero() {
+					a.ScheduledArrival = oc.AimedDepartureTime.Time
+				}
+				if at := oc.ExpectedArrivalTime.Time; !at.IsZero() {
+					a.RealtimeArrival = &at
+				}
+				seen[oc.StopPointRef] = true
+				d.Arrivals = append(d.Arrivals, a)
+			}
+			deps = append(deps, d)
+		}
+	}
+	sortDepartures(deps)
+	return deps, nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseSIRIStopMonitoring reads a SIRI response's StopMonitoringDeliveries.
+// The JSON form is the XML's elements as keys, with or without the Siri
+// element around it.
+func parseSIRIStopMonitoring(data []byte, isJSON bool) ([]siriDelivery, error) {
+	var resp struct {
+		Siri            *siriResponse `json:"Siri"`
+		ServiceDelivery siriServiceDelivery
+	}
+	if !isJSON {
+		var siri siriResponse
+		if err := xml.Unmarshal(data, &siri); err != nil {
+			return nil, err
+		}
+		return siri.ServiceDelivery.StopMonitoringDelivery, nil
+	}
+	// Some services start their JSON with a byte order mark.
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Siri != nil {
+		return resp.Siri.ServiceDelivery.StopMonitoringDelivery, nil
+	}
+	return resp.ServiceDelivery.StopMonitoringDelivery, nil
+}
+
+// The parts of a SIRI StopMonitoring response the board reads. Element
+// names match in any namespace.
+type siriResponse struct {
+	ServiceDelivery siriServiceDelivery
+}
+
+type siriServiceDelivery struct {
+	StopMonitoringDelivery siriList[siriDelivery]
+}
+
+type siriDelivery struct {
+	MonitoredStopVisit siriList[siriVisit]
+	ErrorCondition     *siriError
+}
+
+type siriVisit struct {
+	MonitoredVehicleJourney siriJourney
+}
+
+type siriJourney struct {
+	LineRef                 string
+	PublishedLineName       siriText
+	DestinationName         siriText
+	FramedVehicleJourneyRef struct {
+		DatedVehicleJourneyRef string
+	}
+	Cancellation  bool
+	MonitoredCall siriCall
+	OnwardCalls   struct {
+		OnwardCall siriList[siriCall]
+	}
+}
+
+type siriCall struct {
+	StopPointRef          string
+	StopPointName         siriText
+	DestinationDisplay    siriText
+	AimedArrivalTime      siriTime
+	ExpectedArrivalTime   siriTime
+	AimedDepartureTime    siriTime
+	ExpectedDepartureTime siriTime
+	ArrivalStatus         string
+	DepartureStatus       string
+	ArrivalPlatformName   siriText
+	DeparturePlatformName siriText
+}
+
+// siriError is an ErrorCondition: one of SIRI's error elements, each with
+// an ErrorText, and an optional Description.
+type siriError struct {
+	Description siriText
+	Errors      []struct {
+		ErrorText siriText
+	} `xml:",any"`
+}
+
+func (e *siriError) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for name, raw := range fields {
+		if name == "Description" {
+			json.Unmarshal(raw, &e.Description)
+			continue
+		}
+		var inner struct{ ErrorText siriText }
+		if json.Unmarshal(raw, &inner) == nil {
+			e.Errors = append(e.Errors, inner)
+		}
+	}
+	return nil
+}
+
+func (e *siriError) text() string {
+	for _, err := range e.Errors {
+		if err.ErrorText != "" {
+			return string(err.ErrorText)
+		}
+	}
+	if e.Description != "" {
+		return string(e.Description)
+	}
+	return "error condition"
+}
+
+// siriText is a natural-language string. SIRI allows several, one per
+// language, and its JSON forms give them as a string, a {"value": ...}
+// object or an array of either; the first is used.
+type siriText string
+
+func (t *siriText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+	if *t == "" {
+		*t = siriText(strings.TrimSpace(s))
+	}
+	return nil
+}
+
+func (t *siriText) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*t = siriText(s)
+		return nil
+	}
+	var v struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(b, &v) == nil {
+		*t = siriText(v.Value)
+		return nil
+	}
+	var list []siriText
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	if len(list) > 0 {
+		*t = list[0]
+	}
+	return nil
+}
+
+// siriTime is an xsd:dateTime, left zero when the element is empty.
+type siriTime struct {
+	time.Time
+}
+
+func (t *siriTime) UnmarshalText(b []byte) error {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	return t.Time.UnmarshalText(bytes.TrimSpace(b))
+}
+
+func (t *siriTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return t.UnmarshalText([]byte(s))
+}
+
+// siriList is a repeated element. In JSON a single one may be an object
+// rather than an array of one.
+type siriList[T any] []T
+
+func (l *siriList[T]) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, (*[]T)(l))
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*l = siriList[T]{v}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSIRIXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Siri xmlns="http://www.siri.org.uk/siri" version="2.0">
+  <ServiceDelivery>
+    <ResponseTimestamp>2026-03-02T07:55:00+01:00</ResponseTimestamp>
+    <StopMonitoringDelivery version="2.0">
+      <MonitoredStopVisit>
+        <MonitoredVehicleJourney>
+          <LineRef>L:12</LineRef>
+          <FramedVehicleJourneyRef>
+            <DataFrameRef>2026-03-02</DataFrameRef>
+            <DatedVehicleJourneyRef>j2</DatedVehicleJourneyRef>
+          </FramedVehicleJourneyRef>
+          <PublishedLineName xml:lang="de">12</PublishedLineName>
+          <PublishedLineName xml:lang="en">Line 12</PublishedLineName>
+          <DestinationName>Flughafen</DestinationName>
+          <MonitoredCall>
+            <StopPointRef>100</StopPointRef>
+            <AimedDepartureTime>2026-03-02T08:10:00+01:00</AimedDepartureTime>
+            <ExpectedDepartureTime>2026-03-02T08:13:00+01:00</ExpectedDepartureTime>
+            <DeparturePlatformName>3</DeparturePlatformName>
+          </MonitoredCall>
+          <OnwardCalls>
+            <OnwardCall>
+              <StopPointRef>200</StopPointRef>
+              <AimedArrivalTime>2026-03-02T08:20:00+01:00</AimedArrivalTime>
+            </OnwardCall>
+            <OnwardCall>
+              <StopPointRef>300</StopPointRef>
+              <StopPointName>Flughafen</StopPointName>
+              <AimedArrivalTime>2026-03-02T08:40:00+01:00</AimedArrivalTime>
+              <ExpectedArrivalTime>2026-03-02T08:42:00+01:00</ExpectedArrivalTime>
+              <ArrivalPlatformName>B</ArrivalPlatformName>
+            </OnwardCall>
+          </OnwardCalls>
+        </MonitoredVehicleJourney>
+      </MonitoredStopVisit>
+      <MonitoredStopVisit>
+        <MonitoredVehicleJourney>
+          <LineRef>L:12</LineRef>
+          <FramedVehicleJourneyRef><DatedVehicleJourneyRef>j1</DatedVehicleJourneyRef></FramedVehicleJourneyRef>
+          <DestinationName>Flughafen</DestinationName>
+          <MonitoredCall>
+            <StopPointRef>100</StopPointRef>
+            <DestinationDisplay>Airport</DestinationDisplay>
+            <AimedDepartureTime>2026-03-02T08:00:00+01:00</AimedDepartureTime>
+            <ExpectedDepartureTime></ExpectedDepartureTime>
+          </MonitoredCall>
+        </MonitoredVehicleJourney>
+      </MonitoredStopVisit>
+      <MonitoredStopVisit>
+        <MonitoredVehicleJourney>
+          <LineRef>L:12</LineRef>
+          <FramedVehicleJourneyRef><DatedVehicleJourneyRef>j3</DatedVehicleJourneyRef></FramedVehicleJourneyRef>
+          <Cancellation>true</Cancellation>
+          <MonitoredCall>
+            <StopPointRef>100</StopPointRef>
+            <AimedDepartureTime>2026-03-02T08:05:00+01:00</AimedDepartureTime>
+          </MonitoredCall>
+        </MonitoredVehicleJourney>
+      </MonitoredStopVisit>
+    </StopMonitoringDelivery>
+  </ServiceDelivery>
+</Siri>`
+
+const testSIRIJSON = "\ufeff" + `{"Siri": {"ServiceDelivery": {"StopMonitoringDelivery": [{
+  "MonitoredStopVisit": [{
+    "MonitoredVehicleJourney": {
+      "LineRef": "L:12",
+      "PublishedLineName": [{"value": "12", "lang": "de"}],
+      "DestinationName": {"value": "Flughafen"},
+      "FramedVehicleJourneyRef": {"DatedVehicleJourneyRef": "j1"},
+      "MonitoredCall": {
+        "StopPointRef": "100",
+        "AimedDepartureTime": "2026-03-02T08:00:00.000+01:00",
+        "ExpectedDepartureTime": "2026-03-02T07:59:00+01:00",
+        "DepartureStatus": "onTime"
+      },
+      "OnwardCalls": {"OnwardCall": {
+        "StopPointRef": "300",
+        "AimedArrivalTime": "2026-03-02T08:30:00+01:00",
+        "ExpectedArrivalTime": ""
+      }}
+    }
+  }, {
+    "MonitoredVehicleJourney": {
+      "LineRef": "L:12",
+      "MonitoredCall": {
+        "StopPointRef": "100",
+        "AimedDepartureTime": "2026-03-02T08:05:00+01:00",
+        "DepartureStatus": "cancelled"
+      }
+    }
+  }]
+}]}}}`
+
+func TestSIRISource_XML(t *testing.T) {
+	var query, requestor string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		requestor = r.Header.Get("ET-Client-Name")
+		w.Write([]byte(testSIRIXML))
+	}))
+	defer srv.Close()
+
+	src := newSIRISource(SIRIConfig{URL: srv.URL + "/sm?key=k", RequestorRef: "board", Headers: map[string]string{"ET-Client-Name": "home-board"}})
+	deps, err := src.Departures(context.Background(), "100", "300", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"key=k", "MonitoringRef=100", "PreviewInterval=PT30M", "StopMonitoringDetailLevel=calls", "RequestorRef=board"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("expected %s in the query, got %s", want, query)
+		}
+	}
+	if requestor != "home-board" {
+		t.Errorf("expected the configured header, got %q", requestor)
+	}
+
+	if len(deps) != 2 || deps[0].TripID != "j1" || deps[1].TripID != "j2" {
+		t.Fatalf("expected j1 then j2 without the cancelled j3, got %+v", deps)
+	}
+	j1, j2 := deps[0], deps[1]
+	if j1.RouteShortName != "L:12" || j1.Headsign != "Airport" || j1.RealtimeDeparture != nil || len(j1.Arrivals) != 0 {
+		t.Errorf("unexpected departure %+v", j1)
+	}
+	cet := time.FixedZone("", 3600)
+	if j2.RouteShortName != "12" || j2.Headsign != "Flughafen" || j2.PlatformCode != "3" ||
+		!j2.ScheduledDeparture.Equal(time.Date(2026, 3, 2, 8, 10, 0, 0, cet)) || *j2.DelaySeconds != 180 {
+		t.Errorf("unexpected departure %+v", j2)
+	}
+	if len(j2.Arrivals) != 1 {
+		t.Fatalf("expected only the arrival at 300, got %+v", j2.Arrivals)
+	}
+	if a := j2.Arrivals[0]; a.StopID != "300" || a.StopName != "Flughafen" || a.PlatformCode != "B" ||
+		a.RealtimeArrival == nil || !a.RealtimeArrival.Equal(time.Date(2026, 3, 2, 8, 42, 0, 0, cet)) {
+		t.Errorf("unexpected arrival %+v", a)
+	}
+}
+
+func TestSIRISource_JSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Write([]byte(testSIRIJSON))
+	}))
+	defer srv.Close()
+	sources[localSIRIURL] = newSIRISource(SIRIConfig{URL: srv.URL, Format: "json"})
+	defer delete(sources, localSIRIURL)
+
+	deps, err := fetchDepartures(context.Background(), localSIRIURL, "100", "300", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 1 {
+		t.Fatalf("expected one departure without the cancelled one, got %+v", deps)
+	}
+	d := deps[0]
+	if d.TripID != "j1" || d.RouteShortName != "12" || d.Headsign != "Flughafen" || *d.DelaySeconds != -60 {
+		t.Errorf("unexpected departure %+v", d)
+	}
+	if len(d.Arrivals) != 1 || d.Arrivals[0].RealtimeArrival != nil {
+		t.Errorf("expected a scheduled arrival at 300, got %+v", d.Arrivals)
+	}
+
+	if _, err := fetchStop(context.Background(), localSIRIURL, "100"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected stop lookup to be unsupported, got %v", err)
+	}
+}
+
+func TestSIRISource_ErrorCondition(t *testing.T) {
+	for name, body := range map[string]string{
+		"xml": `<Siri><ServiceDelivery><StopMonitoringDelivery><ErrorCondition>
+			<AccessNotAllowedError><ErrorText>Unknown requestor</ErrorText></AccessNotAllowedError>
+		</ErrorCondition></StopMonitoringDelivery></ServiceDelivery></Siri>`,
+		"json": `{"ServiceDelivery": {"StopMonitoringDelivery": {"ErrorCondition": {
+			"AccessNotAllowedError": {"ErrorText": "Unknown requestor"}}}}}`,
+	} {
+		deliveries, err := parseSIRIStopMonitoring([]byte(body), name == "json")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := siriDepartures(deliveries, "300"); err == nil || !strings.Contains(err.Error(), "Unknown requestor") {
+			t.Errorf("%s: expected the error text, got %v", name, err)
+		}
+	}
+}
+
+func TestSIRIConfig_Validate(t *testing.T) {
+	for _, c := range []SIRIConfig{
+		{Format: "json"},
+		{URL: "ftp://example.com"},
+		{URL: "https://example.com/sm", Format: "csv"},
+	} {
+		if c.validate() == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	if _, err := parseConfig([]byte("gtfs:\n  static: feed.zip\nsiri:\n  url: https://example.com/sm\n")); err == nil {
+		t.Error("expected gtfs and siri together to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// DepartureSource answers the board's departure and stop queries. The GTFS
+// departure service's HTTP API is the usual one; gtfs: and siri: configure
+// others, which the board reads itself.
+type DepartureSource interface {
+	// Departures lists the next hour of departures from stopID, or the
+	// next minutes when that is set, with their arrivals at arrivalStops
+	// (comma separated).
+	Departures(ctx context.Context, stopID, arrivalStops string, minutes int) ([]Departure, error)
+	// DeparturesOn lists every departure of one service day (YYYY-MM-DD).
+	DeparturesOn(ctx context.Context, stopID, arrivalStops, date string) ([]Departure, error)
+	// Stop looks up a stop, failing with errStopNotFound if there's none.
+	Stop(ctx context.Context, stopID string) (*Stop, error)
+	// SearchStops finds stops by name.
+	SearchStops(ctx context.Context, query string) ([]Stop, error)
+}
+
+// sources holds the departure sources the board reads itself, by the URL
+// that stands in for theirs (localGTFSURL, localSIRIURL). They're registered
+// at startup by startSources.
+var sources = map[string]DepartureSource{}
+
+// sourceFor returns the source for an upstream URL: a registered one, else
+// the GTFS departure service at that URL.
+func sourceFor(apiURL string) DepartureSource {
+	if src, ok := sources[apiURL]; ok {
+		return src
+	}
+	return apiSource(apiURL)
+}
+
+// apiSource is the GTFS departure service at a base URL.
+type apiSource string
+
+// startSources loads the gtfs: feed or connects the siri: service, when
+// either is configured, as the board's upstream.
+func (c Config) startSources(ctx context.Context) error {
+	switch {
+	case c.GTFS.Static != "":
+		log.Printf("Loading GTFS feed %s", c.GTFS.Static)
+		f, err := loadGTFSFeed(ctx, c.GTFS)
+		if err != nil {
+			return fmt.Errorf("gtfs: %w", err)
+		}
+		sources[localGTFSURL] = f
+	case c.SIRI.URL != "":
+		sources[localSIRIURL] = newSIRISource(c.SIRI)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Stop struct {
+	StopID   string  `json:"stop_id"`
+	StopName string  `json:"stop_name"`
+	StopLat  float64 `json:"stop_lat"`
+	StopLon  float64 `json:"stop_lon"`
+}
+
+// stopSearchTTL is how long a stop search's results are reused. Stops change
+// with the timetable, so this can be long; it mostly spares the upstream an
+// autocomplete's repeated keystrokes.
+const stopSearchTTL = 10 * time.Minute
+
+// stopSearchMaxEntries bounds the stop search cache, which every distinct
+// query adds to.
+const stopSearchMaxEntries = 1000
+
+// buildStopSearchHandler serves /api/stops?q= (and /api/stops/search?q=),
+// a stop search proxied to the upstream and cached by query. limit, when
+// given, keeps only the first results, for autocomplete.
+func buildStopSearchHandler(apiURL string) http.HandlerFunc {
+	cache := newStopSearchCache()
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing query parameter q")
+			return
+		}
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a positive number")
+				return
+			}
+			limit = n
+		}
+
+		stops, err := cache.search(r.Context(), apiURL, q)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if stops == nil {
+			stops = []Stop{}
+		}
+		if limit > 0 && len(stops) > limit {
+			stops = stops[:limit]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(stopSearchTTL.Seconds())))
+		json.NewEncoder(w).Encode(stops)
+	}
+}
+
+// stopSearchCache keeps stop search results per query, ignoring case and
+// surrounding spaces. Failed searches aren't cached.
+type stopSearchCache struct {
+	mu      sync.Mutex
+	entries map[string]stopSearchEntry
+}
+
+type stopSearchEntry struct {
+	stops     []Stop
+	fetchedAt time.Time
+}
+
+func newStopSearchCache() *stopSearchCache {
+	return &stopSearchCache{entries: make(map[string]stopSearchEntry)}
+}
+
+func (c *stopSearchCache) search(ctx context.Context, apiURL, query string) ([]Stop, error) {
+	key := strings.ToLower(strings.TrimSpace(query))
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < stopSearchTTL {
+		return e.stops, nil
+	}
+
+	stops, err := searchStops(ctx, apiURL, query)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= stopSearchMaxEntries {
+		for k, e := range c.entries {
+			if time.Since(e.fetchedAt) >= stopSearchTTL {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of fresh searches: start again rather than grow
+		if len(c.entries) >= stopSearchMaxEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = stopSearchEntry{stops: stops, fetchedAt: time.Now()}
+	return stops, nil
+}
+
+func searchStops(ctx context.Context, apiURL, query string) ([]Stop, error) {
+	return sourceFor(apiURL).SearchStops(ctx, query)
+}
+
+func (s apiSource) SearchStops(ctx context.Context, query string) ([]Stop, error) {
+	u := fmt.Sprintf("%s/stops/search?q=%s", s, url.QueryEscape(query))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestID(req)
+
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error != "" {
+			return nil, fmt.Errorf("API error: %s", apiErr.Error)
+		}
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var stops []Stop
+	if err := json.NewDecoder(resp.Body).Decode(&stops); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return stops, nil
+}
+
+var errStopNotFound = errors.New("stop not found")
+
+func fetchStop(ctx context.Context, apiURL, stopID string) (*Stop, error) {
+	return sourceFor(apiURL).Stop(ctx, stopID)
+}
+
+func (s apiSource) Stop(ctx context.Context, stopID string) (*Stop, error) {
+	u := fmt.Sprintf("%s/stops/%s", s, url.PathEscape(stopID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestID(req)
+
+	resp, err := gtfsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errStopNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var stop Stop
+	if err := json.NewDecoder(resp.Body).Decode(&stop); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &stop, nil
+}
+
+// validateStops looks up every stop ID referenced by the config and returns a
+// message for each one the upstream doesn't know. Stops that can't be checked
+// (upstream unreachable) are logged but not reported as unknown.
+func validateStops(ctx context.Context, apiURL string, cfg Config) []string {
+	type stopRef struct {
+		trip, field, apiURL, stopID string
+	}
+	var refs []stopRef
+	for _, trip := range cfg.Trips {
+		for _, route := range trip.Routes {
+			api := route.upstreamURL(apiURL)
+			refs = append(refs, stopRef{trip.Name, "departure_stop_id", api, route.DepartureStopID})
+			for i, t := range route.transfers() {
+				prefix := ""
+				if len(route.Legs) > 0 {
+					prefix = fmt.Sprintf("legs[%d].", i)
+				}
+				refs = append(refs,
+					stopRef{trip.Name, prefix + "transfer_arrival_stop_id", api, t.TransferArrivalStopID},
+					stopRef{trip.Name, prefix + "transfer_departure_stop_id", api, t.TransferDepartureStopID})
+			}
+			refs = append(refs, stopRef{trip.Name, "final_arrival_stop", api, route.FinalArrivalStop})
+		}
+	}
+
+	type upstreamStop struct{ apiURL, stopID string }
+	known := make(map[upstreamStop]error)
+	var problems []string
+	for _, ref := range refs {
+		key := upstreamStop{ref.apiURL, ref.stopID}
+		err, checked := known[key]
+		if !checked {
+			_, err = fetchStop(ctx, ref.apiURL, ref.stopID)
+			known[key] = err
+			if err != nil && !errors.Is(err, errStopNotFound) && !errors.Is(err, errors.ErrUnsupported) {
+				log.Printf("could not validate stop %s: %v", ref.stopID, err)
+			}
+		}
+		if errors.Is(err, errStopNotFound) {
+			problems = append(problems, fmt.Sprintf("Trip %q: unknown stop %q (%s)", ref.trip, ref.stopID, ref.field))
+		}
+	}
+	return problems
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
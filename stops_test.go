@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStopSearchHandler(t *testing.T) {
+	var gotQuery string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stops/search" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery = r.URL.Query().Get("q")
+		json.NewEncoder(w).Encode([]Stop{
+			{StopID: "200060", StopName: "Wynyard Station", StopLat: -33.8662, StopLon: 151.2059},
+		})
+	}))
+	defer mock.Close()
+
+	handler := buildStopSearchHandler(mock.URL)
+
+	req := httptest.NewRequest("GET", "/api/stops/search?q=wynyard+station", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if gotQuery != "wynyard station" {
+		t.Errorf("expected upstream query 'wynyard station', got %q", gotQuery)
+	}
+
+	var stops []Stop
+	if err := json.NewDecoder(w.Body).Decode(&stops); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(stops) != 1 || stops[0].StopID != "200060" {
+		t.Fatalf("expected stop 200060, got %+v", stops)
+	}
+	if stops[0].StopLat != -33.8662 {
+		t.Errorf("expected latitude -33.8662, got %v", stops[0].StopLat)
+	}
+}
+
+func TestStopSearchHandler_Cached(t *testing.T) {
+	var searches int
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searches++
+		json.NewEncoder(w).Encode([]Stop{
+			{StopID: "200060", StopName: "Central Station"},
+			{StopID: "2000421", StopName: "Central Station, Platform 21"},
+			{StopID: "2000422", StopName: "Central Station, Platform 22"},
+		})
+	}))
+	defer mock.Close()
+
+	handler := buildStopSearchHandler(mock.URL)
+	for _, path := range []string{"/api/stops?q=central", "/api/stops?q=Central+", "/api/stops/search?q=CENTRAL&limit=2"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		var stops []Stop
+		if w.Code != 200 || json.NewDecoder(w.Body).Decode(&stops) != nil {
+			t.Fatalf("%s: unexpected %d", path, w.Code)
+		}
+		want := 3
+		if strings.Contains(path, "limit") {
+			want = 2
+		}
+		if len(stops) != want {
+			t.Errorf("%s: unexpected stops %+v", path, stops)
+		}
+		if !strings.HasPrefix(w.Header().Get("Cache-Control"), "private") {
+			t.Errorf("%s: expected a private Cache-Control, got %q", path, w.Header().Get("Cache-Control"))
+		}
+	}
+	if searches != 1 {
+		t.Errorf("expected one upstream search for the same query, got %d", searches)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/stops?q=central&limit=0", nil))
+	if w.Code != 400 {
+		t.Errorf("expected 400 for a bad limit, got %d", w.Code)
+	}
+}
+
+func TestStopSearchHandler_MissingQuery(t *testing.T) {
+	handler := buildStopSearchHandler("http://localhost:9999")
+
+	req := httptest.NewRequest("GET", "/api/stops/search", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != 400 {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestStopSearchHandler_APIError(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": "index unavailable"})
+	}))
+	defer mock.Close()
+
+	handler := buildStopSearchHandler(mock.URL)
+
+	req := httptest.NewRequest("GET", "/api/stops/search?q=central", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != 502 {
+		t.Errorf("expected 502, got %d", w.Code)
+	}
+	var body map[string]string
+	json.NewDecoder(w.Body).Decode(&body)
+	if body["error"] != "API error: index unavailable" {
+		t.Errorf("expected upstream error to be passed through, got %q", body["error"])
+	}
+}
+
+func TestValidateStops(t *testing.T) {
+	var lookups int
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		switch r.URL.Path {
+		case "/stops/100", "/stops/300":
+			json.NewEncoder(w).Encode(Stop{StopID: strings.TrimPrefix(r.URL.Path, "/stops/")})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{
+				{DepartureStopID: "100", FinalArrivalStop: "300"},
+				{DepartureStopID: "100", TransferArrivalStopID: "999", TransferDepartureStopID: "300", FinalArrivalStop: "300"},
+			}},
+		},
+	}
+
+	problems := validateStops(context.Background(), mock.URL, cfg)
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+	if !strings.Contains(problems[0], `"999"`) || !strings.Contains(problems[0], "transfer_arrival_stop_id") {
+		t.Errorf("expected problem to name stop 999 and its field, got %q", problems[0])
+	}
+	if lookups != 3 {
+		t.Errorf("expected each distinct stop to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func TestValidateStops_UpstreamDown(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		},
+	}
+
+	if problems := validateStops(context.Background(), mock.URL, cfg); len(problems) != 0 {
+		t.Errorf("expected no unknown stops when upstream is down, got %v", problems)
+	}
+}
+
+func TestHandler_ConfigWarnings(t *testing.T) {
+	mock := newMockAPI(t, nil)
+	defer mock.Close()
+
+	cfg := Config{
+		Trips: []TripConfig{
+			{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		},
+		warnings: []string{`Trip "To Work": unknown stop "300" (final_arrival_stop)`},
+	}
+
+	handler := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if !strings.Contains(w.Body.String(), "unknown stop &#34;300&#34;") {
+		t.Error("expected config warning banner in page")
+	}
+}
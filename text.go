@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// buildTextHandler serves /text: the board as aligned plain-text columns for
+// terminals and status lines. ?trip= shows one trip (without its name as a
+// heading) and ?n= caps the departures listed per trip, so
+// /text?trip=0&n=1 is a single line.
+func buildTextHandler(apiURL string, cfg Config, cache *departureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveText(w, r, apiURL, cfg, cache)
+	}
+}
+
+func serveText(w http.ResponseWriter, r *http.Request, apiURL string, cfg Config, cache *departureCache) {
+	q := r.URL.Query()
+	trips := cfg.Trips
+	if key := q.Get("trip"); key != "" {
+		trip, ok := selectTrip(cfg.Trips, key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		trips = []TripConfig{trip}
+	}
+	limit := 0
+	if s := q.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	data := buildPageData(r.Context(), cache, apiURL, cfg, trips)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeBoardText(w, data.Trips, limit, len(trips) > 1)
+}
+
+// prefersText reports whether r's Accept header asks for plain text over
+// HTML, as `curl -H 'Accept: text/plain'` does.
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html")
+}
+
+// writeBoardText writes each trip's departures as one line each: minutes to
+// go, routes, headsign, departure and final arrival times and platform.
+// headings puts each trip's name above its departures.
+func writeBoardText(w io.Writer, trips []TripView, limit int, headings bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	indent := ""
+	if headings {
+		indent = "  "
+	}
+	for i, tv := range trips {
+		if headings {
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintln(tw, tv.Name)
+		}
+		switch {
+		case tv.Error != "":
+			fmt.Fprintf(tw, "%s%s\n", indent, tv.Error)
+			continue
+		case tv.AsOf != "":
+			fmt.Fprintf(tw, "%sLive data unavailable, as of %s (updated %s)\n", indent, tv.AsOf, tv.UpdatedAgo)
+		}
+		if len(tv.Departures) == 0 {
+			if tv.ArriveBy != "" {
+				fmt.Fprintf(tw, "%sNo departures arrive by %s\n", indent, tv.ArriveBy)
+			} else {
+				fmt.Fprintf(tw, "%sNo departures in next %d min\n", indent, tv.WindowMinutes)
+			}
+			continue
+		}
+		for j, dv := range tv.Departures {
+			if limit > 0 && j == limit {
+				break
+			}
+			routes := []string{dv.RouteShortName}
+			for _, leg := range dv.Connections {
+				routes = append(routes, leg.RouteShortName)
+			}
+			departs := dv.DepartureTime
+			if dv.IsOnDemand {
+				departs = dv.PickupWindow
+			}
+			arrives := dv.FinalArrivalTime
+			if dv.ConnectionUnknown {
+				arrives = "?"
+			}
+			line := fmt.Sprintf("%s%s\t%s\t%s\t%s → %s", indent,
+				strings.TrimSpace(dv.MinutesAway+" "+dv.MinutesAwayLabel),
+				strings.Join(routes, "/"), dv.Headsign, departs, arrives)
+			if dv.Platform != "" {
+				line += "\tplat " + dv.Platform
+			}
+			fmt.Fprintln(tw, line)
+		}
+	}
+	tw.Flush()
+}
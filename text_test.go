@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteBoardText(t *testing.T) {
+	trips := []TripView{
+		{Name: "To Work", Departures: []DepartureView{
+			{MinutesAway: "3", MinutesAwayLabel: "min", RouteShortName: "T1", Headsign: "Hornsby", DepartureTime: "08:07", FinalArrivalTime: "08:31", Platform: "2"},
+			{MinutesAway: "12", MinutesAwayLabel: "min", RouteShortName: "333", Connections: []LegView{{RouteShortName: "L2"}}, Headsign: "Bondi Beach", DepartureTime: "08:16", ConnectionUnknown: true},
+		}},
+		{Name: "To Gym", WindowMinutes: 60},
+		{Name: "Home", Error: "Failed to load departures: boom"},
+	}
+	var b strings.Builder
+	writeBoardText(&b, trips, 0, true)
+	want := `To Work
+  3 min   T1      Hornsby      08:07 → 08:31  plat 2
+  12 min  333/L2  Bondi Beach  08:16 → ?
+
+To Gym
+  No departures in next 60 min
+
+Home
+  Failed to load departures: boom
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+
+	b.Reset()
+	writeBoardText(&b, trips[:1], 1, false)
+	if b.String() != "3 min  T1  Hornsby  08:07 → 08:31  plat 2\n" {
+		t.Errorf("expected a single line, got %q", b.String())
+	}
+}
+
+func TestTextHandler(t *testing.T) {
+	now := time.Now().In(boardTZ)
+	mock := newMockAPI(t, map[string][]Departure{
+		"100": {{RouteShortName: "T1", Headsign: "City", ScheduledDeparture: now.Add(5*time.Minute + 30*time.Second),
+			Arrivals: []ArrivalDetail{{StopID: "300", ScheduledArrival: now.Add(30 * time.Minute)}}}},
+	})
+	defer mock.Close()
+	cfg := Config{Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "To Gym", Routes: []RouteConfig{{DepartureStopID: "200", FinalArrivalStop: "400"}}},
+	}}
+
+	w := httptest.NewRecorder()
+	buildTextHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/text?trip=To+Work&n=1", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("expected plain text, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Body.String(); !strings.Contains(got, "T1  City  "+now.Add(5*time.Minute+30*time.Second).Format("15:04")) || strings.Count(got, "\n") != 1 {
+		t.Errorf("expected one line for the T1, got %q", got)
+	}
+
+	// The board itself answers in plain text when that's all the client takes.
+	board := buildHandler(parseTemplate(), mock.URL, cfg, nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	board(w, req)
+	if got := w.Body.String(); !strings.HasPrefix(got, "To Work\n") || !strings.Contains(got, "To Gym\n") {
+		t.Errorf("expected both trips as text, got %q", got)
+	}
+	req.Header.Set("Accept", "text/html,text/plain;q=0.8")
+	w = httptest.NewRecorder()
+	board(w, req)
+	if !strings.Contains(w.Body.String(), "<!DOCTYPE html>") {
+		t.Error("expected browsers to keep getting HTML")
+	}
+
+	for url, code := range map[string]int{"/text?trip=Pool": 404, "/text?n=0": 400} {
+		w := httptest.NewRecorder()
+		buildTextHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", url, code, w.Code)
+		}
+	}
+}
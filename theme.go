@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ThemeConfig sets the board's colour scheme. mode picks light (the
+// default), dark, auto (following the browser's prefers-color-scheme) or
+// sunset (dark between sunset and sunrise at lat/lon, worked out on the
+// server). The colour fields override the light scheme's custom properties
+// and dark's those of the dark one; empty ones keep the built-in colours.
+type ThemeConfig struct {
+	ThemeColors `yaml:",inline"`
+	Mode        string      `yaml:"mode,omitempty"`
+	Dark        ThemeColors `yaml:"dark,omitempty"`
+	Lat         float64     `yaml:"lat,omitempty"`
+	Lon         float64     `yaml:"lon,omitempty"`
+}
+
+type ThemeColors struct {
+	// Accent underlines the active tab and marks highlighted departures.
+	Accent string `yaml:"accent,omitempty"`
+	// Background is the page background, also used for route badge text.
+	Background string `yaml:"background,omitempty"`
+	// Header is the header and tab bar background, and the row dividers.
+	Header        string `yaml:"header,omitempty"`
+	Text          string `yaml:"text,omitempty"`
+	SecondaryText string `yaml:"secondary_text,omitempty"`
+}
+
+func (c ThemeConfig) validate() error {
+	switch c.Mode {
+	case "", "light", "dark", "auto":
+	case "sunset":
+		if c.Lat == 0 && c.Lon == 0 {
+			return fmt.Errorf("mode sunset needs lat and lon")
+		}
+	default:
+		return fmt.Errorf("mode: %q is not light, dark, auto or sunset", c.Mode)
+	}
+	if c.Lat < -90 || c.Lat > 90 || c.Lon < -180 || c.Lon > 180 {
+		return fmt.Errorf("lat/lon out of range")
+	}
+	if err := c.ThemeColors.validate(); err != nil {
+		return err
+	}
+	if err := c.Dark.validate(); err != nil {
+		return fmt.Errorf("dark: %w", err)
+	}
+	return nil
+}
+
+func (c ThemeColors) validate() error {
+	for _, f := range []struct{ name, color string }{
+		{"accent", c.Accent},
+		{"background", c.Background},
+		{"header", c.Header},
+		{"text", c.Text},
+		{"secondary_text", c.SecondaryText},
+	} {
+		if f.color != "" && !cssColor.MatchString(f.color) {
+			return fmt.Errorf("%s: %q is not a hex or named colour", f.name, f.color)
+		}
+	}
+	return nil
+}
+
+// darkAt reports whether the board renders dark at now, and for sunset mode
+// when that next changes (zero when the sun doesn't rise or set today).
+func (c ThemeConfig) darkAt(now time.Time) (dark bool, change time.Time) {
+	switch c.Mode {
+	case "dark":
+		return true, time.Time{}
+	case "sunset":
+	default:
+		return false, time.Time{}
+	}
+	rise, set, ok := sunTimes(now, c.Lat, c.Lon)
+	if !ok {
+		// Polar day or night: dark when the sun is below the horizon at noon.
+		return set.IsZero(), time.Time{}
+	}
+	switch {
+	case now.Before(rise):
+		return true, rise
+	case now.Before(set):
+		return false, set
+	}
+	tomorrow, _, ok := sunTimes(now.AddDate(0, 0, 1), c.Lat, c.Lon)
+	if !ok {
+		return true, time.Time{}
+	}
+	return true, tomorrow
+}
+
+// j2000 is Julian day 2451545.0.
+var j2000 = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// sunTimes returns sunrise and sunset on day's date (in day's location) at
+// lat/lon, by the sunrise equation; it is accurate to a minute or two. When
+// the sun doesn't rise or set that day, ok is false, and set is zero for
+// polar night but not for polar day.
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	const rad = math.Pi / 180
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, day.Location())
+	n := math.Round(noon.Sub(j2000).Hours()/24 + lon/360)
+	// Mean solar noon, as days since J2000.
+	j := n - lon/360
+	m := math.Mod(357.5291+0.98560028*j, 360)
+	center := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	ecliptic := math.Mod(m+center+180+102.9372, 360)
+	transit := j + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*ecliptic*rad)
+	declination := math.Asin(math.Sin(ecliptic*rad) * math.Sin(23.4397*rad))
+	cosHour := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*math.Sin(declination)) /
+		(math.Cos(lat*rad) * math.Cos(declination))
+
+	at := func(days float64) time.Time {
+		return j2000.Add(time.Duration(days * 24 * float64(time.Hour))).In(day.Location())
+	}
+	switch {
+	case cosHour > 1:
+		return time.Time{}, time.Time{}, false
+	case cosHour < -1:
+		return time.Time{}, at(transit), false
+	}
+	hour := math.Acos(cosHour) / rad / 360
+	return at(transit - hour), at(transit + hour), true
+}
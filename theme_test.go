@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoardTemplate_Theme(t *testing.T) {
+	cfg, err := parseConfig([]byte("theme: {accent: '#0055aa', header: navy, secondary_text: '#999'}\ntrips: [{name: A}]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, PageData{Now: time.Date(2026, 3, 2, 15, 4, 0, 0, boardTZ), Theme: cfg.Theme}); err != nil {
+		t.Fatal(err)
+	}
+	want := ":root{--accent-color: #0055aa;--header-bg-color: navy;--secondary-text-color: #999;}"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("expected %q in the stylesheet, got %.600s", want, b.String())
+	}
+
+	for _, yaml := range []string{"theme: {text: 'red; background: url(x)'}", "theme: {background: '#12345'}"} {
+		if _, err := parseConfig([]byte(yaml + "\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "theme") {
+			t.Errorf("%s: expected a theme error, got %v", yaml, err)
+		}
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	syd, _ := time.LoadLocation("Australia/Sydney")
+	tests := []struct {
+		name      string
+		day       time.Time
+		lat, lon  float64
+		rise, set string
+	}{
+		{"sydney winter", time.Date(2026, 6, 21, 9, 0, 0, 0, syd), -33.87, 151.21, "07:00", "16:54"},
+		{"sydney summer", time.Date(2026, 12, 21, 23, 0, 0, 0, syd), -33.87, 151.21, "05:41", "20:05"},
+		{"london summer", time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC), 51.5, -0.13, "03:43", "20:21"},
+	}
+	for _, tc := range tests {
+		rise, set, ok := sunTimes(tc.day, tc.lat, tc.lon)
+		if !ok {
+			t.Errorf("%s: expected a sunrise and sunset", tc.name)
+			continue
+		}
+		for _, c := range []struct {
+			got  time.Time
+			want string
+		}{{rise, tc.rise}, {set, tc.set}} {
+			want, _ := time.ParseInLocation("2006-01-02 15:04", tc.day.Format("2006-01-02 ")+c.want, tc.day.Location())
+			if d := c.got.Sub(want); d < -2*time.Minute || d > 2*time.Minute {
+				t.Errorf("%s: expected %s, got %s", tc.name, c.want, c.got.Format("15:04"))
+			}
+		}
+	}
+
+	if _, set, ok := sunTimes(time.Date(2026, 12, 21, 12, 0, 0, 0, time.UTC), 78.2, 15.6); ok || !set.IsZero() {
+		t.Error("expected polar night in Svalbard in December")
+	}
+	if _, set, ok := sunTimes(time.Date(2026, 6, 21, 12, 0, 0, 0, time.UTC), 78.2, 15.6); ok || set.IsZero() {
+		t.Error("expected polar day in Svalbard in June")
+	}
+}
+
+func TestThemeConfig_DarkAt(t *testing.T) {
+	syd, _ := time.LoadLocation("Australia/Sydney")
+	sunset := ThemeConfig{Mode: "sunset", Lat: -33.87, Lon: 151.21}
+	tests := []struct {
+		name   string
+		theme  ThemeConfig
+		now    time.Time
+		dark   bool
+		change string
+	}{
+		{"light", ThemeConfig{}, time.Date(2026, 6, 21, 23, 0, 0, 0, syd), false, ""},
+		{"dark", ThemeConfig{Mode: "dark"}, time.Date(2026, 6, 21, 12, 0, 0, 0, syd), true, ""},
+		{"auto is decided by the browser", ThemeConfig{Mode: "auto"}, time.Date(2026, 6, 21, 23, 0, 0, 0, syd), false, ""},
+		{"before sunrise", sunset, time.Date(2026, 6, 21, 5, 0, 0, 0, syd), true, "21 06:59"},
+		{"daytime", sunset, time.Date(2026, 6, 21, 12, 0, 0, 0, syd), false, "21 16:53"},
+		{"after sunset", sunset, time.Date(2026, 6, 21, 20, 0, 0, 0, syd), true, "22 07:00"},
+		{"polar night", ThemeConfig{Mode: "sunset", Lat: 78.2, Lon: 15.6}, time.Date(2026, 12, 21, 12, 0, 0, 0, time.UTC), true, ""},
+	}
+	for _, tc := range tests {
+		dark, change := tc.theme.darkAt(tc.now)
+		got := ""
+		if !change.IsZero() {
+			got = change.Format("02 15:04")
+		}
+		if dark != tc.dark || got != tc.change {
+			t.Errorf("%s: expected dark %v changing at %q, got %v at %q", tc.name, tc.dark, tc.change, dark, got)
+		}
+	}
+}
+
+func TestBoardTemplate_DarkMode(t *testing.T) {
+	cfg, err := parseConfig([]byte("theme: {mode: sunset, lat: -33.87, lon: 151.21, dark: {accent: gold}}\ntrips: [{name: A}]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := PageData{Now: time.Date(2026, 6, 21, 20, 0, 0, 0, boardTZ), Theme: cfg.Theme}
+	data.Dark, data.ThemeChange = cfg.Theme.darkAt(data.Now)
+	var b strings.Builder
+	if err := parseTemplate().Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{`<html lang="en" dir="ltr" class="dark">`, "html.dark{--accent-color: gold;}", `content="#262626"`, "location.reload()"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in the page", want)
+		}
+	}
+
+	b.Reset()
+	parseTemplate().Execute(&b, PageData{Now: data.Now, AutoDark: true})
+	if !strings.Contains(b.String(), "prefers-color-scheme: dark") || strings.Contains(b.String(), `class="dark"`) {
+		t.Error("expected auto mode to leave the scheme to the browser")
+	}
+
+	for _, yaml := range []string{"theme: {mode: night}", "theme: {mode: sunset}", "theme: {dark: {text: 'x y'}}"} {
+		if _, err := parseConfig([]byte(yaml + "\ntrips: [{name: A}]\n")); err == nil || !strings.Contains(err.Error(), "theme") {
+			t.Errorf("%s: expected a theme error, got %v", yaml, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig serves the board over HTTPS on port, with a certificate from
+// cert_file and key_file or one obtained from Let's Encrypt by autocert.
+type TLSConfig struct {
+	CertFile string         `yaml:"cert_file,omitempty"`
+	KeyFile  string         `yaml:"key_file,omitempty"`
+	Autocert AutocertConfig `yaml:"autocert,omitempty"`
+	// HTTPPort is where plain HTTP is answered: ACME challenges and
+	// redirects to HTTPS. autocert needs it reachable as port 80; without
+	// autocert nothing listens on it unless it is set.
+	HTTPPort string `yaml:"http_port,omitempty"`
+}
+
+type AutocertConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Hosts   []string `yaml:"hosts,omitempty"`
+	Email   string   `yaml:"email,omitempty"`
+	// CacheDir holds the account key and certificate, default "autocert".
+	CacheDir string `yaml:"cache_dir,omitempty"`
+	// DirectoryURL is the ACME CA, default Let's Encrypt. Point it at
+	// Let's Encrypt's staging directory while testing.
+	DirectoryURL string `yaml:"directory_url,omitempty"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.Autocert.Enabled
+}
+
+func (c TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file go together")
+	}
+	if c.CertFile != "" && c.Autocert.Enabled {
+		return fmt.Errorf("use either cert_file/key_file or autocert, not both")
+	}
+	if c.Autocert.Enabled && len(c.Autocert.Hosts) == 0 {
+		return fmt.Errorf("autocert: needs hosts")
+	}
+	return nil
+}
+
+func (c TLSConfig) httpPort() string {
+	if c.HTTPPort != "" {
+		return c.HTTPPort
+	}
+	if c.Autocert.Enabled {
+		return "80"
+	}
+	return ""
+}
+
+// startTLS returns the TLS config for the HTTPS listener on port, and
+// starts the plain HTTP listener on http_port with background; it stops when
+// ctx is done. With autocert, certificates are obtained and renewed as TLS
+// clients ask for them, and the HTTP listener answers the ACME challenges.
+func startTLS(ctx context.Context, c TLSConfig, port string, background func(func())) (*tls.Config, error) {
+	tlsConfig := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	redirect := redirectHTTPS(port)
+	if c.Autocert.Enabled {
+		m := newAutocertManager(c.Autocert)
+		tlsConfig.GetCertificate = m.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		redirect = m.HTTPHandler(redirect)
+	} else {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if httpPort := c.httpPort(); httpPort != "" {
+		srv := &http.Server{Addr: ":" + httpPort, Handler: redirect}
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("redirecting HTTP on :%s to HTTPS", httpPort)
+		background(func() {
+			if err := serve(ctx, srv, ln, &sync.WaitGroup{}); err != nil {
+				log.Printf("http: %v", err)
+			}
+		})
+	}
+	return tlsConfig, nil
+}
+
+// newAutocertManager gets certificates for the configured hosts only, keeping
+// the account key and certificates in the cache directory across restarts.
+func newAutocertManager(c AutocertConfig) *autocert.Manager {
+	dir := c.CacheDir
+	if dir == "" {
+		dir = "autocert"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Hosts...),
+		Cache:      autocert.DirCache(dir),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return m
+}
+
+// redirectHTTPS sends plain HTTP requests to the same URL over HTTPS on port.
+func redirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestTLSConfig_Validate(t *testing.T) {
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{"tls: {cert_file: a.pem, key_file: a.key}", ""},
+		{"tls: {autocert: {enabled: true, hosts: [board.example.com]}}", ""},
+		{"tls: {cert_file: a.pem}", "cert_file and key_file"},
+		{"tls: {cert_file: a.pem, key_file: a.key, autocert: {enabled: true, hosts: [a]}}", "not both"},
+		{"tls: {autocert: {enabled: true}}", "needs hosts"},
+	}
+	for _, tc := range tests {
+		_, err := parseConfig([]byte(tc.yaml + "\ntrips: [{name: A}]\n"))
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.yaml, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected %q, got %v", tc.yaml, tc.err, err)
+		}
+	}
+
+	if p := (TLSConfig{Autocert: AutocertConfig{Enabled: true}}).httpPort(); p != "80" {
+		t.Errorf("expected autocert to answer HTTP on port 80, got %q", p)
+	}
+	if p := (TLSConfig{CertFile: "a.pem"}).httpPort(); p != "" {
+		t.Errorf("expected no HTTP listener by default, got %q", p)
+	}
+}
+
+func TestNewAutocertManager(t *testing.T) {
+	m := newAutocertManager(AutocertConfig{Enabled: true, Hosts: []string{"board.example.com"}, Email: "me@example.com"})
+	if err := m.HostPolicy(context.Background(), "board.example.com"); err != nil {
+		t.Errorf("expected the configured host allowed, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("expected other hosts refused")
+	}
+	if m.Cache != autocert.DirCache("autocert") || m.Client != nil {
+		t.Errorf("expected the default cache dir and CA, got %v %v", m.Cache, m.Client)
+	}
+
+	m = newAutocertManager(AutocertConfig{Enabled: true, Hosts: []string{"a"}, CacheDir: "/var/lib/board", DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory"})
+	if m.Cache != autocert.DirCache("/var/lib/board") || m.Client == nil || m.Client.DirectoryURL != "https://acme-staging-v02.api.letsencrypt.org/directory" {
+		t.Errorf("expected the configured cache dir and CA, got %v %+v", m.Cache, m.Client)
+	}
+
+	// Plain HTTP answers challenges and otherwise redirects
+	w := httptest.NewRecorder()
+	m.HTTPHandler(redirectHTTPS("443")).ServeHTTP(w, httptest.NewRequest("GET", "http://a/trip", nil))
+	if w.Code != 301 {
+		t.Errorf("expected other requests redirected, got %d", w.Code)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct{ port, host, want string }{
+		{"443", "board.example.com", "https://board.example.com/trip?x=1"},
+		{"443", "board.example.com:80", "https://board.example.com/trip?x=1"},
+		{"8443", "pi.local:8080", "https://pi.local:8443/trip?x=1"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "http://"+tc.host+"/trip?x=1", nil)
+		w := httptest.NewRecorder()
+		redirectHTTPS(tc.port).ServeHTTP(w, req)
+		if w.Code != 301 || w.Header().Get("Location") != tc.want {
+			t.Errorf("%s on %s: got %d %q", tc.host, tc.port, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// visibleAt reports whether the trip is on the board at now, in the trip's
+// timezone: inside visible_between (which may run past midnight, and then
+// counts as the day it started) on one of visible_days. A trip without
+// either is always visible.
+func (t TripConfig) visibleAt(now time.Time) bool {
+	now = now.In(t.timeZone())
+	day := now.Weekday()
+	if len(t.VisibleBetween) == 2 {
+		start, _ := parseClock(t.VisibleBetween[0])
+		end, _ := parseClock(t.VisibleBetween[1])
+		mins := now.Hour()*60 + now.Minute()
+		switch {
+		case start < end:
+			if mins < start || mins >= end {
+				return false
+			}
+		case mins < end:
+			day = (day + 6) % 7
+		case mins < start:
+			return false
+		}
+	}
+	if len(t.VisibleDays) == 0 {
+		return true
+	}
+	for _, d := range t.VisibleDays {
+		if weekdayNames[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (t TripConfig) validateVisibility() error {
+	if len(t.VisibleBetween) > 0 {
+		if len(t.VisibleBetween) != 2 {
+			return fmt.Errorf("visible_between must be [start, end]")
+		}
+		start, err := parseClock(t.VisibleBetween[0])
+		if err != nil {
+			return fmt.Errorf("visible_between: %w", err)
+		}
+		end, err := parseClock(t.VisibleBetween[1])
+		if err != nil {
+			return fmt.Errorf("visible_between: %w", err)
+		}
+		if start == end {
+			return fmt.Errorf("visible_between %s-%s is empty", t.VisibleBetween[0], t.VisibleBetween[1])
+		}
+	}
+	for _, d := range t.VisibleDays {
+		if _, ok := weekdayNames[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("visible_days: unknown day %q", d)
+		}
+	}
+	return nil
+}
+
+// pageTrips returns the trips a board page shows and the index of the active
+// one: the visible trips with the first active, or, with key (a trip's index,
+// name or slug), that trip active, shown even while it isn't visible. ok is
+// false when key matches no trip.
+func (c Config) pageTrips(now time.Time, key string) (trips []TripConfig, active int, ok bool) {
+	trips = c.boardTrips(now)
+	if key == "" {
+		return trips, 0, true
+	}
+	sel, ok := selectTrip(c.Trips, key)
+	if !ok {
+		return nil, 0, false
+	}
+	for i, trip := range trips {
+		if trip.Name == sel.Name {
+			return trips, i, true
+		}
+	}
+	trips = nil
+	for _, trip := range c.Trips {
+		if trip.Name == sel.Name {
+			active = len(trips)
+		}
+		if trip.Name == sel.Name || trip.visibleAt(now) {
+			trips = append(trips, trip)
+		}
+	}
+	return trips, active, true
+}
+
+// boardTrips returns the trips the board shows at now: those visible then,
+// or every trip when none is, rather than an empty board.
+func (c Config) boardTrips(now time.Time) []TripConfig {
+	var trips []TripConfig
+	for _, trip := range c.Trips {
+		if trip.visibleAt(now) {
+			trips = append(trips, trip)
+		}
+	}
+	if len(trips) == 0 {
+		return c.Trips
+	}
+	return trips
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTripVisibleAt(t *testing.T) {
+	// 2 March 2026 is a Monday.
+	at := func(day, h, m int) time.Time { return time.Date(2026, 3, day, h, m, 0, 0, boardTZ) }
+	work := TripConfig{VisibleBetween: []string{"06:00", "10:00"}, VisibleDays: []string{"mon", "tue", "wed", "thu", "fri"}}
+	late := TripConfig{VisibleBetween: []string{"22:00", "02:00"}, VisibleDays: []string{"Fri"}}
+	perth, _ := time.LoadLocation("Australia/Perth")
+	tests := []struct {
+		name string
+		trip TripConfig
+		at   time.Time
+		want bool
+	}{
+		{"no rules", TripConfig{}, at(2, 3, 0), true},
+		{"weekday morning", work, at(2, 6, 0), true},
+		{"window end", work, at(2, 10, 0), false},
+		{"weekday evening", work, at(2, 18, 0), false},
+		{"weekend morning", work, at(7, 8, 0), false},
+		{"days only", TripConfig{VisibleDays: []string{"sat", "sun"}}, at(7, 18, 0), true},
+		{"friday night", late, at(6, 23, 0), true},
+		{"after midnight counts as friday", late, at(7, 1, 30), true},
+		{"after midnight on friday is thursday's", late, at(6, 1, 30), false},
+		{"between windows", late, at(7, 12, 0), false},
+		{"trip timezone", TripConfig{VisibleBetween: []string{"06:00", "10:00"}, loc: perth}, at(2, 11, 0), true},
+	}
+	for _, tc := range tests {
+		if got := tc.trip.visibleAt(tc.at); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestBoardTrips(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+trips:
+  - name: To Work
+    visible_between: ["06:00", "10:00"]
+    visible_days: [mon, tue, wed, thu, fri]
+    routes: []
+  - name: To Home
+    visible_between: ["15:00", "20:00"]
+    routes: []
+  - name: Gym
+    visible_days: [sat]
+    routes: []
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := func(trips []TripConfig) string {
+		var s []string
+		for _, trip := range trips {
+			s = append(s, trip.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 2, 7, 30, 0, 0, boardTZ), "To Work"},
+		{time.Date(2026, 3, 7, 16, 0, 0, 0, boardTZ), "To Home,Gym"},
+		{time.Date(2026, 3, 3, 23, 0, 0, 0, boardTZ), "To Work,To Home,Gym"},
+	} {
+		if got := names(cfg.boardTrips(tc.at)); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.at.Format(time.DateTime), tc.want, got)
+		}
+	}
+
+	for _, yaml := range []string{
+		`visible_between: ["06:00"]`,
+		`visible_between: ["6am", "10:00"]`,
+		`visible_between: ["06:00", "06:00"]`,
+		`visible_days: [someday]`,
+	} {
+		if _, err := parseConfig([]byte("trips:\n  - name: a\n    " + yaml + "\n    routes: []\n")); err == nil || !strings.Contains(err.Error(), "visible_") {
+			t.Errorf("%s: expected a visibility error, got %v", yaml, err)
+		}
+	}
+}
+
+func TestHandler_HiddenTrips(t *testing.T) {
+	mock := newMockAPI(t, map[string][]Departure{})
+	defer mock.Close()
+	later := time.Now().In(boardTZ).Add(2 * time.Hour)
+	cfg := Config{Trips: []TripConfig{
+		{Name: "Now", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "Later", VisibleBetween: []string{later.Format("15:04"), later.Add(time.Hour).Format("15:04")}, Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+	}}
+
+	w := httptest.NewRecorder()
+	buildHandler(parseTemplate(), mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<div class="tab active" onclick="switchTab( 0 )">Now</div>`) || strings.Contains(body, ">Later</div>") {
+		t.Error("expected only the visible trip's tab")
+	}
+
+	w = httptest.NewRecorder()
+	buildBoardHandler(mock.URL, cfg, nil)(w, httptest.NewRequest("GET", "/api/board", nil))
+	if body := w.Body.String(); strings.Contains(body, `"name":"Later"`) {
+		t.Error("expected /api/board to leave out the hidden trip")
+	}
+}
+
+func TestPageTrips(t *testing.T) {
+	cfg := Config{Trips: []TripConfig{
+		{Name: "To Work", VisibleBetween: []string{"06:00", "10:00"}},
+		{Name: "Gym"},
+		{Name: "To Home", VisibleBetween: []string{"15:00", "20:00"}},
+	}}
+	morning := time.Date(2026, 3, 2, 8, 0, 0, 0, boardTZ)
+	for _, tc := range []struct {
+		key    string
+		want   string
+		active int
+	}{
+		{"", "To Work,Gym", 0},
+		{"gym", "To Work,Gym", 1},
+		{"to-home", "To Work,Gym,To Home", 2},
+		{"0", "To Work,Gym", 0},
+	} {
+		trips, active, ok := cfg.pageTrips(morning, tc.key)
+		var names []string
+		for _, trip := range trips {
+			names = append(names, trip.Name)
+		}
+		if !ok || strings.Join(names, ",") != tc.want || active != tc.active {
+			t.Errorf("%q: expected %s with %d active, got %v with %d", tc.key, tc.want, tc.active, names, active)
+		}
+	}
+	if _, _, ok := cfg.pageTrips(morning, "nowhere"); ok {
+		t.Error("expected an unknown trip to fail")
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultPushLeaveMinutes    = 5
+	defaultPushDelayMinutes    = 5
+	defaultPushCooldownMinutes = 30
+	defaultPushStateFile       = "push-state.json"
+
+	// pushCheckInterval is how often subscribed trips are evaluated.
+	pushCheckInterval = 30 * time.Second
+
+	// maxPushSubscriptions bounds the state file, which anyone who can load
+	// the board can add to.
+	maxPushSubscriptions = 100
+	// maxPushSubscriptionBytes is far more than a browser's subscription.
+	maxPushSubscriptionBytes = 4 << 10
+)
+
+var errTooManySubscriptions = errors.New("too many push subscriptions")
+
+// errPushDestination is a push endpoint on the board's own network. The
+// server posts to whatever endpoint it's given, so only public hosts are
+// reached.
+var errPushDestination = errors.New("push endpoint must be a public host")
+
+var b64 = base64.RawURLEncoding
+
+type WebPushConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	Subject         string `yaml:"subject"`
+	LeaveMinutes    int    `yaml:"leave_minutes,omitempty"`
+	DelayMinutes    int    `yaml:"delay_minutes,omitempty"`
+	CooldownMinutes int    `yaml:"cooldown_minutes,omitempty"`
+	StateFile       string `yaml:"state_file,omitempty"`
+}
+
+func (c WebPushConfig) leaveMinutes() int {
+	if c.LeaveMinutes > 0 {
+		return c.LeaveMinutes
+	}
+	return defaultPushLeaveMinutes
+}
+
+func (c WebPushConfig) delayMinutes() int {
+	if c.DelayMinutes > 0 {
+		return c.DelayMinutes
+	}
+	return defaultPushDelayMinutes
+}
+
+func (c WebPushConfig) cooldown() time.Duration {
+	if c.CooldownMinutes > 0 {
+		return time.Duration(c.CooldownMinutes) * time.Minute
+	}
+	return defaultPushCooldownMinutes * time.Minute
+}
+
+func (c WebPushConfig) stateFile() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return defaultPushStateFile
+}
+
+// PushSubscription is the browser's PushSubscription.toJSON() plus the trip
+// the user asked to be notified about.
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	Trip string `json:"trip"`
+}
+
+type PushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+type pushState struct {
+	VAPIDPrivateKey string             `json:"vapid_private_key"`
+	Subscriptions   []PushSubscription `json:"subscriptions"`
+}
+
+// pushService stores browser subscriptions and the VAPID key pair in a state
+// file, and sends notifications about subscribed trips.
+type pushService struct {
+	cfg    WebPushConfig
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	// habits, when set, adds alerts about the services usually caught.
+	habits *habitStore
+
+	mu   sync.Mutex
+	subs []PushSubscription
+	sent map[string]time.Time
+}
+
+// newPushService loads the state file, generating and saving a VAPID key pair
+// on first use so subscriptions survive restarts.
+func newPushService(cfg WebPushConfig) (*pushService, error) {
+	s := &pushService{
+		cfg:    cfg,
+		client: newPushClient(),
+		sent:   make(map[string]time.Time),
+	}
+
+	var state pushState
+	data, err := os.ReadFile(cfg.stateFile())
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("parsing push state: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("reading push state: %w", err)
+	}
+
+	if state.VAPIDPrivateKey != "" {
+		der, err := b64.DecodeString(state.VAPIDPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("decoding VAPID key: %w", err)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing VAPID key: %w", err)
+		}
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("VAPID key is not a P-256 key")
+		}
+		s.key = key
+		s.subs = state.Subscriptions
+		return s, nil
+	}
+
+	s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.subs = state.Subscriptions
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	log.Printf("generated VAPID key pair in %s", cfg.stateFile())
+	return s, nil
+}
+
+// publicKey returns the VAPID application server key, base64url encoded.
+func (s *pushService) publicKey() string {
+	pub, _ := s.key.PublicKey.ECDH()
+	return b64.EncodeToString(pub.Bytes())
+}
+
+// save writes the state file. Callers must not hold s.mu.
+func (s *pushService) save() error {
+	der, err := x509.MarshalPKCS8PrivateKey(s.key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	state := pushState{VAPIDPrivateKey: b64.EncodeToString(der), Subscriptions: s.subs}
+	data, err := json.MarshalIndent(state, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.cfg.stateFile(), data, 0600)
+}
+
+func (s *pushService) subscribe(sub PushSubscription) error {
+	s.mu.Lock()
+	replaced := false
+	for i := range s.subs {
+		if s.subs[i].Endpoint == sub.Endpoint {
+			s.subs[i] = sub
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(s.subs) >= maxPushSubscriptions {
+			s.mu.Unlock()
+			return errTooManySubscriptions
+		}
+		s.subs = append(s.subs, sub)
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+// newPushClient returns the client pushes are sent with. Its dialer refuses
+// non-public addresses, which also catches public hostnames that resolve to
+// private ones.
+func newPushClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if addr, err := netip.ParseAddr(host); err != nil || !publicAddr(addr) {
+				return errPushDestination
+			}
+			return nil
+		},
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialled instead of the push service
+	t.Proxy = nil
+	t.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: t}
+}
+
+// publicAddr reports whether addr is routable on the internet: not loopback,
+// private, link-local, multicast or unspecified.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+// validatePushEndpoint checks a subscription's endpoint before it is stored:
+// https, and not an address on the board's network.
+func validatePushEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("push endpoint must be an https URL")
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return errPushDestination
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPushDestination
+	}
+	return nil
+}
+
+func (s *pushService) unsubscribe(endpoint string) error {
+	s.mu.Lock()
+	kept := s.subs[:0]
+	for _, sub := range s.subs {
+		if sub.Endpoint != endpoint {
+			kept = append(kept, sub)
+		}
+	}
+	s.subs = kept
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *pushService) subscriptions() []PushSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PushSubscription(nil), s.subs...)
+}
+
+// run evaluates every subscribed trip on an interval and pushes "leave now"
+// and delay notifications.
+func (s *pushService) run(ctx context.Context, apiURL string, config func() Config, cache *departureCache) {
+	ticker := time.NewTicker(pushCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.check(ctx, apiURL, config(), cache, time.Now().In(boardTZ))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *pushService) check(ctx context.Context, apiURL string, cfg Config, cache *departureCache, now time.Time) {
+	byTrip := make(map[string][]PushSubscription)
+	for _, sub := range s.subscriptions() {
+		byTrip[sub.Trip] = append(byTrip[sub.Trip], sub)
+	}
+
+	for name, subs := range byTrip {
+		trip, ok := selectTrip(cfg.Trips, name)
+		if !ok {
+			continue
+		}
+		tv, err := buildTripView(ctx, cache, apiURL, cfg, trip, now)
+		if err != nil {
+			log.Printf("push: building trip %q: %v", trip.Name, err)
+			continue
+		}
+		msgs := s.messagesFor(tv, now)
+		if s.habits != nil {
+			msgs = append(msgs, s.habitMessages(tv, s.habits.habits(trip.Name, now), cfg.habitDelayMinutes(), now)...)
+		}
+		for _, msg := range msgs {
+			for _, sub := range subs {
+				s.deliver(ctx, sub, msg)
+			}
+		}
+	}
+}
+
+// messagesFor returns the notifications due for a trip, recording them so
+// each fires once: "leave now" at most once per cooldown, and a delay once
+// per service.
+func (s *pushService) messagesFor(tv TripView, now time.Time) []PushMessage {
+	var msgs []PushMessage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, at := range s.sent {
+		if now.Sub(at) > 2*s.cfg.cooldown() {
+			delete(s.sent, k)
+		}
+	}
+
+	if len(tv.Departures) > 0 {
+		// Counted to leaving, as the board does, so a walk to the stop is
+		// allowed for
+		best := tv.Departures[0]
+		mins := int(best.leaveTime().Sub(now).Minutes())
+		key := "leave|" + tv.Name
+		if mins <= s.cfg.leaveMinutes() && now.Sub(s.sent[key]) >= s.cfg.cooldown() {
+			s.sent[key] = now
+			body := fmt.Sprintf("Leave now: %s departs %s (%d min), arrives %s", best.RouteShortName, best.DepartureTime, max(mins, 0), best.FinalArrivalTime)
+			if best.LeaveBy != "" {
+				body = fmt.Sprintf("Leave by %s (%d min) for the %s at %s, arriving %s", best.LeaveBy, max(mins, 0), best.RouteShortName, best.DepartureTime, best.FinalArrivalTime)
+			}
+			msgs = append(msgs, PushMessage{Title: tv.Name, Body: body, Tag: tv.Name + "-leave"})
+		}
+	}
+
+	for _, dv := range tv.Departures {
+		if !dv.IsDelayed || dv.DelayMinutes < s.cfg.delayMinutes() {
+			continue
+		}
+		key := "delay|" + tv.Name + "|" + dv.RouteShortName + "|" + dv.departureAt.Format(time.RFC3339)
+		if _, done := s.sent[key]; done {
+			continue
+		}
+		s.sent[key] = now
+		msgs = append(msgs, PushMessage{
+			Title: tv.Name,
+			Body:  fmt.Sprintf("%s delayed %d min, now departs %s", dv.RouteShortName, dv.DelayMinutes, dv.DepartureTime),
+			Tag:   tv.Name + "-delay",
+		})
+	}
+	return msgs
+}
+
+// deliver sends msg to one subscription, dropping subscriptions the push
+// service reports as gone.
+func (s *pushService) deliver(ctx context.Context, sub PushSubscription, msg PushMessage) {
+	payload, _ := json.Marshal(msg)
+	status, err := s.send(ctx, sub, payload)
+	if err != nil {
+		log.Printf("push: sending to %s: %v", sub.Endpoint, err)
+		return
+	}
+	if status == http.StatusGone || status == http.StatusNotFound {
+		if err := s.unsubscribe(sub.Endpoint); err != nil {
+			log.Printf("push: saving state: %v", err)
+		}
+	}
+}
+
+func (s *pushService) send(ctx context.Context, sub PushSubscription, payload []byte) (int, error) {
+	uaPublic, err := b64.DecodeString(sub.Keys.P256dh)
+	if err != nil {
+		return 0, fmt.Errorf("decoding p256dh: %w", err)
+	}
+	authSecret, err := b64.DecodeString(sub.Keys.Auth)
+	if err != nil {
+		return 0, fmt.Errorf("decoding auth: %w", err)
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, err
+	}
+	body, err := encryptPushPayload(payload, uaPublic, authSecret, salt, asPrivate)
+	if err != nil {
+		return 0, err
+	}
+
+	auth, err := s.vapidAuthorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", "600")
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusGone && resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode, fmt.Errorf("push service returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// vapidAuthorization builds the RFC 8292 Authorization header for endpoint.
+func (s *pushService) vapidAuthorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": s.cfg.Subject,
+	})
+	signingInput := header + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	sig.FillBytes(raw[32:])
+
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", signingInput, b64.EncodeToString(raw), s.publicKey()), nil
+}
+
+// encryptPushPayload encrypts payload for a subscription as a single
+// aes128gcm record (RFC 8291).
+func encryptPushPayload(payload, uaPublic, authSecret, salt []byte, asPrivate *ecdh.PrivateKey) ([]byte, error) {
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription key: %w", err)
+	}
+	ecdhSecret, err := asPrivate.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the last (and only) record, with no padding
+	plaintext := append(append([]byte(nil), payload...), 0x02)
+
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(4096))
+	out.WriteByte(byte(len(asPublic)))
+	out.Write(asPublic)
+	out.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return out.Bytes(), nil
+}
+
+func buildPushKeyHandler(push *pushService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, push.publicKey())
+	}
+}
+
+// buildPushSubscribeHandler stores a subscription on POST and removes it on
+// DELETE.
+func buildPushSubscribeHandler(push *pushService, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sub PushSubscription
+		r.Body = http.MaxBytesReader(w, r.Body, maxPushSubscriptionBytes)
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil || sub.Endpoint == "" {
+			writeJSONError(w, http.StatusBadRequest, "invalid subscription")
+			return
+		}
+
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			if _, ok := selectTrip(cfg.Trips, sub.Trip); !ok || sub.Trip == "" {
+				writeJSONError(w, http.StatusBadRequest, "unknown trip")
+				return
+			}
+			if err := validatePushEndpoint(sub.Endpoint); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			err = push.subscribe(sub)
+		case http.MethodDelete:
+			err = push.unsubscribe(sub.Endpoint)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if errors.Is(err, errTooManySubscriptions) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
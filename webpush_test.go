@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vector from RFC 8291 section 5.
+func TestEncryptPushPayload_RFC8291(t *testing.T) {
+	mustDecode := func(s string) []byte {
+		b, err := b64.DecodeString(s)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", s, err)
+		}
+		return b
+	}
+
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustDecode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := mustDecode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	authSecret := mustDecode("BTBZMqHH6r4Tts7J_aSIgg")
+	salt := mustDecode("DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := encryptPushPayload([]byte("When I grow up, I want to be a watermelon"), uaPublic, authSecret, salt, asPrivate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := b64.EncodeToString(body); got != want {
+		t.Errorf("encrypted body mismatch\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func newTestPushService(t *testing.T) *pushService {
+	t.Helper()
+	push, err := newPushService(WebPushConfig{
+		Enabled:   true,
+		Subject:   "mailto:test@example.com",
+		StateFile: filepath.Join(t.TempDir(), "push.json"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return push
+}
+
+func TestPushService_VAPIDAuthorization(t *testing.T) {
+	push := newTestPushService(t)
+
+	auth, err := push.vapidAuthorization("https://push.example.com/send/abc", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(auth, "vapid t=") || !strings.HasSuffix(auth, ", k="+push.publicKey()) {
+		t.Fatalf("unexpected header %q", auth)
+	}
+
+	token := strings.TrimSuffix(strings.TrimPrefix(auth, "vapid t="), ", k="+push.publicKey())
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected JWT with 3 parts, got %d", len(parts))
+	}
+
+	claims, _ := b64.DecodeString(parts[1])
+	var c map[string]any
+	json.Unmarshal(claims, &c)
+	if c["aud"] != "https://push.example.com" || c["sub"] != "mailto:test@example.com" {
+		t.Errorf("unexpected claims %v", c)
+	}
+
+	sig, _ := b64.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&push.key.PublicKey, digest[:], r, s) {
+		t.Error("expected JWT signature to verify with the VAPID key")
+	}
+}
+
+func TestPushService_StatePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.json")
+	cfg := WebPushConfig{Enabled: true, StateFile: path}
+
+	push, err := newPushService(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sub := PushSubscription{Endpoint: "https://push.example.com/1", Trip: "To Work"}
+	if err := push.subscribe(sub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reloaded, err := newPushService(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reloaded.publicKey() != push.publicKey() {
+		t.Error("expected VAPID key to survive a restart")
+	}
+	if subs := reloaded.subscriptions(); len(subs) != 1 || subs[0].Trip != "To Work" {
+		t.Errorf("expected subscription to survive a restart, got %+v", subs)
+	}
+
+	reloaded.unsubscribe(sub.Endpoint)
+	if len(reloaded.subscriptions()) != 0 {
+		t.Error("expected subscription to be removed")
+	}
+}
+
+func TestPushService_MessagesFor(t *testing.T) {
+	push := newTestPushService(t)
+	now := time.Date(2024, 6, 3, 8, 0, 0, 0, boardTZ)
+
+	tv := TripView{
+		Name: "To Work",
+		Departures: []DepartureView{
+			{RouteShortName: "T1", DepartureTime: "08:04", departureAt: now.Add(4 * time.Minute)},
+			{RouteShortName: "T2", DepartureTime: "08:20", departureAt: now.Add(20 * time.Minute), IsDelayed: true, DelayMinutes: 7},
+		},
+	}
+
+	msgs := push.messagesFor(tv, now)
+	if len(msgs) != 2 {
+		t.Fatalf("expected leave and delay messages, got %+v", msgs)
+	}
+	if !strings.Contains(msgs[0].Body, "Leave now: T1") {
+		t.Errorf("unexpected leave message %q", msgs[0].Body)
+	}
+	if !strings.Contains(msgs[1].Body, "T2 delayed 7 min") {
+		t.Errorf("unexpected delay message %q", msgs[1].Body)
+	}
+
+	// With a walk to the stop, the alert comes before leaving, not departing
+	walk := newTestPushService(t)
+	walkTV := TripView{Name: "To Work", Departures: []DepartureView{{
+		RouteShortName: "T1", DepartureTime: "08:15", FinalArrivalTime: "08:45", LeaveBy: "08:04",
+		departureAt: now.Add(15 * time.Minute), leaveAt: now.Add(4 * time.Minute),
+	}}}
+	if msgs := walk.messagesFor(walkTV, now); len(msgs) != 1 || msgs[0].Body != "Leave by 08:04 (4 min) for the T1 at 08:15, arriving 08:45" {
+		t.Errorf("expected a leave alert counted to leaving, got %+v", msgs)
+	}
+
+	if msgs := push.messagesFor(tv, now.Add(time.Minute)); len(msgs) != 0 {
+		t.Errorf("expected no repeat notifications, got %+v", msgs)
+	}
+	if msgs := push.messagesFor(tv, now.Add(31*time.Minute)); len(msgs) != 1 {
+		t.Errorf("expected leave notification again after cooldown, got %+v", msgs)
+	}
+}
+
+func TestPushService_DeliverDropsGoneSubscriptions(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		body = buf.Bytes()
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer endpoint.Close()
+
+	ua, _ := ecdh.P256().GenerateKey(rand.Reader)
+	sub := PushSubscription{Endpoint: endpoint.URL + "/send/1", Trip: "To Work"}
+	sub.Keys.P256dh = b64.EncodeToString(ua.PublicKey().Bytes())
+	sub.Keys.Auth = b64.EncodeToString(make([]byte, 16))
+
+	push := newTestPushService(t)
+	// The test server is on loopback, which the push client refuses
+	push.client = endpoint.Client()
+	push.subscribe(sub)
+	push.deliver(context.Background(), sub, PushMessage{Title: "To Work", Body: "hi"})
+
+	if got == nil {
+		t.Fatal("expected push request")
+	}
+	if got.Header.Get("Content-Encoding") != "aes128gcm" || !strings.HasPrefix(got.Header.Get("Authorization"), "vapid ") {
+		t.Errorf("unexpected headers %v", got.Header)
+	}
+	if len(body) < 86 {
+		t.Errorf("expected encrypted body with header, got %d bytes", len(body))
+	}
+	if len(push.subscriptions()) != 0 {
+		t.Error("expected 410 Gone to remove the subscription")
+	}
+}
+
+func TestPushSubscribeHandler(t *testing.T) {
+	push := newTestPushService(t)
+	cfg := Config{Trips: []TripConfig{{Name: "To Work"}}}
+	handler := buildPushSubscribeHandler(push, cfg)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/push/subscribe", strings.NewReader(`{"endpoint":"https://push.example.com/1","keys":{"p256dh":"x","auth":"y"},"trip":"Gym"}`)))
+	if w.Code != 400 {
+		t.Errorf("expected 400 for unknown trip, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/push/subscribe", strings.NewReader(`{"endpoint":"https://push.example.com/1","keys":{"p256dh":"x","auth":"y"},"trip":"To Work"}`)))
+	if w.Code != 204 {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if len(push.subscriptions()) != 1 {
+		t.Fatal("expected subscription to be stored")
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", "/push/subscribe", strings.NewReader(`{"endpoint":"https://push.example.com/1"}`)))
+	if w.Code != 204 || len(push.subscriptions()) != 0 {
+		t.Errorf("expected subscription to be removed, got %d", w.Code)
+	}
+
+	for _, endpoint := range []string{
+		"http://push.example.com/1",
+		"https://127.0.0.1:8080/admin/trips",
+		"https://[::1]/x",
+		"https://192.168.1.1/x",
+		"https://169.254.169.254/latest/meta-data",
+		"https://localhost/x",
+	} {
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/push/subscribe", strings.NewReader(`{"endpoint":"`+endpoint+`","trip":"To Work"}`)))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", endpoint, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/push/subscribe", strings.NewReader(`{"endpoint":"https://push.example.com/1","trip":"To Work","pad":"`+strings.Repeat("x", maxPushSubscriptionBytes)+`"}`)))
+	if w.Code != 400 {
+		t.Errorf("expected 400 for an oversized body, got %d", w.Code)
+	}
+}
+
+func TestPushService_SubscriptionLimit(t *testing.T) {
+	push := newTestPushService(t)
+	for i := range maxPushSubscriptions {
+		if err := push.subscribe(PushSubscription{Endpoint: fmt.Sprintf("https://push.example.com/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := push.subscribe(PushSubscription{Endpoint: "https://push.example.com/more"}); !errors.Is(err, errTooManySubscriptions) {
+		t.Errorf("expected the limit to be enforced, got %v", err)
+	}
+	if err := push.subscribe(PushSubscription{Endpoint: "https://push.example.com/0", Trip: "Home"}); err != nil {
+		t.Errorf("expected an existing subscription to still update, got %v", err)
+	}
+}
+
+func TestPushClient_RefusesPrivateAddresses(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer endpoint.Close()
+	// A hostname that resolves to loopback is caught when dialling
+	u := strings.Replace(endpoint.URL, "127.0.0.1", "localhost", 1)
+	if _, err := newPushClient().Post(u, "text/plain", nil); !errors.Is(err, errPushDestination) {
+		t.Errorf("expected the dial to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// plannerDays is how many days, starting today, the week-ahead planner covers.
+const plannerDays = 7
+
+type PlannerData struct {
+	Trips     []PlannerTrip
+	Generated time.Time
+}
+
+type PlannerTrip struct {
+	Name string
+	Days []PlannerDay
+}
+
+type PlannerDay struct {
+	Date        time.Time
+	First       *DepartureView
+	Last        *DepartureView
+	Journeys    int
+	Disruptions []Alert
+	Error       string
+}
+
+// buildWeekHandler renders, for each trip (or just ?trip=), the first and last
+// viable journeys of the next seven days from the static schedule, plus any
+// planned disruptions at the trip's stops.
+func buildWeekHandler(apiURL string, cfg Config) http.HandlerFunc {
+	tmpl := template.Must(template.New("week").Funcs(localeFuncs()).Parse(weekTemplate))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		trips := cfg.Trips
+		if key := r.URL.Query().Get("trip"); key != "" {
+			trip, ok := selectTrip(cfg.Trips, key)
+			if !ok {
+				http.Error(w, "unknown trip", http.StatusNotFound)
+				return
+			}
+			trips = []TripConfig{trip}
+		}
+
+		now := time.Now().In(boardTZ)
+		data := PlannerData{Generated: now}
+		for _, trip := range trips {
+			local := now.In(trip.timeZone())
+			today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+			data.Trips = append(data.Trips, buildPlannerTrip(r.Context(), apiURL, cfg, trip, today))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		tmpl.Execute(w, data)
+	}
+}
+
+// buildPlannerTrip summarises one trip for each day from today, fetching the
+// days concurrently. A day that fails to load carries its error rather than
+// failing the whole planner.
+func buildPlannerTrip(ctx context.Context, apiURL string, cfg Config, trip TripConfig, today time.Time) PlannerTrip {
+	var alerts []Alert
+	for api, stopIDs := range tripStopsByAPI(apiURL, trip) {
+		a, err := fetchAlerts(ctx, api, stopIDs)
+		if err != nil {
+			log.Printf("fetching alerts for trip %q: %v", trip.Name, err)
+			continue
+		}
+		alerts = append(alerts, a...)
+	}
+
+	pt := PlannerTrip{Name: trip.Name, Days: make([]PlannerDay, plannerDays)}
+	var wg sync.WaitGroup
+	for i := range pt.Days {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := today.AddDate(0, 0, i)
+			day := PlannerDay{Date: start}
+			for _, a := range alerts {
+				if a.activeDuring(start, start.AddDate(0, 0, 1)) {
+					day.Disruptions = append(day.Disruptions, a)
+				}
+			}
+
+			tv, err := buildDayTripView(ctx, apiURL, cfg, trip, start)
+			if err != nil {
+				day.Error = err.Error()
+			} else if n := len(tv.Departures); n > 0 {
+				day.Journeys = n
+				day.First, day.Last = &tv.Departures[0], &tv.Departures[n-1]
+			}
+			pt.Days[i] = day
+		}(i)
+	}
+	wg.Wait()
+	return pt
+}
+
+var weekTemplate = strings.TrimSpace(`
+<!DOCTYPE html>
+<html lang="{{(locale).Lang}}" dir="{{(locale).Dir}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Week ahead</title>
+<style>
+*{margin:0;padding:0;box-sizing:border-box}
+body{font-family:"IBM Plex Sans",system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;color:#1a1a1a;background:#fafafa;padding:16px;max-width:900px;margin:0 auto}
+h1{font-size:18px;font-weight:600;margin-bottom:16px}
+h2{font-size:15px;font-weight:600;margin:24px 0 8px}
+table{width:100%;border-collapse:collapse;font-size:14px}
+th{text-align:start;font-size:12px;font-weight:500;color:#555;border-bottom:2px solid #e4e4e4;padding:6px 8px}
+td{padding:6px 8px;border-bottom:1px solid #e4e4e4;vertical-align:top}
+.time{font-variant-numeric:tabular-nums;white-space:nowrap}
+.route{font-weight:700}
+.none{color:#555}
+.err{color:#ff6b6b}
+.alert{font-size:13px;color:#8a4b00}
+.alert+.alert{margin-top:4px}
+footer{margin-top:24px;font-size:12px;color:#555}
+</style>
+</head>
+<body>
+<h1>Week ahead</h1>
+{{range .Trips}}
+<h2>{{.Name}}</h2>
+<table>
+<thead><tr><th>Day</th><th>First</th><th>Last</th><th>Journeys</th><th>Planned disruptions</th></tr></thead>
+<tbody>
+{{range .Days}}
+<tr>
+<td>{{(locale).ShortDate .Date}}</td>
+{{if .Error}}
+<td colspan="3" class="err">{{.Error}}</td>
+{{else if not .First}}
+<td colspan="3" class="none">No viable journeys</td>
+{{else}}
+<td class="time">{{with .First}}{{.DepartureTime}} <span class="route">{{.RouteShortName}}</span> → {{.FinalArrivalTime}}{{end}}</td>
+<td class="time">{{with .Last}}{{.DepartureTime}} <span class="route">{{.RouteShortName}}</span> → {{.FinalArrivalTime}}{{end}}</td>
+<td>{{.Journeys}}</td>
+{{end}}
+<td>{{range .Disruptions}}<div class="alert">{{.Header}}</div>{{else}}<span class="none">—</span>{{end}}</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+{{end}}
+<footer>From the published schedule as of {{(locale).ShortDate .Generated}} {{(locale).Clock .Generated}}.</footer>
+</body>
+</html>
+`)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildPlannerTrip(t *testing.T) {
+	today := time.Date(2024, 6, 3, 0, 0, 0, 0, boardTZ)
+	closure := today.AddDate(0, 0, 5)
+	closureEnd := closure.AddDate(0, 0, 2)
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/alerts" {
+			json.NewEncoder(w).Encode([]Alert{{Header: "Weekend trackwork", ActiveFrom: &closure, ActiveTo: &closureEnd}})
+			return
+		}
+		day, err := time.ParseInLocation(dateLayout, r.URL.Query().Get("date"), boardTZ)
+		if err != nil {
+			http.Error(w, "missing date", http.StatusBadRequest)
+			return
+		}
+		var deps []Departure
+		// No service during the closure
+		if day.Before(closure) {
+			for _, h := range []int{6, 12, 22} {
+				dep := day.Add(time.Duration(h) * time.Hour)
+				deps = append(deps, Departure{
+					RouteShortName:     "T1",
+					ScheduledDeparture: dep,
+					Arrivals:           []ArrivalDetail{{StopID: "300", ScheduledArrival: dep.Add(30 * time.Minute)}},
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(deps)
+	}))
+	defer mock.Close()
+
+	trip := TripConfig{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}}
+	pt := buildPlannerTrip(context.Background(), mock.URL, Config{}, trip, today)
+
+	if len(pt.Days) != plannerDays {
+		t.Fatalf("expected %d days, got %d", plannerDays, len(pt.Days))
+	}
+	mon := pt.Days[0]
+	if mon.Journeys != 3 || mon.First.DepartureTime != "06:00" || mon.Last.DepartureTime != "22:00" {
+		t.Errorf("expected first 06:00 and last 22:00 of 3 journeys, got %+v", mon)
+	}
+	if len(mon.Disruptions) != 0 {
+		t.Errorf("expected no disruptions on Monday, got %v", mon.Disruptions)
+	}
+
+	sat := pt.Days[5]
+	if sat.First != nil || len(sat.Disruptions) != 1 {
+		t.Errorf("expected Saturday closure with no journeys, got %+v", sat)
+	}
+	if !pt.Days[5].Date.Equal(closure) {
+		t.Errorf("expected days to start at midnight, got %v", pt.Days[5].Date)
+	}
+}
+
+func TestWeekHandler(t *testing.T) {
+	mock := newMockAPI(t, nil)
+	defer mock.Close()
+
+	cfg := Config{Trips: []TripConfig{
+		{Name: "To Work", Routes: []RouteConfig{{DepartureStopID: "100", FinalArrivalStop: "300"}}},
+		{Name: "To Home", Routes: []RouteConfig{{DepartureStopID: "300", FinalArrivalStop: "100"}}},
+	}}
+	handler := buildWeekHandler(mock.URL, cfg)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/week?trip=To+Home", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "To Home") || strings.Contains(body, "To Work") {
+		t.Error("expected only the selected trip")
+	}
+	if got := strings.Count(body, "No viable journeys"); got != plannerDays {
+		t.Errorf("expected a row per day, got %d", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/week?trip=Nowhere", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown trip, got %d", w.Code)
+	}
+}